@@ -1,12 +1,64 @@
 
-import React, { useState, useRef, useMemo } from 'react';
+import React, { useState, useRef, useMemo, useEffect } from 'react';
 import { 
   LineChart, Line, XAxis, YAxis, CartesianGrid, Tooltip, ResponsiveContainer, 
-  PieChart, Pie, Cell, BarChart, Bar, Legend 
+  PieChart, Pie, Cell, Legend 
 } from 'recharts';
-import { revenueData, distributionData, areaDeliveries, mockOrders } from '../services/mockData';
 import { OrderStatus } from '../types';
 
+const API_BASE = '/api';
+
+// One bucket of /admin/analytics/timeseries
+interface AnalyticsBucket {
+  start: string;
+  end: string;
+  delivered_litres: number;
+  deliveries: number;
+  revenue: number;
+  active_subscriptions: number;
+  paused_subscriptions: number;
+  new_customers: number;
+  churned: number;
+  skip_rate: number;
+  on_time_rate: number;
+}
+
+// One row of /orders
+interface OrderSummary {
+  id: number;
+  order_number: string;
+  customerName: string;
+  total: number;
+  status: string;
+}
+
+// One row of product_sales in /admin/analytics (last 30 days)
+interface ProductSales {
+  category: string;
+  units: number;
+}
+
+// The pending count is read from one page of orders
+const PENDING_LIMIT = 100;
+
+const isoDate = (d: Date) => {
+  const local = new Date(d.getTime() - d.getTimezoneOffset() * 60000);
+  return local.toISOString().slice(0, 10);
+};
+
+const addDays = (dateStr: string, days: number) => {
+  const d = new Date(`${dateStr}T00:00:00`);
+  d.setDate(d.getDate() + days);
+  return isoDate(d);
+};
+
+// Percentage change as "+x.x%" / "-x.x%", or undefined without a baseline
+const trendOf = (current: number, previous: number | undefined) => {
+  if (!previous) return undefined;
+  const pct = ((current - previous) / previous) * 100;
+  return `${pct >= 0 ? '+' : ''}${pct.toFixed(1)}%`;
+};
+
 const COLORS = ['#0C2D57', '#FFD700', '#10B981', '#EF4444'];
 
 const StatCard: React.FC<{ title: string; value: string; icon: string; trend?: string; color: string }> = ({ title, value, icon, trend, color }) => (
@@ -32,26 +84,71 @@ const StatCard: React.FC<{ title: string; value: string; icon: string; trend?: s
 );
 
 const Dashboard: React.FC = () => {
-  const [selectedDate, setSelectedDate] = useState('2023-11-20');
+  const [selectedDate, setSelectedDate] = useState(() => isoDate(new Date()));
+  const [rangeDays, setRangeDays] = useState(7);
+  const [series, setSeries] = useState<AnalyticsBucket[]>([]);
+  const [orders, setOrders] = useState<OrderSummary[]>([]);
+  const [pendingCount, setPendingCount] = useState(0);
+  const [sales, setSales] = useState<ProductSales[]>([]);
   const dateInputRef = useRef<HTMLInputElement>(null);
 
-  const filteredOrders = useMemo(() => {
-    return mockOrders.filter(order => order.date === selectedDate);
+  // Daily series ending on the selected date, plus the same weekday a week earlier for trends
+  useEffect(() => {
+    const from = addDays(selectedDate, -Math.max(rangeDays, 8) + 1);
+    fetch(`${API_BASE}/admin/analytics/timeseries?from=${from}&to=${selectedDate}&granularity=day`)
+      .then(res => (res.ok ? res.json() : null))
+      .then(data => setSeries(data?.series || []))
+      .catch(err => console.error('Error fetching analytics:', err));
+  }, [selectedDate, rangeDays]);
+
+  // Orders placed on the selected date, and how many of them are still pending
+  useEffect(() => {
+    fetch(`${API_BASE}/orders?date=${selectedDate}&limit=5`)
+      .then(res => (res.ok ? res.json() : []))
+      .then(data => setOrders(data || []))
+      .catch(err => console.error('Error fetching orders:', err));
+    fetch(`${API_BASE}/orders?date=${selectedDate}&status=${OrderStatus.PENDING}&limit=${PENDING_LIMIT}`)
+      .then(res => (res.ok ? res.json() : []))
+      .then(data => setPendingCount((data || []).length))
+      .catch(err => console.error('Error fetching pending orders:', err));
   }, [selectedDate]);
 
+  useEffect(() => {
+    fetch(`${API_BASE}/admin/analytics`)
+      .then(res => (res.ok ? res.json() : null))
+      .then(data => setSales(data?.product_sales || []))
+      .catch(err => console.error('Error fetching product sales:', err));
+  }, []);
+
+  // Share of units sold per category over the last 30 days
+  const distributionData = useMemo(() => {
+    const byCategory: Record<string, number> = {};
+    sales.forEach(s => { byCategory[s.category] = (byCategory[s.category] || 0) + s.units; });
+    const total = Object.values(byCategory).reduce((sum, n) => sum + n, 0);
+    return Object.entries(byCategory)
+      .filter(([, units]) => units > 0)
+      .map(([name, units]) => ({ name, value: Math.round((units / total) * 1000) / 10 }));
+  }, [sales]);
+
+  const revenueData = useMemo(() => series.slice(-rangeDays).map(b => ({
+    name: new Date(`${b.start}T00:00:00`).toLocaleDateString('en-US', { month: 'short', day: 'numeric' }),
+    revenue: b.revenue
+  })), [series, rangeDays]);
+
   const stats = useMemo(() => {
-    const dailyTotal = filteredOrders.reduce((acc, order) => acc + order.total, 0);
-    const deliveredCount = filteredOrders.filter(o => o.status === OrderStatus.DELIVERED).length;
-    const pendingCount = filteredOrders.filter(o => o.status === OrderStatus.PENDING).length;
+    const day = series.find(b => b.start === selectedDate);
+    const weekAgo = series.find(b => b.start === addDays(selectedDate, -7));
 
     return {
-      revenue: `₹ ${dailyTotal.toLocaleString()}`,
-      deliveries: filteredOrders.length,
-      pending: pendingCount,
-      // For mock purposes, keep one static or scaled
-      activeSubs: "1,248"
+      revenue: `₹ ${(day?.revenue || 0).toLocaleString()}`,
+      revenueTrend: trendOf(day?.revenue || 0, weekAgo?.revenue),
+      deliveries: day?.deliveries || 0,
+      deliveriesTrend: trendOf(day?.deliveries || 0, weekAgo?.deliveries),
+      pending: pendingCount >= PENDING_LIMIT ? `${PENDING_LIMIT}+` : pendingCount.toString(),
+      activeSubs: (day?.active_subscriptions || 0).toLocaleString(),
+      activeSubsTrend: trendOf(day?.active_subscriptions || 0, weekAgo?.active_subscriptions)
     };
-  }, [filteredOrders]);
+  }, [pendingCount, series, selectedDate]);
 
   const formatDate = (dateStr: string) => {
     const options: Intl.DateTimeFormatOptions = { month: 'short', day: 'numeric', year: 'numeric' };
@@ -90,19 +187,23 @@ const Dashboard: React.FC = () => {
       </div>
 
       <div className="grid grid-cols-1 md:grid-cols-2 lg:grid-cols-4 gap-6">
-        <StatCard title="Daily Revenue" value={stats.revenue} icon="payments" trend="+12.5%" color="bg-zepto-blue" />
-        <StatCard title="Active Subscriptions" value={stats.activeSubs} icon="local_drink" trend="+5.2%" color="bg-zepto-yellow" />
-        <StatCard title="Deliveries" value={stats.deliveries.toString()} icon="delivery_dining" trend="+18.4%" color="bg-zepto-green" />
-        <StatCard title="Pending Orders" value={stats.pending.toString()} icon="pending_actions" trend="-2.4%" color="bg-zepto-danger" />
+        <StatCard title="Daily Revenue" value={stats.revenue} icon="payments" trend={stats.revenueTrend} color="bg-zepto-blue" />
+        <StatCard title="Active Subscriptions" value={stats.activeSubs} icon="local_drink" trend={stats.activeSubsTrend} color="bg-zepto-yellow" />
+        <StatCard title="Deliveries" value={stats.deliveries.toString()} icon="delivery_dining" trend={stats.deliveriesTrend} color="bg-zepto-green" />
+        <StatCard title="Pending Orders" value={stats.pending} icon="pending_actions" color="bg-zepto-danger" />
       </div>
 
       <div className="grid grid-cols-1 lg:grid-cols-3 gap-6">
         <div className="lg:col-span-2 bg-white p-6 rounded-2xl shadow-sm border border-slate-100">
           <div className="flex items-center justify-between mb-6">
-            <h3 className="font-bold text-slate-900">Weekly Revenue Trend</h3>
-            <select className="bg-slate-50 border-none text-sm rounded-lg p-1 outline-none text-slate-500 cursor-pointer">
-              <option>Last 7 Days</option>
-              <option>Last 30 Days</option>
+            <h3 className="font-bold text-slate-900">Revenue Trend</h3>
+            <select
+              value={rangeDays}
+              onChange={(e) => setRangeDays(Number(e.target.value))}
+              className="bg-slate-50 border-none text-sm rounded-lg p-1 outline-none text-slate-500 cursor-pointer"
+            >
+              <option value={7}>Last 7 Days</option>
+              <option value={30}>Last 30 Days</option>
             </select>
           </div>
           <div className="h-80">
@@ -129,58 +230,46 @@ const Dashboard: React.FC = () => {
         </div>
 
         <div className="bg-white p-6 rounded-2xl shadow-sm border border-slate-100">
-          <h3 className="font-bold text-slate-900 mb-6">Order Distribution</h3>
-          <div className="h-64">
-            <ResponsiveContainer width="100%" height="100%">
-              <PieChart>
-                <Pie
-                  data={distributionData}
-                  cx="50%"
-                  cy="50%"
-                  innerRadius={60}
-                  outerRadius={80}
-                  paddingAngle={5}
-                  dataKey="value"
-                >
-                  {distributionData.map((entry, index) => (
-                    <Cell key={`cell-${index}`} fill={COLORS[index % COLORS.length]} />
-                  ))}
-                </Pie>
-                <Tooltip />
-                <Legend verticalAlign="bottom" height={36} iconType="circle" />
-              </PieChart>
-            </ResponsiveContainer>
-          </div>
-          <div className="mt-4 space-y-3">
-            {distributionData.map((item, idx) => (
-              <div key={item.name} className="flex items-center justify-between text-sm">
-                <span className="text-slate-500">{item.name}</span>
-                <span className="font-semibold">{item.value}%</span>
+          <h3 className="font-bold text-slate-900 mb-6">Sales by Category (30 days)</h3>
+          {distributionData.length === 0 ? (
+            <p className="py-8 text-center text-slate-400 text-sm font-medium">No orders in the last 30 days.</p>
+          ) : (
+            <>
+              <div className="h-64">
+                <ResponsiveContainer width="100%" height="100%">
+                  <PieChart>
+                    <Pie
+                      data={distributionData}
+                      cx="50%"
+                      cy="50%"
+                      innerRadius={60}
+                      outerRadius={80}
+                      paddingAngle={5}
+                      dataKey="value"
+                    >
+                      {distributionData.map((entry, index) => (
+                        <Cell key={`cell-${index}`} fill={COLORS[index % COLORS.length]} />
+                      ))}
+                    </Pie>
+                    <Tooltip />
+                    <Legend verticalAlign="bottom" height={36} iconType="circle" />
+                  </PieChart>
+                </ResponsiveContainer>
               </div>
-            ))}
-          </div>
+              <div className="mt-4 space-y-3">
+                {distributionData.map((item, idx) => (
+                  <div key={item.name} className="flex items-center justify-between text-sm">
+                    <span className="text-slate-500">{item.name}</span>
+                    <span className="font-semibold">{item.value}%</span>
+                  </div>
+                ))}
+              </div>
+            </>
+          )}
         </div>
       </div>
 
-      <div className="grid grid-cols-1 lg:grid-cols-2 gap-6">
-        <div className="bg-white p-6 rounded-2xl shadow-sm border border-slate-100">
-          <h3 className="font-bold text-slate-900 mb-6">Deliveries per Area</h3>
-          <div className="h-80">
-            <ResponsiveContainer width="100%" height="100%">
-              <BarChart data={areaDeliveries}>
-                <CartesianGrid strokeDasharray="3 3" vertical={false} stroke="#f1f5f9" />
-                <XAxis dataKey="area" axisLine={false} tickLine={false} tick={{fill: '#94a3b8', fontSize: 12}} />
-                <YAxis axisLine={false} tickLine={false} tick={{fill: '#94a3b8', fontSize: 12}} />
-                <Tooltip 
-                   cursor={{fill: '#f8fafc'}}
-                   contentStyle={{ borderRadius: '12px', border: 'none', boxShadow: '0 10px 15px -3px rgba(0, 0, 0, 0.1)' }}
-                />
-                <Bar dataKey="count" fill="#FFD700" radius={[4, 4, 0, 0]} barSize={40} />
-              </BarChart>
-            </ResponsiveContainer>
-          </div>
-        </div>
-
+      <div className="grid grid-cols-1 gap-6">
         <div className="bg-white p-6 rounded-2xl shadow-sm border border-slate-100">
           <div className="flex items-center justify-between mb-6">
             <h3 className="font-bold text-slate-900">Orders for this Date</h3>
@@ -197,9 +286,9 @@ const Dashboard: React.FC = () => {
                 </tr>
               </thead>
               <tbody className="divide-y divide-slate-100">
-                {filteredOrders.length > 0 ? filteredOrders.slice(0, 5).map((order) => (
+                {orders.length > 0 ? orders.map((order) => (
                   <tr key={order.id} className="hover:bg-slate-50 transition-colors">
-                    <td className="py-4 text-sm font-medium text-slate-900">{order.id}</td>
+                    <td className="py-4 text-sm font-medium text-slate-900">{order.order_number}</td>
                     <td className="py-4 text-sm text-slate-600">{order.customerName}</td>
                     <td className="py-4 text-sm font-semibold">₹{order.total}</td>
                     <td className="py-4">
@@ -47,13 +47,36 @@ interface Delivery {
     delivered_by?: string;
 }
 
+interface InventoryLine {
+    sku: string;
+    milk_type?: string;
+    uom: string;
+    opening: number;
+    received: number;
+    dispatched: number;
+    returned: number;
+    wasted: number;
+    adjusted: number;
+    closing: number;
+}
+
+interface MilkDemand {
+    milk_type: string;
+    product: string;
+    litres: number;
+    price: number;
+    daily_revenue: number;
+}
+
+interface MilkStock {
+    sku: string;
+    stock: number;
+    sold: number;
+}
+
 interface InventoryEntry {
-    id: number;
     date: string;
-    buffalo_stock: number;
-    cow_stock: number;
-    buffalo_sold: number;
-    cow_sold: number;
+    milk: Record<string, MilkStock>;
     wastage: number;
 }
 
@@ -69,17 +92,23 @@ interface AnalyticsData {
     active_subscriptions: number;
     paused_subscriptions: number;
     total_subscriptions: number;
-    buffalo_demand: number;
-    cow_demand: number;
+    demand: MilkDemand[];
     total_demand: number;
-    buffalo_price: number;
-    cow_price: number;
     daily_revenue: number;
     monthly_revenue: number;
     delivered_today: number;
     pending_today: number;
 }
 
+// Card colours, assigned to milk types in catalog order
+const milkPalette = [
+    { card: 'from-blue-600 to-blue-700', text: 'text-blue-600', bar: 'from-blue-500 to-blue-600' },
+    { card: 'from-amber-500 to-amber-600', text: 'text-amber-600', bar: 'from-amber-500 to-amber-600' },
+    { card: 'from-purple-500 to-purple-600', text: 'text-purple-600', bar: 'from-purple-500 to-purple-600' },
+    { card: 'from-emerald-500 to-emerald-600', text: 'text-emerald-600', bar: 'from-emerald-500 to-emerald-600' }
+];
+const milkColor = (i: number) => milkPalette[i % milkPalette.length];
+
 const MilkManagement: React.FC = () => {
     const [mainTab, setMainTab] = useState<'Subscriptions' | 'Inventory' | 'Pricing' | 'Analytics'>('Subscriptions');
     const [subTab, setSubTab] = useState<'List' | 'Today' | 'Routes'>('List');
@@ -90,26 +119,20 @@ const MilkManagement: React.FC = () => {
     // Inventory States
     const [inventory, setInventory] = useState<InventoryEntry[]>([]);
     const [isAddStockModalOpen, setIsAddStockModalOpen] = useState(false);
-    const [newStock, setNewStock] = useState({ buffaloStock: 0, cowStock: 0, date: new Date().toISOString().split('T')[0] });
+    const [newStock, setNewStock] = useState<{ received: Record<string, number>; date: string; key: string }>({ received: {}, date: new Date().toISOString().split('T')[0], key: crypto.randomUUID() });
 
     // Pricing States
-    const [pricing, setPricing] = useState<{ buffalo: { current: number; previous: number; lastUpdated: string }; cow: { current: number; previous: number; lastUpdated: string } }>({
-        buffalo: { current: 90, previous: 85, lastUpdated: '' },
-        cow: { current: 60, previous: 55, lastUpdated: '' }
-    });
+    const [pricing, setPricing] = useState<PricingData[]>([]);
     const [isEditPriceModalOpen, setIsEditPriceModalOpen] = useState(false);
-    const [editingPrices, setEditingPrices] = useState({ buffalo: 90, cow: 60 });
+    const [editingPrices, setEditingPrices] = useState<Record<string, number>>({});
 
     // Analytics States
     const [analytics, setAnalytics] = useState<AnalyticsData>({
         active_subscriptions: 0,
         paused_subscriptions: 0,
         total_subscriptions: 0,
-        buffalo_demand: 0,
-        cow_demand: 0,
+        demand: [],
         total_demand: 0,
-        buffalo_price: 90,
-        cow_price: 60,
         daily_revenue: 0,
         monthly_revenue: 0,
         delivered_today: 0,
@@ -148,8 +171,19 @@ const MilkManagement: React.FC = () => {
         try {
             const res = await fetch(`${API_BASE}/admin/inventory`);
             if (res.ok) {
-                const data = await res.json();
-                setInventory(data);
+                const data: { date: string; lines: InventoryLine[] }[] = await res.json();
+                // The dashboard shows the milk SKUs; on hand is opening plus the day's inflows
+                setInventory(data.map((day) => {
+                    const milk: Record<string, MilkStock> = {};
+                    day.lines.filter(l => l.milk_type).forEach(l => {
+                        milk[l.milk_type!] = { sku: l.sku, stock: l.opening + l.received + l.returned + l.adjusted, sold: l.dispatched };
+                    });
+                    return {
+                        date: day.date,
+                        milk,
+                        wastage: day.lines.filter(l => l.milk_type).reduce((sum, l) => sum + l.wasted, 0)
+                    };
+                }));
             }
         } catch (err) {
             console.error('Error fetching inventory:', err);
@@ -162,12 +196,7 @@ const MilkManagement: React.FC = () => {
             const res = await fetch(`${API_BASE}/admin/pricing`);
             if (res.ok) {
                 const data: PricingData[] = await res.json();
-                const buffalo = data.find(p => p.milk_type === 'buffalo');
-                const cow = data.find(p => p.milk_type === 'cow');
-                setPricing({
-                    buffalo: { current: buffalo?.price || 90, previous: buffalo?.previous_price || 85, lastUpdated: buffalo?.updated_at?.split('T')[0] || '' },
-                    cow: { current: cow?.price || 60, previous: cow?.previous_price || 55, lastUpdated: cow?.updated_at?.split('T')[0] || '' }
-                });
+                setPricing(data);
             }
         } catch (err) {
             console.error('Error fetching pricing:', err);
@@ -179,7 +208,7 @@ const MilkManagement: React.FC = () => {
         try {
             const res = await fetch(`${API_BASE}/admin/analytics`);
             if (res.ok) {
-                const data = await res.json();
+                const data: AnalyticsData = await res.json();
                 setAnalytics(data);
             }
         } catch (err) {
@@ -259,6 +288,11 @@ const MilkManagement: React.FC = () => {
         document.body.removeChild(link);
     };
 
+    const priceOf = (milkType: string) => pricing.find(p => p.milk_type === milkType);
+
+    // Milk stock items are named after the milk type unless the ledger says otherwise
+    const milkSku = (milkType: string) => inventory[0]?.milk[milkType]?.sku || `MILK-${milkType.toUpperCase()}`;
+
     const handleAddStock = async () => {
         try {
             const res = await fetch(`${API_BASE}/admin/inventory`, {
@@ -266,15 +300,16 @@ const MilkManagement: React.FC = () => {
                 headers: { 'Content-Type': 'application/json' },
                 body: JSON.stringify({
                     date: newStock.date,
-                    buffalo_stock: newStock.buffaloStock,
-                    cow_stock: newStock.cowStock,
-                    wastage: 0
+                    idempotency_key: newStock.key,
+                    lines: analytics.demand
+                        .filter(d => newStock.received[d.milk_type])
+                        .map(d => ({ sku: milkSku(d.milk_type), received: newStock.received[d.milk_type] }))
                 })
             });
             if (res.ok) {
                 fetchInventory();
                 setIsAddStockModalOpen(false);
-                setNewStock({ buffaloStock: 0, cowStock: 0, date: new Date().toISOString().split('T')[0] });
+                setNewStock({ received: {}, date: new Date().toISOString().split('T')[0], key: crypto.randomUUID() });
             }
         } catch (err) {
             console.error('Error adding stock:', err);
@@ -283,16 +318,15 @@ const MilkManagement: React.FC = () => {
 
     const handleUpdatePricing = async () => {
         try {
-            await fetch(`${API_BASE}/admin/pricing`, {
-                method: 'PUT',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ milk_type: 'buffalo', price: editingPrices.buffalo })
-            });
-            await fetch(`${API_BASE}/admin/pricing`, {
-                method: 'PUT',
-                headers: { 'Content-Type': 'application/json' },
-                body: JSON.stringify({ milk_type: 'cow', price: editingPrices.cow })
-            });
+            for (const d of analytics.demand) {
+                const price = editingPrices[d.milk_type];
+                if (!price || price === priceOf(d.milk_type)?.price) continue;
+                await fetch(`${API_BASE}/admin/pricing`, {
+                    method: 'PUT',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ milk_type: d.milk_type, price })
+                });
+            }
             fetchPricing();
             fetchAnalytics();
             setIsEditPriceModalOpen(false);
@@ -407,14 +441,12 @@ const MilkManagement: React.FC = () => {
                             <p className="text-xs font-bold opacity-80 uppercase tracking-wider">Paused</p>
                             <p className="text-3xl font-black">{analytics.paused_subscriptions}</p>
                         </div>
-                        <div className="bg-gradient-to-br from-blue-500 to-blue-600 rounded-2xl p-4 text-white">
-                            <p className="text-xs font-bold opacity-80 uppercase tracking-wider">Buffalo (L/day)</p>
-                            <p className="text-3xl font-black">{analytics.buffalo_demand}</p>
-                        </div>
-                        <div className="bg-gradient-to-br from-purple-500 to-purple-600 rounded-2xl p-4 text-white">
-                            <p className="text-xs font-bold opacity-80 uppercase tracking-wider">Cow (L/day)</p>
-                            <p className="text-3xl font-black">{analytics.cow_demand}</p>
-                        </div>
+                        {analytics.demand.map((d, i) => (
+                            <div key={d.milk_type} className={`bg-gradient-to-br ${milkColor(i).card} rounded-2xl p-4 text-white`}>
+                                <p className="text-xs font-bold opacity-80 uppercase tracking-wider">{d.product} (L/day)</p>
+                                <p className="text-3xl font-black">{d.litres}</p>
+                            </div>
+                        ))}
                     </div>
 
                     {subTab === 'List' && (
@@ -581,37 +613,26 @@ const MilkManagement: React.FC = () => {
 
                     {/* Today's Stock Overview */}
                     <div className="grid grid-cols-1 md:grid-cols-3 gap-6">
-                        <div className="bg-gradient-to-br from-blue-600 to-blue-700 rounded-3xl p-6 text-white">
-                            <div className="flex items-center gap-3 mb-4">
-                                <div className="w-12 h-12 bg-white/20 rounded-2xl flex items-center justify-center">
-                                    <span className="text-2xl">🐃</span>
-                                </div>
-                                <div>
-                                    <p className="text-xs font-bold opacity-80 uppercase">Buffalo Milk</p>
-                                    <p className="text-3xl font-black">{inventory[0]?.buffalo_stock || 0}L</p>
-                                </div>
-                            </div>
-                            <div className="flex justify-between text-xs font-bold opacity-80">
-                                <span>Sold: {inventory[0]?.buffalo_sold || 0}L</span>
-                                <span>Available: {(inventory[0]?.buffalo_stock || 0) - (inventory[0]?.buffalo_sold || 0)}L</span>
-                            </div>
-                        </div>
-
-                        <div className="bg-gradient-to-br from-amber-500 to-amber-600 rounded-3xl p-6 text-white">
-                            <div className="flex items-center gap-3 mb-4">
-                                <div className="w-12 h-12 bg-white/20 rounded-2xl flex items-center justify-center">
-                                    <span className="text-2xl">🐄</span>
-                                </div>
-                                <div>
-                                    <p className="text-xs font-bold opacity-80 uppercase">Cow Milk</p>
-                                    <p className="text-3xl font-black">{inventory[0]?.cow_stock || 0}L</p>
+                        {analytics.demand.map((d, i) => {
+                            const stock = inventory[0]?.milk[d.milk_type];
+                            return (
+                                <div key={d.milk_type} className={`bg-gradient-to-br ${milkColor(i).card} rounded-3xl p-6 text-white`}>
+                                    <div className="flex items-center gap-3 mb-4">
+                                        <div className="w-12 h-12 bg-white/20 rounded-2xl flex items-center justify-center">
+                                            <span className="material-symbols-outlined text-2xl">water_drop</span>
+                                        </div>
+                                        <div>
+                                            <p className="text-xs font-bold opacity-80 uppercase">{d.product}</p>
+                                            <p className="text-3xl font-black">{stock?.stock || 0}L</p>
+                                        </div>
+                                    </div>
+                                    <div className="flex justify-between text-xs font-bold opacity-80">
+                                        <span>Sold: {stock?.sold || 0}L</span>
+                                        <span>Available: {(stock?.stock || 0) - (stock?.sold || 0)}L</span>
+                                    </div>
                                 </div>
-                            </div>
-                            <div className="flex justify-between text-xs font-bold opacity-80">
-                                <span>Sold: {inventory[0]?.cow_sold || 0}L</span>
-                                <span>Available: {(inventory[0]?.cow_stock || 0) - (inventory[0]?.cow_sold || 0)}L</span>
-                            </div>
-                        </div>
+                            );
+                        })}
 
                         <div className="bg-gradient-to-br from-red-500 to-red-600 rounded-3xl p-6 text-white">
                             <div className="flex items-center gap-3 mb-4">
@@ -637,25 +658,29 @@ const MilkManagement: React.FC = () => {
                                 <thead className="bg-slate-50">
                                     <tr>
                                         <th className="px-6 py-4 text-left text-[10px] font-black text-slate-400 uppercase tracking-widest">Date</th>
-                                        <th className="px-6 py-4 text-left text-[10px] font-black text-slate-400 uppercase tracking-widest">Buffalo Stock</th>
-                                        <th className="px-6 py-4 text-left text-[10px] font-black text-slate-400 uppercase tracking-widest">Cow Stock</th>
-                                        <th className="px-6 py-4 text-left text-[10px] font-black text-slate-400 uppercase tracking-widest">Buffalo Sold</th>
-                                        <th className="px-6 py-4 text-left text-[10px] font-black text-slate-400 uppercase tracking-widest">Cow Sold</th>
+                                        {analytics.demand.map(d => (
+                                            <th key={`${d.milk_type}-stock`} className="px-6 py-4 text-left text-[10px] font-black text-slate-400 uppercase tracking-widest">{d.product} Stock</th>
+                                        ))}
+                                        {analytics.demand.map(d => (
+                                            <th key={`${d.milk_type}-sold`} className="px-6 py-4 text-left text-[10px] font-black text-slate-400 uppercase tracking-widest">{d.product} Sold</th>
+                                        ))}
                                         <th className="px-6 py-4 text-left text-[10px] font-black text-slate-400 uppercase tracking-widest">Wastage</th>
                                     </tr>
                                 </thead>
                                 <tbody className="divide-y divide-slate-50">
                                     {inventory.length === 0 ? (
                                         <tr>
-                                            <td colSpan={6} className="px-6 py-8 text-center text-slate-400">No inventory records yet</td>
+                                            <td colSpan={2 + analytics.demand.length * 2} className="px-6 py-8 text-center text-slate-400">No inventory records yet</td>
                                         </tr>
                                     ) : inventory.map((row) => (
-                                        <tr key={row.id} className="hover:bg-slate-50/50">
+                                        <tr key={row.date} className="hover:bg-slate-50/50">
                                             <td className="px-6 py-4 text-sm font-bold text-slate-900">{row.date}</td>
-                                            <td className="px-6 py-4 text-sm font-bold text-blue-600">{row.buffalo_stock}L</td>
-                                            <td className="px-6 py-4 text-sm font-bold text-amber-600">{row.cow_stock}L</td>
-                                            <td className="px-6 py-4 text-sm text-slate-600">{row.buffalo_sold}L</td>
-                                            <td className="px-6 py-4 text-sm text-slate-600">{row.cow_sold}L</td>
+                                            {analytics.demand.map((d, i) => (
+                                                <td key={`${d.milk_type}-stock`} className={`px-6 py-4 text-sm font-bold ${milkColor(i).text}`}>{row.milk[d.milk_type]?.stock || 0}L</td>
+                                            ))}
+                                            {analytics.demand.map(d => (
+                                                <td key={`${d.milk_type}-sold`} className="px-6 py-4 text-sm text-slate-600">{row.milk[d.milk_type]?.sold || 0}L</td>
+                                            ))}
                                             <td className="px-6 py-4 text-sm font-bold text-red-500">{row.wastage}L</td>
                                         </tr>
                                     ))}
@@ -673,7 +698,7 @@ const MilkManagement: React.FC = () => {
                         <h2 className="text-lg font-black text-slate-900">Milk Pricing</h2>
                         <button
                             onClick={() => {
-                                setEditingPrices({ buffalo: pricing.buffalo.current, cow: pricing.cow.current });
+                                setEditingPrices(Object.fromEntries(analytics.demand.map(d => [d.milk_type, priceOf(d.milk_type)?.price || d.price])));
                                 setIsEditPriceModalOpen(true);
                             }}
                             className="bg-zepto-blue text-white px-5 py-2.5 rounded-xl font-black shadow-lg hover:bg-black transition-all flex items-center gap-2"
@@ -684,63 +709,40 @@ const MilkManagement: React.FC = () => {
                     </div>
 
                     <div className="grid grid-cols-1 md:grid-cols-2 gap-6">
-                        {/* Buffalo Milk Pricing */}
-                        <div className="bg-white rounded-3xl border border-slate-100 overflow-hidden">
-                            <div className="bg-gradient-to-r from-blue-600 to-blue-700 p-6 text-white">
-                                <div className="flex items-center gap-4">
-                                    <div className="w-16 h-16 bg-white/20 rounded-2xl flex items-center justify-center">
-                                        <span className="text-4xl">🐃</span>
-                                    </div>
-                                    <div>
-                                        <p className="text-sm font-bold opacity-80 uppercase">Buffalo Milk</p>
-                                        <p className="text-4xl font-black">₹{pricing.buffalo.current}<span className="text-lg font-bold opacity-80">/L</span></p>
-                                    </div>
-                                </div>
-                            </div>
-                            <div className="p-6 space-y-4">
-                                <div className="flex justify-between items-center p-4 bg-slate-50 rounded-xl">
-                                    <span className="text-sm font-bold text-slate-500">Previous Price</span>
-                                    <span className="text-sm font-black text-slate-400 line-through">₹{pricing.buffalo.previous}/L</span>
-                                </div>
-                                <div className="flex justify-between items-center p-4 bg-emerald-50 rounded-xl border border-emerald-100">
-                                    <span className="text-sm font-bold text-emerald-600">Change</span>
-                                    <span className="text-sm font-black text-emerald-600">
-                                        {pricing.buffalo.current > pricing.buffalo.previous ? '+' : ''}
-                                        ₹{pricing.buffalo.current - pricing.buffalo.previous}/L
-                                    </span>
-                                </div>
-                                {pricing.buffalo.lastUpdated && <p className="text-xs text-slate-400 text-center">Last updated: {pricing.buffalo.lastUpdated}</p>}
-                            </div>
-                        </div>
-
-                        {/* Cow Milk Pricing */}
-                        <div className="bg-white rounded-3xl border border-slate-100 overflow-hidden">
-                            <div className="bg-gradient-to-r from-amber-500 to-amber-600 p-6 text-white">
-                                <div className="flex items-center gap-4">
-                                    <div className="w-16 h-16 bg-white/20 rounded-2xl flex items-center justify-center">
-                                        <span className="text-4xl">🐄</span>
+                        {analytics.demand.map((d, i) => {
+                            const p = priceOf(d.milk_type);
+                            const current = p?.price || d.price;
+                            const previous = p?.previous_price || 0;
+                            return (
+                                <div key={d.milk_type} className="bg-white rounded-3xl border border-slate-100 overflow-hidden">
+                                    <div className={`bg-gradient-to-r ${milkColor(i).card} p-6 text-white`}>
+                                        <div className="flex items-center gap-4">
+                                            <div className="w-16 h-16 bg-white/20 rounded-2xl flex items-center justify-center">
+                                                <span className="material-symbols-outlined text-4xl">water_drop</span>
+                                            </div>
+                                            <div>
+                                                <p className="text-sm font-bold opacity-80 uppercase">{d.product}</p>
+                                                <p className="text-4xl font-black">₹{current}<span className="text-lg font-bold opacity-80">/L</span></p>
+                                            </div>
+                                        </div>
                                     </div>
-                                    <div>
-                                        <p className="text-sm font-bold opacity-80 uppercase">Cow Milk</p>
-                                        <p className="text-4xl font-black">₹{pricing.cow.current}<span className="text-lg font-bold opacity-80">/L</span></p>
+                                    <div className="p-6 space-y-4">
+                                        <div className="flex justify-between items-center p-4 bg-slate-50 rounded-xl">
+                                            <span className="text-sm font-bold text-slate-500">Previous Price</span>
+                                            <span className="text-sm font-black text-slate-400 line-through">₹{previous}/L</span>
+                                        </div>
+                                        <div className="flex justify-between items-center p-4 bg-emerald-50 rounded-xl border border-emerald-100">
+                                            <span className="text-sm font-bold text-emerald-600">Change</span>
+                                            <span className="text-sm font-black text-emerald-600">
+                                                {current > previous ? '+' : ''}
+                                                ₹{current - previous}/L
+                                            </span>
+                                        </div>
+                                        {p?.updated_at && <p className="text-xs text-slate-400 text-center">Last updated: {p.updated_at.split('T')[0]}</p>}
                                     </div>
                                 </div>
-                            </div>
-                            <div className="p-6 space-y-4">
-                                <div className="flex justify-between items-center p-4 bg-slate-50 rounded-xl">
-                                    <span className="text-sm font-bold text-slate-500">Previous Price</span>
-                                    <span className="text-sm font-black text-slate-400 line-through">₹{pricing.cow.previous}/L</span>
-                                </div>
-                                <div className="flex justify-between items-center p-4 bg-emerald-50 rounded-xl border border-emerald-100">
-                                    <span className="text-sm font-bold text-emerald-600">Change</span>
-                                    <span className="text-sm font-black text-emerald-600">
-                                        {pricing.cow.current > pricing.cow.previous ? '+' : ''}
-                                        ₹{pricing.cow.current - pricing.cow.previous}/L
-                                    </span>
-                                </div>
-                                {pricing.cow.lastUpdated && <p className="text-xs text-slate-400 text-center">Last updated: {pricing.cow.lastUpdated}</p>}
-                            </div>
-                        </div>
+                            );
+                        })}
                     </div>
 
                     {/* Revenue Calculator */}
@@ -750,16 +752,13 @@ const MilkManagement: React.FC = () => {
                             Daily Revenue Estimate
                         </h3>
                         <div className="grid grid-cols-1 md:grid-cols-3 gap-6">
-                            <div className="bg-white/10 rounded-2xl p-4">
-                                <p className="text-xs font-bold opacity-60 uppercase mb-1">Buffalo Revenue</p>
-                                <p className="text-2xl font-black">₹{analytics.buffalo_demand * pricing.buffalo.current}</p>
-                                <p className="text-xs opacity-60">{analytics.buffalo_demand}L × ₹{pricing.buffalo.current}</p>
-                            </div>
-                            <div className="bg-white/10 rounded-2xl p-4">
-                                <p className="text-xs font-bold opacity-60 uppercase mb-1">Cow Revenue</p>
-                                <p className="text-2xl font-black">₹{analytics.cow_demand * pricing.cow.current}</p>
-                                <p className="text-xs opacity-60">{analytics.cow_demand}L × ₹{pricing.cow.current}</p>
-                            </div>
+                            {analytics.demand.map(d => (
+                                <div key={d.milk_type} className="bg-white/10 rounded-2xl p-4">
+                                    <p className="text-xs font-bold opacity-60 uppercase mb-1">{d.product} Revenue</p>
+                                    <p className="text-2xl font-black">₹{d.daily_revenue}</p>
+                                    <p className="text-xs opacity-60">{d.litres}L × ₹{d.price}</p>
+                                </div>
+                            ))}
                             <div className="bg-zepto-yellow/20 rounded-2xl p-4 border border-zepto-yellow/30">
                                 <p className="text-xs font-bold text-zepto-yellow uppercase mb-1">Total Daily Revenue</p>
                                 <p className="text-3xl font-black text-zepto-yellow">₹{analytics.daily_revenue}</p>
@@ -823,30 +822,20 @@ const MilkManagement: React.FC = () => {
                         <div className="bg-white rounded-3xl p-6 border border-slate-100">
                             <h3 className="font-black text-slate-900 mb-6">Milk Type Distribution</h3>
                             <div className="space-y-4">
-                                <div>
-                                    <div className="flex justify-between text-sm mb-2">
-                                        <span className="font-bold text-slate-700">🐃 Buffalo Milk</span>
-                                        <span className="font-black text-blue-600">{analytics.buffalo_demand}L</span>
-                                    </div>
-                                    <div className="h-4 bg-slate-100 rounded-full overflow-hidden">
-                                        <div
-                                            className="h-full bg-gradient-to-r from-blue-500 to-blue-600 rounded-full transition-all duration-500"
-                                            style={{ width: `${analytics.total_demand > 0 ? (analytics.buffalo_demand / analytics.total_demand) * 100 : 0}%` }}
-                                        ></div>
-                                    </div>
-                                </div>
-                                <div>
-                                    <div className="flex justify-between text-sm mb-2">
-                                        <span className="font-bold text-slate-700">🐄 Cow Milk</span>
-                                        <span className="font-black text-amber-600">{analytics.cow_demand}L</span>
-                                    </div>
-                                    <div className="h-4 bg-slate-100 rounded-full overflow-hidden">
-                                        <div
-                                            className="h-full bg-gradient-to-r from-amber-500 to-amber-600 rounded-full transition-all duration-500"
-                                            style={{ width: `${analytics.total_demand > 0 ? (analytics.cow_demand / analytics.total_demand) * 100 : 0}%` }}
-                                        ></div>
+                                {analytics.demand.map((d, i) => (
+                                    <div key={d.milk_type}>
+                                        <div className="flex justify-between text-sm mb-2">
+                                            <span className="font-bold text-slate-700">{d.product}</span>
+                                            <span className={`font-black ${milkColor(i).text}`}>{d.litres}L</span>
+                                        </div>
+                                        <div className="h-4 bg-slate-100 rounded-full overflow-hidden">
+                                            <div
+                                                className={`h-full bg-gradient-to-r ${milkColor(i).bar} rounded-full transition-all duration-500`}
+                                                style={{ width: `${analytics.total_demand > 0 ? (d.litres / analytics.total_demand) * 100 : 0}%` }}
+                                            ></div>
+                                        </div>
                                     </div>
-                                </div>
+                                ))}
                             </div>
                         </div>
 
@@ -894,24 +883,17 @@ const MilkManagement: React.FC = () => {
                                 />
                             </div>
                             <div className="grid grid-cols-2 gap-4">
-                                <div className="space-y-2">
-                                    <label className="text-[10px] font-black text-slate-400 uppercase tracking-widest ml-1">Buffalo Milk (L)</label>
-                                    <input
-                                        type="number"
-                                        value={newStock.buffaloStock}
-                                        onChange={(e) => setNewStock({ ...newStock, buffaloStock: Number(e.target.value) })}
-                                        className="w-full p-4 bg-slate-50 border-2 border-transparent focus:border-zepto-blue transition-all outline-none rounded-2xl font-black"
-                                    />
-                                </div>
-                                <div className="space-y-2">
-                                    <label className="text-[10px] font-black text-slate-400 uppercase tracking-widest ml-1">Cow Milk (L)</label>
-                                    <input
-                                        type="number"
-                                        value={newStock.cowStock}
-                                        onChange={(e) => setNewStock({ ...newStock, cowStock: Number(e.target.value) })}
-                                        className="w-full p-4 bg-slate-50 border-2 border-transparent focus:border-zepto-blue transition-all outline-none rounded-2xl font-black"
-                                    />
-                                </div>
+                                {analytics.demand.map(d => (
+                                    <div key={d.milk_type} className="space-y-2">
+                                        <label className="text-[10px] font-black text-slate-400 uppercase tracking-widest ml-1">{d.product} (L)</label>
+                                        <input
+                                            type="number"
+                                            value={newStock.received[d.milk_type] || 0}
+                                            onChange={(e) => setNewStock({ ...newStock, received: { ...newStock.received, [d.milk_type]: Number(e.target.value) } })}
+                                            className="w-full p-4 bg-slate-50 border-2 border-transparent focus:border-zepto-blue transition-all outline-none rounded-2xl font-black"
+                                        />
+                                    </div>
+                                ))}
                             </div>
                         </div>
 
@@ -936,24 +918,17 @@ const MilkManagement: React.FC = () => {
                         </div>
 
                         <div className="space-y-6">
-                            <div className="space-y-2">
-                                <label className="text-[10px] font-black text-slate-400 uppercase tracking-widest ml-1">🐃 Buffalo Milk (₹/L)</label>
-                                <input
-                                    type="number"
-                                    value={editingPrices.buffalo}
-                                    onChange={(e) => setEditingPrices({ ...editingPrices, buffalo: Number(e.target.value) })}
-                                    className="w-full p-4 bg-slate-50 border-2 border-transparent focus:border-zepto-blue transition-all outline-none rounded-2xl font-black text-2xl"
-                                />
-                            </div>
-                            <div className="space-y-2">
-                                <label className="text-[10px] font-black text-slate-400 uppercase tracking-widest ml-1">🐄 Cow Milk (₹/L)</label>
-                                <input
-                                    type="number"
-                                    value={editingPrices.cow}
-                                    onChange={(e) => setEditingPrices({ ...editingPrices, cow: Number(e.target.value) })}
-                                    className="w-full p-4 bg-slate-50 border-2 border-transparent focus:border-zepto-blue transition-all outline-none rounded-2xl font-black text-2xl"
-                                />
-                            </div>
+                            {analytics.demand.map(d => (
+                                <div key={d.milk_type} className="space-y-2">
+                                    <label className="text-[10px] font-black text-slate-400 uppercase tracking-widest ml-1">{d.product} (₹/L)</label>
+                                    <input
+                                        type="number"
+                                        value={editingPrices[d.milk_type] || 0}
+                                        onChange={(e) => setEditingPrices({ ...editingPrices, [d.milk_type]: Number(e.target.value) })}
+                                        className="w-full p-4 bg-slate-50 border-2 border-transparent focus:border-zepto-blue transition-all outline-none rounded-2xl font-black text-2xl"
+                                    />
+                                </div>
+                            ))}
                         </div>
 
                         <div className="flex gap-4 mt-8">
@@ -37,18 +37,3 @@ export const revenueData = [
   { name: 'Sat', revenue: 72000 },
   { name: 'Sun', revenue: 68000 },
 ];
-
-export const distributionData = [
-  { name: 'Milk', value: 45 },
-  { name: 'Meat', value: 25 },
-  { name: 'Fruits', value: 15 },
-  { name: 'Vegetables', value: 15 },
-];
-
-export const areaDeliveries = [
-  { area: 'Indiranagar', count: 120 },
-  { area: 'Koramangala', count: 95 },
-  { area: 'HSR Layout', count: 85 },
-  { area: 'Whitefield', count: 70 },
-  { area: 'Jayanagar', count: 110 },
-];
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ============================================================================
+// TIME-SERIES ANALYTICS
+// ============================================================================
+//
+// Metrics over a date range bucketed by day, week (starting Monday) or month,
+// computed from deliveries, subscriptions, their audit trail and orders:
+//   - delivered litres and subscription revenue at the price in force on each
+//     delivery's date for the customer's zone and segment, plus delivered
+//     one-time order revenue;
+//   - active, paused (including suspended for funds) and cancelled
+//     subscriptions at the end of each bucket, replayed from
+//     subscription_events (subscriptions older than the trail fall back to
+//     their current status);
+//   - new customers (first subscription or order in the bucket) and churn
+//     (cancellations, other than a subscription replaced by a new one, against
+//     subscriptions active at the bucket start);
+//   - skip rate (customer skips and cancellations over scheduled stops) and
+//     on-time rate (delivered before the end of the delivery window that
+//     applied to the stop).
+//
+// Routes (admin):
+//   /api/admin/analytics/timeseries   GET ?from=YYYY-MM-DD&to=YYYY-MM-DD&granularity=day|week|month
+
+const (
+	GranularityDay   = "day"
+	GranularityWeek  = "week"
+	GranularityMonth = "month"
+	// analyticsMaxBuckets bounds a single request
+	analyticsMaxBuckets = 400
+)
+
+var (
+	errAnalyticsRange   = errors.New("from and to must be YYYY-MM-DD with from <= to")
+	errAnalyticsTooLong = errors.New("range too long for this granularity")
+)
+
+// AnalyticsBucket holds the metrics for one period
+type AnalyticsBucket struct {
+	Start                  string  `json:"start"`
+	End                    string  `json:"end"`
+	DeliveredLitres        float64 `json:"delivered_litres"`
+	Deliveries             int     `json:"deliveries"`
+	SubscriptionRevenue    float64 `json:"subscription_revenue"`
+	OrderRevenue           float64 `json:"order_revenue"`
+	Revenue                float64 `json:"revenue"`
+	ActiveSubscriptions    int     `json:"active_subscriptions"`
+	PausedSubscriptions    int     `json:"paused_subscriptions"`
+	CancelledSubscriptions int     `json:"cancelled_subscriptions"`
+	NewCustomers           int     `json:"new_customers"`
+	Churned                int     `json:"churned"`
+	ChurnRate              float64 `json:"churn_rate"`
+	ScheduledStops         int     `json:"scheduled_stops"`
+	Skipped                int     `json:"skipped"`
+	SkipRate               float64 `json:"skip_rate"`
+	OnTime                 int     `json:"on_time"`
+	OnTimeRate             float64 `json:"on_time_rate"`
+
+	activeAtStart int
+	timed         int
+}
+
+// bucketStart truncates a date to the start of its bucket
+func bucketStart(t time.Time, granularity string) time.Time {
+	t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch granularity {
+	case GranularityWeek:
+		return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+// nextBucket returns the start of the bucket after the one starting at t
+func nextBucket(t time.Time, granularity string) time.Time {
+	switch granularity {
+	case GranularityWeek:
+		return t.AddDate(0, 0, 7)
+	case GranularityMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// analyticsBuckets lays out the buckets covering [from, to]; the first and last are clipped to the range
+func analyticsBuckets(from, to time.Time, granularity string) []AnalyticsBucket {
+	buckets := []AnalyticsBucket{}
+	for start := bucketStart(from, granularity); !start.After(to); start = nextBucket(start, granularity) {
+		b := AnalyticsBucket{Start: start.Format("2006-01-02"), End: nextBucket(start, granularity).AddDate(0, 0, -1).Format("2006-01-02")}
+		if start.Before(from) {
+			b.Start = from.Format("2006-01-02")
+		}
+		if b.End > to.Format("2006-01-02") {
+			b.End = to.Format("2006-01-02")
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets
+}
+
+// analyticsRange reads ?from=&to= (default: the last 30 days up to today). The
+// span is bounded by the number of buckets it covers rather than in days, so a
+// weekly or monthly series can reach back several years.
+func analyticsRange(r *http.Request, granularity string) (time.Time, time.Time, error) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, errAnalyticsRange
+		}
+		from = to.AddDate(0, 0, -29)
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, errAnalyticsRange
+		}
+	}
+	if from.After(to) {
+		return from, to, errAnalyticsRange
+	}
+	n := 0
+	for start := bucketStart(from, granularity); !start.After(to); start = nextBucket(start, granularity) {
+		if n++; n > analyticsMaxBuckets {
+			return from, to, errAnalyticsTooLong
+		}
+	}
+	return from, to, nil
+}
+
+// subscriptionStatusSQL is the status of subscription ms just before the timestamp
+// expression at: the after-state of its last audit event by then, else the
+// before-state of its first event after (a subscription cancelled later was still
+// live), else its current status
+func subscriptionStatusSQL(at string) string {
+	return `COALESCE(
+		(SELECT e.after_state->>'status' FROM subscription_events e
+		 WHERE e.subscription_id = ms.id AND e.after_state IS NOT NULL AND e.created_at < ` + at + `
+		 ORDER BY e.created_at DESC, e.id DESC LIMIT 1),
+		(SELECT e.before_state->>'status' FROM subscription_events e
+		 WHERE e.subscription_id = ms.id AND e.before_state IS NOT NULL AND e.created_at >= ` + at + `
+		 ORDER BY e.created_at, e.id LIMIT 1),
+		ms.status)`
+}
+
+// subscriptionStatusAt is the status of every subscription at the end of a day
+var subscriptionStatusAt = `
+	SELECT ms.id, ` + subscriptionStatusSQL("$1::date + 1") + ` AS status
+	FROM milk_subscriptions ms WHERE ms.created_at < $1::date + 1`
+
+// loadAnalytics fills the buckets for [from, to]
+func loadAnalytics(from, to time.Time, granularity string) ([]AnalyticsBucket, error) {
+	from, to = bucketStart(from, GranularityDay), bucketStart(to, GranularityDay)
+	buckets := analyticsBuckets(from, to, granularity)
+	index := map[string]int{}
+	for i, b := range buckets {
+		index[b.Start] = i
+	}
+	// bucketOf maps a day to its bucket; the first bucket may start after its natural start
+	bucketOf := func(day time.Time) *AnalyticsBucket {
+		key := bucketStart(day, granularity).Format("2006-01-02")
+		if key < buckets[0].Start {
+			key = buckets[0].Start
+		}
+		if i, ok := index[key]; ok {
+			return &buckets[i]
+		}
+		return nil
+	}
+	fromStr, toStr := from.Format("2006-01-02"), to.Format("2006-01-02")
+
+	// Deliveries per day: volume, revenue at historical prices, skips and punctuality
+	rows, err := db.Query(`
+		SELECT d.delivery_date,
+		       COALESCE(SUM(d.quantity) FILTER (WHERE d.status = $3), 0),
+		       COUNT(*) FILTER (WHERE d.status = $3),
+		       COALESCE(SUM(d.quantity * p.price) FILTER (WHERE d.status = $3), 0),
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE d.status = $4 OR d.status = $5),
+		       COUNT(*) FILTER (WHERE d.status = $3 AND dw.end_time IS NOT NULL),
+		       COUNT(*) FILTER (WHERE d.status = $3 AND d.delivered_at <= d.delivery_date + dw.end_time)
+		FROM deliveries d
+		LEFT JOIN milk_subscriptions ms ON ms.id = d.subscription_id
+		LEFT JOIN addresses a ON a.id = ms.address_id
+		LEFT JOIN users u ON u.id = d.user_id
+		LEFT JOIN LATERAL (
+			SELECT h.price FROM price_history h
+			WHERE h.milk_type = d.milk_type AND h.effective_from <= d.delivery_date
+			  AND (h.effective_to IS NULL OR h.effective_to > d.delivery_date)
+			  AND (h.zone_id IS NULL OR h.zone_id = COALESCE(a.zone_id, 0))
+			  AND (h.segment IS NULL OR h.segment = COALESCE(u.price_segment, ''))
+			ORDER BY (h.zone_id IS NOT NULL) DESC, (h.segment IS NOT NULL) DESC LIMIT 1
+		) p ON true
+		LEFT JOIN LATERAL (
+			SELECT w.end_time FROM delivery_windows w
+			WHERE w.slot_type = d.slot_type AND (w.zone_id IS NULL OR w.zone_id = a.zone_id)
+			  AND (w.date IS NULL OR w.date = d.delivery_date)
+			  AND (w.day_of_week IS NULL OR w.day_of_week = EXTRACT(DOW FROM d.delivery_date))
+			ORDER BY (w.date IS NOT NULL) DESC, (w.day_of_week IS NOT NULL) DESC, (w.zone_id IS NOT NULL) DESC LIMIT 1
+		) dw ON true
+		WHERE d.delivery_date BETWEEN $1 AND $2
+		  AND NOT (d.status = $5 AND COALESCE(d.failure_reason, '') IN ($6, $7))
+		GROUP BY d.delivery_date
+	`, fromStr, toStr, DeliveryDelivered, DeliverySkipped, DeliveryCancelled, reasonInsufficientFunds, reasonSubscriptionStopped)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day time.Time
+		var litres, revenue float64
+		var delivered, scheduled, skipped, timed, onTime int
+		rows.Scan(&day, &litres, &delivered, &revenue, &scheduled, &skipped, &timed, &onTime)
+		if b := bucketOf(day); b != nil {
+			b.DeliveredLitres += litres
+			b.Deliveries += delivered
+			b.SubscriptionRevenue += revenue
+			b.ScheduledStops += scheduled
+			b.Skipped += skipped
+			b.timed += timed
+			b.OnTime += onTime
+		}
+	}
+	rows.Close()
+
+	// Delivered one-time orders, on the day they were delivered
+	rows, err = db.Query(`
+		SELECT delivered_at::date, SUM(total) FROM orders
+		WHERE status = $3 AND delivered_at::date BETWEEN $1 AND $2 GROUP BY 1
+	`, fromStr, toStr, OrderDelivered)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day time.Time
+		var revenue float64
+		rows.Scan(&day, &revenue)
+		if b := bucketOf(day); b != nil {
+			b.OrderRevenue += revenue
+		}
+	}
+	rows.Close()
+
+	// New customers: first subscription or order
+	rows, err = db.Query(`
+		SELECT first::date, COUNT(*) FROM (
+			SELECT user_id, MIN(created_at) AS first FROM (
+				SELECT user_id, created_at FROM milk_subscriptions
+				UNION ALL
+				SELECT user_id, created_at FROM orders WHERE status <> $3
+			) activity GROUP BY user_id
+		) f WHERE first::date BETWEEN $1 AND $2 GROUP BY 1
+	`, fromStr, toStr, OrderCancelled)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day time.Time
+		var n int
+		rows.Scan(&day, &n)
+		if b := bucketOf(day); b != nil {
+			b.NewCustomers += n
+		}
+	}
+	rows.Close()
+
+	// Cancellations, except subscriptions replaced by a new one from the same customer
+	rows, err = db.Query(`
+		SELECT e.created_at::date, COUNT(DISTINCT e.subscription_id) FROM subscription_events e
+		JOIN milk_subscriptions ms ON ms.id = e.subscription_id
+		WHERE e.event_type = $3 AND e.created_at::date BETWEEN $1 AND $2
+		  AND ms.cancel_reason IS DISTINCT FROM $4
+		GROUP BY 1
+	`, fromStr, toStr, SubEventCancelled, churnReplaced)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day time.Time
+		var n int
+		rows.Scan(&day, &n)
+		if b := bucketOf(day); b != nil {
+			b.Churned += n
+		}
+	}
+	rows.Close()
+
+	// Subscription states at the end of each bucket and active ones at its start
+	for i := range buckets {
+		b := &buckets[i]
+		start, _ := time.Parse("2006-01-02", b.Start)
+		err := db.QueryRow(`
+			SELECT COUNT(*) FILTER (WHERE status = 'Active'),
+			       COUNT(*) FILTER (WHERE status IN ('Paused', $2)),
+			       COUNT(*) FILTER (WHERE status = 'Cancelled')
+			FROM (`+subscriptionStatusAt+`) s`, b.End, SubStatusSuspendedNoFunds).
+			Scan(&b.ActiveSubscriptions, &b.PausedSubscriptions, &b.CancelledSubscriptions)
+		if err != nil {
+			return nil, err
+		}
+		err = db.QueryRow(`SELECT COUNT(*) FILTER (WHERE status = 'Active') FROM (`+subscriptionStatusAt+`) s`,
+			start.AddDate(0, 0, -1).Format("2006-01-02")).Scan(&b.activeAtStart)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range buckets {
+		b := &buckets[i]
+		b.DeliveredLitres = roundMoney(b.DeliveredLitres)
+		b.SubscriptionRevenue = roundMoney(b.SubscriptionRevenue)
+		b.OrderRevenue = roundMoney(b.OrderRevenue)
+		b.Revenue = roundMoney(b.SubscriptionRevenue + b.OrderRevenue)
+		if b.activeAtStart > 0 {
+			b.ChurnRate = roundQty(float64(b.Churned) / float64(b.activeAtStart))
+		}
+		if b.ScheduledStops > 0 {
+			b.SkipRate = roundQty(float64(b.Skipped) / float64(b.ScheduledStops))
+		}
+		if b.timed > 0 {
+			b.OnTimeRate = roundQty(float64(b.OnTime) / float64(b.timed))
+		}
+	}
+	return buckets, nil
+}
+
+// adminAnalyticsTimeseriesHandler returns bucketed metrics over a date range
+func adminAnalyticsTimeseriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	granularity := r.URL.Query().Get("granularity")
+	switch granularity {
+	case "":
+		granularity = GranularityDay
+	case GranularityDay, GranularityWeek, GranularityMonth:
+	default:
+		http.Error(w, "granularity must be day, week or month", http.StatusBadRequest)
+		return
+	}
+	from, to, err := analyticsRange(r, granularity)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	buckets, err := loadAnalytics(from, to, granularity)
+	if err != nil {
+		log.Println("Error computing analytics:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Range totals; subscription counts are those at the end of the range
+	var totals AnalyticsBucket
+	var activeAtStart, timed int
+	for i, b := range buckets {
+		totals.DeliveredLitres += b.DeliveredLitres
+		totals.Deliveries += b.Deliveries
+		totals.SubscriptionRevenue += b.SubscriptionRevenue
+		totals.OrderRevenue += b.OrderRevenue
+		totals.NewCustomers += b.NewCustomers
+		totals.Churned += b.Churned
+		totals.ScheduledStops += b.ScheduledStops
+		totals.Skipped += b.Skipped
+		totals.OnTime += b.OnTime
+		timed += b.timed
+		if i == 0 {
+			activeAtStart = b.activeAtStart
+		}
+	}
+	if len(buckets) > 0 {
+		last := buckets[len(buckets)-1]
+		totals.Start, totals.End = buckets[0].Start, last.End
+		totals.ActiveSubscriptions, totals.PausedSubscriptions, totals.CancelledSubscriptions =
+			last.ActiveSubscriptions, last.PausedSubscriptions, last.CancelledSubscriptions
+	}
+	totals.DeliveredLitres = roundMoney(totals.DeliveredLitres)
+	totals.SubscriptionRevenue = roundMoney(totals.SubscriptionRevenue)
+	totals.OrderRevenue = roundMoney(totals.OrderRevenue)
+	totals.Revenue = roundMoney(totals.SubscriptionRevenue + totals.OrderRevenue)
+	if activeAtStart > 0 {
+		totals.ChurnRate = roundQty(float64(totals.Churned) / float64(activeAtStart))
+	}
+	if totals.ScheduledStops > 0 {
+		totals.SkipRate = roundQty(float64(totals.Skipped) / float64(totals.ScheduledStops))
+	}
+	if timed > 0 {
+		totals.OnTimeRate = roundQty(float64(totals.OnTime) / float64(timed))
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"granularity": granularity,
+		"series":      buckets,
+		"totals":      totals,
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBucketStart(t *testing.T) {
+	tests := []struct {
+		date        string
+		granularity string
+		want        string
+	}{
+		{"2024-05-15", GranularityDay, "2024-05-15"},
+		{"2024-05-15", "", "2024-05-15"},
+		{"2024-05-13", GranularityWeek, "2024-05-13"}, // Monday
+		{"2024-05-15", GranularityWeek, "2024-05-13"},
+		{"2024-05-19", GranularityWeek, "2024-05-13"}, // Sunday ends the week
+		{"2024-01-03", GranularityWeek, "2024-01-01"},
+		{"2023-01-01", GranularityWeek, "2022-12-26"}, // across a year boundary
+		{"2024-05-01", GranularityMonth, "2024-05-01"},
+		{"2024-05-31", GranularityMonth, "2024-05-01"},
+		{"2024-02-29", GranularityMonth, "2024-02-01"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.granularity+" "+tt.date, func(t *testing.T) {
+			d, _ := time.Parse("2006-01-02", tt.date)
+			if got := bucketStart(d.Add(15*time.Hour), tt.granularity).Format("2006-01-02"); got != tt.want {
+				t.Errorf("bucketStart(%s, %q) = %s, want %s", tt.date, tt.granularity, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyticsBuckets(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    string
+		granularity string
+		want        [][2]string
+	}{
+		{"days", "2024-05-30", "2024-06-01", GranularityDay,
+			[][2]string{{"2024-05-30", "2024-05-30"}, {"2024-05-31", "2024-05-31"}, {"2024-06-01", "2024-06-01"}}},
+		{"weeks clipped at both ends", "2024-05-15", "2024-05-22", GranularityWeek,
+			[][2]string{{"2024-05-15", "2024-05-19"}, {"2024-05-20", "2024-05-22"}}},
+		{"months clipped at both ends", "2024-01-31", "2024-03-10", GranularityMonth,
+			[][2]string{{"2024-01-31", "2024-01-31"}, {"2024-02-01", "2024-02-29"}, {"2024-03-01", "2024-03-10"}}},
+		{"single day", "2024-05-15", "2024-05-15", GranularityMonth, [][2]string{{"2024-05-15", "2024-05-15"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, _ := time.Parse("2006-01-02", tt.from)
+			to, _ := time.Parse("2006-01-02", tt.to)
+			got := analyticsBuckets(from, to, tt.granularity)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d buckets, want %d", len(got), len(tt.want))
+			}
+			for i, b := range got {
+				if b.Start != tt.want[i][0] || b.End != tt.want[i][1] {
+					t.Errorf("bucket %d = %s..%s, want %s..%s", i, b.Start, b.End, tt.want[i][0], tt.want[i][1])
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyticsRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		granularity string
+		wantErr     error
+	}{
+		{"default last 30 days", "", GranularityDay, nil},
+		{"400 days", "?from=2023-01-01&to=2024-02-04", GranularityDay, nil},
+		{"401 days", "?from=2023-01-01&to=2024-02-05", GranularityDay, errAnalyticsTooLong},
+		{"five years weekly", "?from=2019-01-01&to=2023-12-31", GranularityWeek, nil},
+		{"ten years weekly", "?from=2014-01-01&to=2023-12-31", GranularityWeek, errAnalyticsTooLong},
+		{"twenty years monthly", "?from=2004-01-01&to=2023-12-31", GranularityMonth, nil},
+		{"from after to", "?from=2024-02-01&to=2024-01-01", GranularityDay, errAnalyticsRange},
+		{"bad date", "?from=01-01-2024", GranularityDay, errAnalyticsRange},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := analyticsRange(httptest.NewRequest("GET", "/api/admin/analytics/timeseries"+tt.query, nil), tt.granularity)
+			if err != tt.wantErr {
+				t.Errorf("analyticsRange(%q) error = %v, want %v", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,622 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// MONTHLY BILLING
+// ============================================================================
+//
+// At each cycle end every Delivered delivery that hasn't been billed yet is
+// rated at the price in effect on its delivery date and written to an invoice.
+// Invoices and their lines are immutable once written (enforced by triggers);
+// a delivery can only ever appear on one invoice line, so re-running a cycle
+// only picks up deliveries that were completed late. Deliveries already paid
+// from the wallet by auto-pay are still supplies on the tax invoice, but as
+// prepaid lines that don't count towards the amount due.
+//
+// A one-time order gets an invoice of its own when it is delivered, with a
+// line per item and one for its fees; the cycle also picks up delivered
+// orders that don't have one yet.
+
+type Invoice struct {
+	ID              int           `json:"id"`
+	InvoiceNumber   string        `json:"invoice_number"`
+	InvoiceDate     string        `json:"invoice_date"`
+	SellerGSTIN     string        `json:"seller_gstin,omitempty"`
+	UserID          int           `json:"user_id"`
+	CustomerName    string        `json:"customer_name,omitempty"`
+	CustomerAddress string        `json:"customer_address,omitempty"`
+	PeriodStart     string        `json:"period_start"`
+	PeriodEnd       string        `json:"period_end"`
+	TaxableValue    float64       `json:"taxable_value"`
+	CGST            float64       `json:"cgst"`
+	SGST            float64       `json:"sgst"`
+	OrderID         int           `json:"order_id,omitempty"`
+	Total           float64       `json:"total"`
+	AmountDue       float64       `json:"amount_due"`
+	DeliveredDays   int           `json:"delivered_days"`
+	SkippedCount    int           `json:"skipped_count"`
+	Status          string        `json:"status"`
+	CreatedAt       string        `json:"created_at"`
+	Lines           []InvoiceLine `json:"lines,omitempty"`
+}
+
+type InvoiceLine struct {
+	DeliveryID   int     `json:"delivery_id,omitempty"`
+	OrderItemID  int     `json:"order_item_id,omitempty"`
+	DeliveryDate string  `json:"delivery_date"`
+	SlotType     string  `json:"slot_type,omitempty"`
+	MilkType     string  `json:"milk_type,omitempty"`
+	Description  string  `json:"description,omitempty"`
+	Category     string  `json:"category"`
+	HSNCode      string  `json:"hsn_code"`
+	Quantity     float64 `json:"quantity"`
+	Rate         float64 `json:"rate"`
+	TaxableValue float64 `json:"taxable_value"`
+	GSTRate      float64 `json:"gst_rate"`
+	CGST         float64 `json:"cgst"`
+	SGST         float64 `json:"sgst"`
+	Amount       float64 `json:"amount"` // tax-inclusive
+	Prepaid      bool    `json:"prepaid,omitempty"`
+}
+
+func initBillingSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS invoices (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id),
+			period_start DATE NOT NULL,
+			period_end DATE NOT NULL,
+			total DECIMAL(10,2) NOT NULL,
+			delivered_days INTEGER NOT NULL DEFAULT 0,
+			skipped_count INTEGER NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'Issued',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_invoices_user ON invoices(user_id, period_start);
+
+		CREATE TABLE IF NOT EXISTS invoice_lines (
+			id SERIAL PRIMARY KEY,
+			invoice_id INTEGER REFERENCES invoices(id),
+			delivery_id INTEGER UNIQUE REFERENCES deliveries(id),
+			delivery_date DATE NOT NULL,
+			slot_type TEXT NOT NULL,
+			milk_type TEXT NOT NULL,
+			quantity DECIMAL(6,2) NOT NULL,
+			rate DECIMAL(8,2) NOT NULL,
+			amount DECIMAL(10,2) NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_invoice_lines_invoice ON invoice_lines(invoice_id);
+
+		CREATE OR REPLACE FUNCTION invoice_immutable() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' OR TG_TABLE_NAME = 'invoice_lines' THEN
+				RAISE EXCEPTION 'invoices are immutable';
+			END IF;
+			IF NEW.user_id IS DISTINCT FROM OLD.user_id
+			   OR NEW.period_start <> OLD.period_start OR NEW.period_end <> OLD.period_end
+			   OR NEW.total <> OLD.total THEN
+				RAISE EXCEPTION 'invoices are immutable';
+			END IF;
+			RETURN NEW;
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS invoices_immutable ON invoices;
+		CREATE TRIGGER invoices_immutable BEFORE UPDATE OR DELETE ON invoices
+			FOR EACH ROW EXECUTE FUNCTION invoice_immutable();
+		DROP TRIGGER IF EXISTS invoice_lines_immutable ON invoice_lines;
+		CREATE TRIGGER invoice_lines_immutable BEFORE UPDATE OR DELETE ON invoice_lines
+			FOR EACH ROW EXECUTE FUNCTION invoice_immutable();
+
+		ALTER TABLE invoices ADD COLUMN IF NOT EXISTS amount_due DECIMAL(10,2);
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS prepaid BOOLEAN NOT NULL DEFAULT false;
+	`)
+	if err != nil {
+		log.Fatal("Failed to create billing schema:", err)
+	}
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// billingPeriod returns the first and last day of the calendar month containing t
+func billingPeriod(t time.Time) (start, end time.Time) {
+	start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, -1)
+}
+
+// previousMonth returns the first day of the month before t. AddDate(0, -1, 0) would
+// normalise 31 March to 3 March and bill the wrong month.
+func previousMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// runBillingCycle invoices every user with unbilled Delivered deliveries in the period.
+// Returns the ids of invoices created.
+func runBillingCycle(periodStart, periodEnd time.Time) ([]int, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT d.user_id FROM deliveries d
+		LEFT JOIN invoice_lines il ON il.delivery_id = d.id
+		WHERE d.status = 'Delivered' AND d.delivery_date BETWEEN $1 AND $2 AND il.id IS NULL AND d.user_id IS NOT NULL
+	`, periodStart, periodEnd)
+	if err != nil {
+		return nil, err
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		userIDs = append(userIDs, id)
+	}
+	rows.Close()
+
+	var created []int
+	for _, userID := range userIDs {
+		id, err := billUser(userID, periodStart, periodEnd)
+		if err != nil {
+			log.Printf("Billing failed for user %d: %v", userID, err)
+			continue
+		}
+		if id != 0 {
+			created = append(created, id)
+		}
+	}
+
+	// Orders are invoiced as they're delivered; catch the ones that weren't
+	rows, err = db.Query(`
+		SELECT o.id FROM orders o
+		WHERE o.status = $1 AND COALESCE(o.delivered_at, o.updated_at)::date BETWEEN $2 AND $3
+		  AND NOT EXISTS (SELECT 1 FROM invoices i WHERE i.order_id = o.id)
+	`, OrderDelivered, periodStart, periodEnd)
+	if err != nil {
+		return created, err
+	}
+	var orderIDs []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		orderIDs = append(orderIDs, id)
+	}
+	rows.Close()
+	for _, orderID := range orderIDs {
+		id, err := billOrder(orderID)
+		if err != nil {
+			log.Printf("Billing failed for order %d: %v", orderID, err)
+			continue
+		}
+		if id != 0 {
+			created = append(created, id)
+		}
+	}
+	return created, nil
+}
+
+// billUser writes one invoice for a user's unbilled deliveries in the period (0 if nothing to bill)
+func billUser(userID int, periodStart, periodEnd time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Serialise concurrent runs for the same user
+	if _, err := tx.Exec("SELECT id FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT d.id, d.delivery_date, d.slot_type, d.milk_type, d.quantity,
+		       EXISTS (SELECT 1 FROM wallet_transactions wt WHERE wt.delivery_id = d.id AND wt.type = $4)
+		FROM deliveries d
+		LEFT JOIN invoice_lines il ON il.delivery_id = d.id
+		WHERE d.user_id = $1 AND d.status = 'Delivered' AND d.delivery_date BETWEEN $2 AND $3 AND il.id IS NULL
+		ORDER BY d.delivery_date, d.slot_type
+	`, userID, periodStart, periodEnd, WalletDebit)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct {
+		line InvoiceLine
+		date time.Time
+	}
+	var items []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.line.DeliveryID, &p.date, &p.line.SlotType, &p.line.MilkType, &p.line.Quantity, &p.line.Prepaid); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		p.line.DeliveryDate = p.date.Format("2006-01-02")
+		items = append(items, p)
+	}
+	rows.Close()
+	if len(items) == 0 {
+		return 0, nil
+	}
+
+	var total, due float64
+	days := map[string]bool{}
+	scope := userPriceScope(tx, userID)
+	hsnByMilk := map[string]HSNCode{}
+	for i := range items {
+		rate, err := lookupPrice(tx, items[i].line.MilkType, items[i].date, scope)
+		if err != nil {
+			return 0, fmt.Errorf("no price for %s on %s: %w", items[i].line.MilkType, items[i].line.DeliveryDate, err)
+		}
+		items[i].line.Rate = rate
+		items[i].line.Amount = roundMoney(rate * items[i].line.Quantity)
+		h, ok := hsnByMilk[items[i].line.MilkType]
+		if !ok {
+			h = lookupMilkHSN(tx, items[i].line.MilkType)
+			hsnByMilk[items[i].line.MilkType] = h
+		}
+		applyGST(&items[i].line, h)
+		total += items[i].line.Amount
+		if !items[i].line.Prepaid {
+			due += items[i].line.Amount
+		}
+		days[items[i].line.DeliveryDate] = true
+	}
+
+	var skipped int
+	tx.QueryRow(`
+		SELECT COUNT(*) FROM deliveries WHERE user_id = $1 AND status = 'Skipped' AND delivery_date BETWEEN $2 AND $3
+	`, userID, periodStart, periodEnd).Scan(&skipped)
+
+	issued := time.Now()
+	number, err := nextInvoiceNumber(tx, issued)
+	if err != nil {
+		return 0, err
+	}
+
+	var invoiceID int
+	err = tx.QueryRow(`
+		INSERT INTO invoices (user_id, period_start, period_end, total, amount_due, delivered_days, skipped_count,
+		                      invoice_number, invoice_date, seller_gstin)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
+	`, userID, periodStart, periodEnd, roundMoney(total), roundMoney(due), len(days), skipped,
+		number, issued.Format("2006-01-02"), sellerInfo().GSTIN).Scan(&invoiceID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, p := range items {
+		_, err := tx.Exec(`
+			INSERT INTO invoice_lines (invoice_id, delivery_id, delivery_date, slot_type, milk_type, quantity, rate, amount,
+			                           category, hsn_code, gst_rate, taxable_value, cgst, sgst, prepaid)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		`, invoiceID, p.line.DeliveryID, p.date, p.line.SlotType, p.line.MilkType, p.line.Quantity, p.line.Rate, p.line.Amount,
+			p.line.Category, p.line.HSNCode, p.line.GSTRate, p.line.TaxableValue, p.line.CGST, p.line.SGST, p.line.Prepaid)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return invoiceID, tx.Commit()
+}
+
+// billOrder invoices one delivered order in its own transaction
+func billOrder(orderID int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	id, err := invoiceOrder(tx, orderID)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// invoiceOrder writes the tax invoice of a delivered order inside the caller's transaction
+// (0 if it already has one). Items are classified by product, or by species for advance
+// bookings; lines are prepaid once the order is paid, which COD is by delivery.
+func invoiceOrder(q dbExecer, orderID int) (int, error) {
+	var userID int
+	var date time.Time
+	var fees, total float64
+	var paid, invoiced bool
+	err := q.QueryRow(`
+		SELECT user_id, COALESCE(delivered_at, NOW())::date, delivery_fee + platform_fee, total, payment_status = $2,
+		       EXISTS (SELECT 1 FROM invoices WHERE order_id = o.id)
+		FROM orders o WHERE id = $1 FOR UPDATE
+	`, orderID, OrderPaid).Scan(&userID, &date, &fees, &total, &paid, &invoiced)
+	if err != nil || invoiced {
+		return 0, err
+	}
+
+	rows, err := q.Query(`
+		SELECT oi.id, COALESCE(oi.product_id, 0), oi.name, COALESCE(oi.variant_name, ''), COALESCE(oi.category, ''),
+		       COALESCE(oi.species, p.species, ''), oi.quantity, oi.unit_price, oi.line_total
+		FROM order_items oi LEFT JOIN products p ON p.id = oi.product_id
+		WHERE oi.order_id = $1 ORDER BY oi.id
+	`, orderID)
+	if err != nil {
+		return 0, err
+	}
+	type item struct {
+		line      InvoiceLine
+		productID int
+		species   string
+	}
+	var items []item
+	for rows.Next() {
+		var it item
+		var variant string
+		if err := rows.Scan(&it.line.OrderItemID, &it.productID, &it.line.Description, &variant, &it.line.Category,
+			&it.species, &it.line.Quantity, &it.line.Rate, &it.line.Amount); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if variant != "" {
+			it.line.Description += " - " + variant
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+
+	lines := make([]InvoiceLine, 0, len(items)+1)
+	for _, it := range items {
+		applyGST(&it.line, lookupProductHSN(q, it.productID, it.line.Category, it.species))
+		lines = append(lines, it.line)
+	}
+	if fees > 0 {
+		l := InvoiceLine{Description: "Delivery and platform fees", Quantity: 1, Rate: fees, Amount: fees}
+		applyGST(&l, lookupHSN(q, CategoryFees))
+		lines = append(lines, l)
+	}
+	due := 0.0
+	for i := range lines {
+		lines[i].DeliveryDate, lines[i].Prepaid = date.Format("2006-01-02"), paid
+		if !paid {
+			due += lines[i].Amount
+		}
+	}
+
+	issued := time.Now()
+	number, err := nextInvoiceNumber(q, issued)
+	if err != nil {
+		return 0, err
+	}
+	var invoiceID int
+	err = q.QueryRow(`
+		INSERT INTO invoices (user_id, order_id, period_start, period_end, total, amount_due, delivered_days, skipped_count,
+		                      invoice_number, invoice_date, seller_gstin)
+		VALUES ($1, $2, $3, $3, $4, $5, 1, 0, $6, $7, $8) RETURNING id
+	`, userID, orderID, date, total, roundMoney(due), number, issued.Format("2006-01-02"), sellerInfo().GSTIN).Scan(&invoiceID)
+	if err != nil {
+		return 0, err
+	}
+	for _, l := range lines {
+		var itemArg interface{}
+		if l.OrderItemID != 0 {
+			itemArg = l.OrderItemID
+		}
+		_, err := q.Exec(`
+			INSERT INTO invoice_lines (invoice_id, order_item_id, delivery_date, description, quantity, rate, amount,
+			                           category, hsn_code, gst_rate, taxable_value, cgst, sgst, prepaid)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		`, invoiceID, itemArg, date, l.Description, l.Quantity, l.Rate, l.Amount,
+			l.Category, l.HSNCode, l.GSTRate, l.TaxableValue, l.CGST, l.SGST, l.Prepaid)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return invoiceID, nil
+}
+
+// startBillingScheduler bills the previous month shortly after each cycle end.
+// Runs are idempotent, so checking hourly is safe.
+func startBillingScheduler() {
+	go func() {
+		for {
+			start, end := billingPeriod(previousMonth(time.Now()))
+			if ids, err := runBillingCycle(start, end); err != nil {
+				log.Println("Billing cycle failed:", err)
+			} else if len(ids) > 0 {
+				log.Printf("Billing cycle %s: %d invoices created", start.Format("2006-01"), len(ids))
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func loadInvoice(id int) (*Invoice, error) {
+	var inv Invoice
+	err := db.QueryRow(`
+		SELECT i.id, COALESCE(i.invoice_number, ''), COALESCE(i.invoice_date::text, ''), COALESCE(i.seller_gstin, ''),
+		       COALESCE(i.user_id, 0), COALESCE(u.name, ''), COALESCE(a.full_address, ''),
+		       COALESCE(i.order_id, 0), i.period_start::text, i.period_end::text, i.total, COALESCE(i.amount_due, i.total),
+		       i.delivered_days, i.skipped_count, i.status, i.created_at::text
+		FROM invoices i
+		LEFT JOIN users u ON i.user_id = u.id
+		LEFT JOIN LATERAL (
+			SELECT full_address FROM addresses WHERE user_id = i.user_id ORDER BY is_default DESC, id LIMIT 1
+		) a ON true
+		WHERE i.id = $1
+	`, id).Scan(&inv.ID, &inv.InvoiceNumber, &inv.InvoiceDate, &inv.SellerGSTIN, &inv.UserID, &inv.CustomerName, &inv.CustomerAddress,
+		&inv.OrderID, &inv.PeriodStart, &inv.PeriodEnd, &inv.Total, &inv.AmountDue, &inv.DeliveredDays, &inv.SkippedCount, &inv.Status, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT COALESCE(delivery_id, 0), COALESCE(order_item_id, 0), delivery_date::text, COALESCE(slot_type, ''),
+		       COALESCE(milk_type, ''), COALESCE(description, ''), quantity, rate, amount, COALESCE(category, ''),
+		       COALESCE(hsn_code, ''), COALESCE(gst_rate, 0), COALESCE(taxable_value, 0), COALESCE(cgst, 0), COALESCE(sgst, 0), prepaid
+		FROM invoice_lines WHERE invoice_id = $1 ORDER BY delivery_date, slot_type, id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	inv.Lines = []InvoiceLine{}
+	for rows.Next() {
+		var l InvoiceLine
+		rows.Scan(&l.DeliveryID, &l.OrderItemID, &l.DeliveryDate, &l.SlotType, &l.MilkType, &l.Description, &l.Quantity, &l.Rate,
+			&l.Amount, &l.Category, &l.HSNCode, &l.GSTRate, &l.TaxableValue, &l.CGST, &l.SGST, &l.Prepaid)
+		if l.HSNCode == "" {
+			// Lines billed before GST breakup was recorded, all subscription milk
+			applyGST(&l, lookupMilkHSN(db, l.MilkType))
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+	sumGST(&inv)
+	return &inv, nil
+}
+
+func listInvoices(userID int, period string) ([]Invoice, error) {
+	rows, err := db.Query(`
+		SELECT i.id, COALESCE(i.invoice_number, ''), COALESCE(i.invoice_date::text, ''), COALESCE(i.user_id, 0), COALESCE(u.name, ''),
+		       COALESCE(i.order_id, 0), i.period_start::text, i.period_end::text, i.total, COALESCE(i.amount_due, i.total),
+		       i.delivered_days, i.skipped_count, i.status, i.created_at::text
+		FROM invoices i LEFT JOIN users u ON i.user_id = u.id
+		WHERE ($1 = 0 OR i.user_id = $1) AND ($2 = '' OR to_char(i.period_start, 'YYYY-MM') = $2)
+		ORDER BY i.period_start DESC, i.id DESC
+	`, userID, period)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []Invoice{}
+	for rows.Next() {
+		var inv Invoice
+		rows.Scan(&inv.ID, &inv.InvoiceNumber, &inv.InvoiceDate, &inv.UserID, &inv.CustomerName, &inv.OrderID, &inv.PeriodStart, &inv.PeriodEnd,
+			&inv.Total, &inv.AmountDue, &inv.DeliveredDays, &inv.SkippedCount, &inv.Status, &inv.CreatedAt)
+		invoices = append(invoices, inv)
+	}
+	return invoices, nil
+}
+
+// invoicesHandler lists the signed-in user's invoices, or returns one with its lines when ?id= is given
+// (?format=pdf downloads it as a PDF)
+func invoicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if id, _ := strconv.Atoi(r.URL.Query().Get("id")); id > 0 {
+		inv, err := loadInvoice(id)
+		if err == sql.ErrNoRows || (err == nil && inv.UserID != userID) {
+			http.Error(w, "Invoice not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error fetching invoice:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		writeInvoice(w, r, inv)
+		return
+	}
+
+	invoices, err := listInvoices(userID, r.URL.Query().Get("period"))
+	if err != nil {
+		log.Println("Error fetching invoices:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(invoices)
+}
+
+// adminInvoicesHandler lists invoices (filter by ?user_id= and ?period=YYYY-MM) or returns one by ?id=
+func adminInvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	if id, _ := strconv.Atoi(q.Get("id")); id > 0 {
+		inv, err := loadInvoice(id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Invoice not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error fetching invoice:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		writeInvoice(w, r, inv)
+		return
+	}
+
+	userID, _ := strconv.Atoi(q.Get("user_id"))
+	invoices, err := listInvoices(userID, q.Get("period"))
+	if err != nil {
+		log.Println("Error fetching invoices:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(invoices)
+}
+
+// adminRunBillingHandler runs a billing cycle on demand, for {"period": "YYYY-MM"} (default: last month)
+func adminRunBillingHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Period string `json:"period"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	month := previousMonth(time.Now())
+	if req.Period != "" {
+		t, err := time.Parse("2006-01", req.Period)
+		if err != nil {
+			http.Error(w, "period must be YYYY-MM", http.StatusBadRequest)
+			return
+		}
+		month = t
+	}
+	start, end := billingPeriod(month)
+	if !time.Now().After(end.AddDate(0, 0, 1)) {
+		http.Error(w, "Billing period has not ended yet", http.StatusConflict)
+		return
+	}
+
+	ids, err := runBillingCycle(start, end)
+	if err != nil {
+		log.Println("Error running billing:", err)
+		http.Error(w, "Failed to run billing", http.StatusInternalServerError)
+		return
+	}
+	if ids == nil {
+		ids = []int{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":      true,
+		"period_start": start.Format("2006-01-02"),
+		"period_end":   end.Format("2006-01-02"),
+		"invoice_ids":  ids,
+	})
+}
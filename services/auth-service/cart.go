@@ -0,0 +1,492 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// CART
+// ============================================================================
+//
+// The cart is stored server-side so it follows the customer across devices.
+// Signed-in carts belong to the user; guests get a random cart token
+// (X-Cart-Token header) that is merged into the account cart when they log
+// in. Items keep the price seen when they were added, but every read
+// re-prices them against the live catalog and flags anything that changed
+// price, went out of stock, became unavailable or sat in the cart too long.
+//
+//   GET    /api/cart          priced cart
+//   DELETE /api/cart          clear
+//   POST   /api/cart/items    add {product_id, variant_id, quantity} (adds to an existing line)
+//   PUT    /api/cart/items    set quantity (0 removes)
+//   DELETE /api/cart/items    remove ?product_id=&variant_id=&options=
+
+const cartTokenHeader = "X-Cart-Token"
+
+var (
+	errCartNotFound = errors.New("cart not found")
+	errCartQuantity = errors.New("quantity must be between 1 and 50")
+)
+
+// CartItem is a cart line as of now, with its status against the catalog
+type CartItem struct {
+	ProductID   int      `json:"product_id"`
+	VariantID   int      `json:"variant_id,omitempty"`
+	Name        string   `json:"name"`
+	VariantName string   `json:"variant_name,omitempty"`
+	Image       string   `json:"image,omitempty"`
+	Weight      string   `json:"weight,omitempty"`
+	Quantity    int      `json:"quantity"`
+	UnitPrice   float64  `json:"unit_price"`
+	PriceAtAdd  float64  `json:"price_at_add"`
+	LineTotal   float64  `json:"line_total"`
+	Options     []string `json:"options,omitempty"`
+	Prep        string   `json:"prep,omitempty"`
+	// Status is ok, price_changed, expired or one of the CartIssue codes
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+	Available int    `json:"available,omitempty"`
+	AddedAt   string `json:"added_at"`
+}
+
+type Cart struct {
+	Items       []CartItem `json:"items"`
+	ItemTotal   float64    `json:"item_total"`
+	DeliveryFee float64    `json:"delivery_fee"`
+	PlatformFee float64    `json:"platform_fee"`
+	Total       float64    `json:"total"`
+	HasIssues   bool       `json:"has_issues"`
+	CartToken   string     `json:"cart_token,omitempty"`
+}
+
+// cartOwner identifies a cart: a signed-in user, or a guest token
+type cartOwner struct {
+	UserID     int
+	GuestToken string
+}
+
+// cartItemTTL is how long an item can sit in a cart before it's flagged as expired
+func cartItemTTL() time.Duration {
+	if days, err := strconv.Atoi(os.Getenv("CART_ITEM_TTL_DAYS")); err == nil && days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return 7 * 24 * time.Hour
+}
+
+func initCartSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS carts (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+			guest_token TEXT UNIQUE,
+			claimed_email TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK (user_id IS NOT NULL OR guest_token IS NOT NULL)
+		);
+		CREATE INDEX IF NOT EXISTS idx_carts_claimed_email ON carts(claimed_email) WHERE claimed_email IS NOT NULL;
+
+		CREATE TABLE IF NOT EXISTS cart_items (
+			id SERIAL PRIMARY KEY,
+			cart_id INTEGER NOT NULL REFERENCES carts(id) ON DELETE CASCADE,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			variant_id INTEGER NOT NULL DEFAULT 0,
+			quantity INTEGER NOT NULL CHECK (quantity > 0),
+			price_at_add DECIMAL(10,2) NOT NULL DEFAULT 0,
+			added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(cart_id, product_id, variant_id)
+		);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create cart schema:", err)
+	}
+}
+
+func newCartToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// cartID finds the owner's cart, creating it when create is set (guests without a token get a new one)
+func cartID(q dbExecer, owner *cartOwner, create bool) (int, error) {
+	var id int
+	var err error
+	if owner.UserID != 0 {
+		err = q.QueryRow("SELECT id FROM carts WHERE user_id = $1", owner.UserID).Scan(&id)
+	} else if owner.GuestToken != "" {
+		err = q.QueryRow("SELECT id FROM carts WHERE guest_token = $1", owner.GuestToken).Scan(&id)
+	} else {
+		err = sql.ErrNoRows
+	}
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+	if !create {
+		return 0, errCartNotFound
+	}
+
+	if owner.UserID != 0 {
+		err = q.QueryRow(`INSERT INTO carts (user_id) VALUES ($1)
+			ON CONFLICT (user_id) DO UPDATE SET updated_at = NOW() RETURNING id`, owner.UserID).Scan(&id)
+	} else {
+		if owner.GuestToken == "" {
+			owner.GuestToken = newCartToken()
+		}
+		err = q.QueryRow(`INSERT INTO carts (guest_token) VALUES ($1)
+			ON CONFLICT (guest_token) DO UPDATE SET updated_at = NOW() RETURNING id`, owner.GuestToken).Scan(&id)
+	}
+	return id, err
+}
+
+// currentUnitPrice is the price a line would be charged now, or 0 if it can't be priced
+func currentUnitPrice(q dbExecer, userID int, item CartItemRequest) float64 {
+	item.Quantity = 1
+	lines, _, err := priceCartItems(q, userID, []CartItemRequest{item})
+	if err != nil || len(lines) == 0 {
+		return 0
+	}
+	return lines[0].UnitPrice
+}
+
+// setCartItem adds to (add=true) or sets the quantity of a line; a resulting quantity of 0 removes it
+func setCartItem(owner *cartOwner, item CartItemRequest, add bool) error {
+	if item.Quantity < 0 || (add && item.Quantity == 0) || item.Quantity > maxLineQuantity {
+		return errCartQuantity
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := cartID(tx, owner, true)
+	if err != nil {
+		return err
+	}
+
+	// Lines are keyed by their normalized preparation options
+	options, err := prepKey(tx, item.ProductID, item.Options)
+	if err != nil {
+		if !errors.Is(err, errPrepInvalid) || item.Quantity != 0 {
+			return err
+		}
+		// The product's options changed since the line was added; remove it by its stored key
+		options = strings.Join(item.Options, ",")
+	}
+
+	if !add && item.Quantity == 0 {
+		_, err = tx.Exec("DELETE FROM cart_items WHERE cart_id = $1 AND product_id = $2 AND variant_id = $3 AND options = $4",
+			id, item.ProductID, item.VariantID, options)
+	} else {
+		if _, err := loadProduct(tx, item.ProductID); err != nil {
+			return err
+		}
+		price := currentUnitPrice(tx, owner.UserID, item)
+		_, err = tx.Exec(`
+			INSERT INTO cart_items (cart_id, product_id, variant_id, options, quantity, price_at_add)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (cart_id, product_id, variant_id, options) DO UPDATE SET
+				quantity = LEAST(CASE WHEN $7 THEN cart_items.quantity + EXCLUDED.quantity ELSE EXCLUDED.quantity END, $8),
+				updated_at = NOW()
+		`, id, item.ProductID, item.VariantID, options, item.Quantity, price, add, maxLineQuantity)
+	}
+	if err != nil {
+		return err
+	}
+	tx.Exec("UPDATE carts SET updated_at = NOW() WHERE id = $1", id)
+	return tx.Commit()
+}
+
+func clearCart(q dbExecer, owner *cartOwner) error {
+	id, err := cartID(q, owner, false)
+	if err == errCartNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	_, err = q.Exec("DELETE FROM cart_items WHERE cart_id = $1", id)
+	return err
+}
+
+// cartItemRequests returns the raw lines of a cart, for checkout
+func cartItemRequests(q dbExecer, owner *cartOwner) ([]CartItemRequest, error) {
+	items := []CartItemRequest{}
+	id, err := cartID(q, owner, false)
+	if err == errCartNotFound {
+		return items, nil
+	} else if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query("SELECT product_id, variant_id, quantity, options FROM cart_items WHERE cart_id = $1 ORDER BY added_at, id", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var it CartItemRequest
+		var options string
+		rows.Scan(&it.ProductID, &it.VariantID, &it.Quantity, &options)
+		if options != "" {
+			it.Options = strings.Split(options, ",")
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// loadCart re-prices every line against the catalog and flags the ones that can't be ordered as-is
+func loadCart(owner *cartOwner) (*Cart, error) {
+	cart := &Cart{Items: []CartItem{}}
+	id, err := cartID(db, owner, false)
+	if err == errCartNotFound {
+		return cart, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT ci.product_id, ci.variant_id, ci.quantity, ci.options, ci.price_at_add, ci.added_at, ci.updated_at,
+		       COALESCE(p.name, ''), COALESCE(p.image, ''), COALESCE(p.weight, '')
+		FROM cart_items ci LEFT JOIN products p ON ci.product_id = p.id
+		WHERE ci.cart_id = $1 ORDER BY ci.added_at, ci.id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	type storedItem struct {
+		CartItem
+		touched time.Time
+	}
+	var stored []storedItem
+	for rows.Next() {
+		var s storedItem
+		var added time.Time
+		var options string
+		rows.Scan(&s.ProductID, &s.VariantID, &s.Quantity, &options, &s.PriceAtAdd, &added, &s.touched, &s.Name, &s.Image, &s.Weight)
+		if options != "" {
+			s.Options = strings.Split(options, ",")
+		}
+		s.AddedAt = added.Format(time.RFC3339)
+		stored = append(stored, s)
+	}
+	rows.Close()
+
+	ttl := cartItemTTL()
+	for _, s := range stored {
+		item := s.CartItem
+		lines, issues, err := priceCartItems(db, owner.UserID, []CartItemRequest{{ProductID: item.ProductID, VariantID: item.VariantID,
+			Quantity: item.Quantity, Options: item.Options}})
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(issues) > 0:
+			item.Status, item.Message, item.Available = issues[0].Code, issues[0].Message, issues[0].Available
+		case time.Since(s.touched) > ttl:
+			item.Status, item.Message = "expired", "this item has been in your cart a while; please confirm it"
+			item.UnitPrice, item.LineTotal, item.VariantName = lines[0].UnitPrice, lines[0].LineTotal, lines[0].VariantName
+		default:
+			l := lines[0]
+			item.Name, item.VariantName, item.Image, item.Weight, item.Prep = l.Name, l.VariantName, l.Image, l.Weight, l.PrepNote
+			item.UnitPrice, item.LineTotal = l.UnitPrice, l.LineTotal
+			item.Status = "ok"
+			if item.PriceAtAdd != 0 && item.PriceAtAdd != l.UnitPrice {
+				item.Status = "price_changed"
+			}
+			cart.ItemTotal += l.LineTotal
+		}
+		if item.Status != "ok" && item.Status != "price_changed" {
+			cart.HasIssues = true
+		}
+		cart.Items = append(cart.Items, item)
+	}
+
+	cart.ItemTotal = roundMoney(cart.ItemTotal)
+	cart.DeliveryFee, cart.PlatformFee = computeOrderFees(cart.ItemTotal, "standard")
+	cart.Total = roundMoney(cart.ItemTotal + cart.DeliveryFee + cart.PlatformFee)
+	cart.CartToken = owner.GuestToken
+	return cart, nil
+}
+
+// mergeGuestCart moves a guest cart into the user's cart, adding quantities of
+// lines present in both. The guest cart is removed in the same transaction.
+func mergeGuestCart(guestToken string, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The row lock makes a concurrent merge of the same cart wait and then find it gone
+	var guestID int
+	err = tx.QueryRow("SELECT id FROM carts WHERE guest_token = $1 AND user_id IS NULL FOR UPDATE", guestToken).Scan(&guestID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	userCartID, err := cartID(tx, &cartOwner{UserID: userID}, true)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO cart_items (cart_id, product_id, variant_id, options, quantity, price_at_add, added_at)
+		SELECT $1, product_id, variant_id, options, quantity, price_at_add, added_at FROM cart_items WHERE cart_id = $2
+		ON CONFLICT (cart_id, product_id, variant_id, options) DO UPDATE SET
+			quantity = LEAST(cart_items.quantity + EXCLUDED.quantity, $3),
+			updated_at = NOW()
+	`, userCartID, guestID, maxLineQuantity)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM carts WHERE id = $1", guestID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// claimGuestCart is called after OTP verification. Items the client kept locally are
+// added to the guest cart first. Existing users get the cart merged straight away;
+// for new users the cart is tagged with their email and adopted on registration.
+func claimGuestCart(email, guestToken string, localItems []CartItemRequest) {
+	if guestToken == "" && len(localItems) == 0 {
+		return
+	}
+	owner := &cartOwner{GuestToken: guestToken}
+	for _, it := range localItems {
+		if err := setCartItem(owner, it, true); err != nil {
+			log.Printf("Skipping guest cart item %d for %s: %v", it.ProductID, email, err)
+		}
+	}
+	if owner.GuestToken == "" {
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", email).Scan(&userID); err != nil {
+		db.Exec("UPDATE carts SET claimed_email = $1 WHERE guest_token = $2 AND user_id IS NULL", email, owner.GuestToken)
+		return
+	}
+	if err := mergeGuestCart(owner.GuestToken, userID); err != nil {
+		log.Println("Error merging guest cart:", err)
+	}
+}
+
+// adoptClaimedCarts merges carts claimed at login into a freshly registered account
+func adoptClaimedCarts(email string) {
+	rows, err := db.Query("SELECT guest_token FROM carts WHERE claimed_email = $1 AND user_id IS NULL", email)
+	if err != nil {
+		log.Println("Error loading claimed carts:", err)
+		return
+	}
+	var tokens []string
+	for rows.Next() {
+		var t string
+		rows.Scan(&t)
+		tokens = append(tokens, t)
+	}
+	rows.Close()
+	if len(tokens) == 0 {
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", email).Scan(&userID); err != nil {
+		return
+	}
+	for _, t := range tokens {
+		if err := mergeGuestCart(t, userID); err != nil {
+			log.Println("Error merging guest cart:", err)
+		}
+	}
+}
+
+func cartErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errCartQuantity), errors.Is(err, errPrepInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errProductNotFound), errors.Is(err, errCartNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// --- Handlers ---
+
+// cartRouter serves signed-in users (JWT) and guests (X-Cart-Token, or none yet)
+func cartRouter(w http.ResponseWriter, r *http.Request) {
+	_, cookieErr := r.Cookie("taaza_token")
+	if r.Header.Get("Authorization") != "" || cookieErr == nil {
+		authMiddleware(cartHandler, false)(w, r)
+		return
+	}
+	cartHandler(w, r)
+}
+
+func cartHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	owner := &cartOwner{GuestToken: strings.TrimSpace(r.Header.Get(cartTokenHeader))}
+	if email, _ := r.Context().Value("email").(string); email != "" {
+		if err := db.QueryRow("SELECT id FROM users WHERE email = $1", email).Scan(&owner.UserID); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		owner.GuestToken = ""
+	}
+
+	itemsPath := strings.TrimSuffix(r.URL.Path, "/") == "/api/cart/items"
+	var err error
+	switch {
+	case !itemsPath && r.Method == "GET":
+	case !itemsPath && r.Method == "DELETE":
+		err = clearCart(db, owner)
+	case itemsPath && (r.Method == "POST" || r.Method == "PUT"):
+		var item CartItemRequest
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		err = setCartItem(owner, item, r.Method == "POST")
+	case itemsPath && r.Method == "DELETE":
+		productID, _ := strconv.Atoi(r.URL.Query().Get("product_id"))
+		variantID, _ := strconv.Atoi(r.URL.Query().Get("variant_id"))
+		item := CartItemRequest{ProductID: productID, VariantID: variantID}
+		if options := r.URL.Query().Get("options"); options != "" {
+			item.Options = strings.Split(options, ",")
+		}
+		err = setCartItem(owner, item, false)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if cartErrorStatus(err) == http.StatusInternalServerError {
+			log.Println("Error updating cart:", err)
+		}
+		http.Error(w, err.Error(), cartErrorStatus(err))
+		return
+	}
+
+	cart, err := loadCart(owner)
+	if err != nil {
+		log.Println("Error loading cart:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(cart)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ============================================================================
+// DELIVERY LIFECYCLE
+// ============================================================================
+
+// Delivery statuses
+const (
+	DeliveryScheduled      = "Scheduled"
+	DeliveryPacked         = "Packed"
+	DeliveryOutForDelivery = "OutForDelivery"
+	DeliveryDelivered      = "Delivered"
+	DeliveryFailed         = "Failed"
+	DeliverySkipped        = "Skipped"
+	DeliveryCancelled      = "Cancelled"
+)
+
+// deliveryTransitions lists, for each status, the statuses it may move to.
+// Delivered, Failed and Cancelled are terminal; a Skipped delivery can be un-skipped.
+var deliveryTransitions = map[string][]string{
+	DeliveryScheduled:      {DeliveryPacked, DeliverySkipped, DeliveryCancelled},
+	DeliveryPacked:         {DeliveryOutForDelivery, DeliveryCancelled},
+	DeliveryOutForDelivery: {DeliveryDelivered, DeliveryFailed},
+	DeliverySkipped:        {DeliveryScheduled},
+	DeliveryDelivered:      {},
+	DeliveryFailed:         {},
+	DeliveryCancelled:      {},
+}
+
+// Failure reason codes (required when a delivery moves to Failed)
+var deliveryFailureReasons = map[string]string{
+	"customer_unavailable": "Customer unavailable",
+	"gate_locked":          "Gate locked",
+	"spilled":              "Spilled",
+}
+
+var (
+	errDeliveryNotFound     = errors.New("delivery not found")
+	errIllegalTransition    = errors.New("illegal delivery status transition")
+	errInvalidFailureReason = errors.New("a valid reason_code is required for failed deliveries")
+	errUnknownStatus        = errors.New("unknown delivery status")
+)
+
+// deliveryErrorStatus maps a lifecycle error to the HTTP status it should be reported as
+func deliveryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errDeliveryNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errIllegalTransition):
+		return http.StatusConflict
+	case errors.Is(err, errInvalidFailureReason), errors.Is(err, errUnknownStatus):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func canTransitionDelivery(from, to string) bool {
+	for _, next := range deliveryTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+type DeliveryStatusEvent struct {
+	ID         int    `json:"id"`
+	DeliveryID int    `json:"delivery_id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	ReasonCode string `json:"reason_code,omitempty"`
+	Note       string `json:"note,omitempty"`
+	ActorEmail string `json:"actor_email"`
+	ActorRole  string `json:"actor_role"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// deliveryTransition describes a requested status change
+type deliveryTransition struct {
+	To          string
+	ReasonCode  string
+	Note        string
+	DeliveredBy string
+	ActorEmail  string
+	ActorRole   string
+	// OwnerID, when non-zero, restricts the change to deliveries belonging to that user
+	OwnerID int
+	// RiderID, when non-zero, restricts the change to deliveries assigned to that rider
+	RiderID int
+	// Optional proof of delivery captured by the rider
+	ProofPhotoURL string
+	Latitude      *float64
+	Longitude     *float64
+}
+
+func initDeliveryLifecycleSchema() {
+	_, err := db.Exec(`
+		ALTER TABLE deliveries ALTER COLUMN status SET DEFAULT 'Scheduled';
+		ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS failure_reason TEXT;
+		ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS status_updated_at TIMESTAMP;
+
+		CREATE TABLE IF NOT EXISTS delivery_status_events (
+			id SERIAL PRIMARY KEY,
+			delivery_id INTEGER REFERENCES deliveries(id) ON DELETE CASCADE,
+			from_status TEXT,
+			to_status TEXT NOT NULL,
+			reason_code TEXT,
+			note TEXT,
+			actor_email TEXT,
+			actor_role TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_delivery_status_events_delivery ON delivery_status_events(delivery_id, created_at);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create delivery lifecycle schema:", err)
+	}
+
+	// Legacy rows were created as 'Pending' before the lifecycle existed
+	db.Exec("UPDATE deliveries SET status = 'Scheduled' WHERE status = 'Pending'")
+}
+
+// transitionDelivery moves a delivery to a new status if the lifecycle allows it,
+// stamping the change and recording it in delivery_status_events.
+func transitionDelivery(id int, t deliveryTransition) error {
+	if _, ok := deliveryTransitions[t.To]; !ok {
+		return errUnknownStatus
+	}
+	if t.To == DeliveryFailed {
+		if _, ok := deliveryFailureReasons[t.ReasonCode]; !ok {
+			return errInvalidFailureReason
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var from string
+	var ownerID, riderID int
+	err = tx.QueryRow("SELECT status, COALESCE(user_id, 0), COALESCE(rider_id, 0) FROM deliveries WHERE id = $1 FOR UPDATE", id).
+		Scan(&from, &ownerID, &riderID)
+	if err == sql.ErrNoRows ||
+		(err == nil && t.OwnerID != 0 && ownerID != t.OwnerID) ||
+		(err == nil && t.RiderID != 0 && riderID != t.RiderID) {
+		return errDeliveryNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if !canTransitionDelivery(from, t.To) {
+		return errIllegalTransition
+	}
+
+	switch t.To {
+	case DeliveryDelivered:
+		_, err = tx.Exec(`
+			UPDATE deliveries SET status = $1, delivered_at = NOW(), delivered_by = $2, status_updated_at = NOW()
+			WHERE id = $3
+		`, t.To, t.DeliveredBy, id)
+	case DeliveryFailed:
+		_, err = tx.Exec(`
+			UPDATE deliveries SET status = $1, failure_reason = $2, status_updated_at = NOW()
+			WHERE id = $3
+		`, t.To, t.ReasonCode, id)
+	default:
+		_, err = tx.Exec("UPDATE deliveries SET status = $1, status_updated_at = NOW() WHERE id = $2", t.To, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if t.ProofPhotoURL != "" || t.Latitude != nil || t.Longitude != nil {
+		_, err = tx.Exec(`
+			UPDATE deliveries SET proof_photo_url = COALESCE(NULLIF($1, ''), proof_photo_url),
+			       proof_latitude = COALESCE($2, proof_latitude), proof_longitude = COALESCE($3, proof_longitude)
+			WHERE id = $4
+		`, t.ProofPhotoURL, t.Latitude, t.Longitude, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t.To == DeliveryDelivered {
+		if err := debitWalletForDelivery(tx, id); err != nil {
+			return err
+		}
+		if err := recordDeliveryDispatch(tx, id, t.ActorEmail); err != nil {
+			return err
+		}
+	}
+	if t.To == DeliveryFailed && t.ReasonCode == "spilled" {
+		if err := recordDeliverySpill(tx, id, t.ActorEmail); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO delivery_status_events (delivery_id, from_status, to_status, reason_code, note, actor_email, actor_role)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7)
+	`, id, from, t.To, t.ReasonCode, t.Note, t.ActorEmail, t.ActorRole)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if t.To == DeliveryFailed && t.ReasonCode == "spilled" {
+		var date string
+		db.QueryRow("SELECT delivery_date::text FROM deliveries WHERE id = $1", id).Scan(&date)
+		go evaluateWastageThresholds(date)
+	}
+	return nil
+}
+
+// adminDeliveryHistoryHandler returns the status timeline of a single delivery
+func adminDeliveryHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	if id == 0 {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT id, delivery_id, COALESCE(from_status, ''), to_status, COALESCE(reason_code, ''), COALESCE(note, ''),
+		       COALESCE(actor_email, ''), COALESCE(actor_role, ''), created_at::text
+		FROM delivery_status_events WHERE delivery_id = $1 ORDER BY created_at, id
+	`, id)
+	if err != nil {
+		log.Println("Error fetching delivery history:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	events := []DeliveryStatusEvent{}
+	for rows.Next() {
+		var e DeliveryStatusEvent
+		rows.Scan(&e.ID, &e.DeliveryID, &e.FromStatus, &e.ToStatus, &e.ReasonCode, &e.Note,
+			&e.ActorEmail, &e.ActorRole, &e.CreatedAt)
+		events = append(events, e)
+	}
+	json.NewEncoder(w).Encode(events)
+}
+
+// deliveryLifecycleHandler describes the lifecycle so clients can render allowed actions
+func deliveryLifecycleHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"transitions":     deliveryTransitions,
+		"failure_reasons": deliveryFailureReasons,
+	})
+}
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// DELIVERY SLOTS
+// ============================================================================
+//
+// Delivery windows (Morning/Evening) are defined per zone and day with a
+// capacity in stops and/or litres. A window row can apply to every day, a
+// weekday or one date, and to every zone or one zone; the most specific row
+// wins. Capacity use counts subscription deliveries, open one-time orders
+// and unexpired checkout holds; an order's litres are the milk on its lines,
+// worked out from stock item pack sizes rather than taken from the client.
+// Checkout holds a slot for a few minutes (SLOT_HOLD_MINUTES, default 10) for
+// one delivery address and the milk in the customer's cart, against the
+// capacity of that address's zone; placing the order to the same address
+// confirms the hold and an abandoned hold simply expires.
+
+// Slot hold states
+const (
+	HoldHeld      = "held"
+	HoldConfirmed = "confirmed"
+	HoldReleased  = "released"
+	HoldExpired   = "expired"
+)
+
+var (
+	errSlotFull        = errors.New("this delivery slot is full")
+	errSlotClosed      = errors.New("this delivery slot is no longer taking orders")
+	errNoWindow        = errors.New("no delivery window for this day and slot")
+	errHoldNotFound    = errors.New("slot reservation not found")
+	errHoldExpired     = errors.New("slot reservation has expired; please pick a slot again")
+	errHoldAddress     = errors.New("slot reservation was made for another delivery address; please pick a slot again")
+	errWindowInvalid   = errors.New("slot_type must be Morning or Evening with start before end")
+	errWindowNotFound  = errors.New("delivery window not found")
+	errTooManySlotDays = errors.New("days must be between 1 and 14")
+)
+
+func slotErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errHoldNotFound), errors.Is(err, errWindowNotFound), errors.Is(err, errAddressNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errSlotFull), errors.Is(err, errSlotClosed), errors.Is(err, errHoldExpired), errors.Is(err, errHoldAddress):
+		return http.StatusConflict
+	case errors.Is(err, errNoWindow), errors.Is(err, errSlotInvalid), errors.Is(err, errWindowInvalid),
+		errors.Is(err, errTooManySlotDays):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// DeliveryWindow is a configured window; zero ZoneID/DayOfWeek/Date mean "any"
+type DeliveryWindow struct {
+	ID            int     `json:"id"`
+	ZoneID        int     `json:"zone_id,omitempty"`
+	DayOfWeek     *int    `json:"day_of_week,omitempty"` // 0 = Sunday
+	Date          string  `json:"date,omitempty"`
+	SlotType      string  `json:"slot_type"`
+	Label         string  `json:"label"`
+	StartTime     string  `json:"start_time"`
+	EndTime       string  `json:"end_time"`
+	MaxOrders     int     `json:"max_orders,omitempty"`
+	MaxLitres     float64 `json:"max_litres,omitempty"`
+	CutoffMinutes int     `json:"cutoff_minutes"`
+	IsActive      bool    `json:"is_active"`
+}
+
+// SlotAvailability is one bookable window on a date
+type SlotAvailability struct {
+	WindowID        int      `json:"window_id"`
+	Date            string   `json:"date"`
+	SlotType        string   `json:"slot_type"`
+	Label           string   `json:"label"`
+	StartTime       string   `json:"start_time"`
+	EndTime         string   `json:"end_time"`
+	ClosesAt        string   `json:"closes_at"`
+	Orders          int      `json:"orders"`
+	Litres          float64  `json:"litres"`
+	RemainingOrders *int     `json:"remaining_orders,omitempty"`
+	RemainingLitres *float64 `json:"remaining_litres,omitempty"`
+	Available       bool     `json:"available"`
+}
+
+type SlotHold struct {
+	ID           int     `json:"id"`
+	WindowID     int     `json:"window_id"`
+	DeliveryDate string  `json:"delivery_date"`
+	SlotType     string  `json:"slot_type"`
+	AddressID    int     `json:"address_id"`
+	ZoneID       int     `json:"zone_id,omitempty"`
+	Litres       float64 `json:"litres,omitempty"`
+	Status       string  `json:"status"`
+	ExpiresAt    string  `json:"expires_at"`
+	OrderID      int     `json:"order_id,omitempty"`
+}
+
+func slotHoldTTL() time.Duration {
+	if m, err := strconv.Atoi(os.Getenv("SLOT_HOLD_MINUTES")); err == nil && m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return 10 * time.Minute
+}
+
+func initDeliverySlotsSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS delivery_windows (
+			id SERIAL PRIMARY KEY,
+			zone_id INTEGER,
+			day_of_week SMALLINT CHECK (day_of_week BETWEEN 0 AND 6),
+			date DATE,
+			slot_type TEXT NOT NULL,
+			label TEXT,
+			start_time TIME NOT NULL,
+			end_time TIME NOT NULL,
+			max_orders INTEGER,
+			max_litres DECIMAL(8,2),
+			cutoff_minutes INTEGER NOT NULL DEFAULT 60,
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK (start_time < end_time)
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_delivery_windows_scope
+			ON delivery_windows((COALESCE(zone_id, 0)), (COALESCE(day_of_week, -1)), (COALESCE(date, '1970-01-01')), slot_type);
+
+		CREATE TABLE IF NOT EXISTS slot_holds (
+			id SERIAL PRIMARY KEY,
+			window_id INTEGER NOT NULL REFERENCES delivery_windows(id) ON DELETE CASCADE,
+			delivery_date DATE NOT NULL,
+			slot_type TEXT NOT NULL,
+			zone_id INTEGER,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			litres DECIMAL(8,2) NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'held',
+			expires_at TIMESTAMP NOT NULL,
+			order_id INTEGER REFERENCES orders(id),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_slot_holds_slot ON slot_holds(delivery_date, slot_type, status);
+		CREATE INDEX IF NOT EXISTS idx_slot_holds_user ON slot_holds(user_id, status);
+
+		ALTER TABLE slot_holds ADD COLUMN IF NOT EXISTS address_id INTEGER REFERENCES addresses(id) ON DELETE SET NULL;
+		ALTER TABLE orders ADD COLUMN IF NOT EXISTS zone_id INTEGER;
+
+		-- Default windows, matching the slots the apps have always shown
+		INSERT INTO delivery_windows (slot_type, label, start_time, end_time)
+		SELECT 'Morning', '6:00 - 7:30 AM', '06:00', '07:30'
+		WHERE NOT EXISTS (SELECT 1 FROM delivery_windows);
+		INSERT INTO delivery_windows (slot_type, label, start_time, end_time)
+		SELECT 'Evening', '5:30 - 7:30 PM', '17:30', '19:30'
+		WHERE NOT EXISTS (SELECT 1 FROM delivery_windows WHERE slot_type = 'Evening');
+	`)
+	if err != nil {
+		log.Fatal("Failed to create delivery slots schema:", err)
+	}
+}
+
+const deliveryWindowColumns = `id, COALESCE(zone_id, 0), day_of_week, COALESCE(date::text, ''), slot_type, COALESCE(label, ''),
+	to_char(start_time, 'HH24:MI'), to_char(end_time, 'HH24:MI'), COALESCE(max_orders, 0), COALESCE(max_litres, 0),
+	cutoff_minutes, is_active`
+
+func scanDeliveryWindow(row interface{ Scan(...interface{}) error }) (*DeliveryWindow, error) {
+	var w DeliveryWindow
+	var dow sql.NullInt64
+	err := row.Scan(&w.ID, &w.ZoneID, &dow, &w.Date, &w.SlotType, &w.Label, &w.StartTime, &w.EndTime,
+		&w.MaxOrders, &w.MaxLitres, &w.CutoffMinutes, &w.IsActive)
+	if err == sql.ErrNoRows {
+		return nil, errWindowNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if dow.Valid {
+		d := int(dow.Int64)
+		w.DayOfWeek = &d
+	}
+	return &w, nil
+}
+
+// windowsFor returns the window that applies to each slot type on a date in a zone:
+// date beats weekday beats every day, and a zone-specific row beats the default.
+// An inactive row closes its slot (e.g. a holiday override).
+func windowsFor(q dbExecer, date time.Time, zoneID int) ([]DeliveryWindow, error) {
+	rows, err := q.Query(`SELECT `+deliveryWindowColumns+` FROM (
+			SELECT DISTINCT ON (slot_type) * FROM delivery_windows
+			WHERE (zone_id IS NULL OR zone_id = $1)
+			  AND (date IS NULL OR date = $2::date)
+			  AND (day_of_week IS NULL OR day_of_week = $3)
+			ORDER BY slot_type, (date IS NOT NULL) DESC, (day_of_week IS NOT NULL) DESC, (zone_id IS NOT NULL) DESC
+		) w WHERE is_active ORDER BY start_time`, zoneID, date.Format("2006-01-02"), int(date.Weekday()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	windows := []DeliveryWindow{}
+	for rows.Next() {
+		w, err := scanDeliveryWindow(rows)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, *w)
+	}
+	return windows, nil
+}
+
+// windowCloses is when a window on date stops taking orders
+func windowCloses(w DeliveryWindow, date time.Time) time.Time {
+	start, _ := time.ParseInLocation("15:04", w.StartTime, time.Local)
+	at := time.Date(date.Year(), date.Month(), date.Day(), start.Hour(), start.Minute(), 0, 0, time.Local)
+	return at.Add(-time.Duration(w.CutoffMinutes) * time.Minute)
+}
+
+// slotUsage counts stops and litres already committed to a slot: subscription deliveries
+// (one stop per customer), open one-time orders and unexpired holds. Confirmed holds are
+// counted through their orders, whose litres are the milk on their lines.
+func slotUsage(q dbExecer, date time.Time, slotType string, zoneID int) (int, float64, error) {
+	var orders int
+	var litres float64
+	err := q.QueryRow(`
+		SELECT
+			(SELECT COUNT(DISTINCT d.user_id) FROM deliveries d
+			 LEFT JOIN milk_subscriptions ms ON ms.id = d.subscription_id LEFT JOIN addresses a ON a.id = ms.address_id
+			 WHERE d.delivery_date = $1 AND d.slot_type = $2 AND d.status NOT IN ('Cancelled', 'Skipped')
+			   AND ($4 = 0 OR COALESCE(a.zone_id, 0) IN (0, $4)))
+			+ (SELECT COUNT(*) FROM orders
+			   WHERE delivery_date = $1 AND slot_type = $2 AND status <> $3 AND ($4 = 0 OR COALESCE(zone_id, 0) IN (0, $4)))
+			+ (SELECT COUNT(*) FROM slot_holds
+			   WHERE delivery_date = $1 AND slot_type = $2 AND status = $5 AND expires_at > NOW()
+			     AND ($4 = 0 OR COALESCE(zone_id, 0) IN (0, $4))),
+			(SELECT COALESCE(SUM(d.quantity), 0) FROM deliveries d
+			 LEFT JOIN milk_subscriptions ms ON ms.id = d.subscription_id LEFT JOIN addresses a ON a.id = ms.address_id
+			 WHERE d.delivery_date = $1 AND d.slot_type = $2 AND d.status NOT IN ('Cancelled', 'Skipped')
+			   AND ($4 = 0 OR COALESCE(a.zone_id, 0) IN (0, $4)))
+			+ (SELECT COALESCE(SUM(oi.quantity * s.pack_size), 0) FROM orders o
+			   JOIN order_items oi ON oi.order_id = o.id
+			   JOIN stock_items s ON s.product_id = oi.product_id AND COALESCE(s.variant_id, 0) = COALESCE(oi.variant_id, 0)
+			   WHERE o.delivery_date = $1 AND o.slot_type = $2 AND o.status <> $3 AND s.uom = $6
+			     AND ($4 = 0 OR COALESCE(o.zone_id, 0) IN (0, $4)))
+			+ (SELECT COALESCE(SUM(litres), 0) FROM slot_holds
+			   WHERE delivery_date = $1 AND slot_type = $2 AND status = $5 AND expires_at > NOW()
+			     AND ($4 = 0 OR COALESCE(zone_id, 0) IN (0, $4)))
+	`, date.Format("2006-01-02"), slotType, OrderCancelled, zoneID, HoldHeld, UomLitre).Scan(&orders, &litres)
+	return orders, litres, err
+}
+
+// itemsLitres is the milk in a set of cart or order items, from their stock items' pack sizes
+func itemsLitres(q dbExecer, items []CartItemRequest) (float64, error) {
+	var litres float64
+	for _, it := range items {
+		var pack float64
+		err := q.QueryRow(`
+			SELECT COALESCE((SELECT pack_size FROM stock_items
+			                 WHERE product_id = $1 AND COALESCE(variant_id, 0) = $2 AND uom = $3), 0)
+		`, it.ProductID, it.VariantID, UomLitre).Scan(&pack)
+		if err != nil {
+			return 0, err
+		}
+		litres += pack * float64(it.Quantity)
+	}
+	return litres, nil
+}
+
+// fits reports whether one more stop of litres fits in a window with the given usage
+func (w DeliveryWindow) fits(orders int, litres, extraLitres float64) bool {
+	if w.MaxOrders > 0 && orders+1 > w.MaxOrders {
+		return false
+	}
+	if w.MaxLitres > 0 && litres+extraLitres > w.MaxLitres {
+		return false
+	}
+	return true
+}
+
+// availableSlots lists each window for the next days with its remaining capacity
+func availableSlots(zoneID, days int, litres float64) ([]SlotAvailability, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	slots := []SlotAvailability{}
+	for i := 0; i < days; i++ {
+		date := today.AddDate(0, 0, i)
+		windows, err := windowsFor(db, date, zoneID)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range windows {
+			closes := windowCloses(w, date)
+			orders, used, err := slotUsage(db, date, w.SlotType, zoneID)
+			if err != nil {
+				return nil, err
+			}
+			s := SlotAvailability{
+				WindowID: w.ID, Date: date.Format("2006-01-02"), SlotType: w.SlotType, Label: w.Label,
+				StartTime: w.StartTime, EndTime: w.EndTime, ClosesAt: closes.Format(time.RFC3339),
+				Orders: orders, Litres: used,
+				Available: now.Before(closes) && w.fits(orders, used, litres),
+			}
+			if w.MaxOrders > 0 {
+				left := w.MaxOrders - orders
+				if left < 0 {
+					left = 0
+				}
+				s.RemainingOrders = &left
+			}
+			if w.MaxLitres > 0 {
+				left := w.MaxLitres - used
+				if left < 0 {
+					left = 0
+				}
+				s.RemainingLitres = &left
+			}
+			slots = append(slots, s)
+		}
+	}
+	return slots, nil
+}
+
+// nextOpenSlot is the earliest slot in the coming days that still takes orders
+func nextOpenSlot(zoneID int) (*SlotAvailability, error) {
+	slots, err := availableSlots(zoneID, 3, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range slots {
+		if s.Available {
+			return &s, nil
+		}
+	}
+	return nil, errSlotFull
+}
+
+// lockWindow resolves and row-locks the window for a slot, so capacity checks on it serialize
+func lockWindow(q dbExecer, date time.Time, slotType string, zoneID int) (*DeliveryWindow, error) {
+	windows, err := windowsFor(q, date, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range windows {
+		if w.SlotType == slotType {
+			if _, err := q.Exec("SELECT id FROM delivery_windows WHERE id = $1 FOR UPDATE", w.ID); err != nil {
+				return nil, err
+			}
+			return &w, nil
+		}
+	}
+	return nil, errNoWindow
+}
+
+// holdSlot reserves a slot for a user's delivery address during checkout, in the address's
+// zone, for the milk in the user's cart. A user has at most one open hold: holding a new
+// slot releases the previous one.
+func holdSlot(userID, addressID int, date, slotType string) (*SlotHold, error) {
+	date, slotType, err := normalizeSlot(date, slotType)
+	if err != nil {
+		return nil, err
+	}
+	day, _ := time.ParseInLocation("2006-01-02", date, time.Local)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var owned bool
+	tx.QueryRow("SELECT EXISTS (SELECT 1 FROM addresses WHERE id = $1 AND user_id = $2)", addressID, userID).Scan(&owned)
+	if !owned {
+		return nil, errAddressNotFound
+	}
+	zoneID, err := addressZone(tx, addressID)
+	if err != nil {
+		return nil, err
+	}
+
+	w, err := lockWindow(tx, day, slotType, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(windowCloses(*w, day)) {
+		return nil, errSlotClosed
+	}
+	if _, err := tx.Exec("UPDATE slot_holds SET status = $1 WHERE user_id = $2 AND status = $3", HoldReleased, userID, HoldHeld); err != nil {
+		return nil, err
+	}
+	items, err := cartItemRequests(tx, &cartOwner{UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+	litres, err := itemsLitres(tx, items)
+	if err != nil {
+		return nil, err
+	}
+	orders, used, err := slotUsage(tx, day, slotType, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	if !w.fits(orders, used, litres) {
+		return nil, errSlotFull
+	}
+
+	h := &SlotHold{WindowID: w.ID, DeliveryDate: date, SlotType: slotType, AddressID: addressID, ZoneID: zoneID, Litres: litres, Status: HoldHeld}
+	expires := time.Now().Add(slotHoldTTL())
+	var zoneArg interface{}
+	if zoneID != 0 {
+		zoneArg = zoneID
+	}
+	err = tx.QueryRow(`
+		INSERT INTO slot_holds (window_id, delivery_date, slot_type, address_id, zone_id, user_id, litres, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id
+	`, w.ID, date, slotType, addressID, zoneArg, userID, litres, expires).Scan(&h.ID)
+	if err != nil {
+		return nil, err
+	}
+	h.ExpiresAt = expires.Format(time.RFC3339)
+	return h, tx.Commit()
+}
+
+func releaseSlotHold(id, userID int) error {
+	res, err := db.Exec("UPDATE slot_holds SET status = $1 WHERE id = $2 AND user_id = $3 AND status = $4", HoldReleased, id, userID, HoldHeld)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errHoldNotFound
+	}
+	return nil
+}
+
+// confirmSlotHold turns a user's hold into the slot of an order, inside the order's transaction.
+// The order must go to the address the hold was made for, still in the zone it was counted against,
+// and milk beyond what the hold counted must still fit the slot.
+func confirmSlotHold(q dbExecer, holdID, userID, addressID, zoneID int, litres float64) (*SlotHold, error) {
+	var h SlotHold
+	var heldAddress, heldZone sql.NullInt64
+	var expired bool
+	err := q.QueryRow(`
+		SELECT id, window_id, delivery_date::text, slot_type, address_id, zone_id, litres, status, expires_at <= NOW()
+		FROM slot_holds WHERE id = $1 AND user_id = $2 FOR UPDATE
+	`, holdID, userID).Scan(&h.ID, &h.WindowID, &h.DeliveryDate, &h.SlotType, &heldAddress, &heldZone, &h.Litres, &h.Status, &expired)
+	if err == sql.ErrNoRows {
+		return nil, errHoldNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	h.AddressID, h.ZoneID = int(heldAddress.Int64), int(heldZone.Int64)
+	if h.Status != HoldHeld || expired {
+		return nil, errHoldExpired
+	}
+	if h.AddressID != addressID || h.ZoneID != zoneID {
+		return nil, errHoldAddress
+	}
+	if _, err := q.Exec("UPDATE slot_holds SET status = $1, litres = $2 WHERE id = $3", HoldConfirmed, litres, h.ID); err != nil {
+		return nil, err
+	}
+	if litres > h.Litres {
+		// The hold no longer counts and the order isn't written yet: check it as a new stop
+		day, _ := time.ParseInLocation("2006-01-02", h.DeliveryDate, time.Local)
+		w, err := lockWindow(q, day, h.SlotType, zoneID)
+		if err != nil {
+			return nil, err
+		}
+		orders, used, err := slotUsage(q, day, h.SlotType, zoneID)
+		if err != nil {
+			return nil, err
+		}
+		if !w.fits(orders, used, litres) {
+			return nil, errSlotFull
+		}
+	}
+	h.Status, h.Litres = HoldConfirmed, litres
+	return &h, nil
+}
+
+// checkSlotCapacity is used when an order carrying litres of milk is placed without a hold
+func checkSlotCapacity(q dbExecer, date, slotType string, zoneID int, litres float64) error {
+	day, _ := time.ParseInLocation("2006-01-02", date, time.Local)
+	w, err := lockWindow(q, day, slotType, zoneID)
+	if err != nil {
+		return err
+	}
+	if !time.Now().Before(windowCloses(*w, day)) {
+		return errSlotClosed
+	}
+	orders, used, err := slotUsage(q, day, slotType, zoneID)
+	if err != nil {
+		return err
+	}
+	if !w.fits(orders, used, litres) {
+		return errSlotFull
+	}
+	return nil
+}
+
+// expireSlotHolds marks abandoned holds expired; capacity already ignores them once past expires_at
+func expireSlotHolds() {
+	if _, err := db.Exec("UPDATE slot_holds SET status = $1 WHERE status = $2 AND expires_at <= NOW()", HoldExpired, HoldHeld); err != nil {
+		log.Println("Error expiring slot holds:", err)
+	}
+}
+
+func startSlotHoldReaper() {
+	go func() {
+		for {
+			expireSlotHolds()
+			time.Sleep(time.Minute)
+		}
+	}()
+}
+
+type windowInput struct {
+	ID            int     `json:"id"`
+	ZoneID        int     `json:"zone_id"`
+	DayOfWeek     *int    `json:"day_of_week"`
+	Date          string  `json:"date"`
+	SlotType      string  `json:"slot_type"`
+	Label         string  `json:"label"`
+	StartTime     string  `json:"start_time"`
+	EndTime       string  `json:"end_time"`
+	MaxOrders     int     `json:"max_orders"`
+	MaxLitres     float64 `json:"max_litres"`
+	CutoffMinutes *int    `json:"cutoff_minutes"`
+	IsActive      *bool   `json:"is_active"`
+}
+
+func saveDeliveryWindow(in windowInput) (int, error) {
+	start, err1 := time.Parse("15:04", in.StartTime)
+	end, err2 := time.Parse("15:04", in.EndTime)
+	if (in.SlotType != SlotMorning && in.SlotType != SlotEvening) || err1 != nil || err2 != nil || !start.Before(end) {
+		return 0, errWindowInvalid
+	}
+	if in.DayOfWeek != nil && (*in.DayOfWeek < 0 || *in.DayOfWeek > 6) {
+		return 0, errWindowInvalid
+	}
+	cutoff, active := 60, true
+	if in.CutoffMinutes != nil {
+		cutoff = *in.CutoffMinutes
+	}
+	if in.IsActive != nil {
+		active = *in.IsActive
+	}
+	nullInt := func(v int) interface{} {
+		if v == 0 {
+			return nil
+		}
+		return v
+	}
+	var dateArg, dowArg, litresArg interface{}
+	if in.Date != "" {
+		dateArg = in.Date
+	}
+	if in.DayOfWeek != nil {
+		dowArg = *in.DayOfWeek
+	}
+	if in.MaxLitres > 0 {
+		litresArg = in.MaxLitres
+	}
+
+	var id int
+	if in.ID == 0 {
+		err := db.QueryRow(`
+			INSERT INTO delivery_windows (zone_id, day_of_week, date, slot_type, label, start_time, end_time, max_orders, max_litres, cutoff_minutes, is_active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id
+		`, nullInt(in.ZoneID), dowArg, dateArg, in.SlotType, in.Label, in.StartTime, in.EndTime, nullInt(in.MaxOrders), litresArg, cutoff, active).Scan(&id)
+		return id, err
+	}
+	err := db.QueryRow(`
+		UPDATE delivery_windows SET zone_id = $1, day_of_week = $2, date = $3, slot_type = $4, label = $5, start_time = $6, end_time = $7,
+			max_orders = $8, max_litres = $9, cutoff_minutes = $10, is_active = $11, updated_at = NOW()
+		WHERE id = $12 RETURNING id
+	`, nullInt(in.ZoneID), dowArg, dateArg, in.SlotType, in.Label, in.StartTime, in.EndTime, nullInt(in.MaxOrders), litresArg, cutoff, active, in.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errWindowNotFound
+	}
+	return id, err
+}
+
+// --- Handlers ---
+
+// slotsAvailableHandler lists the next slots with remaining capacity:
+// GET ?zone_id=&days=3&litres= (or ?lat=&lng= / ?pincode= to look the zone up)
+func slotsAvailableHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	zoneID, _ := strconv.Atoi(r.URL.Query().Get("zone_id"))
+	if zoneID == 0 {
+		var p GeoPoint
+		p.Lat, _ = strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+		p.Lng, _ = strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+		if pincode := r.URL.Query().Get("pincode"); p.located() || pincode != "" {
+			zone, err := resolveZone(db, p, pincode)
+			if err != nil {
+				http.Error(w, err.Error(), zoneErrorStatus(err))
+				return
+			}
+			if zone != nil {
+				zoneID = zone.ID
+			}
+		}
+	}
+	litres, _ := strconv.ParseFloat(r.URL.Query().Get("litres"), 64)
+	days := 3
+	if v := r.URL.Query().Get("days"); v != "" {
+		days, _ = strconv.Atoi(v)
+	}
+	if days < 1 || days > 14 {
+		http.Error(w, errTooManySlotDays.Error(), http.StatusBadRequest)
+		return
+	}
+
+	slots, err := availableSlots(zoneID, days, litres)
+	if err != nil {
+		log.Println("Error fetching slots:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if r.URL.Query().Get("all") != "true" {
+		open := []SlotAvailability{}
+		for _, s := range slots {
+			if s.Available {
+				open = append(open, s)
+			}
+		}
+		slots = open
+	}
+	json.NewEncoder(w).Encode(slots)
+}
+
+// slotHoldHandler holds a slot during checkout (POST {delivery_date, slot_type, address_id})
+// or releases it (DELETE ?id=)
+func slotHoldHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		var req struct {
+			DeliveryDate string `json:"delivery_date"`
+			SlotType     string `json:"slot_type"`
+			AddressID    int    `json:"address_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		// The delivery address decides the zone whose capacity is held
+		if req.AddressID == 0 {
+			http.Error(w, "address_id is required", http.StatusBadRequest)
+			return
+		}
+		hold, err := holdSlot(userID, req.AddressID, req.DeliveryDate, req.SlotType)
+		if err != nil {
+			code := slotErrorStatus(err)
+			if code == http.StatusInternalServerError {
+				code = zoneErrorStatus(err)
+			}
+			if code == http.StatusInternalServerError {
+				log.Println("Error holding slot:", err)
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "hold": hold})
+
+	case "DELETE":
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		if err := releaseSlotHold(id, userID); err != nil {
+			http.Error(w, err.Error(), slotErrorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminDeliveryWindowsHandler manages windows: GET list, POST create, PUT {id,...} update, DELETE ?id=
+func adminDeliveryWindowsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		rows, err := db.Query(`SELECT ` + deliveryWindowColumns + ` FROM delivery_windows
+			ORDER BY COALESCE(zone_id, 0), date NULLS FIRST, day_of_week NULLS FIRST, start_time`)
+		if err != nil {
+			log.Println("Error fetching delivery windows:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		windows := []DeliveryWindow{}
+		for rows.Next() {
+			win, err := scanDeliveryWindow(rows)
+			if err != nil {
+				log.Println("Error scanning delivery window:", err)
+				continue
+			}
+			windows = append(windows, *win)
+		}
+		json.NewEncoder(w).Encode(windows)
+
+	case "POST", "PUT":
+		var in windowInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if r.Method == "POST" {
+			in.ID = 0
+		}
+		id, err := saveDeliveryWindow(in)
+		if err != nil {
+			if slotErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error saving delivery window:", err)
+				http.Error(w, "Could not save window (a window for this zone, day and slot may already exist)", http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), slotErrorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id})
+
+	case "DELETE":
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		// Windows with holds are deactivated rather than deleted
+		res, err := db.Exec("UPDATE delivery_windows SET is_active = false, updated_at = NOW() WHERE id = $1", id)
+		if err != nil {
+			log.Println("Error deactivating delivery window:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, errWindowNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
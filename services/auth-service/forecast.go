@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// DEMAND FORECAST
+// ============================================================================
+//
+// Expected litres per milk type and slot for a target day, so procurement knows
+// how much to collect. Each subscription stop is treated as an independent
+// draw that happens with the weekday's historical delivery rate:
+//   - materialized deliveries use their own quantity and status (a skip or
+//     cancellation is known, a dispatched or delivered one is certain, a
+//     scheduled one is still a draw);
+//   - enabled slots of active subscriptions not yet materialized for the day
+//     are projected with the slot's quantity; paused and suspended
+//     subscriptions contribute nothing (reported as paused_litres);
+//   - one-time milk orders booked into the slot are certain.
+// The historical rates come from the same weekday over the last N weeks.
+// The band is expected ± z·σ of the resulting sum (90% by default).
+//
+// Routes (admin):
+//   /api/admin/forecast   GET ?date=YYYY-MM-DD (default tomorrow) &weeks=8 &zone_id=
+
+const (
+	forecastDefaultWeeks = 8
+	forecastMaxWeeks     = 26
+	// forecastZ is the normal quantile for a two-sided 90% band
+	forecastZ = 1.645
+)
+
+// weekdayRates are the historical outcomes of a weekday's stops for one milk type and slot
+type weekdayRates struct {
+	Samples     int     `json:"samples"`
+	SkipRate    float64 `json:"skip_rate"`
+	FailureRate float64 `json:"failure_rate"`
+}
+
+// ForecastLine is the forecast for one milk type in one slot
+type ForecastLine struct {
+	MilkType          string       `json:"milk_type"`
+	SlotType          string       `json:"slot_type"`
+	Stops             int          `json:"stops"`
+	ConfirmedLitres   float64      `json:"confirmed_litres"`
+	ScheduledLitres   float64      `json:"scheduled_litres"`
+	OrderLitres       float64      `json:"order_litres"`
+	SkippedLitres     float64      `json:"skipped_litres"`
+	PausedLitres      float64      `json:"paused_litres"`
+	History           weekdayRates `json:"history"`
+	ExpectedLitres    float64      `json:"expected_litres"`
+	LowLitres         float64      `json:"low_litres"`
+	HighLitres        float64      `json:"high_litres"`
+	ExpectedDelivered float64      `json:"expected_delivered_litres"`
+	CollectedLitres   float64      `json:"collected_litres"`
+
+	variance float64
+}
+
+// add counts a stop of qty litres that happens with probability p
+func (l *ForecastLine) add(qty, p float64) {
+	l.Stops++
+	l.ExpectedLitres += qty * p
+	l.variance += qty * qty * p * (1 - p)
+}
+
+// finish rounds the line and derives its band
+func (l *ForecastLine) finish() {
+	sd := math.Sqrt(l.variance)
+	l.LowLitres = roundMoney(math.Max(0, l.ExpectedLitres-forecastZ*sd))
+	l.HighLitres = roundMoney(l.ExpectedLitres + forecastZ*sd)
+	l.ExpectedDelivered = roundMoney(l.ExpectedLitres * (1 - l.History.FailureRate))
+	l.ExpectedLitres = roundMoney(l.ExpectedLitres)
+	l.ConfirmedLitres = roundMoney(l.ConfirmedLitres)
+	l.ScheduledLitres = roundMoney(l.ScheduledLitres)
+	l.OrderLitres = roundMoney(l.OrderLitres)
+	l.SkippedLitres = roundMoney(l.SkippedLitres)
+	l.PausedLitres = roundMoney(l.PausedLitres)
+	l.CollectedLitres = roundMoney(l.CollectedLitres)
+}
+
+// loadWeekdayRates reads skip and failure rates for the date's weekday over the last weeks,
+// keyed by "milk_type|slot_type". Deliveries dropped for lack of funds or by a paused or
+// cancelled subscription are not customer skips.
+func loadWeekdayRates(date time.Time, weeks, zoneID int) (map[string]weekdayRates, error) {
+	from := date.AddDate(0, 0, -7*weeks)
+	rows, err := db.Query(`
+		SELECT d.milk_type, d.slot_type, COUNT(*),
+		       COUNT(*) FILTER (WHERE d.status = $4 OR (d.status = $5 AND COALESCE(d.failure_reason, '') NOT IN ($6, $9))),
+		       COUNT(*) FILTER (WHERE d.status = $7)
+		FROM deliveries d
+		LEFT JOIN milk_subscriptions ms ON ms.id = d.subscription_id LEFT JOIN addresses a ON a.id = ms.address_id
+		WHERE d.delivery_date >= $1 AND d.delivery_date < LEAST($2::date, CURRENT_DATE)
+		  AND EXTRACT(DOW FROM d.delivery_date) = $3
+		  AND NOT (d.status = $5 AND COALESCE(d.failure_reason, '') IN ($6, $9))
+		  AND ($8 = 0 OR COALESCE(a.zone_id, 0) IN (0, $8))
+		GROUP BY d.milk_type, d.slot_type
+	`, from.Format("2006-01-02"), date.Format("2006-01-02"), int(date.Weekday()),
+		DeliverySkipped, DeliveryCancelled, reasonInsufficientFunds, DeliveryFailed, zoneID, reasonSubscriptionStopped)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := map[string]weekdayRates{}
+	for rows.Next() {
+		var milkType, slotType string
+		var total, skipped, failed int
+		rows.Scan(&milkType, &slotType, &total, &skipped, &failed)
+		rt := weekdayRates{Samples: total}
+		if total > 0 {
+			rt.SkipRate = roundQty(float64(skipped) / float64(total))
+		}
+		if total-skipped > 0 {
+			rt.FailureRate = roundQty(float64(failed) / float64(total-skipped))
+		}
+		rates[milkType+"|"+slotType] = rt
+	}
+	return rates, nil
+}
+
+// forecastDemand builds the forecast lines for a date
+func forecastDemand(date time.Time, weeks, zoneID int) ([]ForecastLine, error) {
+	rates, err := loadWeekdayRates(date, weeks, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	day := date.Format("2006-01-02")
+
+	lines := map[string]*ForecastLine{}
+	line := func(milkType, slotType string) *ForecastLine {
+		key := milkType + "|" + slotType
+		if lines[key] == nil {
+			lines[key] = &ForecastLine{MilkType: milkType, SlotType: slotType, History: rates[key]}
+		}
+		return lines[key]
+	}
+
+	// Deliveries already materialized for the day
+	rows, err := db.Query(`
+		SELECT d.milk_type, d.slot_type, d.quantity, d.status, COALESCE(d.failure_reason, '')
+		FROM deliveries d
+		LEFT JOIN milk_subscriptions ms ON ms.id = d.subscription_id LEFT JOIN addresses a ON a.id = ms.address_id
+		WHERE d.delivery_date = $1 AND ($2 = 0 OR COALESCE(a.zone_id, 0) IN (0, $2))
+	`, day, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var milkType, slotType, status, reason string
+		var qty float64
+		rows.Scan(&milkType, &slotType, &qty, &status, &reason)
+		if reason == reasonSubscriptionStopped {
+			continue // dropped by a paused or cancelled subscription: no longer demand
+		}
+		l := line(milkType, slotType)
+		switch status {
+		case DeliverySkipped, DeliveryCancelled:
+			if reason == reasonInsufficientFunds {
+				l.PausedLitres += qty
+			} else {
+				l.SkippedLitres += qty
+			}
+		case DeliveryScheduled:
+			l.ScheduledLitres += qty
+			l.add(qty, 1-l.History.SkipRate)
+		default:
+			l.ConfirmedLitres += qty
+			l.add(qty, 1)
+		}
+	}
+	rows.Close()
+
+	// Slots that will deliver on the day but have no delivery row yet
+	rows, err = db.Query(`
+		SELECT ss.id, ss.slot_type, ss.milk_type, ss.quantity, ss.frequency, COALESCE(array_to_string(ss.days, ','), ''), ms.status
+		FROM subscription_slots ss
+		JOIN milk_subscriptions ms ON ms.id = ss.subscription_id
+		LEFT JOIN addresses a ON a.id = ms.address_id
+		WHERE ss.is_enabled = true AND ms.status <> 'Cancelled'
+		  AND ($2 = 0 OR COALESCE(a.zone_id, 0) IN (0, $2))
+		  AND NOT EXISTS (SELECT 1 FROM deliveries d WHERE d.slot_id = ss.id AND d.delivery_date = $1)
+	`, day, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s scheduledSlot
+		var slotType, days, status string
+		rows.Scan(&s.ID, &slotType, &s.MilkType, &s.Quantity, &s.Frequency, &days, &status)
+		if days != "" {
+			s.Days = strings.Split(days, ",")
+		}
+		if !s.deliversOn(date) {
+			continue
+		}
+		l := line(s.MilkType, slotType)
+		if status != "Active" {
+			l.PausedLitres += s.Quantity
+			continue
+		}
+		l.ScheduledLitres += s.Quantity
+		l.add(s.Quantity, 1-l.History.SkipRate)
+	}
+	rows.Close()
+
+	// One-time orders of milk products booked into the day's slots
+	rows, err = db.Query(`
+		SELECT p.milk_type, o.slot_type, SUM(oi.quantity * si.pack_size)
+		FROM orders o
+		JOIN order_items oi ON oi.order_id = o.id
+		JOIN products p ON p.id = oi.product_id
+		JOIN stock_items si ON si.product_id = oi.product_id AND COALESCE(si.variant_id, 0) = COALESCE(oi.variant_id, 0)
+		WHERE o.delivery_date = $1 AND o.status <> $2 AND p.milk_type IS NOT NULL AND p.milk_type <> ''
+		  AND si.uom = $3 AND o.slot_type IS NOT NULL
+		  AND ($4 = 0 OR COALESCE(o.zone_id, 0) IN (0, $4))
+		GROUP BY p.milk_type, o.slot_type
+	`, day, OrderCancelled, UomLitre, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var milkType, slotType string
+		var litres float64
+		rows.Scan(&milkType, &slotType, &litres)
+		l := line(milkType, slotType)
+		l.OrderLitres += litres
+		l.ExpectedLitres += litres
+	}
+	rows.Close()
+
+	// Milk already collected from farms for the day, by shift
+	rows, err = db.Query(`SELECT milk_type, shift, SUM(litres) FROM milk_collections WHERE collection_date = $1 GROUP BY milk_type, shift`, day)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var milkType, shift string
+		var litres float64
+		rows.Scan(&milkType, &shift, &litres)
+		line(milkType, shift).CollectedLitres += litres
+	}
+	rows.Close()
+
+	result := []ForecastLine{}
+	for _, l := range lines {
+		l.finish()
+		result = append(result, *l)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].MilkType != result[j].MilkType {
+			return result[i].MilkType < result[j].MilkType
+		}
+		return result[i].SlotType > result[j].SlotType
+	})
+	return result, nil
+}
+
+// adminForecastHandler returns the demand forecast for a day
+func adminForecastHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+	date := today.AddDate(0, 0, 1)
+	if s := q.Get("date"); s != "" {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		date = d
+	}
+	weeks, _ := strconv.Atoi(q.Get("weeks"))
+	if weeks <= 0 {
+		weeks = forecastDefaultWeeks
+	}
+	if weeks > forecastMaxWeeks {
+		weeks = forecastMaxWeeks
+	}
+	zoneID, _ := strconv.Atoi(q.Get("zone_id"))
+
+	lines, err := forecastDemand(date, weeks, zoneID)
+	if err != nil {
+		log.Println("Error building demand forecast:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Totals per milk type; slots are summed as independent, so σ adds in quadrature
+	type milkTotal struct {
+		MilkType        string  `json:"milk_type"`
+		ExpectedLitres  float64 `json:"expected_litres"`
+		LowLitres       float64 `json:"low_litres"`
+		HighLitres      float64 `json:"high_litres"`
+		CollectedLitres float64 `json:"collected_litres"`
+	}
+	totals := []milkTotal{}
+	index := map[string]int{}
+	variances := map[string]float64{}
+	for _, l := range lines {
+		i, ok := index[l.MilkType]
+		if !ok {
+			i = len(totals)
+			index[l.MilkType] = i
+			totals = append(totals, milkTotal{MilkType: l.MilkType})
+		}
+		totals[i].ExpectedLitres += l.ExpectedLitres
+		totals[i].CollectedLitres += l.CollectedLitres
+		sd := (l.HighLitres - l.ExpectedLitres) / forecastZ
+		variances[l.MilkType] += sd * sd
+	}
+	for i := range totals {
+		sd := math.Sqrt(variances[totals[i].MilkType])
+		totals[i].LowLitres = roundMoney(math.Max(0, totals[i].ExpectedLitres-forecastZ*sd))
+		totals[i].HighLitres = roundMoney(totals[i].ExpectedLitres + forecastZ*sd)
+		totals[i].ExpectedLitres = roundMoney(totals[i].ExpectedLitres)
+		totals[i].CollectedLitres = roundMoney(totals[i].CollectedLitres)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"date":       date.Format("2006-01-02"),
+		"weekday":    date.Weekday().String(),
+		"weeks":      weeks,
+		"confidence": 0.9,
+		"lines":      lines,
+		"totals":     totals,
+	})
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// GST TAX INVOICES
+// ============================================================================
+//
+// Invoices get a number that runs sequentially within an Indian financial
+// year (April-March), e.g. TZ/2025-26/000042, assigned inside the billing
+// transaction so there are no gaps. Subscription milk is invoiced monthly and
+// every one-time order (catalog or advance booking) when it is delivered.
+//
+// Each line stores the category, HSN code and GST rate it was billed under.
+// They are resolved when billing: a product's own override, else the code for
+// its species (mutton and poultry share the Meat category), else its
+// category's. Prices are tax-inclusive, so tax is carved out of the line
+// amount and split equally into CGST and SGST (all supplies are intra-state).
+
+// CategoryMilk is the product category of subscription milk
+const CategoryMilk = "Milk"
+
+// CategoryFees classifies an order's delivery and platform fees
+const CategoryFees = "Fees"
+
+// HSNCode is the tax classification of a product category, or of one product when
+// ProductID is set (a category can mix goods, e.g. poultry and mutton under Meat)
+type HSNCode struct {
+	Category    string  `json:"category"`
+	ProductID   int     `json:"product_id,omitempty"`
+	Species     string  `json:"species,omitempty"`
+	Code        string  `json:"hsn_code"`
+	Description string  `json:"description"`
+	GSTRate     float64 `json:"gst_rate"` // percent; 0 = exempt
+}
+
+// sellerDetails are printed on every tax invoice
+type sellerDetails struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	GSTIN   string `json:"gstin"`
+	State   string `json:"state_code"`
+}
+
+func initGSTSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS hsn_codes (
+			category TEXT PRIMARY KEY,
+			hsn_code TEXT NOT NULL,
+			description TEXT,
+			gst_rate DECIMAL(5,2) NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO hsn_codes (category, hsn_code, description, gst_rate) VALUES
+			('Milk', '0401', 'Fresh milk (exempt)', 0),
+			('Meat', '0207', 'Poultry meat', 5),
+			('Fruits', '0810', 'Fruits', 5),
+			('Vegetables', '0709', 'Vegetables', 5),
+			('Fees', '9968', 'Delivery and platform fees', 18)
+		ON CONFLICT (category) DO NOTHING;
+
+		CREATE TABLE IF NOT EXISTS product_hsn_codes (
+			product_id INTEGER PRIMARY KEY REFERENCES products(id) ON DELETE CASCADE,
+			hsn_code TEXT NOT NULL,
+			description TEXT,
+			gst_rate DECIMAL(5,2) NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		-- Meat is classified by the animal it comes from
+		ALTER TABLE products ADD COLUMN IF NOT EXISTS species TEXT;
+		ALTER TABLE order_items ADD COLUMN IF NOT EXISTS species TEXT;
+		CREATE TABLE IF NOT EXISTS species_hsn_codes (
+			species TEXT PRIMARY KEY,
+			hsn_code TEXT NOT NULL,
+			description TEXT,
+			gst_rate DECIMAL(5,2) NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO species_hsn_codes (species, hsn_code, description, gst_rate) VALUES
+			('chicken', '0207', 'Poultry meat', 5),
+			('goat', '0204', 'Meat of sheep or goats', 5),
+			('sheep', '0204', 'Meat of sheep or goats', 5)
+		ON CONFLICT (species) DO NOTHING;
+
+		CREATE TABLE IF NOT EXISTS invoice_number_sequences (
+			financial_year TEXT PRIMARY KEY,
+			last_number INTEGER NOT NULL
+		);
+
+		ALTER TABLE invoices ADD COLUMN IF NOT EXISTS invoice_number TEXT UNIQUE;
+		ALTER TABLE invoices ADD COLUMN IF NOT EXISTS invoice_date DATE;
+		ALTER TABLE invoices ADD COLUMN IF NOT EXISTS seller_gstin TEXT;
+		ALTER TABLE invoices ADD COLUMN IF NOT EXISTS emailed_at TIMESTAMP;
+
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS category TEXT;
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS hsn_code TEXT;
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS gst_rate DECIMAL(5,2);
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS taxable_value DECIMAL(10,2);
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS cgst DECIMAL(10,2);
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS sgst DECIMAL(10,2);
+
+		-- Order invoices: one per order, a line per item plus one for the fees
+		ALTER TABLE invoices ADD COLUMN IF NOT EXISTS order_id INTEGER UNIQUE REFERENCES orders(id);
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS order_item_id INTEGER UNIQUE REFERENCES order_items(id);
+		ALTER TABLE invoice_lines ADD COLUMN IF NOT EXISTS description TEXT;
+		ALTER TABLE invoice_lines ALTER COLUMN slot_type DROP NOT NULL;
+		ALTER TABLE invoice_lines ALTER COLUMN milk_type DROP NOT NULL;
+	`)
+	if err != nil {
+		log.Fatal("Failed to create GST schema:", err)
+	}
+
+	// Meat products named before species existed
+	applyMigration("product_species_from_names", `
+		UPDATE products p SET species = CASE WHEN p.name ILIKE '%lamb%' OR p.name ILIKE '%sheep%' THEN 'sheep'
+		                                     WHEN p.name ILIKE '%mutton%' OR p.name ILIKE '%goat%' THEN 'goat'
+		                                     WHEN p.name ILIKE '%chicken%' THEN 'chicken' END
+		FROM product_categories c
+		WHERE c.id = p.category_id AND c.name = 'Meat' AND p.species IS NULL
+	`)
+
+	// Number invoices issued before numbering existed, in issue order
+	rows, err := db.Query("SELECT id, created_at FROM invoices WHERE invoice_number IS NULL ORDER BY created_at, id")
+	if err != nil {
+		return
+	}
+	type legacy struct {
+		id      int
+		created time.Time
+	}
+	var pending []legacy
+	for rows.Next() {
+		var l legacy
+		rows.Scan(&l.id, &l.created)
+		pending = append(pending, l)
+	}
+	rows.Close()
+	for _, l := range pending {
+		tx, err := db.Begin()
+		if err != nil {
+			return
+		}
+		number, err := nextInvoiceNumber(tx, l.created)
+		if err == nil {
+			_, err = tx.Exec("UPDATE invoices SET invoice_number = $1, invoice_date = $2, seller_gstin = $3 WHERE id = $4",
+				number, l.created.Format("2006-01-02"), sellerInfo().GSTIN, l.id)
+		}
+		if err != nil {
+			tx.Rollback()
+			log.Println("Failed to number legacy invoice:", err)
+			return
+		}
+		tx.Commit()
+	}
+}
+
+func sellerInfo() sellerDetails {
+	s := sellerDetails{
+		Name:    os.Getenv("SELLER_NAME"),
+		Address: os.Getenv("SELLER_ADDRESS"),
+		GSTIN:   os.Getenv("SELLER_GSTIN"),
+	}
+	if s.Name == "" {
+		s.Name = "Taaza"
+	}
+	if len(s.GSTIN) >= 2 {
+		s.State = s.GSTIN[:2]
+	}
+	return s
+}
+
+// financialYear returns the Indian financial year label for a date, e.g. "2025-26"
+func financialYear(t time.Time) string {
+	start := t.Year()
+	if t.Month() < time.April {
+		start--
+	}
+	return fmt.Sprintf("%d-%02d", start, (start+1)%100)
+}
+
+// nextInvoiceNumber takes the next number in the date's financial year. The sequence
+// row stays locked until the caller's transaction ends, so numbers are gap-free.
+func nextInvoiceNumber(q dbExecer, date time.Time) (string, error) {
+	fy := financialYear(date)
+	var n int
+	err := q.QueryRow(`
+		INSERT INTO invoice_number_sequences (financial_year, last_number) VALUES ($1, 1)
+		ON CONFLICT (financial_year) DO UPDATE SET last_number = invoice_number_sequences.last_number + 1
+		RETURNING last_number
+	`, fy).Scan(&n)
+	if err != nil {
+		return "", err
+	}
+	prefix := os.Getenv("INVOICE_PREFIX")
+	if prefix == "" {
+		prefix = "TZ"
+	}
+	return fmt.Sprintf("%s/%s/%06d", prefix, fy, n), nil
+}
+
+// lookupHSN returns the classification for a product category; unknown categories
+// are taxed like the catch-all so nothing is silently treated as exempt.
+func lookupHSN(q dbExecer, category string) HSNCode {
+	h := HSNCode{Category: category}
+	err := q.QueryRow("SELECT hsn_code, COALESCE(description, ''), gst_rate FROM hsn_codes WHERE category = $1", category).
+		Scan(&h.Code, &h.Description, &h.GSTRate)
+	if err != nil {
+		h.Code, h.GSTRate = "9999", 5
+	}
+	return h
+}
+
+// lookupProductHSN returns a product's own classification, else its species', else its
+// category's. Lines without a product (advance bookings) are classified by species alone.
+func lookupProductHSN(q dbExecer, productID int, category, species string) HSNCode {
+	h := HSNCode{Category: category, ProductID: productID}
+	if productID != 0 {
+		err := q.QueryRow("SELECT hsn_code, COALESCE(description, ''), gst_rate FROM product_hsn_codes WHERE product_id = $1", productID).
+			Scan(&h.Code, &h.Description, &h.GSTRate)
+		if err == nil {
+			return h
+		}
+		if species == "" {
+			q.QueryRow("SELECT COALESCE(species, '') FROM products WHERE id = $1", productID).Scan(&species)
+		}
+	}
+	if species != "" {
+		h.Species = species
+		err := q.QueryRow("SELECT hsn_code, COALESCE(description, ''), gst_rate FROM species_hsn_codes WHERE species = $1", species).
+			Scan(&h.Code, &h.Description, &h.GSTRate)
+		if err == nil {
+			return h
+		}
+	}
+	return lookupHSN(q, category)
+}
+
+// lookupMilkHSN classifies subscription milk through the catalog product for its milk type
+func lookupMilkHSN(q dbExecer, milkType string) HSNCode {
+	var productID int
+	q.QueryRow("SELECT id FROM products WHERE milk_type = $1 AND archived_at IS NULL ORDER BY id LIMIT 1", milkType).Scan(&productID)
+	return lookupProductHSN(q, productID, CategoryMilk, "")
+}
+
+// applyGST carves the tax out of a tax-inclusive line amount
+func applyGST(l *InvoiceLine, h HSNCode) {
+	l.Category, l.HSNCode, l.GSTRate = h.Category, h.Code, h.GSTRate
+	l.TaxableValue = roundMoney(l.Amount * 100 / (100 + h.GSTRate))
+	tax := roundMoney(l.Amount - l.TaxableValue)
+	l.CGST = roundMoney(tax / 2)
+	l.SGST = roundMoney(tax - l.CGST)
+}
+
+// sumGST totals the tax breakup of an invoice from its lines
+func sumGST(inv *Invoice) {
+	inv.TaxableValue, inv.CGST, inv.SGST = 0, 0, 0
+	for _, l := range inv.Lines {
+		inv.TaxableValue += l.TaxableValue
+		inv.CGST += l.CGST
+		inv.SGST += l.SGST
+	}
+	inv.TaxableValue = roundMoney(inv.TaxableValue)
+	inv.CGST = roundMoney(inv.CGST)
+	inv.SGST = roundMoney(inv.SGST)
+}
+
+// lineItem names what a line is for: an order item, or a subscription delivery
+func lineItem(l InvoiceLine) string {
+	if l.Description != "" {
+		return l.Description
+	}
+	return l.MilkType + " (" + l.SlotType + ")"
+}
+
+// lineAmount prints a line's amount, marking the ones already paid from the wallet
+func lineAmount(l InvoiceLine) string {
+	if l.Prepaid {
+		return fmt.Sprintf("%.2f (paid)", l.Amount)
+	}
+	return fmt.Sprintf("%.2f", l.Amount)
+}
+
+// invoiceFilename is a filesystem-safe name for an invoice download
+func invoiceFilename(inv *Invoice, ext string) string {
+	name := inv.InvoiceNumber
+	if name == "" {
+		name = "invoice-" + strconv.Itoa(inv.ID)
+	}
+	return strings.ReplaceAll(name, "/", "-") + "." + ext
+}
+
+func renderInvoicePDF(inv *Invoice) []byte {
+	seller := sellerInfo()
+	doc := newPDFDoc()
+
+	doc.Line("TAX INVOICE", 14, true)
+	doc.Gap(4)
+	doc.Line(seller.Name, 11, true)
+	if seller.Address != "" {
+		doc.Line(seller.Address, 9, false)
+	}
+	gstin := inv.SellerGSTIN
+	if gstin == "" {
+		gstin = seller.GSTIN
+	}
+	doc.Line("GSTIN: "+gstin, 9, false)
+	doc.Gap(8)
+	doc.Line(fmt.Sprintf("Invoice No: %-24s Date: %s", inv.InvoiceNumber, inv.InvoiceDate), 9, true)
+	if inv.OrderID != 0 {
+		doc.Line(fmt.Sprintf("Order: %s   Delivered: %s", orderNumber(inv.OrderID), inv.PeriodStart), 9, false)
+	} else {
+		doc.Line(fmt.Sprintf("Billing period: %s to %s", inv.PeriodStart, inv.PeriodEnd), 9, false)
+	}
+	doc.Line("Billed to: "+inv.CustomerName, 9, false)
+	if inv.CustomerAddress != "" {
+		doc.Line("           "+inv.CustomerAddress, 9, false)
+	}
+	doc.Gap(8)
+
+	const size = 7.5
+	doc.Line(strings.Join([]string{pdfFit("Date", 10), pdfFit("Item", 18), pdfFit("HSN", 5), pdfFit("Qty", 6),
+		pdfFit("Rate", 8), pdfFit("Taxable", 10), pdfFit("GST%", 5), pdfFit("CGST", 8), pdfFit("SGST", 8), "Amount"}, " "), size, true)
+	doc.Line(strings.Repeat("-", 100), size, false)
+	for _, l := range inv.Lines {
+		doc.Line(strings.Join([]string{
+			pdfFit(l.DeliveryDate, 10), pdfFit(lineItem(l), 18), pdfFit(l.HSNCode, 5),
+			pdfFit(strconv.FormatFloat(l.Quantity, 'f', 2, 64), 6), pdfFit(fmt.Sprintf("%.2f", l.Rate), 8),
+			pdfFit(fmt.Sprintf("%.2f", l.TaxableValue), 10), pdfFit(fmt.Sprintf("%.0f", l.GSTRate), 5),
+			pdfFit(fmt.Sprintf("%.2f", l.CGST), 8), pdfFit(fmt.Sprintf("%.2f", l.SGST), 8), lineAmount(l),
+		}, " "), size, false)
+	}
+	doc.Line(strings.Repeat("-", 100), size, false)
+	doc.Gap(4)
+
+	// Tax summary per HSN, as the invoice rules require
+	type hsnTotal struct{ taxable, cgst, sgst float64 }
+	byHSN := map[string]*hsnTotal{}
+	var codes []string
+	for _, l := range inv.Lines {
+		key := fmt.Sprintf("%s @ %.0f%%", l.HSNCode, l.GSTRate)
+		if byHSN[key] == nil {
+			byHSN[key] = &hsnTotal{}
+			codes = append(codes, key)
+		}
+		byHSN[key].taxable += l.TaxableValue
+		byHSN[key].cgst += l.CGST
+		byHSN[key].sgst += l.SGST
+	}
+	doc.Line("Tax summary", 9, true)
+	for _, k := range codes {
+		t := byHSN[k]
+		label := "HSN " + k
+		if t.cgst == 0 && t.sgst == 0 {
+			label += " (exempt)"
+		}
+		doc.Line(fmt.Sprintf("  %-28s Taxable %10.2f  CGST %8.2f  SGST %8.2f", label, t.taxable, t.cgst, t.sgst), 8, false)
+	}
+	doc.Gap(6)
+	doc.Line(fmt.Sprintf("%-40s %12.2f", "Taxable value", inv.TaxableValue), 9, false)
+	doc.Line(fmt.Sprintf("%-40s %12.2f", "CGST", inv.CGST), 9, false)
+	doc.Line(fmt.Sprintf("%-40s %12.2f", "SGST", inv.SGST), 9, false)
+	doc.Line(fmt.Sprintf("%-40s %12.2f", "Invoice total (Rs.)", inv.Total), 10, true)
+	if inv.AmountDue != inv.Total {
+		doc.Line(fmt.Sprintf("%-40s %12.2f", "Paid from wallet", roundMoney(inv.Total-inv.AmountDue)), 9, false)
+		doc.Line(fmt.Sprintf("%-40s %12.2f", "Amount due (Rs.)", inv.AmountDue), 10, true)
+	}
+	doc.Gap(10)
+	if inv.OrderID == 0 {
+		doc.Line(fmt.Sprintf("Delivered days: %d   Skipped deliveries: %d", inv.DeliveredDays, inv.SkippedCount), 8, false)
+	}
+	doc.Line("This is a computer-generated invoice and does not require a signature.", 7, false)
+	return doc.Bytes()
+}
+
+// writeInvoice responds with one invoice as JSON (default) or a PDF download (?format=pdf)
+func writeInvoice(w http.ResponseWriter, r *http.Request, inv *Invoice) {
+	if r.URL.Query().Get("format") == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+invoiceFilename(inv, "pdf")+`"`)
+		w.Write(renderInvoicePDF(inv))
+		return
+	}
+	if r.URL.Query().Get("download") == "1" {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+invoiceFilename(inv, "json")+`"`)
+	}
+	json.NewEncoder(w).Encode(inv)
+}
+
+// emailInvoice sends an invoice PDF to the customer (or to override, when given)
+func emailInvoice(inv *Invoice, override string) (string, error) {
+	to := override
+	if to == "" {
+		db.QueryRow("SELECT email FROM users WHERE id = $1", inv.UserID).Scan(&to)
+	}
+	if to == "" {
+		return "", fmt.Errorf("no email address for invoice %d", inv.ID)
+	}
+
+	subject := "Your Taaza invoice " + inv.InvoiceNumber
+	body := fmt.Sprintf(`<p>Hello %s,</p>
+<p>Please find attached your tax invoice <strong>%s</strong> for %s to %s, totalling <strong>Rs. %.2f</strong>
+(amount due <strong>Rs. %.2f</strong> after wallet payments).</p>
+<p>Best regards,<br><strong>Team Taaza</strong></p>`, inv.CustomerName, inv.InvoiceNumber, inv.PeriodStart, inv.PeriodEnd, inv.Total, inv.AmountDue)
+	err := deliverHTMLEmail(to, subject, body, emailAttachment{
+		Filename:    invoiceFilename(inv, "pdf"),
+		ContentType: "application/pdf",
+		Data:        renderInvoicePDF(inv),
+	})
+	if err != nil {
+		return to, err
+	}
+	db.Exec("UPDATE invoices SET emailed_at = NOW() WHERE id = $1", inv.ID)
+	return to, nil
+}
+
+// adminSendInvoiceHandler re-sends an invoice by email (POST {id, email})
+func adminSendInvoiceHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID    int    `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	inv, err := loadInvoice(req.ID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invoice not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("Error fetching invoice:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	to, err := emailInvoice(inv, strings.TrimSpace(req.Email))
+	if err != nil {
+		log.Println("Error emailing invoice:", err)
+		http.Error(w, "Failed to send invoice", http.StatusBadGateway)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "sent_to": to})
+}
+
+// adminHSNHandler lists HSN codes (GET), sets one for a category, a species or a product (PUT) or
+// removes a product override (DELETE ?product_id=); applies to invoices billed afterwards
+func adminHSNHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		rows, err := db.Query(`
+			SELECT category, 0, '', hsn_code, COALESCE(description, ''), gst_rate FROM hsn_codes
+			UNION ALL
+			SELECT '', 0, species, hsn_code, COALESCE(description, ''), gst_rate FROM species_hsn_codes
+			UNION ALL
+			SELECT c.name, p.id, COALESCE(p.species, ''), ph.hsn_code, COALESCE(ph.description, ''), ph.gst_rate
+			FROM product_hsn_codes ph JOIN products p ON ph.product_id = p.id JOIN product_categories c ON p.category_id = c.id
+			ORDER BY 1, 2, 3
+		`)
+		if err != nil {
+			log.Println("Error fetching HSN codes:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		codes := []HSNCode{}
+		for rows.Next() {
+			var h HSNCode
+			rows.Scan(&h.Category, &h.ProductID, &h.Species, &h.Code, &h.Description, &h.GSTRate)
+			codes = append(codes, h)
+		}
+		json.NewEncoder(w).Encode(codes)
+
+	case "PUT":
+		var h HSNCode
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil || (h.Category == "" && h.ProductID == 0 && h.Species == "") || h.Code == "" {
+			http.Error(w, "category, species or product_id, and hsn_code are required", http.StatusBadRequest)
+			return
+		}
+		if h.GSTRate < 0 || h.GSTRate > 28 {
+			http.Error(w, "gst_rate must be between 0 and 28", http.StatusBadRequest)
+			return
+		}
+		var err error
+		if h.ProductID != 0 {
+			_, err = db.Exec(`
+				INSERT INTO product_hsn_codes (product_id, hsn_code, description, gst_rate) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (product_id) DO UPDATE SET hsn_code = $2, description = $3, gst_rate = $4, updated_at = NOW()
+			`, h.ProductID, h.Code, h.Description, h.GSTRate)
+			if err != nil && strings.Contains(err.Error(), "foreign key") {
+				http.Error(w, "Product not found", http.StatusNotFound)
+				return
+			}
+		} else if h.Species != "" {
+			_, err = db.Exec(`
+				INSERT INTO species_hsn_codes (species, hsn_code, description, gst_rate) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (species) DO UPDATE SET hsn_code = $2, description = $3, gst_rate = $4, updated_at = NOW()
+			`, strings.ToLower(strings.TrimSpace(h.Species)), h.Code, h.Description, h.GSTRate)
+		} else {
+			_, err = db.Exec(`
+				INSERT INTO hsn_codes (category, hsn_code, description, gst_rate) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (category) DO UPDATE SET hsn_code = $2, description = $3, gst_rate = $4, updated_at = NOW()
+			`, h.Category, h.Code, h.Description, h.GSTRate)
+		}
+		if err != nil {
+			http.Error(w, "Failed to save HSN code", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	case "DELETE":
+		productID, err := strconv.Atoi(r.URL.Query().Get("product_id"))
+		if err != nil {
+			http.Error(w, "product_id is required", http.StatusBadRequest)
+			return
+		}
+		if _, err := db.Exec("DELETE FROM product_hsn_codes WHERE product_id = $1", productID); err != nil {
+			http.Error(w, "Failed to remove HSN code", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,1150 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// INVENTORY (MOVEMENTS LEDGER PER SKU AND WAREHOUSE)
+// ============================================================================
+//
+// Stock is never stored or edited: every change is an inventory_movements row
+// (receipt from farm, dispatch, customer return, wastage, adjustment) for one
+// stock item in one warehouse, carrying a signed quantity in the item's unit
+// of measure, and the table rejects UPDATE/DELETE. Stock on a date is
+// SUM(quantity) up to that date. Movements carry a unique idempotency key, so
+// a retried request never counts twice.
+//
+// The ledger is the only source of stock. products.stock and
+// product_variants.stock are a cache of what can still be sold (whole packs on
+// hand less open orders), rewritten after every movement; an admin stock edit
+// posts the difference as an adjustment. Checkout still takes its quantity off
+// the cache with a conditional update so two orders cannot sell the last pack.
+//
+// Stock items (SKUs) are kept in step with the catalog: one per product, or
+// one per variant for products with variants. Milk is counted in litres, meat
+// in kg (a variant's pack size is its weight) and everything else in pieces.
+// Delivered subscription deliveries post "delivery:<id>" and delivered orders
+// "order-item:<id>" dispatches from the warehouse serving the address's zone.
+//
+// Routes (admin):
+//   GET  /api/admin/inventory?from=&to=&warehouse_id=&sku=   daily view computed from movements (or &stock_item_id=)
+//   POST /api/admin/inventory                                {date, warehouse_id, idempotency_key, lines: [{sku | stock_item_id, received, wasted, waste_reason}]}
+//   GET  /api/admin/inventory/stock?warehouse_id=&date=      stock on hand per SKU and warehouse
+//   GET  /api/admin/inventory/movements                      ?from&to&sku&warehouse_id&kind&limit&offset
+//   POST /api/admin/inventory/movements                      {kind, sku | stock_item_id, warehouse_id, quantity | counted, date, idempotency_key, note}
+//   /api/admin/stock-items                                   GET list, PUT {id, uom, pack_size, is_active}
+//   /api/admin/warehouses                                    GET list, POST, PUT {id,...}
+
+// Movement kinds
+const (
+	MoveReceipt    = "receipt"
+	MoveDispatch   = "dispatch"
+	MoveReturn     = "return"
+	MoveWastage    = "wastage"
+	MoveAdjustment = "adjustment"
+)
+
+// Units of measure
+const (
+	UomLitre = "l"
+	UomKg    = "kg"
+	UomPiece = "pc"
+)
+
+// defaultWarehouseCode is the warehouse used when a zone has none of its own
+const defaultWarehouseCode = "MAIN"
+
+var (
+	errMovementKind      = errors.New("kind must be receipt, dispatch, return, wastage or adjustment")
+	errMovementQuantity  = errors.New("quantity must be positive (adjustments may be negative, but not zero)")
+	errStockItemNotFound = errors.New("stock item (SKU) not found")
+	errWarehouseNotFound = errors.New("warehouse not found")
+	errWarehouseInvalid  = errors.New("warehouse needs a code and a name")
+	errUomInvalid        = errors.New("uom must be l, kg or pc and pack_size positive")
+)
+
+func inventoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errStockItemNotFound), errors.Is(err, errWarehouseNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errIdempotencyConflict):
+		return http.StatusConflict
+	case errors.Is(err, errMovementKind), errors.Is(err, errMovementQuantity), errors.Is(err, errWarehouseInvalid),
+		errors.Is(err, errUomInvalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+type Warehouse struct {
+	ID       int    `json:"id"`
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	ZoneID   int    `json:"zone_id,omitempty"`
+	IsActive bool   `json:"is_active"`
+}
+
+// StockItem is one SKU. PackSize is how much of the unit one catalog unit holds
+// (a 500 g cut counted in kg has pack size 0.5).
+type StockItem struct {
+	ID        int     `json:"id"`
+	SKU       string  `json:"sku"`
+	ProductID int     `json:"product_id"`
+	VariantID int     `json:"variant_id,omitempty"`
+	Name      string  `json:"name"`
+	Category  string  `json:"category"`
+	MilkType  string  `json:"milk_type,omitempty"`
+	Uom       string  `json:"uom"`
+	PackSize  float64 `json:"pack_size"`
+	IsActive  bool    `json:"is_active"`
+}
+
+type InventoryMovement struct {
+	ID          int     `json:"id"`
+	Kind        string  `json:"kind"`
+	StockItemID int     `json:"stock_item_id"`
+	SKU         string  `json:"sku"`
+	WarehouseID int     `json:"warehouse_id"`
+	Quantity    float64 `json:"quantity"` // signed, in the item's uom: positive adds stock
+	Uom         string  `json:"uom"`
+	Date        string  `json:"date"`
+	DeliveryID  int     `json:"delivery_id,omitempty"`
+	OrderID     int     `json:"order_id,omitempty"`
+	Note        string  `json:"note,omitempty"`
+	CreatedBy   string  `json:"created_by,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+// inventoryMove describes one movement to post. Quantity is positive except
+// for adjustments, where the sign is the direction. WarehouseID 0 means the
+// default warehouse.
+type inventoryMove struct {
+	Key         string
+	Kind        string
+	StockItemID int
+	WarehouseID int
+	Quantity    float64
+	Date        string
+	DeliveryID  int
+	OrderID     int
+	Note        string
+	CreatedBy   string
+}
+
+// InventoryLine is one stock item's movements on one day
+type InventoryLine struct {
+	StockItemID int     `json:"stock_item_id"`
+	SKU         string  `json:"sku"`
+	Name        string  `json:"name"`
+	MilkType    string  `json:"milk_type,omitempty"`
+	Uom         string  `json:"uom"`
+	Opening     float64 `json:"opening"`
+	Received    float64 `json:"received"`
+	Dispatched  float64 `json:"dispatched"`
+	Returned    float64 `json:"returned"`
+	Wasted      float64 `json:"wasted"`
+	Adjusted    float64 `json:"adjusted"`
+	Closing     float64 `json:"closing"`
+}
+
+type InventoryEntry struct {
+	Date  string          `json:"date"`
+	Lines []InventoryLine `json:"lines"`
+}
+
+func initInventorySchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS warehouses (
+			id SERIAL PRIMARY KEY,
+			code TEXT UNIQUE NOT NULL,
+			name TEXT NOT NULL,
+			zone_id INTEGER REFERENCES delivery_zones(id) ON DELETE SET NULL,
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO warehouses (code, name) VALUES ('MAIN', 'Main warehouse') ON CONFLICT (code) DO NOTHING;
+
+		-- variant_id is not a foreign key: variants can be deleted from the catalog
+		-- while their movements must stay
+		CREATE TABLE IF NOT EXISTS stock_items (
+			id SERIAL PRIMARY KEY,
+			sku TEXT UNIQUE NOT NULL,
+			product_id INTEGER NOT NULL REFERENCES products(id),
+			variant_id INTEGER,
+			name TEXT NOT NULL,
+			uom TEXT NOT NULL CHECK (uom IN ('l', 'kg', 'pc')),
+			pack_size DECIMAL(10,3) NOT NULL DEFAULT 1 CHECK (pack_size > 0),
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_items_product ON stock_items(product_id, (COALESCE(variant_id, 0)));
+
+		CREATE TABLE IF NOT EXISTS inventory_movements (
+			id SERIAL PRIMARY KEY,
+			idempotency_key TEXT UNIQUE NOT NULL,
+			kind TEXT NOT NULL CHECK (kind IN ('receipt', 'dispatch', 'return', 'wastage', 'adjustment')),
+			milk_type TEXT,
+			quantity DECIMAL(12,3) NOT NULL CHECK (quantity <> 0),
+			movement_date DATE NOT NULL,
+			delivery_id INTEGER REFERENCES deliveries(id),
+			note TEXT,
+			created_by TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		ALTER TABLE inventory_movements ADD COLUMN IF NOT EXISTS stock_item_id INTEGER REFERENCES stock_items(id);
+		ALTER TABLE inventory_movements ADD COLUMN IF NOT EXISTS warehouse_id INTEGER REFERENCES warehouses(id);
+		ALTER TABLE inventory_movements ADD COLUMN IF NOT EXISTS order_id INTEGER REFERENCES orders(id);
+		CREATE INDEX IF NOT EXISTS idx_inventory_movements_date ON inventory_movements(movement_date, stock_item_id);
+
+		CREATE OR REPLACE FUNCTION inventory_movements_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'inventory_movements is append-only; post an adjustment instead';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS trg_inventory_movements_append_only ON inventory_movements;
+		CREATE TRIGGER trg_inventory_movements_append_only BEFORE UPDATE OR DELETE ON inventory_movements
+			FOR EACH ROW EXECUTE PROCEDURE inventory_movements_append_only();
+	`)
+	if err != nil {
+		log.Fatal("Failed to create inventory schema:", err)
+	}
+
+	// Ledgers from before SKUs had litres only, as whole numbers per milk type
+	applyMigration("inventory_movements_per_sku", `
+		ALTER TABLE inventory_movements ALTER COLUMN milk_type DROP NOT NULL;
+		ALTER TABLE inventory_movements ALTER COLUMN quantity TYPE DECIMAL(12,3);
+	`)
+
+	if err := syncStockItems(db); err != nil {
+		log.Println("Error syncing stock items:", err)
+	}
+
+	// Carry the old per-day totals over as movements. The old sheet did not split
+	// wastage by milk type; it is booked against buffalo. Movements recorded per milk
+	// type then move onto the milk's stock item in the default warehouse; this one-off
+	// backfill is the only write the ledger allows.
+	applyMigration("inventory_legacy_movements", `
+		INSERT INTO inventory_movements (idempotency_key, kind, milk_type, quantity, movement_date, note, created_by)
+		SELECT 'legacy:' || i.id || ':' || m.milk_type || ':' || m.kind, m.kind, m.milk_type, m.quantity, i.date, 'migrated from daily inventory', 'migration'
+		FROM inventory i
+		CROSS JOIN LATERAL (VALUES
+			('buffalo', 'receipt', i.buffalo_stock), ('cow', 'receipt', i.cow_stock),
+			('buffalo', 'dispatch', -i.buffalo_sold), ('cow', 'dispatch', -i.cow_sold),
+			('buffalo', 'wastage', -i.wastage)
+		) AS m(milk_type, kind, quantity)
+		WHERE m.quantity <> 0
+		ON CONFLICT (idempotency_key) DO NOTHING;
+
+		ALTER TABLE inventory_movements DISABLE TRIGGER trg_inventory_movements_append_only;
+		UPDATE inventory_movements m SET
+			stock_item_id = (SELECT s.id FROM stock_items s JOIN products p ON p.id = s.product_id
+			                 WHERE p.milk_type = m.milk_type AND s.variant_id IS NULL ORDER BY s.id LIMIT 1),
+			warehouse_id = (SELECT id FROM warehouses WHERE code = 'MAIN')
+		WHERE m.stock_item_id IS NULL;
+		ALTER TABLE inventory_movements ENABLE TRIGGER trg_inventory_movements_append_only;
+	`)
+
+	// Catalog stock entered before the ledger was authoritative becomes an opening
+	// adjustment, so the cache rebuilt below keeps the numbers admins last saw.
+	// Milk already has real receipts and dispatches.
+	applyMigration("catalog_stock_opening_balance", `
+		INSERT INTO inventory_movements (idempotency_key, kind, stock_item_id, warehouse_id, quantity, movement_date, note, created_by)
+		SELECT 'opening:' || x.id, 'adjustment', x.id, w.id, (COALESCE(v.stock, p.stock) - x.sellable) * x.pack_size, CURRENT_DATE,
+		       'opening balance from catalog stock', 'migration'
+		FROM (`+sellableStockSQL("true")+`) x
+		JOIN products p ON p.id = x.product_id
+		LEFT JOIN product_variants v ON v.id = x.variant_id
+		JOIN warehouses w ON w.code = 'MAIN'
+		WHERE p.milk_type IS NULL AND COALESCE(v.stock, p.stock) <> x.sellable
+	`)
+	if err := refreshSellableStock(db, 0); err != nil {
+		log.Println("Error refreshing catalog stock:", err)
+	}
+}
+
+// roundQty rounds a stock quantity to the ledger's precision (grams / millilitres)
+func roundQty(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
+
+// syncStockItems gives every catalog product (or each of its variants) a stock
+// item and retires items whose product or variant has gone
+func syncStockItems(q dbExecer) error {
+	_, err := q.Exec(`
+		INSERT INTO stock_items (sku, product_id, variant_id, name, uom, pack_size)
+		SELECT COALESCE(NULLIF(v.sku, ''), 'P' || p.id || '-V' || v.id), p.id, v.id, p.name || ' - ' || v.name,
+		       CASE WHEN c.name = 'Meat' THEN 'kg' ELSE 'pc' END,
+		       CASE WHEN c.name = 'Meat' AND v.weight_grams > 0 THEN v.weight_grams / 1000.0 ELSE 1 END
+		FROM product_variants v
+		JOIN products p ON p.id = v.product_id
+		JOIN product_categories c ON c.id = p.category_id
+		WHERE p.archived_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM stock_items s WHERE s.product_id = p.id AND s.variant_id = v.id)
+		  AND NOT EXISTS (SELECT 1 FROM stock_items s WHERE s.sku = COALESCE(NULLIF(v.sku, ''), 'P' || p.id || '-V' || v.id));
+
+		INSERT INTO stock_items (sku, product_id, name, uom)
+		SELECT CASE WHEN p.milk_type IS NOT NULL THEN 'MILK-' || UPPER(p.milk_type) ELSE 'P' || p.id END, p.id, p.name,
+		       CASE WHEN p.milk_type IS NOT NULL THEN 'l' WHEN c.name = 'Meat' THEN 'kg' ELSE 'pc' END
+		FROM products p
+		JOIN product_categories c ON c.id = p.category_id
+		WHERE p.archived_at IS NULL
+		  AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)
+		  AND NOT EXISTS (SELECT 1 FROM stock_items s WHERE s.product_id = p.id AND s.variant_id IS NULL)
+		  AND NOT EXISTS (SELECT 1 FROM stock_items s
+		                  WHERE s.sku = CASE WHEN p.milk_type IS NOT NULL THEN 'MILK-' || UPPER(p.milk_type) ELSE 'P' || p.id END);
+
+		UPDATE stock_items s SET is_active = false
+		WHERE s.is_active AND (
+			EXISTS (SELECT 1 FROM products p WHERE p.id = s.product_id AND p.archived_at IS NOT NULL)
+			OR (s.variant_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.id = s.variant_id))
+			OR (s.variant_id IS NULL AND EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = s.product_id)));
+	`)
+	return err
+}
+
+const stockItemColumns = `s.id, s.sku, s.product_id, COALESCE(s.variant_id, 0), s.name, c.name, COALESCE(p.milk_type, ''),
+	s.uom, s.pack_size, s.is_active`
+
+const stockItemJoins = ` FROM stock_items s JOIN products p ON p.id = s.product_id JOIN product_categories c ON c.id = p.category_id`
+
+func scanStockItem(row interface{ Scan(...interface{}) error }) (*StockItem, error) {
+	var s StockItem
+	err := row.Scan(&s.ID, &s.SKU, &s.ProductID, &s.VariantID, &s.Name, &s.Category, &s.MilkType, &s.Uom, &s.PackSize, &s.IsActive)
+	if err == sql.ErrNoRows {
+		return nil, errStockItemNotFound
+	}
+	return &s, err
+}
+
+// stockItemBySKU finds a stock item by id when stockItemID is set, otherwise by SKU
+func stockItemBySKU(q dbExecer, stockItemID int, sku string) (*StockItem, error) {
+	if stockItemID != 0 {
+		return scanStockItem(q.QueryRow(`SELECT `+stockItemColumns+stockItemJoins+` WHERE s.id = $1`, stockItemID))
+	}
+	return scanStockItem(q.QueryRow(`SELECT `+stockItemColumns+stockItemJoins+` WHERE s.sku = $1`, strings.TrimSpace(sku)))
+}
+
+// stockItemForProduct finds the stock item of a catalog product or variant
+func stockItemForProduct(q dbExecer, productID, variantID int) (*StockItem, error) {
+	return scanStockItem(q.QueryRow(`SELECT `+stockItemColumns+stockItemJoins+`
+		WHERE s.product_id = $1 AND COALESCE(s.variant_id, 0) = $2`, productID, variantID))
+}
+
+// stockItemForMilk finds the stock item of a milk type's product
+func stockItemForMilk(q dbExecer, milkType string) (*StockItem, error) {
+	return scanStockItem(q.QueryRow(`SELECT `+stockItemColumns+stockItemJoins+`
+		WHERE p.milk_type = $1 AND s.variant_id IS NULL ORDER BY s.is_active DESC, s.id LIMIT 1`, milkType))
+}
+
+// warehouseForZone returns the active warehouse serving a zone, else the default one
+func warehouseForZone(q dbExecer, zoneID int) (int, error) {
+	var id int
+	err := q.QueryRow(`
+		SELECT id FROM warehouses
+		WHERE is_active AND (zone_id = $1 OR code = $2)
+		ORDER BY (zone_id = $1) DESC NULLS LAST, id LIMIT 1`, zoneID, defaultWarehouseCode).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errWarehouseNotFound
+	}
+	return id, err
+}
+
+// itemStock is the stock of an item at the end of a date, in one warehouse (or all with 0)
+func itemStock(q dbExecer, stockItemID, warehouseID int, date string) (float64, error) {
+	var stock float64
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(quantity), 0) FROM inventory_movements
+		WHERE stock_item_id = $1 AND ($2 = 0 OR warehouse_id = $2) AND movement_date <= $3`,
+		stockItemID, warehouseID, date).Scan(&stock)
+	return stock, err
+}
+
+// sellableStockSQL selects, per active stock item matching filter, how many
+// catalog units can still be sold: whole packs on hand across warehouses less
+// the quantity on orders that are neither cancelled nor delivered (a delivered
+// order has already been dispatched from the ledger)
+func sellableStockSQL(filter string) string {
+	return `
+		SELECT s.id, s.product_id, s.variant_id, s.pack_size,
+		       FLOOR(COALESCE((SELECT SUM(m.quantity) FROM inventory_movements m WHERE m.stock_item_id = s.id), 0) / s.pack_size)::int
+		       - COALESCE((SELECT SUM(oi.quantity) FROM order_items oi JOIN orders o ON o.id = oi.order_id
+		                   WHERE oi.product_id = s.product_id AND oi.variant_id IS NOT DISTINCT FROM s.variant_id
+		                     AND o.status NOT IN ('` + OrderCancelled + `', '` + OrderDelivered + `')), 0)::int AS sellable
+		FROM stock_items s
+		WHERE s.is_active AND ` + filter
+}
+
+// refreshSellableStock rewrites the catalog stock of a stock item (every item
+// with 0) from the ledger. Listings are revalidated on products.updated_at, so
+// it is bumped only when a number changes.
+func refreshSellableStock(q dbExecer, stockItemID int) error {
+	_, err := q.Exec(`
+		WITH x AS (`+sellableStockSQL("($1 = 0 OR s.id = $1)")+`),
+		v AS (
+			UPDATE product_variants v SET stock = GREATEST(x.sellable, 0)
+			FROM x WHERE v.id = x.variant_id AND v.stock <> GREATEST(x.sellable, 0)
+			RETURNING v.product_id
+		)
+		UPDATE products p SET stock = CASE WHEN x.variant_id IS NULL THEN GREATEST(x.sellable, 0) ELSE p.stock END, updated_at = NOW()
+		FROM x
+		WHERE p.id = x.product_id
+		  AND ((x.variant_id IS NULL AND p.stock <> GREATEST(x.sellable, 0)) OR p.id IN (SELECT product_id FROM v))
+	`, stockItemID)
+	return err
+}
+
+// setSellableStock brings the catalog stock of a product or variant to stock by
+// posting the difference as an adjustment in the default warehouse
+func setSellableStock(q dbExecer, productID, variantID, stock int, actor string) error {
+	if stock < 0 {
+		return errNegativeStock
+	}
+	item, err := stockItemForProduct(q, productID, variantID)
+	if err != nil {
+		return err
+	}
+	var sellable int
+	err = q.QueryRow(`SELECT sellable FROM (`+sellableStockSQL("s.id = $1")+`) x`, item.ID).Scan(&sellable)
+	if err == sql.ErrNoRows {
+		return errStockItemNotFound
+	} else if err != nil {
+		return err
+	}
+	if sellable == stock {
+		return refreshSellableStock(q, item.ID)
+	}
+	_, _, err = postInventoryMovement(q, inventoryMove{
+		Key:         "catalog-stock:" + strconv.Itoa(item.ID) + ":" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		Kind:        MoveAdjustment,
+		StockItemID: item.ID,
+		Quantity:    float64(stock-sellable) * item.PackSize,
+		Note:        "catalog stock set to " + strconv.Itoa(stock),
+		CreatedBy:   actor,
+	})
+	return err
+}
+
+// postInventoryMovement records a movement. If the idempotency key was already
+// used for the same movement the original id is returned with replayed=true.
+func postInventoryMovement(q dbExecer, m inventoryMove) (id int, replayed bool, err error) {
+	m.Quantity = roundQty(m.Quantity)
+	signed := m.Quantity
+	switch m.Kind {
+	case MoveReceipt, MoveReturn, MoveAdjustment:
+	case MoveDispatch, MoveWastage:
+		signed = -m.Quantity
+	default:
+		return 0, false, errMovementKind
+	}
+	if m.Quantity == 0 || (m.Kind != MoveAdjustment && m.Quantity < 0) {
+		return 0, false, errMovementQuantity
+	}
+	if m.WarehouseID == 0 {
+		if m.WarehouseID, err = warehouseForZone(q, 0); err != nil {
+			return 0, false, err
+		}
+	}
+	if m.Date == "" {
+		m.Date = time.Now().Format("2006-01-02")
+	}
+	var deliveryArg, orderArg interface{}
+	if m.DeliveryID != 0 {
+		deliveryArg = m.DeliveryID
+	}
+	if m.OrderID != 0 {
+		orderArg = m.OrderID
+	}
+
+	err = q.QueryRow(`
+		INSERT INTO inventory_movements (idempotency_key, kind, stock_item_id, warehouse_id, milk_type, quantity, movement_date,
+		                                 delivery_id, order_id, note, created_by)
+		SELECT $1, $2, s.id, w.id, p.milk_type, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, '')
+		FROM stock_items s JOIN products p ON p.id = s.product_id, warehouses w
+		WHERE s.id = $3 AND w.id = $4
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id
+	`, m.Key, m.Kind, m.StockItemID, m.WarehouseID, signed, m.Date, deliveryArg, orderArg, m.Note, m.CreatedBy).Scan(&id)
+	if err == sql.ErrNoRows {
+		// Either a replay (same key must mean the same movement) or an unknown item/warehouse
+		var kind string
+		var itemID, warehouseID int
+		var quantity float64
+		err = q.QueryRow(`SELECT id, kind, COALESCE(stock_item_id, 0), COALESCE(warehouse_id, 0), quantity
+			FROM inventory_movements WHERE idempotency_key = $1`, m.Key).Scan(&id, &kind, &itemID, &warehouseID, &quantity)
+		if err == sql.ErrNoRows {
+			var exists bool
+			q.QueryRow("SELECT EXISTS (SELECT 1 FROM stock_items WHERE id = $1)", m.StockItemID).Scan(&exists)
+			if !exists {
+				return 0, false, errStockItemNotFound
+			}
+			return 0, false, errWarehouseNotFound
+		} else if err != nil {
+			return 0, false, err
+		}
+		if kind != m.Kind || itemID != m.StockItemID || warehouseID != m.WarehouseID || roundQty(quantity) != signed {
+			return 0, false, errIdempotencyConflict
+		}
+		return id, true, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return id, false, refreshSellableStock(q, m.StockItemID)
+}
+
+// recordDeliveryDispatch takes a delivered subscription delivery out of stock
+func recordDeliveryDispatch(q dbExecer, deliveryID int, actor string) error {
+	var milkType, date string
+	var quantity float64
+	var zoneID int
+	err := q.QueryRow(`
+		SELECT d.milk_type, d.delivery_date::text, d.quantity, COALESCE(a.zone_id, 0)
+		FROM deliveries d
+		LEFT JOIN milk_subscriptions ms ON ms.id = d.subscription_id
+		LEFT JOIN addresses a ON a.id = ms.address_id
+		WHERE d.id = $1`, deliveryID).Scan(&milkType, &date, &quantity, &zoneID)
+	if err != nil {
+		return err
+	}
+	item, err := stockItemForMilk(q, milkType)
+	if errors.Is(err, errStockItemNotFound) || quantity <= 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	warehouseID, err := warehouseForZone(q, zoneID)
+	if err != nil {
+		return err
+	}
+	_, _, err = postInventoryMovement(q, inventoryMove{
+		Key:         "delivery:" + strconv.Itoa(deliveryID),
+		Kind:        MoveDispatch,
+		StockItemID: item.ID,
+		WarehouseID: warehouseID,
+		Quantity:    quantity * item.PackSize,
+		Date:        date,
+		DeliveryID:  deliveryID,
+		CreatedBy:   actor,
+	})
+	return err
+}
+
+// recordOrderDispatch takes a delivered order's lines out of stock
+func recordOrderDispatch(q dbExecer, orderID int, actor string) error {
+	var zoneID int
+	if err := q.QueryRow("SELECT COALESCE(zone_id, 0) FROM orders WHERE id = $1", orderID).Scan(&zoneID); err != nil {
+		return err
+	}
+	warehouseID, err := warehouseForZone(q, zoneID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := q.Query(`SELECT id, product_id, COALESCE(variant_id, 0), quantity FROM order_items
+		WHERE order_id = $1 AND product_id IS NOT NULL ORDER BY id`, orderID)
+	if err != nil {
+		return err
+	}
+	type line struct{ id, productID, variantID, quantity int }
+	var lines []line
+	for rows.Next() {
+		var l line
+		rows.Scan(&l.id, &l.productID, &l.variantID, &l.quantity)
+		lines = append(lines, l)
+	}
+	rows.Close()
+
+	for _, l := range lines {
+		item, err := stockItemForProduct(q, l.productID, l.variantID)
+		if errors.Is(err, errStockItemNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		_, _, err = postInventoryMovement(q, inventoryMove{
+			Key:         "order-item:" + strconv.Itoa(l.id),
+			Kind:        MoveDispatch,
+			StockItemID: item.ID,
+			WarehouseID: warehouseID,
+			Quantity:    float64(l.quantity) * item.PackSize,
+			OrderID:     orderID,
+			CreatedBy:   actor,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dailyInventory computes the daily view for [from, to], most recent day first.
+// warehouseID 0 sums all warehouses; stockItemID 0 covers every item.
+func dailyInventory(from, to time.Time, warehouseID, stockItemID int) ([]InventoryEntry, error) {
+	rows, err := db.Query(`SELECT `+stockItemColumns+stockItemJoins+`
+		WHERE ($1 = 0 OR s.id = $1) ORDER BY c.sort_order, p.sort_order, s.id`, stockItemID)
+	if err != nil {
+		return nil, err
+	}
+	var items []StockItem
+	for rows.Next() {
+		if s, err := scanStockItem(rows); err == nil {
+			items = append(items, *s)
+		}
+	}
+	rows.Close()
+
+	balance := map[int]float64{}
+	rows, err = db.Query(`SELECT stock_item_id, SUM(quantity) FROM inventory_movements
+		WHERE movement_date < $1 AND ($2 = 0 OR warehouse_id = $2) AND stock_item_id IS NOT NULL
+		GROUP BY stock_item_id`, from.Format("2006-01-02"), warehouseID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var qty float64
+		rows.Scan(&id, &qty)
+		balance[id] = qty
+	}
+	rows.Close()
+
+	type dayKey struct {
+		date   string
+		itemID int
+	}
+	sums := map[dayKey]*InventoryLine{}
+	moved := map[int]bool{}
+	rows, err = db.Query(`
+		SELECT movement_date::text, stock_item_id, kind, SUM(quantity) FROM inventory_movements
+		WHERE movement_date BETWEEN $1 AND $2 AND ($3 = 0 OR warehouse_id = $3) AND stock_item_id IS NOT NULL
+		GROUP BY movement_date, stock_item_id, kind`, from.Format("2006-01-02"), to.Format("2006-01-02"), warehouseID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var date, kind string
+		var itemID int
+		var qty float64
+		rows.Scan(&date, &itemID, &kind, &qty)
+		k := dayKey{date, itemID}
+		if sums[k] == nil {
+			sums[k] = &InventoryLine{}
+		}
+		moved[itemID] = true
+		switch kind {
+		case MoveReceipt:
+			sums[k].Received += qty
+		case MoveDispatch:
+			sums[k].Dispatched -= qty
+		case MoveReturn:
+			sums[k].Returned += qty
+		case MoveWastage:
+			sums[k].Wasted -= qty
+		case MoveAdjustment:
+			sums[k].Adjusted += qty
+		}
+	}
+	rows.Close()
+
+	// Retired items are only shown while they still hold or move stock
+	var shown []StockItem
+	for _, s := range items {
+		if s.IsActive || balance[s.ID] != 0 || moved[s.ID] {
+			shown = append(shown, s)
+		}
+	}
+
+	var entries []InventoryEntry
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		e := InventoryEntry{Date: date, Lines: []InventoryLine{}}
+		for _, s := range shown {
+			line := InventoryLine{StockItemID: s.ID, SKU: s.SKU, Name: s.Name, MilkType: s.MilkType, Uom: s.Uom, Opening: balance[s.ID]}
+			if m := sums[dayKey{date, s.ID}]; m != nil {
+				line.Received, line.Dispatched, line.Returned, line.Wasted, line.Adjusted = m.Received, m.Dispatched, m.Returned, m.Wasted, m.Adjusted
+			}
+			line.Closing = roundQty(line.Opening + line.Received - line.Dispatched + line.Returned - line.Wasted + line.Adjusted)
+			balance[s.ID] = line.Closing
+			e.Lines = append(e.Lines, line)
+		}
+		entries = append(entries, e)
+	}
+
+	// most recent first, as the dashboard reads entries[0] as today
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if entries == nil {
+		entries = []InventoryEntry{}
+	}
+	return entries, nil
+}
+
+// inventoryRange reads ?from=&to= (default: the last 30 days up to today)
+func inventoryRange(r *http.Request) (time.Time, time.Time, error) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			return from, to, err
+		}
+		from = to.AddDate(0, 0, -29)
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			return from, to, err
+		}
+	}
+	if from.After(to) || to.Sub(from) > 366*24*time.Hour {
+		return from, to, errors.New("from must be before to, at most a year apart")
+	}
+	return from, to, nil
+}
+
+// --- Handlers ---
+
+func writeInventoryError(w http.ResponseWriter, err error, context string) {
+	if inventoryErrorStatus(err) == http.StatusInternalServerError {
+		log.Println("Error "+context+":", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, err.Error(), inventoryErrorStatus(err))
+}
+
+// adminInventoryHandler serves the daily view (GET) and records a day's farm
+// receipts and wastage for several SKUs at once (POST)
+func adminInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		from, to, err := inventoryRange(r)
+		if err != nil {
+			http.Error(w, "Invalid date range: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		warehouseID, _ := strconv.Atoi(r.URL.Query().Get("warehouse_id"))
+		itemID, _ := strconv.Atoi(r.URL.Query().Get("stock_item_id"))
+		if sku := r.URL.Query().Get("sku"); sku != "" && itemID == 0 {
+			item, err := stockItemBySKU(db, 0, sku)
+			if err != nil {
+				writeInventoryError(w, err, "finding stock item")
+				return
+			}
+			itemID = item.ID
+		}
+		entries, err := dailyInventory(from, to, warehouseID, itemID)
+		if err != nil {
+			log.Println("Error computing inventory:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+
+	case "POST":
+		var req struct {
+			Date           string `json:"date"`
+			WarehouseID    int    `json:"warehouse_id"`
+			IdempotencyKey string `json:"idempotency_key"`
+			Lines          []struct {
+				StockItemID int     `json:"stock_item_id"`
+				SKU         string  `json:"sku"`
+				Received    float64 `json:"received"`
+				Wasted      float64 `json:"wasted"`
+				WasteReason string  `json:"waste_reason"`
+			} `json:"lines"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.IdempotencyKey == "" {
+			req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+		}
+		if req.IdempotencyKey == "" {
+			http.Error(w, "idempotency_key is required", http.StatusBadRequest)
+			return
+		}
+		actorEmail, _ := requestActor(r)
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		if req.Date == "" {
+			req.Date = time.Now().Format("2006-01-02")
+		}
+		ids := []int{}
+		wasted := false
+		for _, l := range req.Lines {
+			item, err := stockItemBySKU(tx, l.StockItemID, l.SKU)
+			if err != nil {
+				writeInventoryError(w, err, "finding stock item")
+				return
+			}
+			if l.Received != 0 {
+				id, _, err := postInventoryMovement(tx, inventoryMove{
+					Key:         "admin:" + req.IdempotencyKey + ":" + item.SKU + ":" + MoveReceipt,
+					Kind:        MoveReceipt,
+					StockItemID: item.ID,
+					WarehouseID: req.WarehouseID,
+					Quantity:    l.Received,
+					Date:        req.Date,
+					CreatedBy:   actorEmail,
+				})
+				if err != nil {
+					writeInventoryError(w, err, "recording inventory")
+					return
+				}
+				ids = append(ids, id)
+			}
+			if l.Wasted != 0 {
+				if l.WasteReason == "" {
+					l.WasteReason = "spoilage"
+				}
+				_, replayed, err := recordWastage(tx, wastageInput{
+					IdempotencyKey: "admin:" + req.IdempotencyKey + ":" + item.SKU + ":" + MoveWastage,
+					Reason:         l.WasteReason,
+					SKU:            strconv.Itoa(item.ID),
+					WarehouseID:    req.WarehouseID,
+					Quantity:       l.Wasted,
+					Date:           req.Date,
+					ReportedBy:     actorEmail,
+				})
+				if err != nil {
+					writeWastageError(w, err, "recording wastage")
+					return
+				}
+				wasted = wasted || !replayed
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if wasted {
+			go evaluateWastageThresholds(req.Date)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "movement_ids": ids})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminInventoryStockHandler lists stock on hand per SKU and warehouse: GET ?warehouse_id=&date=
+func adminInventoryStockHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+	warehouseID, _ := strconv.Atoi(r.URL.Query().Get("warehouse_id"))
+	rows, err := db.Query(`
+		SELECT s.id, s.sku, s.name, s.uom, w.id, w.code, COALESCE(SUM(m.quantity), 0)
+		FROM stock_items s
+		CROSS JOIN warehouses w
+		LEFT JOIN inventory_movements m ON m.stock_item_id = s.id AND m.warehouse_id = w.id AND m.movement_date <= $1
+		WHERE ($2 = 0 OR w.id = $2) AND (s.is_active OR m.id IS NOT NULL) AND (w.is_active OR m.id IS NOT NULL)
+		GROUP BY s.id, w.id
+		ORDER BY w.id, s.id
+	`, date, warehouseID)
+	if err != nil {
+		log.Println("Error fetching stock:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	type stockRow struct {
+		StockItemID int     `json:"stock_item_id"`
+		SKU         string  `json:"sku"`
+		Name        string  `json:"name"`
+		Uom         string  `json:"uom"`
+		WarehouseID int     `json:"warehouse_id"`
+		Warehouse   string  `json:"warehouse"`
+		Quantity    float64 `json:"quantity"`
+	}
+	stock := []stockRow{}
+	for rows.Next() {
+		var s stockRow
+		rows.Scan(&s.StockItemID, &s.SKU, &s.Name, &s.Uom, &s.WarehouseID, &s.Warehouse, &s.Quantity)
+		stock = append(stock, s)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"date": date, "stock": stock})
+}
+
+// adminInventoryMovementsHandler lists the ledger (GET) or posts one movement (POST).
+// An adjustment may give counted stock instead of a quantity; the difference from
+// the computed stock on that date is posted.
+func adminInventoryMovementsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		from, to, err := inventoryRange(r)
+		if err != nil {
+			http.Error(w, "Invalid date range: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit, offset := paginationParams(r)
+		q := r.URL.Query()
+		warehouseID, _ := strconv.Atoi(q.Get("warehouse_id"))
+		rows, err := db.Query(`
+			SELECT m.id, m.kind, COALESCE(m.stock_item_id, 0), COALESCE(s.sku, ''), COALESCE(m.warehouse_id, 0), m.quantity,
+			       COALESCE(s.uom, ''), m.movement_date::text, COALESCE(m.delivery_id, 0), COALESCE(m.order_id, 0),
+			       COALESCE(m.note, ''), COALESCE(m.created_by, ''), m.created_at::text, COUNT(*) OVER ()
+			FROM inventory_movements m LEFT JOIN stock_items s ON s.id = m.stock_item_id
+			WHERE m.movement_date BETWEEN $1 AND $2 AND ($3 = '' OR s.sku = $3) AND ($4 = 0 OR m.warehouse_id = $4)
+			  AND ($5 = '' OR m.kind = $5)
+			ORDER BY m.movement_date DESC, m.id DESC LIMIT $6 OFFSET $7
+		`, from.Format("2006-01-02"), to.Format("2006-01-02"), q.Get("sku"), warehouseID, q.Get("kind"), limit, offset)
+		if err != nil {
+			log.Println("Error fetching inventory movements:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		movements := []InventoryMovement{}
+		total := 0
+		for rows.Next() {
+			var m InventoryMovement
+			rows.Scan(&m.ID, &m.Kind, &m.StockItemID, &m.SKU, &m.WarehouseID, &m.Quantity, &m.Uom, &m.Date, &m.DeliveryID,
+				&m.OrderID, &m.Note, &m.CreatedBy, &m.CreatedAt, &total)
+			movements = append(movements, m)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"movements": movements, "total": total, "limit": limit, "offset": offset})
+
+	case "POST":
+		var req struct {
+			Kind           string   `json:"kind"`
+			StockItemID    int      `json:"stock_item_id"`
+			SKU            string   `json:"sku"`
+			WarehouseID    int      `json:"warehouse_id"`
+			Quantity       float64  `json:"quantity"`
+			Counted        *float64 `json:"counted"`
+			Date           string   `json:"date"`
+			DeliveryID     int      `json:"delivery_id"`
+			Note           string   `json:"note"`
+			IdempotencyKey string   `json:"idempotency_key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.IdempotencyKey == "" {
+			http.Error(w, "idempotency_key is required", http.StatusBadRequest)
+			return
+		}
+		if req.Date == "" {
+			req.Date = time.Now().Format("2006-01-02")
+		}
+		// Dispatches come from deliveries and orders; recording them by hand would count them twice
+		if req.Kind == MoveDispatch {
+			http.Error(w, "dispatches are recorded when deliveries and orders are marked Delivered", http.StatusBadRequest)
+			return
+		}
+		// Wastage needs a reason and attribution, so it goes through /api/admin/wastage
+		if req.Kind == MoveWastage {
+			http.Error(w, "record wastage through /api/admin/wastage with a reason", http.StatusBadRequest)
+			return
+		}
+		actorEmail, _ := requestActor(r)
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		item, err := stockItemBySKU(tx, req.StockItemID, req.SKU)
+		if err != nil {
+			writeInventoryError(w, err, "finding stock item")
+			return
+		}
+		if req.WarehouseID == 0 {
+			if req.WarehouseID, err = warehouseForZone(tx, 0); err != nil {
+				writeInventoryError(w, err, "finding warehouse")
+				return
+			}
+		}
+
+		if req.Kind == MoveAdjustment && req.Counted != nil {
+			// Serialise stock counts so two counts can't both post the same difference
+			tx.Exec("SELECT pg_advisory_xact_lock($1, $2)", item.ID, req.WarehouseID)
+			stock, err := itemStock(tx, item.ID, req.WarehouseID, req.Date)
+			if err != nil {
+				log.Println("Error reading stock:", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			req.Quantity = *req.Counted - stock
+			if roundQty(req.Quantity) == 0 {
+				json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "stock": stock, "adjusted": 0})
+				return
+			}
+		}
+
+		id, replayed, err := postInventoryMovement(tx, inventoryMove{
+			Key:         "admin:" + req.IdempotencyKey,
+			Kind:        req.Kind,
+			StockItemID: item.ID,
+			WarehouseID: req.WarehouseID,
+			Quantity:    req.Quantity,
+			Date:        req.Date,
+			DeliveryID:  req.DeliveryID,
+			Note:        req.Note,
+			CreatedBy:   actorEmail,
+		})
+		if err != nil {
+			writeInventoryError(w, err, "recording inventory movement")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		stock, _ := itemStock(db, item.ID, req.WarehouseID, req.Date)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id, "replayed": replayed, "stock": stock, "uom": item.Uom})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminStockItemsHandler lists SKUs (GET, syncing them with the catalog first)
+// and sets an item's unit, pack size or active flag (PUT)
+func adminStockItemsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		if err := syncStockItems(db); err != nil {
+			log.Println("Error syncing stock items:", err)
+		}
+		rows, err := db.Query(`SELECT ` + stockItemColumns + stockItemJoins + ` ORDER BY c.sort_order, p.sort_order, s.id`)
+		if err != nil {
+			log.Println("Error fetching stock items:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		items := []StockItem{}
+		for rows.Next() {
+			if s, err := scanStockItem(rows); err == nil {
+				items = append(items, *s)
+			}
+		}
+		json.NewEncoder(w).Encode(items)
+
+	case "PUT":
+		var req struct {
+			ID       int     `json:"id"`
+			Uom      string  `json:"uom"`
+			PackSize float64 `json:"pack_size"`
+			IsActive *bool   `json:"is_active"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if (req.Uom != "" && req.Uom != UomLitre && req.Uom != UomKg && req.Uom != UomPiece) || req.PackSize < 0 {
+			http.Error(w, errUomInvalid.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := db.Exec(`UPDATE stock_items SET uom = COALESCE(NULLIF($1, ''), uom), pack_size = COALESCE(NULLIF($2, 0), pack_size),
+			is_active = COALESCE($3, is_active) WHERE id = $4`, req.Uom, req.PackSize, req.IsActive, req.ID)
+		if err != nil {
+			log.Println("Error updating stock item:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, errStockItemNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminWarehousesHandler manages warehouses: GET list, POST create, PUT {id,...} update
+func adminWarehousesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		rows, err := db.Query("SELECT id, code, name, COALESCE(zone_id, 0), is_active FROM warehouses ORDER BY id")
+		if err != nil {
+			log.Println("Error fetching warehouses:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		warehouses := []Warehouse{}
+		for rows.Next() {
+			var wh Warehouse
+			rows.Scan(&wh.ID, &wh.Code, &wh.Name, &wh.ZoneID, &wh.IsActive)
+			warehouses = append(warehouses, wh)
+		}
+		json.NewEncoder(w).Encode(warehouses)
+
+	case "POST", "PUT":
+		wh := Warehouse{IsActive: true}
+		if err := json.NewDecoder(r.Body).Decode(&wh); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		wh.Code = strings.ToUpper(strings.TrimSpace(wh.Code))
+		wh.Name = strings.TrimSpace(wh.Name)
+		if wh.Code == "" || wh.Name == "" {
+			http.Error(w, errWarehouseInvalid.Error(), http.StatusBadRequest)
+			return
+		}
+		var zoneArg interface{}
+		if wh.ZoneID != 0 {
+			zoneArg = wh.ZoneID
+		}
+		var err error
+		if r.Method == "POST" {
+			err = db.QueryRow("INSERT INTO warehouses (code, name, zone_id, is_active) VALUES ($1, $2, $3, $4) RETURNING id",
+				wh.Code, wh.Name, zoneArg, wh.IsActive).Scan(&wh.ID)
+		} else {
+			err = db.QueryRow("UPDATE warehouses SET code = $1, name = $2, zone_id = $3, is_active = $4 WHERE id = $5 RETURNING id",
+				wh.Code, wh.Name, zoneArg, wh.IsActive, wh.ID).Scan(&wh.ID)
+		}
+		if err == sql.ErrNoRows {
+			http.Error(w, errWarehouseNotFound.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error saving warehouse:", err)
+			http.Error(w, "Could not save warehouse (the code may already be in use, or the zone does not exist)", http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": wh.ID})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
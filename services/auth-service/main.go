@@ -4,9 +4,11 @@ import (
 	"context"
 	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
@@ -259,9 +261,63 @@ func initSchema() {
 	db.Exec(`INSERT INTO pricing (milk_type, price, previous_price) VALUES ('buffalo', 90, 85) ON CONFLICT (milk_type) DO NOTHING`)
 	db.Exec(`INSERT INTO pricing (milk_type, price, previous_price) VALUES ('cow', 60, 55) ON CONFLICT (milk_type) DO NOTHING`)
 	
+	initSubscriptionEventsSchema()
+	initDeliveryLifecycleSchema()
+	initRidersSchema()
+	initBillingSchema()
+	initPriceHistorySchema()
+	initWalletSchema()
+	initWalletPolicySchema()
+	initPaymentsSchema()
+	initProductsSchema()
+	initOrdersSchema()
+	initGSTSchema()
+	initCartSchema()
+	initMuttonBookingSchema()
+	initPrepOptionsSchema()
+	initDeliverySlotsSchema()
+	initZonesSchema()
+	initInventorySchema()
+	initProcurementSchema()
+	initWastageSchema()
+	initRetentionSchema()
+	
 	log.Println("Schema initialized with milk subscription tables")
 }
 
+// applyMigration runs a one-off data or schema change the first time a database sees it.
+// Applied names are kept in schema_migrations; the change and its record commit together.
+func applyMigration(name, stmt string) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Fatal("Failed to apply migration "+name+":", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		log.Fatal("Failed to apply migration "+name+":", err)
+	}
+	res, err := tx.Exec("INSERT INTO schema_migrations (name) VALUES ($1) ON CONFLICT DO NOTHING", name)
+	if err != nil {
+		log.Fatal("Failed to apply migration "+name+":", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return
+	}
+	if _, err := tx.Exec(stmt); err != nil {
+		log.Fatal("Failed to apply migration "+name+":", err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("Failed to apply migration "+name+":", err)
+	}
+	log.Println("Applied migration", name)
+}
+
 // --- Models ---
 type OTPEntry struct {
 	Email     string    `json:"email"`
@@ -293,21 +349,26 @@ type Address struct {
 	ReceiverName  string  `json:"receiver_name"`
 	ReceiverPhone string  `json:"receiver_phone"`
 	IsDefault     bool    `json:"is_default"`
+	Pincode       string  `json:"pincode"`
+	ZoneID        int     `json:"zone_id,omitempty"`
+	ZoneName      string  `json:"zone_name,omitempty"`
 	CreatedAt     string  `json:"created_at"`
 }
 
 // --- Milk Subscription Models ---
 type MilkSubscription struct {
-	ID           int                `json:"id"`
-	UserID       int                `json:"user_id"`
-	AddressID    int                `json:"address_id"`
-	Status       string             `json:"status"`
-	AutoPay      bool               `json:"auto_pay"`
-	CustomerName string             `json:"customer_name,omitempty"`
-	Address      string             `json:"address,omitempty"`
-	Slots        []SubscriptionSlot `json:"slots,omitempty"`
-	CreatedAt    string             `json:"created_at"`
-	UpdatedAt    string             `json:"updated_at"`
+	ID            int                `json:"id"`
+	UserID        int                `json:"user_id"`
+	AddressID     int                `json:"address_id"`
+	Status        string             `json:"status"`
+	AutoPay       bool               `json:"auto_pay"`
+	CustomerName  string             `json:"customer_name,omitempty"`
+	Address       string             `json:"address,omitempty"`
+	Slots         []SubscriptionSlot `json:"slots,omitempty"`
+	WalletBalance float64            `json:"wallet_balance"`
+	RunsOutOn     string             `json:"runs_out_on,omitempty"`
+	CreatedAt     string             `json:"created_at"`
+	UpdatedAt     string             `json:"updated_at"`
 }
 
 type SubscriptionSlot struct {
@@ -336,24 +397,18 @@ type Delivery struct {
 	Status         string  `json:"status"`
 	DeliveredAt    string  `json:"delivered_at,omitempty"`
 	DeliveredBy    string  `json:"delivered_by,omitempty"`
-}
-
-type InventoryEntry struct {
-	ID          int     `json:"id"`
-	Date        string  `json:"date"`
-	BuffaloStock float64 `json:"buffalo_stock"`
-	CowStock    float64 `json:"cow_stock"`
-	BuffaloSold float64 `json:"buffalo_sold"`
-	CowSold     float64 `json:"cow_sold"`
-	Wastage     float64 `json:"wastage"`
+	FailureReason  string  `json:"failure_reason,omitempty"`
+	RiderID        int     `json:"rider_id,omitempty"`
+	ProofPhotoURL  string  `json:"proof_photo_url,omitempty"`
 }
 
 type Pricing struct {
-	ID            int     `json:"id"`
-	MilkType      string  `json:"milk_type"`
-	Price         float64 `json:"price"`
-	PreviousPrice float64 `json:"previous_price"`
-	UpdatedAt     string  `json:"updated_at"`
+	ID            int           `json:"id"`
+	MilkType      string        `json:"milk_type"`
+	Price         float64       `json:"price"`
+	PreviousPrice float64       `json:"previous_price"`
+	UpdatedAt     string        `json:"updated_at"`
+	Upcoming      []PriceChange `json:"upcoming"`
 }
 
 // --- Request/Response Types ---
@@ -365,6 +420,9 @@ type SendOTPRequest struct {
 type VerifyOTPRequest struct {
 	Email string `json:"email"`
 	Code  string `json:"code"`
+	// Optional guest cart to merge into the account: a server cart token and/or locally kept items
+	GuestCartToken string            `json:"guest_cart_token,omitempty"`
+	GuestCart      []CartItemRequest `json:"guest_cart,omitempty"`
 }
 
 type VerifyOTPResponse struct {
@@ -398,7 +456,7 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 		}
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Cart-Token")
 		// Allow credentials when origin is not wildcard
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
@@ -413,20 +471,6 @@ func enableCORS(next http.HandlerFunc) http.HandlerFunc {
 
 // --- Email Sending ---
 func sendEmail(toEmail, otp string, isAdmin bool) error {
-	smtpHost := "smtp.gmail.com"
-	smtpPort := "587"
-
-	// Get credentials from environment variables
-	senderEmail := os.Getenv("SMTP_EMAIL")
-	senderPassword := os.Getenv("SMTP_PASSWORD")
-
-	if senderEmail == "" || senderPassword == "" {
-		log.Println("SMTP credentials not configured, logging OTP instead")
-		return nil
-	}
-
-	auth := smtp.PlainAuth("", senderEmail, senderPassword, smtpHost)
-
 	var subject, body string
 
 	if isAdmin {
@@ -597,15 +641,62 @@ func sendEmail(toEmail, otp string, isAdmin bool) error {
 `, otp)
 	}
 
+	return deliverHTMLEmail(toEmail, subject, body)
+}
+
+// emailAttachment is a file sent along with an HTML email
+type emailAttachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// deliverHTMLEmail sends an HTML email over SMTP (no-op with a log line when SMTP isn't configured)
+func deliverHTMLEmail(toEmail, subject, body string, attachments ...emailAttachment) error {
+	smtpHost := "smtp.gmail.com"
+	smtpPort := "587"
+
+	// Get credentials from environment variables
+	senderEmail := os.Getenv("SMTP_EMAIL")
+	senderPassword := os.Getenv("SMTP_PASSWORD")
+
+	if senderEmail == "" || senderPassword == "" {
+		log.Printf("SMTP credentials not configured, not sending %q to %s", subject, toEmail)
+		return nil
+	}
+
+	auth := smtp.PlainAuth("", senderEmail, senderPassword, smtpHost)
+
 	// MIME headers for HTML email
 	headers := "MIME-Version: 1.0\r\n" +
-		"Content-Type: text/html; charset=\"UTF-8\"\r\n" +
 		fmt.Sprintf("From: Taaza <%s>\r\n", senderEmail) +
 		fmt.Sprintf("To: %s\r\n", toEmail) +
-		fmt.Sprintf("Subject: %s\r\n", subject) +
-		"\r\n"
+		fmt.Sprintf("Subject: %s\r\n", subject)
 
-	msg := []byte(headers + body)
+	var msg []byte
+	if len(attachments) == 0 {
+		msg = []byte(headers + "Content-Type: text/html; charset=\"UTF-8\"\r\n\r\n" + body)
+	} else {
+		// multipart/mixed: the HTML body first, then each attachment base64-encoded
+		boundary := "taaza-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+		var b strings.Builder
+		b.WriteString(headers + "Content-Type: multipart/mixed; boundary=\"" + boundary + "\"\r\n\r\n")
+		b.WriteString("--" + boundary + "\r\nContent-Type: text/html; charset=\"UTF-8\"\r\n\r\n" + body + "\r\n")
+		for _, a := range attachments {
+			b.WriteString("--" + boundary + "\r\n")
+			b.WriteString("Content-Type: " + a.ContentType + "\r\n")
+			b.WriteString("Content-Transfer-Encoding: base64\r\n")
+			b.WriteString("Content-Disposition: attachment; filename=\"" + a.Filename + "\"\r\n\r\n")
+			encoded := base64.StdEncoding.EncodeToString(a.Data)
+			for len(encoded) > 76 {
+				b.WriteString(encoded[:76] + "\r\n")
+				encoded = encoded[76:]
+			}
+			b.WriteString(encoded + "\r\n")
+		}
+		b.WriteString("--" + boundary + "--\r\n")
+		msg = []byte(b.String())
+	}
 
 	err := smtp.SendMail(smtpHost+":"+smtpPort, auth, senderEmail, []string{toEmail}, msg)
 	if err != nil {
@@ -730,6 +821,9 @@ func verifyOTPHandler(w http.ResponseWriter, r *http.Request) {
 
 	isNewUser := count == 0
 
+	// Bring the guest cart into the account (new users adopt it when they register)
+	claimGuestCart(req.Email, req.GuestCartToken, req.GuestCart)
+
 	// Create JWT
 	claims := jwt.MapClaims{
 		"sub": req.Email,
@@ -816,6 +910,31 @@ func authMiddleware(next http.HandlerFunc, requireAdmin bool) http.HandlerFunc {
 	}
 }
 
+// requestEmail returns the authenticated email from context, falling back to the ?email query param for dev
+func requestEmail(r *http.Request) string {
+	if s, ok := r.Context().Value("email").(string); ok && s != "" {
+		return s
+	}
+	return r.URL.Query().Get("email")
+}
+
+// requestActor returns who is performing the request, for audit trails
+func requestActor(r *http.Request) (email, role string) {
+	email, _ = r.Context().Value("email").(string)
+	role, _ = r.Context().Value("role").(string)
+	if role == "" {
+		role = "user"
+	}
+	return email, role
+}
+
+// dbExecer is satisfied by both *sql.DB and *sql.Tx
+type dbExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 func registerHandler(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -838,6 +957,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to register", http.StatusInternalServerError)
 		return
 	}
+	adoptClaimedCarts(req.Email)
 
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
@@ -1056,10 +1176,11 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 
 
 		rows, err := db.Query(`
-			SELECT id, user_id, tag, house_no, COALESCE(landmark, ''), full_address, 
-			       COALESCE(latitude, 0), COALESCE(longitude, 0), receiver_name, receiver_phone, 
-			       is_default, created_at::text
-			FROM addresses WHERE user_id = $1 ORDER BY is_default DESC, created_at DESC
+			SELECT a.id, a.user_id, a.tag, a.house_no, COALESCE(a.landmark, ''), a.full_address, 
+			       COALESCE(a.latitude, 0), COALESCE(a.longitude, 0), a.receiver_name, a.receiver_phone, 
+			       a.is_default, COALESCE(a.pincode, ''), COALESCE(a.zone_id, 0), COALESCE(z.name, ''), a.created_at::text
+			FROM addresses a LEFT JOIN delivery_zones z ON z.id = a.zone_id
+			WHERE a.user_id = $1 ORDER BY a.is_default DESC, a.created_at DESC
 		`, userID)
 		if err != nil {
 			log.Println("Error fetching addresses:", err)
@@ -1073,7 +1194,7 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 			var addr Address
 			rows.Scan(&addr.ID, &addr.UserID, &addr.Tag, &addr.HouseNo, &addr.Landmark,
 				&addr.FullAddress, &addr.Latitude, &addr.Longitude, &addr.ReceiverName,
-				&addr.ReceiverPhone, &addr.IsDefault, &addr.CreatedAt)
+				&addr.ReceiverPhone, &addr.IsDefault, &addr.Pincode, &addr.ZoneID, &addr.ZoneName, &addr.CreatedAt)
 			addresses = append(addresses, addr)
 		}
 
@@ -1095,6 +1216,7 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 			ReceiverName  string  `json:"receiver_name"`
 			ReceiverPhone string  `json:"receiver_phone"`
 			IsDefault     bool    `json:"is_default"`
+			Pincode       string  `json:"pincode"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1110,6 +1232,22 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Reject addresses outside every delivery zone
+		zone, err := resolveZone(db, GeoPoint{Lat: req.Latitude, Lng: req.Longitude}, req.Pincode)
+		if err != nil {
+			if zoneErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error resolving address zone:", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, err.Error(), zoneErrorStatus(err))
+			return
+		}
+		var zoneID interface{}
+		if zone != nil {
+			zoneID = zone.ID
+		}
+
 		// If setting as default, unset other defaults
 		if req.IsDefault {
 			db.Exec("UPDATE addresses SET is_default = false WHERE user_id = $1", userID)
@@ -1117,9 +1255,10 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 
 		var newID int
 		err = db.QueryRow(`
-			INSERT INTO addresses (user_id, tag, house_no, landmark, full_address, latitude, longitude, receiver_name, receiver_phone, is_default)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
-		`, userID, req.Tag, req.HouseNo, req.Landmark, req.FullAddress, req.Latitude, req.Longitude, req.ReceiverName, req.ReceiverPhone, req.IsDefault).Scan(&newID)
+			INSERT INTO addresses (user_id, tag, house_no, landmark, full_address, latitude, longitude, receiver_name, receiver_phone, is_default, pincode, zone_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, NULLIF($11, ''), $12) RETURNING id
+		`, userID, req.Tag, req.HouseNo, req.Landmark, req.FullAddress, req.Latitude, req.Longitude, req.ReceiverName, req.ReceiverPhone, req.IsDefault,
+			normalizePincode(req.Pincode), zoneID).Scan(&newID)
 
 		if err != nil {
 			log.Println("Error creating address:", err)
@@ -1130,6 +1269,7 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"success": true,
 			"id":      newID,
+			"zone_id": zoneID,
 		})
 
 	case "PUT":
@@ -1145,6 +1285,7 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 			ReceiverName  string  `json:"receiver_name"`
 			ReceiverPhone string  `json:"receiver_phone"`
 			IsDefault     bool    `json:"is_default"`
+			Pincode       string  `json:"pincode"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1152,6 +1293,22 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Reject moving an address outside every delivery zone
+		zone, err := resolveZone(db, GeoPoint{Lat: req.Latitude, Lng: req.Longitude}, req.Pincode)
+		if err != nil {
+			if zoneErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error resolving address zone:", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, err.Error(), zoneErrorStatus(err))
+			return
+		}
+		var zoneID interface{}
+		if zone != nil {
+			zoneID = zone.ID
+		}
+
 		// If setting as default, unset other defaults for this user
 		if req.IsDefault {
 			var userID int
@@ -1159,11 +1316,12 @@ func addressHandler(w http.ResponseWriter, r *http.Request) {
 			db.Exec("UPDATE addresses SET is_default = false WHERE user_id = $1", userID)
 		}
 
-		_, err := db.Exec(`
+		_, err = db.Exec(`
 			UPDATE addresses SET tag=$1, house_no=$2, landmark=$3, full_address=$4, 
-			latitude=$5, longitude=$6, receiver_name=$7, receiver_phone=$8, is_default=$9
-			WHERE id=$10
-		`, req.Tag, req.HouseNo, req.Landmark, req.FullAddress, req.Latitude, req.Longitude, req.ReceiverName, req.ReceiverPhone, req.IsDefault, req.ID)
+			latitude=$5, longitude=$6, receiver_name=$7, receiver_phone=$8, is_default=$9, pincode=NULLIF($10, ''), zone_id=$11
+			WHERE id=$12
+		`, req.Tag, req.HouseNo, req.Landmark, req.FullAddress, req.Latitude, req.Longitude, req.ReceiverName, req.ReceiverPhone, req.IsDefault,
+			normalizePincode(req.Pincode), zoneID, req.ID)
 
 		if err != nil {
 			log.Println("Error updating address:", err)
@@ -1210,10 +1368,11 @@ func adminUserAddressesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	rows, err := db.Query(`
-		SELECT id, user_id, tag, house_no, COALESCE(landmark, ''), full_address, 
-		       COALESCE(latitude, 0), COALESCE(longitude, 0), receiver_name, receiver_phone, 
-		       is_default, created_at::text
-		FROM addresses WHERE user_id = $1 ORDER BY is_default DESC, created_at DESC
+		SELECT a.id, a.user_id, a.tag, a.house_no, COALESCE(a.landmark, ''), a.full_address, 
+		       COALESCE(a.latitude, 0), COALESCE(a.longitude, 0), a.receiver_name, a.receiver_phone, 
+		       a.is_default, COALESCE(a.pincode, ''), COALESCE(a.zone_id, 0), COALESCE(z.name, ''), a.created_at::text
+		FROM addresses a LEFT JOIN delivery_zones z ON z.id = a.zone_id
+		WHERE a.user_id = $1 ORDER BY a.is_default DESC, a.created_at DESC
 	`, userID)
 	if err != nil {
 		log.Println("Error fetching addresses:", err)
@@ -1227,7 +1386,7 @@ func adminUserAddressesHandler(w http.ResponseWriter, r *http.Request) {
 		var addr Address
 		rows.Scan(&addr.ID, &addr.UserID, &addr.Tag, &addr.HouseNo, &addr.Landmark,
 			&addr.FullAddress, &addr.Latitude, &addr.Longitude, &addr.ReceiverName,
-			&addr.ReceiverPhone, &addr.IsDefault, &addr.CreatedAt)
+			&addr.ReceiverPhone, &addr.IsDefault, &addr.Pincode, &addr.ZoneID, &addr.ZoneName, &addr.CreatedAt)
 		addresses = append(addresses, addr)
 	}
 
@@ -1354,12 +1513,58 @@ func createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Cancel any existing active subscription
-	db.Exec("UPDATE milk_subscriptions SET status = 'Cancelled', updated_at = NOW() WHERE user_id = $1 AND status != 'Cancelled'", userID)
+	// Milk can only be delivered to an address inside a delivery zone
+	if _, err := addressZone(db, req.AddressID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Address not found", http.StatusNotFound)
+		} else if zoneErrorStatus(err) != http.StatusInternalServerError {
+			http.Error(w, err.Error(), zoneErrorStatus(err))
+		} else {
+			log.Println("Error checking address zone:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+	
+	actorEmail, actorRole := requestActor(r)
+
+	// The old subscriptions are cancelled (with their scheduled deliveries) and
+	// the new one created together, so a failure leaves the customer as they were
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Error creating subscription:", err)
+		http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	
+	// Cancel any existing active subscription (recording each in the audit trail)
+	var previousIDs []int
+	prevRows, err := tx.Query("SELECT id FROM milk_subscriptions WHERE user_id = $1 AND status != 'Cancelled'", userID)
+	if err != nil {
+		log.Println("Error creating subscription:", err)
+		http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+		return
+	}
+	for prevRows.Next() {
+		var id int
+		prevRows.Scan(&id)
+		previousIDs = append(previousIDs, id)
+	}
+	prevRows.Close()
+	for _, prevID := range previousIDs {
+		before := loadSubscriptionSnapshot(tx, prevID)
+		if err := setSubscriptionStatus(tx, prevID, "Cancelled", churnReplaced, "", actorEmail, actorRole); err != nil {
+			log.Println("Error replacing subscription:", err)
+			http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+			return
+		}
+		recordSubscriptionChanges(tx, before, loadSubscriptionSnapshot(tx, prevID), actorEmail, actorRole)
+	}
 	
 	// Create subscription
 	var subID int
-	err = db.QueryRow(`
+	err = tx.QueryRow(`
 		INSERT INTO milk_subscriptions (user_id, address_id, auto_pay, status)
 		VALUES ($1, $2, $3, 'Active') RETURNING id
 	`, userID, req.AddressID, req.AutoPay).Scan(&subID)
@@ -1373,15 +1578,25 @@ func createSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 	// Create slots
 	for _, s := range req.Slots {
 		daysArr := "{" + strings.Join(s.Days, ",") + "}"
-		_, err = db.Exec(`
+		_, err = tx.Exec(`
 			INSERT INTO subscription_slots (subscription_id, slot_type, milk_type, quantity, time_slot, frequency, days, is_enabled)
 			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		`, subID, s.SlotType, s.MilkType, s.Quantity, s.TimeSlot, s.Frequency, daysArr, s.IsEnabled)
 		if err != nil {
 			log.Println("Error creating slot:", err)
+			http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+			return
 		}
 	}
 	
+	recordSubscriptionEvent(tx, subID, userID, SubEventCreated, actorEmail, actorRole, nil, loadSubscriptionSnapshot(tx, subID))
+	
+	if err := tx.Commit(); err != nil {
+		log.Println("Error creating subscription:", err)
+		http.Error(w, "Failed to create subscription", http.StatusInternalServerError)
+		return
+	}
+	
 	// Generate deliveries for next 7 days
 	generateDeliveries(subID, userID, 7)
 	
@@ -1397,11 +1612,13 @@ func updateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	var req struct {
-		ID        int    `json:"id"`
-		Status    string `json:"status"`
-		AddressID int    `json:"address_id"`
-		AutoPay   bool   `json:"auto_pay"`
-		Slots     []struct {
+		ID           int    `json:"id"`
+		Status       string `json:"status"`
+		AddressID    int    `json:"address_id"`
+		AutoPay      bool   `json:"auto_pay"`
+		CancelReason string `json:"cancel_reason"`
+		CancelNote   string `json:"cancel_note"`
+		Slots        []struct {
 			ID        int      `json:"id"`
 			SlotType  string   `json:"slot_type"`
 			MilkType  string   `json:"milk_type"`
@@ -1418,46 +1635,158 @@ func updateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Update subscription
-	_, err := db.Exec(`
-		UPDATE milk_subscriptions SET status = $1, address_id = $2, auto_pay = $3, updated_at = NOW()
-		WHERE id = $4
-	`, req.Status, req.AddressID, req.AutoPay, req.ID)
+	before := loadSubscriptionSnapshot(db, req.ID)
+	if before == nil {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+	
+	// Only a top-up lifts a funding suspension; the customer may still cancel
+	if before.Status == SubStatusSuspendedNoFunds && req.Status != before.Status && req.Status != "Cancelled" {
+		http.Error(w, "Subscription is suspended for insufficient wallet balance; top up to resume", http.StatusConflict)
+		return
+	}
+	
+	cancelling := req.Status == "Cancelled" && before.Status != "Cancelled"
+	if cancelling && !validChurnReason(req.CancelReason, req.CancelNote) {
+		http.Error(w, errChurnReason.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	// Moving the subscription to another address needs that address to be serviceable
+	if req.AddressID != before.AddressID {
+		if _, err := addressZone(db, req.AddressID); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Address not found", http.StatusNotFound)
+			} else if zoneErrorStatus(err) != http.StatusInternalServerError {
+				http.Error(w, err.Error(), zoneErrorStatus(err))
+			} else {
+				log.Println("Error checking address zone:", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+			}
+			return
+		}
+	}
 	
+	actorEmail, actorRole := requestActor(r)
+	
+	tx, err := db.Begin()
 	if err != nil {
+		log.Println("Error updating subscription:", err)
 		http.Error(w, "Failed to update subscription", http.StatusInternalServerError)
 		return
 	}
+	defer tx.Rollback()
+	
+	// Update subscription
+	_, err = tx.Exec(`
+		UPDATE milk_subscriptions SET address_id = $1, auto_pay = $2, updated_at = NOW()
+		WHERE id = $3
+	`, req.AddressID, req.AutoPay, req.ID)
+	if err == nil {
+		err = setSubscriptionStatus(tx, req.ID, req.Status, req.CancelReason, req.CancelNote, actorEmail, actorRole)
+	}
 	
 	// Update slots
 	for _, s := range req.Slots {
+		if err != nil {
+			break
+		}
 		daysArr := "{" + strings.Join(s.Days, ",") + "}"
 		if s.ID > 0 {
-			db.Exec(`
+			_, err = tx.Exec(`
 				UPDATE subscription_slots SET milk_type = $1, quantity = $2, time_slot = $3, frequency = $4, days = $5, is_enabled = $6
 				WHERE id = $7
 			`, s.MilkType, s.Quantity, s.TimeSlot, s.Frequency, daysArr, s.IsEnabled, s.ID)
 		} else {
-			db.Exec(`
+			_, err = tx.Exec(`
 				INSERT INTO subscription_slots (subscription_id, slot_type, milk_type, quantity, time_slot, frequency, days, is_enabled)
 				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			`, req.ID, s.SlotType, s.MilkType, s.Quantity, s.TimeSlot, s.Frequency, daysArr, s.IsEnabled)
 		}
 	}
 	
+	if err == nil {
+		recordSubscriptionChanges(tx, before, loadSubscriptionSnapshot(tx, req.ID), actorEmail, actorRole)
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("Error updating subscription:", err)
+		http.Error(w, "Failed to update subscription", http.StatusInternalServerError)
+		return
+	}
+	
+	// Resuming brings back the deliveries dropped while paused
+	if req.Status == "Active" {
+		generateDeliveries(req.ID, before.UserID, 7)
+	}
+	
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+func cancelSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	
+	idStr := r.URL.Query().Get("id")
+	id, _ := strconv.Atoi(idStr)
+	
+	// The reason may come as ?reason=&note= or as a JSON body
+	var req struct {
+		CancelReason string `json:"cancel_reason"`
+		CancelNote   string `json:"cancel_note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.CancelReason == "" {
+		req.CancelReason, req.CancelNote = r.URL.Query().Get("reason"), r.URL.Query().Get("note")
+	}
+	if !validChurnReason(req.CancelReason, req.CancelNote) {
+		http.Error(w, errChurnReason.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	before := loadSubscriptionSnapshot(db, id)
+	if before == nil {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+	
+	actorEmail, actorRole := requestActor(r)
+	tx, err := db.Begin()
+	if err == nil {
+		defer tx.Rollback()
+		err = setSubscriptionStatus(tx, id, "Cancelled", req.CancelReason, req.CancelNote, actorEmail, actorRole)
+	}
+	if err == nil {
+		recordSubscriptionChanges(tx, before, loadSubscriptionSnapshot(tx, id), actorEmail, actorRole)
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("Error cancelling subscription:", err)
+		http.Error(w, "Failed to cancel subscription", http.StatusInternalServerError)
+		return
+	}
+	
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
 }
 
 func generateDeliveries(subID, userID, days int) {
 	// Get subscription details
-	var addressText, customerName string
+	var addressText, customerName, status string
 	db.QueryRow(`
-		SELECT COALESCE(a.full_address, ''), COALESCE(u.name, '')
+		SELECT COALESCE(a.full_address, ''), COALESCE(u.name, ''), ms.status
 		FROM milk_subscriptions ms
 		LEFT JOIN addresses a ON ms.address_id = a.id
 		LEFT JOIN users u ON ms.user_id = u.id
 		WHERE ms.id = $1
-	`, subID).Scan(&addressText, &customerName)
+	`, subID).Scan(&addressText, &customerName, &status)
+	
+	// Paused, cancelled and unfunded subscriptions don't get new deliveries
+	if status != "Active" {
+		return
+	}
 	
 	// Get slots
 	rows, _ := db.Query(`SELECT id, slot_type, milk_type, quantity, frequency, days FROM subscription_slots WHERE subscription_id = $1 AND is_enabled = true`, subID)
@@ -1493,15 +1822,16 @@ func generateDeliveries(subID, userID, days int) {
 			}
 			
 			if shouldDeliver {
-				// Check if delivery already exists
+				// Check if delivery already exists (ignoring ones dropped while the subscription was paused or unfunded)
 				var exists int
-				db.QueryRow(`SELECT COUNT(*) FROM deliveries WHERE subscription_id = $1 AND slot_id = $2 AND delivery_date = $3`,
-					subID, slot.id, date.Format("2006-01-02")).Scan(&exists)
+				db.QueryRow(`SELECT COUNT(*) FROM deliveries WHERE subscription_id = $1 AND slot_id = $2 AND delivery_date = $3
+					AND NOT (status = 'Cancelled' AND failure_reason IN ($4, $5))`,
+					subID, slot.id, date.Format("2006-01-02"), reasonInsufficientFunds, reasonSubscriptionStopped).Scan(&exists)
 				
 				if exists == 0 {
 					db.Exec(`
 						INSERT INTO deliveries (subscription_id, slot_id, user_id, delivery_date, slot_type, quantity, milk_type, address, customer_name, status)
-						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'Pending')
+						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'Scheduled')
 					`, subID, slot.id, userID, date.Format("2006-01-02"), slot.slotType, slot.quantity, slot.milkType, addressText, customerName)
 				}
 			}
@@ -1518,7 +1848,8 @@ func skipDeliveryHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	var req struct {
-		DeliveryID int `json:"delivery_id"`
+		DeliveryID int   `json:"delivery_id"`
+		Skip       *bool `json:"skip"` // false un-skips; omitted means skip
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1526,9 +1857,27 @@ func skipDeliveryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	_, err := db.Exec("UPDATE deliveries SET status = 'Skipped' WHERE id = $1", req.DeliveryID)
-	if err != nil {
-		http.Error(w, "Failed to skip delivery", http.StatusInternalServerError)
+	actorEmail, actorRole := requestActor(r)
+	t := deliveryTransition{To: DeliverySkipped, ActorEmail: actorEmail, ActorRole: actorRole}
+	if req.Skip != nil && !*req.Skip {
+		t.To = DeliveryScheduled
+	}
+	
+	// Users may only skip their own deliveries
+	if actorRole != "admin" {
+		if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&t.OwnerID); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	}
+	
+	if err := transitionDelivery(req.DeliveryID, t); err != nil {
+		if deliveryErrorStatus(err) == http.StatusInternalServerError {
+			log.Println("Error skipping delivery:", err)
+			http.Error(w, "Failed to skip delivery", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, err.Error(), deliveryErrorStatus(err))
 		return
 	}
 	
@@ -1585,20 +1934,47 @@ func getScheduleHandler(w http.ResponseWriter, r *http.Request) {
 func getPricingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	
-	rows, err := db.Query(`SELECT id, milk_type, price, COALESCE(previous_price, 0), updated_at::text FROM pricing`)
+	rows, err := db.Query("SELECT id, milk_type, price, COALESCE(previous_price, 0), updated_at::text FROM pricing ORDER BY id")
 	if err != nil {
 		log.Println("Error fetching pricing:", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-	
 	var prices []Pricing
 	for rows.Next() {
 		var p Pricing
 		rows.Scan(&p.ID, &p.MilkType, &p.Price, &p.PreviousPrice, &p.UpdatedAt)
 		prices = append(prices, p)
 	}
+	rows.Close()
+	
+	// Current price is today's default from price_history; previous is the one it replaced
+	today := time.Now()
+	for i := range prices {
+		price, from, err := lookupPriceSince(db, prices[i].MilkType, today, priceScope{})
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err == nil {
+			prices[i].Price = price
+			prices[i].UpdatedAt = from.Format("2006-01-02")
+			var prev float64
+			prev, err = priceOnDate(db, prices[i].MilkType, from.AddDate(0, 0, -1))
+			if err == nil {
+				prices[i].PreviousPrice = prev
+			} else if err == sql.ErrNoRows {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Println("Error looking up price:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	for i := range prices {
+		prices[i].Upcoming = upcomingPriceChanges(prices[i].MilkType)
+	}
 	
 	if prices == nil {
 		prices = []Pricing{}
@@ -1649,6 +2025,16 @@ func adminListSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		slotRows.Close()
 		
+		// Auto-pay subscriptions show how long the wallet will last
+		if sub.AutoPay {
+			if p, err := projectSubscriptionFunding(sub.ID, sub.UserID); err == nil {
+				sub.WalletBalance = p.Balance
+				if !p.RunsOutOn.IsZero() {
+					sub.RunsOutOn = p.RunsOutOn.Format("2006-01-02")
+				}
+			}
+		}
+		
 		subscriptions = append(subscriptions, sub)
 	}
 	
@@ -1667,8 +2053,10 @@ func adminUpdateSubscriptionStatusHandler(w http.ResponseWriter, r *http.Request
 	}
 	
 	var req struct {
-		ID     int    `json:"id"`
-		Status string `json:"status"`
+		ID           int    `json:"id"`
+		Status       string `json:"status"`
+		CancelReason string `json:"cancel_reason"`
+		CancelNote   string `json:"cancel_note"`
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1676,12 +2064,37 @@ func adminUpdateSubscriptionStatusHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 	
-	_, err := db.Exec("UPDATE milk_subscriptions SET status = $1, updated_at = NOW() WHERE id = $2", req.Status, req.ID)
+	before := loadSubscriptionSnapshot(db, req.ID)
+	if before == nil {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+	cancelling := req.Status == "Cancelled" && before.Status != "Cancelled"
+	if cancelling && !validChurnReason(req.CancelReason, req.CancelNote) {
+		http.Error(w, errChurnReason.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	actorEmail, actorRole := requestActor(r)
+	tx, err := db.Begin()
+	if err == nil {
+		defer tx.Rollback()
+		err = setSubscriptionStatus(tx, req.ID, req.Status, req.CancelReason, req.CancelNote, actorEmail, actorRole)
+	}
+	if err == nil {
+		recordSubscriptionChanges(tx, before, loadSubscriptionSnapshot(tx, req.ID), actorEmail, actorRole)
+		err = tx.Commit()
+	}
 	if err != nil {
+		log.Println("Error updating subscription status:", err)
 		http.Error(w, "Failed to update status", http.StatusInternalServerError)
 		return
 	}
 	
+	if req.Status == "Active" {
+		generateDeliveries(req.ID, before.UserID, 7)
+	}
+	
 	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
 }
 
@@ -1697,7 +2110,8 @@ func adminDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
 		rows, err := db.Query(`
 			SELECT id, subscription_id, slot_id, user_id, delivery_date::text, slot_type, quantity, milk_type,
 			       COALESCE(address, ''), COALESCE(customer_name, ''), status,
-			       COALESCE(delivered_at::text, ''), COALESCE(delivered_by, '')
+			       COALESCE(delivered_at::text, ''), COALESCE(delivered_by, ''), COALESCE(failure_reason, ''),
+			       COALESCE(rider_id, 0), COALESCE(proof_photo_url, '')
 			FROM deliveries
 			WHERE delivery_date = $1
 			ORDER BY slot_type, customer_name
@@ -1714,7 +2128,8 @@ func adminDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
 		for rows.Next() {
 			var d Delivery
 			rows.Scan(&d.ID, &d.SubscriptionID, &d.SlotID, &d.UserID, &d.DeliveryDate, &d.SlotType,
-				&d.Quantity, &d.MilkType, &d.Address, &d.CustomerName, &d.Status, &d.DeliveredAt, &d.DeliveredBy)
+				&d.Quantity, &d.MilkType, &d.Address, &d.CustomerName, &d.Status, &d.DeliveredAt, &d.DeliveredBy, &d.FailureReason,
+				&d.RiderID, &d.ProofPhotoURL)
 			deliveries = append(deliveries, d)
 		}
 		
@@ -1728,6 +2143,8 @@ func adminDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
 			ID          int    `json:"id"`
 			Status      string `json:"status"`
 			DeliveredBy string `json:"delivered_by"`
+			ReasonCode  string `json:"reason_code"`
+			Note        string `json:"note"`
 		}
 		
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -1735,97 +2152,22 @@ func adminDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		
-		if req.Status == "Delivered" {
-			db.Exec("UPDATE deliveries SET status = $1, delivered_at = NOW(), delivered_by = $2 WHERE id = $3",
-				req.Status, req.DeliveredBy, req.ID)
-		} else {
-			db.Exec("UPDATE deliveries SET status = $1 WHERE id = $2", req.Status, req.ID)
-		}
-		
-		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
-	}
-}
-
-func adminInventoryHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	
-	switch r.Method {
-	case "GET":
-		rows, err := db.Query(`
-			SELECT id, date::text, buffalo_stock, cow_stock, buffalo_sold, cow_sold, wastage
-			FROM inventory ORDER BY date DESC LIMIT 30
-		`)
-		if err != nil {
-			http.Error(w, "Database error", http.StatusInternalServerError)
-			return
-		}
-		defer rows.Close()
-		
-		var inventory []InventoryEntry
-		for rows.Next() {
-			var inv InventoryEntry
-			rows.Scan(&inv.ID, &inv.Date, &inv.BuffaloStock, &inv.CowStock, &inv.BuffaloSold, &inv.CowSold, &inv.Wastage)
-			inventory = append(inventory, inv)
-		}
-		
-		if inventory == nil {
-			inventory = []InventoryEntry{}
-		}
-		json.NewEncoder(w).Encode(inventory)
-		
-	case "POST":
-		var req struct {
-			Date         string  `json:"date"`
-			BuffaloStock float64 `json:"buffalo_stock"`
-			CowStock     float64 `json:"cow_stock"`
-			Wastage      float64 `json:"wastage"`
-		}
-		
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
-			return
-		}
-		
-		_, err := db.Exec(`
-			INSERT INTO inventory (date, buffalo_stock, cow_stock, wastage)
-			VALUES ($1, $2, $3, $4)
-			ON CONFLICT (date) DO UPDATE SET 
-				buffalo_stock = inventory.buffalo_stock + $2,
-				cow_stock = inventory.cow_stock + $3,
-				wastage = inventory.wastage + $4,
-				updated_at = NOW()
-		`, req.Date, req.BuffaloStock, req.CowStock, req.Wastage)
-		
-		if err != nil {
-			log.Println("Error adding inventory:", err)
-			http.Error(w, "Failed to add inventory", http.StatusInternalServerError)
-			return
-		}
-		
-		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
-		
-	case "PUT":
-		var req struct {
-			ID           int     `json:"id"`
-			BuffaloStock float64 `json:"buffalo_stock"`
-			CowStock     float64 `json:"cow_stock"`
-			BuffaloSold  float64 `json:"buffalo_sold"`
-			CowSold      float64 `json:"cow_sold"`
-			Wastage      float64 `json:"wastage"`
-		}
-		
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
-			return
-		}
-		
-		_, err := db.Exec(`
-			UPDATE inventory SET buffalo_stock = $1, cow_stock = $2, buffalo_sold = $3, cow_sold = $4, wastage = $5, updated_at = NOW()
-			WHERE id = $6
-		`, req.BuffaloStock, req.CowStock, req.BuffaloSold, req.CowSold, req.Wastage, req.ID)
-		
+		actorEmail, actorRole := requestActor(r)
+		err := transitionDelivery(req.ID, deliveryTransition{
+			To:          req.Status,
+			ReasonCode:  req.ReasonCode,
+			Note:        req.Note,
+			DeliveredBy: req.DeliveredBy,
+			ActorEmail:  actorEmail,
+			ActorRole:   actorRole,
+		})
 		if err != nil {
-			http.Error(w, "Failed to update inventory", http.StatusInternalServerError)
+			if deliveryErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error updating delivery:", err)
+				http.Error(w, "Failed to update delivery", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, err.Error(), deliveryErrorStatus(err))
 			return
 		}
 		
@@ -1843,26 +2185,53 @@ func adminPricingHandler(w http.ResponseWriter, r *http.Request) {
 	
 	if r.Method == "PUT" {
 		var req struct {
-			MilkType string  `json:"milk_type"`
-			Price    float64 `json:"price"`
+			MilkType      string  `json:"milk_type"`
+			Price         float64 `json:"price"`
+			EffectiveFrom string  `json:"effective_from"` // YYYY-MM-DD, defaults to today
+			ZoneID        int     `json:"zone_id"`
+			Segment       string  `json:"segment"`
 		}
 		
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
+		if req.MilkType == "" || req.Price <= 0 {
+			http.Error(w, "milk_type and a positive price are required", http.StatusBadRequest)
+			return
+		}
+		// Prices exist for the catalog's milk products; add the product first
+		var known bool
+		db.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE milk_type = $1 AND archived_at IS NULL)", req.MilkType).Scan(&known)
+		if !known {
+			http.Error(w, "No milk product with this milk_type", http.StatusNotFound)
+			return
+		}
 		
-		_, err := db.Exec(`
-			UPDATE pricing SET previous_price = price, price = $1, updated_at = NOW()
-			WHERE milk_type = $2
-		`, req.Price, req.MilkType)
+		today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+		from := today
+		if req.EffectiveFrom != "" {
+			t, err := time.Parse("2006-01-02", req.EffectiveFrom)
+			if err != nil {
+				http.Error(w, "effective_from must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			if t.Before(today) {
+				http.Error(w, "Price changes cannot be backdated", http.StatusBadRequest)
+				return
+			}
+			from = t
+		}
 		
+		actorEmail, _ := requestActor(r)
+		id, err := setPrice(req.MilkType, req.Price, from, priceScope{ZoneID: req.ZoneID, Segment: req.Segment}, actorEmail)
 		if err != nil {
+			log.Println("Error updating pricing:", err)
 			http.Error(w, "Failed to update pricing", http.StatusInternalServerError)
 			return
 		}
 		
-		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id, "effective_from": from.Format("2006-01-02")})
 	}
 }
 
@@ -1874,40 +2243,101 @@ func adminAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
 	db.QueryRow("SELECT COUNT(*) FROM milk_subscriptions WHERE status = 'Active'").Scan(&activeCount)
 	db.QueryRow("SELECT COUNT(*) FROM milk_subscriptions WHERE status = 'Paused'").Scan(&pausedCount)
 	
-	// Get daily demand
-	var buffaloTotal, cowTotal float64
-	db.QueryRow(`
-		SELECT COALESCE(SUM(CASE WHEN milk_type = 'buffalo' THEN quantity ELSE 0 END), 0),
-		       COALESCE(SUM(CASE WHEN milk_type = 'cow' THEN quantity ELSE 0 END), 0)
-		FROM subscription_slots ss
-		JOIN milk_subscriptions ms ON ss.subscription_id = ms.id
-		WHERE ms.status = 'Active' AND ss.is_enabled = true
-	`).Scan(&buffaloTotal, &cowTotal)
-	
-	// Get pricing for revenue calculation
-	var buffaloPrice, cowPrice float64
-	db.QueryRow("SELECT COALESCE(price, 90) FROM pricing WHERE milk_type = 'buffalo'").Scan(&buffaloPrice)
-	db.QueryRow("SELECT COALESCE(price, 60) FROM pricing WHERE milk_type = 'cow'").Scan(&cowPrice)
-	
-	dailyRevenue := (buffaloTotal * buffaloPrice) + (cowTotal * cowPrice)
+	// Daily subscription demand and revenue for every milk product in the catalog
+	type milkDemand struct {
+		MilkType string  `json:"milk_type"`
+		Product  string  `json:"product"`
+		Litres   float64 `json:"litres"`
+		Price    float64 `json:"price"`
+		Revenue  float64 `json:"daily_revenue"`
+	}
+	demand := []milkDemand{}
+	rows, err := db.Query(`
+		SELECT p.milk_type, MIN(p.name), COALESCE((
+			SELECT SUM(ss.quantity) FROM subscription_slots ss
+			JOIN milk_subscriptions ms ON ss.subscription_id = ms.id
+			WHERE ms.status = 'Active' AND ss.is_enabled = true AND ss.milk_type = p.milk_type), 0)
+		FROM products p
+		WHERE p.milk_type IS NOT NULL AND p.archived_at IS NULL
+		GROUP BY p.milk_type
+		ORDER BY MIN(p.sort_order), p.milk_type
+	`)
+	if err != nil {
+		log.Println("Error fetching demand:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var d milkDemand
+		rows.Scan(&d.MilkType, &d.Product, &d.Litres)
+		demand = append(demand, d)
+	}
+	rows.Close()
+	
+	var totalDemand, dailyRevenue float64
+	for i := range demand {
+		// Today's default price; a milk type without one contributes no revenue
+		demand[i].Price, _ = priceOnDate(db, demand[i].MilkType, time.Now())
+		demand[i].Revenue = roundMoney(demand[i].Litres * demand[i].Price)
+		totalDemand += demand[i].Litres
+		dailyRevenue += demand[i].Revenue
+	}
+	
+	// One-time order sales per product over the last 30 days
+	type productSales struct {
+		ProductID int     `json:"product_id"`
+		Name      string  `json:"name"`
+		Category  string  `json:"category"`
+		Units     int     `json:"units"`
+		Revenue   float64 `json:"revenue"`
+	}
+	sales := []productSales{}
+	rows, err = db.Query(`
+		SELECT p.id, p.name, c.name, COALESCE(SUM(oi.quantity), 0), COALESCE(SUM(oi.line_total), 0)
+		FROM products p
+		JOIN product_categories c ON c.id = p.category_id
+		LEFT JOIN order_items oi ON oi.product_id = p.id
+		     AND oi.order_id IN (SELECT id FROM orders WHERE status <> $1 AND created_at >= NOW() - INTERVAL '30 days')
+		WHERE p.archived_at IS NULL
+		GROUP BY p.id, p.name, c.name, c.sort_order
+		ORDER BY c.sort_order, p.sort_order, p.id
+	`, OrderCancelled)
+	if err != nil {
+		log.Println("Error fetching product sales:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var ps productSales
+		rows.Scan(&ps.ProductID, &ps.Name, &ps.Category, &ps.Units, &ps.Revenue)
+		sales = append(sales, ps)
+	}
+	rows.Close()
 	
 	// Today's deliveries
 	var deliveredToday, pendingToday int
 	today := time.Now().Format("2006-01-02")
 	db.QueryRow("SELECT COUNT(*) FROM deliveries WHERE delivery_date = $1 AND status = 'Delivered'", today).Scan(&deliveredToday)
-	db.QueryRow("SELECT COUNT(*) FROM deliveries WHERE delivery_date = $1 AND status = 'Pending'", today).Scan(&pendingToday)
+	db.QueryRow("SELECT COUNT(*) FROM deliveries WHERE delivery_date = $1 AND status IN ('Scheduled', 'Packed', 'OutForDelivery')", today).Scan(&pendingToday)
+	
+	// Revenue actually earned so far this month, at the prices in force on each day
+	var monthlyRevenue float64
+	now := time.Now()
+	if month, err := loadAnalytics(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), now, GranularityMonth); err == nil && len(month) > 0 {
+		monthlyRevenue = month[0].Revenue
+	} else if err != nil {
+		log.Println("Error computing monthly revenue:", err)
+	}
 	
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"active_subscriptions": activeCount,
 		"paused_subscriptions": pausedCount,
 		"total_subscriptions":  activeCount + pausedCount,
-		"buffalo_demand":       buffaloTotal,
-		"cow_demand":           cowTotal,
-		"total_demand":         buffaloTotal + cowTotal,
-		"buffalo_price":        buffaloPrice,
-		"cow_price":            cowPrice,
-		"daily_revenue":        dailyRevenue,
-		"monthly_revenue":      dailyRevenue * 30,
+		"demand":               demand,
+		"total_demand":         totalDemand,
+		"daily_revenue":        roundMoney(dailyRevenue),
+		"monthly_revenue":      monthlyRevenue,
+		"product_sales":        sales,
 		"delivered_today":      deliveredToday,
 		"pending_today":        pendingToday,
 	})
@@ -1942,6 +2372,11 @@ func main() {
 	validateEnv()
 	initDB()
 	initSchema()
+	initPaymentProvider()
+	startBillingScheduler()
+	startSubscriptionScheduler()
+	startBookingScheduler()
+	startSlotHoldReaper()
 
 	// Public Auth routes
 	http.HandleFunc("/send-otp", enableCORS(sendOTPHandler))
@@ -1967,11 +2402,7 @@ func main() {
 		case "PUT":
 			updateSubscriptionHandler(w, r)
 		case "DELETE":
-			// Cancel subscription
-			idStr := r.URL.Query().Get("id")
-			id, _ := strconv.Atoi(idStr)
-			db.Exec("UPDATE milk_subscriptions SET status = 'Cancelled', updated_at = NOW() WHERE id = $1", id)
-			json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+			cancelSubscriptionHandler(w, r)
 		case "OPTIONS":
 			w.WriteHeader(http.StatusOK)
 		default:
@@ -1980,15 +2411,80 @@ func main() {
 	}, false)))
 	http.HandleFunc("/api/subscription/skip", enableCORS(authMiddleware(skipDeliveryHandler, false)))
 	http.HandleFunc("/api/subscription/schedule", enableCORS(authMiddleware(getScheduleHandler, false)))
+	http.HandleFunc("/api/subscription/history", enableCORS(authMiddleware(subscriptionHistoryHandler, false)))
+	http.HandleFunc("/api/subscription/cancel-reasons", enableCORS(subscriptionCancelReasonsHandler))
 	http.HandleFunc("/api/pricing", enableCORS(getPricingHandler))
+	http.HandleFunc("/api/products", enableCORS(productsRouter))
+	http.HandleFunc("/api/products/", enableCORS(productsRouter))
+	http.HandleFunc("/api/orders", enableCORS(authMiddleware(ordersRouter, false)))
+	http.HandleFunc("/api/orders/", enableCORS(authMiddleware(ordersRouter, false)))
+	http.HandleFunc("/api/orders/options", enableCORS(orderCancelReasonsHandler))
+	http.HandleFunc("/api/cart", enableCORS(cartRouter))
+	http.HandleFunc("/api/cart/items", enableCORS(cartRouter))
+	http.HandleFunc("/api/bookings/animals", enableCORS(bookingAnimalsHandler))
+	http.HandleFunc("/api/slots/available", enableCORS(slotsAvailableHandler))
+	http.HandleFunc("/api/zones/serviceable", enableCORS(serviceableHandler))
+	http.HandleFunc("/api/slots/hold", enableCORS(authMiddleware(slotHoldHandler, false)))
+	http.HandleFunc("/api/bookings", enableCORS(authMiddleware(bookingsHandler, false)))
+	http.HandleFunc("/api/invoices", enableCORS(authMiddleware(invoicesHandler, false)))
+	http.HandleFunc("/api/wallet", enableCORS(authMiddleware(walletHandler, false)))
+	http.HandleFunc("/api/wallet/statement", enableCORS(authMiddleware(walletStatementHandler, false)))
+	http.HandleFunc("/api/payments", enableCORS(authMiddleware(paymentsHandler, false)))
+	http.HandleFunc("/api/payments/topup", enableCORS(authMiddleware(paymentTopUpHandler, false)))
+	http.HandleFunc("/api/payments/verify", enableCORS(authMiddleware(paymentVerifyHandler, false)))
+	http.HandleFunc("/api/payments/webhook", paymentWebhookHandler) // gateway-signed, no JWT
 	
 	// Admin subscription routes
 	http.HandleFunc("/api/admin/subscriptions", enableCORS(authMiddleware(adminListSubscriptionsHandler, true)))
 	http.HandleFunc("/api/admin/subscriptions/status", enableCORS(authMiddleware(adminUpdateSubscriptionStatusHandler, true)))
+	http.HandleFunc("/api/admin/subscriptions/history", enableCORS(authMiddleware(adminSubscriptionHistoryHandler, true)))
 	http.HandleFunc("/api/admin/deliveries", enableCORS(authMiddleware(adminDeliveriesHandler, true)))
+	http.HandleFunc("/api/admin/deliveries/history", enableCORS(authMiddleware(adminDeliveryHistoryHandler, true)))
+	http.HandleFunc("/api/deliveries/lifecycle", enableCORS(deliveryLifecycleHandler))
+	http.HandleFunc("/api/admin/riders", enableCORS(authMiddleware(adminRidersHandler, true)))
+	http.HandleFunc("/api/admin/riders/assign", enableCORS(authMiddleware(adminAssignDeliveriesHandler, true)))
+	http.HandleFunc("/api/admin/routes/plan", enableCORS(authMiddleware(adminRoutePlanHandler, true)))
+	http.HandleFunc("/api/admin/manifests", enableCORS(authMiddleware(adminManifestsHandler, true)))
+	http.HandleFunc("/api/admin/invoices", enableCORS(authMiddleware(adminInvoicesHandler, true)))
+	http.HandleFunc("/api/admin/invoices/send", enableCORS(authMiddleware(adminSendInvoiceHandler, true)))
+	http.HandleFunc("/api/admin/hsn", enableCORS(authMiddleware(adminHSNHandler, true)))
+	http.HandleFunc("/api/admin/billing/run", enableCORS(authMiddleware(adminRunBillingHandler, true)))
+	http.HandleFunc("/api/admin/wallet", enableCORS(authMiddleware(adminWalletHandler, true)))
+	http.HandleFunc("/api/admin/payments", enableCORS(authMiddleware(adminPaymentsHandler, true)))
+	http.HandleFunc("/api/admin/payments/refund", enableCORS(authMiddleware(adminRefundPaymentHandler, true)))
+	http.HandleFunc("/api/admin/bookings", enableCORS(authMiddleware(adminBookingsHandler, true)))
+	http.HandleFunc("/api/admin/bookings/animals", enableCORS(authMiddleware(adminBookingAnimalsHandler, true)))
+	http.HandleFunc("/api/admin/bookings/roll", enableCORS(authMiddleware(adminRollBookingsHandler, true)))
+	http.HandleFunc("/api/admin/prep-list", enableCORS(authMiddleware(adminPrepListHandler, true)))
+	http.HandleFunc("/api/admin/delivery-windows", enableCORS(authMiddleware(adminDeliveryWindowsHandler, true)))
+	http.HandleFunc("/api/admin/zones", enableCORS(authMiddleware(adminZonesHandler, true)))
+
+	// Rider routes (require rider role)
+	http.HandleFunc("/api/rider/deliveries", enableCORS(authMiddleware(requireRider(riderDeliveriesHandler), false)))
+	http.HandleFunc("/api/rider/deliveries/status", enableCORS(authMiddleware(requireRider(riderUpdateDeliveryHandler), false)))
+	http.HandleFunc("/api/rider/wastage", enableCORS(authMiddleware(requireRider(riderWastageHandler), false)))
 	http.HandleFunc("/api/admin/inventory", enableCORS(authMiddleware(adminInventoryHandler, true)))
+	http.HandleFunc("/api/admin/inventory/movements", enableCORS(authMiddleware(adminInventoryMovementsHandler, true)))
+	http.HandleFunc("/api/admin/inventory/stock", enableCORS(authMiddleware(adminInventoryStockHandler, true)))
+	http.HandleFunc("/api/admin/stock-items", enableCORS(authMiddleware(adminStockItemsHandler, true)))
+	http.HandleFunc("/api/admin/warehouses", enableCORS(authMiddleware(adminWarehousesHandler, true)))
+	http.HandleFunc("/api/admin/procurement/suppliers", enableCORS(authMiddleware(adminSuppliersHandler, true)))
+	http.HandleFunc("/api/admin/procurement/rate-charts", enableCORS(authMiddleware(adminRateChartsHandler, true)))
+	http.HandleFunc("/api/admin/procurement/collections", enableCORS(authMiddleware(adminCollectionsHandler, true)))
+	http.HandleFunc("/api/admin/procurement/statements", enableCORS(authMiddleware(adminSupplierStatementsHandler, true)))
+	http.HandleFunc("/api/admin/wastage", enableCORS(authMiddleware(adminWastageHandler, true)))
+	http.HandleFunc("/api/admin/wastage/report", enableCORS(authMiddleware(adminWastageReportHandler, true)))
+	http.HandleFunc("/api/admin/wastage/thresholds", enableCORS(authMiddleware(adminWastageThresholdsHandler, true)))
+	http.HandleFunc("/api/admin/wastage/alerts", enableCORS(authMiddleware(adminWastageAlertsHandler, true)))
 	http.HandleFunc("/api/admin/pricing", enableCORS(authMiddleware(adminPricingHandler, true)))
+	http.HandleFunc("/api/admin/pricing/history", enableCORS(authMiddleware(adminPriceHistoryHandler, true)))
+	http.HandleFunc("/api/admin/users/segment", enableCORS(authMiddleware(adminUserSegmentHandler, true)))
 	http.HandleFunc("/api/admin/analytics", enableCORS(authMiddleware(adminAnalyticsHandler, true)))
+	http.HandleFunc("/api/admin/analytics/timeseries", enableCORS(authMiddleware(adminAnalyticsTimeseriesHandler, true)))
+	http.HandleFunc("/api/admin/retention/cohorts", enableCORS(authMiddleware(adminCohortsHandler, true)))
+	http.HandleFunc("/api/admin/retention/churn", enableCORS(authMiddleware(adminChurnHandler, true)))
+	http.HandleFunc("/api/admin/retention/at-risk", enableCORS(authMiddleware(adminAtRiskHandler, true)))
+	http.HandleFunc("/api/admin/forecast", enableCORS(authMiddleware(adminForecastHandler, true)))
 
 	// Health endpoint
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// DELIVERY MANIFESTS
+// ============================================================================
+
+type ManifestLine struct {
+	DeliveryID    int     `json:"delivery_id"`
+	RouteSeq      int     `json:"route_seq"`
+	CustomerName  string  `json:"customer_name"`
+	Address       string  `json:"address"`
+	Landmark      string  `json:"landmark"`
+	ReceiverPhone string  `json:"receiver_phone"`
+	MilkType      string  `json:"milk_type"`
+	Quantity      float64 `json:"quantity"`
+	Status        string  `json:"status"`
+}
+
+// Manifest is the sheet for one rider (or the unassigned pile) in one slot
+type Manifest struct {
+	Date      string             `json:"date"`
+	SlotType  string             `json:"slot_type"`
+	RiderID   int                `json:"rider_id"`
+	RiderName string             `json:"rider_name"`
+	Lines     []ManifestLine     `json:"lines"`
+	Totals    map[string]float64 `json:"loading_totals"` // litres per milk type
+}
+
+// loadManifests reads deliveries for a date and groups them per slot and rider in route order.
+// Cancelled and skipped deliveries are left off the sheet.
+func loadManifests(date, slotType string, riderID int) ([]Manifest, error) {
+	rows, err := db.Query(`
+		SELECT d.id, d.slot_type, COALESCE(d.rider_id, 0), COALESCE(rd.name, ''), COALESCE(d.route_seq, 0),
+		       COALESCE(d.customer_name, ''), COALESCE(d.address, ''), COALESCE(a.landmark, ''), COALESCE(a.receiver_phone, ''),
+		       d.milk_type, d.quantity, d.status
+		FROM deliveries d
+		LEFT JOIN users rd ON d.rider_id = rd.id
+		LEFT JOIN milk_subscriptions ms ON d.subscription_id = ms.id
+		LEFT JOIN addresses a ON ms.address_id = a.id
+		WHERE d.delivery_date = $1
+		  AND ($2 = '' OR d.slot_type = $2)
+		  AND ($3 = 0 OR d.rider_id = $3)
+		  AND d.status NOT IN ('Cancelled', 'Skipped')
+		ORDER BY d.slot_type, COALESCE(d.rider_id, 0) = 0, rd.name, d.rider_id, d.route_seq NULLS LAST, d.customer_name
+	`, date, slotType, riderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var manifests []Manifest
+	for rows.Next() {
+		var slot, riderName string
+		var rid int
+		var l ManifestLine
+		if err := rows.Scan(&l.DeliveryID, &slot, &rid, &riderName, &l.RouteSeq, &l.CustomerName, &l.Address, &l.Landmark,
+			&l.ReceiverPhone, &l.MilkType, &l.Quantity, &l.Status); err != nil {
+			log.Println("Error scanning manifest line:", err)
+			continue
+		}
+
+		n := len(manifests)
+		if n == 0 || manifests[n-1].SlotType != slot || manifests[n-1].RiderID != rid {
+			if rid == 0 {
+				riderName = "Unassigned"
+			} else if riderName == "" {
+				riderName = "Rider #" + strconv.Itoa(rid)
+			}
+			manifests = append(manifests, Manifest{Date: date, SlotType: slot, RiderID: rid, RiderName: riderName,
+				Totals: map[string]float64{}})
+			n++
+		}
+		m := &manifests[n-1]
+		m.Lines = append(m.Lines, l)
+		m.Totals[l.MilkType] += l.Quantity
+	}
+	return manifests, nil
+}
+
+func sortedMilkTypes(totals map[string]float64) []string {
+	types := make([]string, 0, len(totals))
+	for t := range totals {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+func writeManifestsCSV(w http.ResponseWriter, manifests []Manifest) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"date", "slot", "rider", "seq", "customer_name", "address", "landmark", "receiver_phone", "milk_type", "quantity", "signature"})
+	for _, m := range manifests {
+		for i, l := range m.Lines {
+			cw.Write([]string{m.Date, m.SlotType, m.RiderName, strconv.Itoa(i + 1), l.CustomerName, l.Address, l.Landmark,
+				l.ReceiverPhone, l.MilkType, strconv.FormatFloat(l.Quantity, 'f', 2, 64), ""})
+		}
+		for _, t := range sortedMilkTypes(m.Totals) {
+			cw.Write([]string{m.Date, m.SlotType, m.RiderName, "", "LOADING TOTAL", "", "", "", t,
+				strconv.FormatFloat(m.Totals[t], 'f', 2, 64), ""})
+		}
+	}
+	cw.Flush()
+}
+
+func renderManifestsPDF(manifests []Manifest) []byte {
+	doc := newPDFDoc()
+	const size = 7.0
+	header := strings.Join([]string{pdfFit("#", 3), pdfFit("Customer", 18), pdfFit("Address", 32), pdfFit("Landmark", 16),
+		pdfFit("Phone", 12), pdfFit("Milk", 8), pdfFit("Qty", 5), "Signature"}, " ")
+
+	for i, m := range manifests {
+		if i > 0 {
+			doc.NewPage()
+		}
+		doc.Line(fmt.Sprintf("TAAZA DELIVERY MANIFEST - %s - %s slot", m.Date, m.SlotType), 12, true)
+		doc.Line("Rider: "+m.RiderName, 10, true)
+		doc.Gap(6)
+		doc.Line(header, size, true)
+		doc.Line(strings.Repeat("-", 124), size, false)
+		for seq, l := range m.Lines {
+			doc.Line(strings.Join([]string{
+				pdfFit(strconv.Itoa(seq+1), 3), pdfFit(l.CustomerName, 18), pdfFit(l.Address, 32), pdfFit(l.Landmark, 16),
+				pdfFit(l.ReceiverPhone, 12), pdfFit(l.MilkType, 8), pdfFit(strconv.FormatFloat(l.Quantity, 'f', 2, 64), 5),
+				"________________",
+			}, " "), size, false)
+			doc.Gap(4) // room to sign
+		}
+		doc.Gap(8)
+		doc.Line("Loading totals", 9, true)
+		for _, t := range sortedMilkTypes(m.Totals) {
+			doc.Line(fmt.Sprintf("  %-10s %8.2f L", t, m.Totals[t]), 9, false)
+		}
+	}
+	if len(manifests) == 0 {
+		doc.Line("No deliveries for this selection.", 10, false)
+	}
+	return doc.Bytes()
+}
+
+// adminManifestsHandler returns per-slot, per-rider manifests as JSON, CSV or PDF
+func adminManifestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	date := q.Get("date")
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	riderID, _ := strconv.Atoi(q.Get("rider_id"))
+
+	manifests, err := loadManifests(date, q.Get("slot"), riderID)
+	if err != nil {
+		log.Println("Error loading manifests:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	filename := "manifest-" + date
+	if slot := q.Get("slot"); slot != "" {
+		filename += "-" + strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return '_'
+		}, slot)
+	}
+
+	switch q.Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.csv"`)
+		writeManifestsCSV(w, manifests)
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.pdf"`)
+		w.Write(renderManifestsPDF(manifests))
+	default:
+		if manifests == nil {
+			manifests = []Manifest{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(manifests)
+	}
+}
@@ -0,0 +1,942 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// MUTTON ADVANCE BOOKING
+// ============================================================================
+//
+// Sheep and goats are slaughtered to order on a fixed date (usually Sunday).
+// Admins publish each animal with its estimated net yield and its part
+// inventory (head, legs, liver...). Customers reserve either meat by the kg
+// or specific parts; reservations are conditional UPDATEs on the animal and
+// part rows, so concurrent bookings can never take more than is there.
+// Booking closes at the animal's cutoff, and on the slaughter date every
+// open booking is rolled into a regular order for delivery.
+
+// Species that can be booked
+const (
+	SpeciesSheep = "sheep"
+	SpeciesGoat  = "goat"
+)
+
+// Animal states: open for booking, closed at cutoff, slaughtered once rolled into orders
+const (
+	AnimalOpen        = "open"
+	AnimalClosed      = "closed"
+	AnimalSlaughtered = "slaughtered"
+	AnimalCancelled   = "cancelled"
+)
+
+// Booking states
+const (
+	BookingReserved  = "reserved"
+	BookingCancelled = "cancelled"
+	BookingOrdered   = "ordered"
+)
+
+// Meat is booked in half-kilo steps
+const (
+	bookingKgStep = 0.5
+	maxBookingKg  = 10.0
+)
+
+var (
+	errAnimalNotFound     = errors.New("animal not found")
+	errBookingNotFound    = errors.New("booking not found")
+	errBookingClosed      = errors.New("booking for this animal has closed")
+	errBookingSoldOut     = errors.New("not enough left on this animal")
+	errPartSoldOut        = errors.New("part already reserved")
+	errBookingQuantity    = errors.New("kg must be a multiple of 0.5 between 0.5 and 10")
+	errBookingEmpty       = errors.New("choose meat or at least one part")
+	errAnimalInvalid      = errors.New("species must be sheep or goat, with a slaughter date, net estimate and price")
+	errBookingNotEditable = errors.New("booking can no longer be changed")
+)
+
+func bookingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errAnimalNotFound), errors.Is(err, errBookingNotFound), errors.Is(err, errAddressNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errBookingClosed), errors.Is(err, errBookingSoldOut), errors.Is(err, errPartSoldOut),
+		errors.Is(err, errBookingNotEditable):
+		return http.StatusConflict
+	case errors.Is(err, errBookingQuantity), errors.Is(err, errBookingEmpty), errors.Is(err, errAnimalInvalid):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+type AnimalPart struct {
+	ID        int     `json:"id"`
+	Code      string  `json:"code"`
+	Name      string  `json:"name"`
+	Unit      string  `json:"unit"`
+	Price     float64 `json:"price"`
+	Quantity  int     `json:"quantity"`
+	Reserved  int     `json:"reserved"`
+	Available int     `json:"available"`
+	CanRoast  bool    `json:"canRoast"`
+}
+
+type BookingAnimal struct {
+	ID            int          `json:"id"`
+	SlaughterDate string       `json:"slaughter_date"`
+	Species       string       `json:"type"`
+	Tag           string       `json:"tag"`
+	Origin        string       `json:"origin"`
+	Age           string       `json:"age"`
+	LiveWeightKg  float64      `json:"live_weight_kg"`
+	NetEstimateKg float64      `json:"net_estimate_kg"`
+	Yield         float64      `json:"yield"`
+	FatLevel      string       `json:"fatLevel"`
+	PricePerKg    float64      `json:"pricePerKg"`
+	Image         string       `json:"image"`
+	ReservedKg    float64      `json:"reserved_kg"`
+	AvailableKg   float64      `json:"available_kg"`
+	Status        string       `json:"status"`
+	CutoffAt      string       `json:"cutoff_at"`
+	Parts         []AnimalPart `json:"parts"`
+}
+
+type BookingItem struct {
+	ID       int     `json:"id"`
+	Kind     string  `json:"kind"` // meat or part
+	PartID   int     `json:"part_id,omitempty"`
+	Name     string  `json:"name"`
+	Kg       float64 `json:"kg,omitempty"`
+	CutType  string  `json:"cut_type,omitempty"`
+	Roast    bool    `json:"roast,omitempty"`
+	Amount   float64 `json:"amount"`
+	UnitNote string  `json:"unit,omitempty"`
+}
+
+type Booking struct {
+	ID            int           `json:"id"`
+	UserID        int           `json:"user_id"`
+	CustomerName  string        `json:"customer_name,omitempty"`
+	AnimalID      int           `json:"animal_id"`
+	AnimalTag     string        `json:"animal_tag"`
+	Species       string        `json:"type"`
+	SlaughterDate string        `json:"slaughter_date"`
+	AddressID     int           `json:"address_id"`
+	Amount        float64       `json:"amount"`
+	Status        string        `json:"status"`
+	OrderID       int           `json:"order_id,omitempty"`
+	CreatedAt     string        `json:"created_at"`
+	Items         []BookingItem `json:"items"`
+}
+
+// defaultBookingCutoff closes booking the evening before slaughter (MUTTON_CUTOFF_HOUR, default 18:00)
+func defaultBookingCutoff(slaughterDate time.Time) time.Time {
+	hour := 18
+	if h, err := strconv.Atoi(os.Getenv("MUTTON_CUTOFF_HOUR")); err == nil && h >= 0 && h < 24 {
+		hour = h
+	}
+	d := slaughterDate.AddDate(0, 0, -1)
+	return time.Date(d.Year(), d.Month(), d.Day(), hour, 0, 0, 0, time.Local)
+}
+
+func initMuttonBookingSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS booking_animals (
+			id SERIAL PRIMARY KEY,
+			slaughter_date DATE NOT NULL,
+			species TEXT NOT NULL CHECK (species IN ('sheep', 'goat')),
+			tag TEXT,
+			origin TEXT,
+			age TEXT,
+			live_weight_kg DECIMAL(6,2),
+			net_estimate_kg DECIMAL(6,2) NOT NULL,
+			price_per_kg DECIMAL(10,2) NOT NULL,
+			fat_level TEXT,
+			image TEXT,
+			reserved_kg DECIMAL(6,2) NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'open',
+			cutoff_at TIMESTAMP NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK (reserved_kg >= 0 AND reserved_kg <= net_estimate_kg)
+		);
+		CREATE INDEX IF NOT EXISTS idx_booking_animals_date ON booking_animals(slaughter_date, status);
+
+		CREATE TABLE IF NOT EXISTS booking_animal_parts (
+			id SERIAL PRIMARY KEY,
+			animal_id INTEGER NOT NULL REFERENCES booking_animals(id) ON DELETE CASCADE,
+			code TEXT NOT NULL,
+			name TEXT NOT NULL,
+			unit TEXT,
+			price DECIMAL(10,2) NOT NULL,
+			quantity INTEGER NOT NULL DEFAULT 1,
+			reserved INTEGER NOT NULL DEFAULT 0,
+			can_roast BOOLEAN NOT NULL DEFAULT false,
+			UNIQUE(animal_id, code),
+			CHECK (reserved >= 0 AND reserved <= quantity)
+		);
+
+		CREATE TABLE IF NOT EXISTS bookings (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			animal_id INTEGER NOT NULL REFERENCES booking_animals(id),
+			address_id INTEGER REFERENCES addresses(id) ON DELETE SET NULL,
+			amount DECIMAL(10,2) NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'reserved',
+			order_id INTEGER REFERENCES orders(id),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_bookings_user ON bookings(user_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_bookings_animal ON bookings(animal_id, status);
+
+		CREATE TABLE IF NOT EXISTS booking_items (
+			id SERIAL PRIMARY KEY,
+			booking_id INTEGER NOT NULL REFERENCES bookings(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL CHECK (kind IN ('meat', 'part')),
+			part_id INTEGER REFERENCES booking_animal_parts(id),
+			kg DECIMAL(6,2),
+			cut_type TEXT,
+			roast BOOLEAN NOT NULL DEFAULT false,
+			amount DECIMAL(10,2) NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_booking_items_booking ON booking_items(booking_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create mutton booking schema:", err)
+	}
+}
+
+const bookingAnimalColumns = `id, slaughter_date::text, species, COALESCE(tag, ''), COALESCE(origin, ''), COALESCE(age, ''),
+	COALESCE(live_weight_kg, 0), net_estimate_kg, price_per_kg, COALESCE(fat_level, ''), COALESCE(image, ''),
+	reserved_kg, status, cutoff_at`
+
+func scanBookingAnimal(row interface{ Scan(...interface{}) error }) (*BookingAnimal, error) {
+	var a BookingAnimal
+	var cutoff time.Time
+	err := row.Scan(&a.ID, &a.SlaughterDate, &a.Species, &a.Tag, &a.Origin, &a.Age, &a.LiveWeightKg, &a.NetEstimateKg,
+		&a.PricePerKg, &a.FatLevel, &a.Image, &a.ReservedKg, &a.Status, &cutoff)
+	if err == sql.ErrNoRows {
+		return nil, errAnimalNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	a.CutoffAt = cutoff.Format(time.RFC3339)
+	a.AvailableKg = math.Max(0, a.NetEstimateKg-a.ReservedKg)
+	if a.LiveWeightKg > 0 {
+		a.Yield = math.Round(a.NetEstimateKg / a.LiveWeightKg * 100)
+	}
+	a.Parts = []AnimalPart{}
+	return &a, nil
+}
+
+// loadAnimalParts fills the parts of a set of animals in one query
+func loadAnimalParts(q dbExecer, animals []BookingAnimal) error {
+	if len(animals) == 0 {
+		return nil
+	}
+	index := map[int]*BookingAnimal{}
+	ids := make([]string, len(animals))
+	for i := range animals {
+		index[animals[i].ID] = &animals[i]
+		ids[i] = strconv.Itoa(animals[i].ID)
+	}
+	rows, err := q.Query(`
+		SELECT animal_id, id, code, name, COALESCE(unit, ''), price, quantity, reserved, can_roast
+		FROM booking_animal_parts WHERE animal_id = ANY($1::int[]) ORDER BY animal_id, id
+	`, "{"+strings.Join(ids, ",")+"}")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var animalID int
+		var p AnimalPart
+		rows.Scan(&animalID, &p.ID, &p.Code, &p.Name, &p.Unit, &p.Price, &p.Quantity, &p.Reserved, &p.CanRoast)
+		p.Available = p.Quantity - p.Reserved
+		index[animalID].Parts = append(index[animalID].Parts, p)
+	}
+	return nil
+}
+
+// listBookingAnimals returns animals for a date (or all upcoming), optionally only ones still open for booking
+func listBookingAnimals(date, species string, openOnly bool) ([]BookingAnimal, error) {
+	rows, err := db.Query(`SELECT `+bookingAnimalColumns+` FROM booking_animals
+		WHERE ($1 = '' OR slaughter_date = $1::date) AND ($1 <> '' OR slaughter_date >= CURRENT_DATE)
+		  AND ($2 = '' OR species = $2)
+		  AND (NOT $3 OR (status = 'open' AND cutoff_at > NOW()))
+		ORDER BY slaughter_date, species, id`, date, species, openOnly)
+	if err != nil {
+		return nil, err
+	}
+	animals := []BookingAnimal{}
+	for rows.Next() {
+		a, err := scanBookingAnimal(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		animals = append(animals, *a)
+	}
+	rows.Close()
+	return animals, loadAnimalParts(db, animals)
+}
+
+type animalInput struct {
+	ID            int     `json:"id"`
+	SlaughterDate string  `json:"slaughter_date"`
+	Species       string  `json:"type"`
+	Tag           string  `json:"tag"`
+	Origin        string  `json:"origin"`
+	Age           string  `json:"age"`
+	LiveWeightKg  float64 `json:"live_weight_kg"`
+	NetEstimateKg float64 `json:"net_estimate_kg"`
+	PricePerKg    float64 `json:"pricePerKg"`
+	FatLevel      string  `json:"fatLevel"`
+	Image         string  `json:"image"`
+	CutoffAt      string  `json:"cutoff_at"`
+	Parts         []struct {
+		Code     string  `json:"code"`
+		Name     string  `json:"name"`
+		Unit     string  `json:"unit"`
+		Price    float64 `json:"price"`
+		Quantity int     `json:"quantity"`
+		CanRoast bool    `json:"canRoast"`
+	} `json:"parts"`
+}
+
+// publishAnimal creates an animal with its part inventory
+func publishAnimal(in animalInput) (int, error) {
+	date, err := time.ParseInLocation("2006-01-02", in.SlaughterDate, time.Local)
+	if err != nil || (in.Species != SpeciesSheep && in.Species != SpeciesGoat) || in.NetEstimateKg <= 0 || in.PricePerKg <= 0 {
+		return 0, errAnimalInvalid
+	}
+	cutoff := defaultBookingCutoff(date)
+	if in.CutoffAt != "" {
+		if cutoff, err = time.Parse(time.RFC3339, in.CutoffAt); err != nil {
+			return 0, errAnimalInvalid
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO booking_animals (slaughter_date, species, tag, origin, age, live_weight_kg, net_estimate_kg, price_per_kg, fat_level, image, cutoff_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), $7, $8, $9, $10, $11) RETURNING id
+	`, in.SlaughterDate, in.Species, in.Tag, in.Origin, in.Age, in.LiveWeightKg, in.NetEstimateKg, in.PricePerKg, in.FatLevel, in.Image, cutoff).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range in.Parts {
+		if p.Quantity <= 0 {
+			p.Quantity = 1
+		}
+		if p.Code == "" || p.Name == "" || p.Price < 0 {
+			return 0, errAnimalInvalid
+		}
+		_, err := tx.Exec(`
+			INSERT INTO booking_animal_parts (animal_id, code, name, unit, price, quantity, can_roast)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`, id, p.Code, p.Name, p.Unit, p.Price, p.Quantity, p.CanRoast)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+type bookingRequest struct {
+	AnimalID  int     `json:"animal_id"`
+	AddressID int     `json:"address_id"`
+	Kg        float64 `json:"kg"`
+	CutType   string  `json:"cut_type"`
+	Parts     []struct {
+		PartID int  `json:"part_id"`
+		Roast  bool `json:"roast"`
+	} `json:"parts"`
+}
+
+// reserveBooking takes meat and/or parts off an animal for a user. Every decrement is a
+// conditional UPDATE, so a competing booking that got there first makes this one fail cleanly.
+func reserveBooking(userID int, req bookingRequest) (int, error) {
+	if req.Kg == 0 && len(req.Parts) == 0 {
+		return 0, errBookingEmpty
+	}
+	if req.Kg != 0 && (req.Kg < bookingKgStep || req.Kg > maxBookingKg || math.Mod(req.Kg, bookingKgStep) != 0) {
+		return 0, errBookingQuantity
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	var open bool
+	var pricePerKg float64
+	// The lock orders this booking against an admin cancelling or rolling the animal
+	err = tx.QueryRow("SELECT status, cutoff_at > NOW(), price_per_kg FROM booking_animals WHERE id = $1 FOR UPDATE", req.AnimalID).
+		Scan(&status, &open, &pricePerKg)
+	if err == sql.ErrNoRows {
+		return 0, errAnimalNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if status != AnimalOpen || !open {
+		return 0, errBookingClosed
+	}
+
+	var addressOK bool
+	tx.QueryRow("SELECT EXISTS(SELECT 1 FROM addresses WHERE id = $1 AND user_id = $2)", req.AddressID, userID).Scan(&addressOK)
+	if !addressOK {
+		return 0, errAddressNotFound
+	}
+
+	var id int
+	if err := tx.QueryRow("INSERT INTO bookings (user_id, animal_id, address_id) VALUES ($1, $2, $3) RETURNING id",
+		userID, req.AnimalID, req.AddressID).Scan(&id); err != nil {
+		return 0, err
+	}
+
+	total := 0.0
+	if req.Kg > 0 {
+		res, err := tx.Exec(`
+			UPDATE booking_animals SET reserved_kg = reserved_kg + $1
+			WHERE id = $2 AND status = 'open' AND cutoff_at > NOW() AND reserved_kg + $1 <= net_estimate_kg
+		`, req.Kg, req.AnimalID)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return 0, errBookingSoldOut
+		}
+		amount := roundMoney(pricePerKg * req.Kg)
+		if req.CutType == "" {
+			req.CutType = "Mixed Curry Cut"
+		}
+		if _, err := tx.Exec("INSERT INTO booking_items (booking_id, kind, kg, cut_type, amount) VALUES ($1, 'meat', $2, $3, $4)",
+			id, req.Kg, req.CutType, amount); err != nil {
+			return 0, err
+		}
+		total += amount
+	}
+
+	for _, p := range req.Parts {
+		var price float64
+		var canRoast bool
+		err := tx.QueryRow(`
+			UPDATE booking_animal_parts SET reserved = reserved + 1
+			WHERE id = $1 AND animal_id = $2 AND reserved < quantity
+			RETURNING price, can_roast
+		`, p.PartID, req.AnimalID).Scan(&price, &canRoast)
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w (part %d)", errPartSoldOut, p.PartID)
+		} else if err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec("INSERT INTO booking_items (booking_id, kind, part_id, roast, amount) VALUES ($1, 'part', $2, $3, $4)",
+			id, p.PartID, p.Roast && canRoast, price); err != nil {
+			return 0, err
+		}
+		total += price
+	}
+
+	if _, err := tx.Exec("UPDATE bookings SET amount = $1 WHERE id = $2", roundMoney(total), id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// cancelBooking returns a booking's meat and parts to the animal. Customers can only cancel
+// before cutoff; admins (ownerID 0) any time before the booking becomes an order.
+func cancelBooking(id, ownerID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := cancelBookingTx(tx, id, ownerID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func cancelBookingTx(tx dbExecer, id, ownerID int) error {
+	var userID, animalID int
+	var status string
+	var beforeCutoff bool
+	err := tx.QueryRow(`
+		SELECT b.user_id, b.animal_id, b.status, a.cutoff_at > NOW()
+		FROM bookings b JOIN booking_animals a ON b.animal_id = a.id WHERE b.id = $1 FOR UPDATE OF b
+	`, id).Scan(&userID, &animalID, &status, &beforeCutoff)
+	if err == sql.ErrNoRows || (err == nil && ownerID != 0 && ownerID != userID) {
+		return errBookingNotFound
+	} else if err != nil {
+		return err
+	}
+	if status != BookingReserved || (ownerID != 0 && !beforeCutoff) {
+		return errBookingNotEditable
+	}
+
+	_, err = tx.Exec(`
+		UPDATE booking_animals SET reserved_kg = reserved_kg -
+			(SELECT COALESCE(SUM(kg), 0) FROM booking_items WHERE booking_id = $1 AND kind = 'meat')
+		WHERE id = $2
+	`, id, animalID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE booking_animal_parts p SET reserved = p.reserved -
+			(SELECT COUNT(*) FROM booking_items bi WHERE bi.booking_id = $1 AND bi.part_id = p.id)
+		WHERE p.id IN (SELECT part_id FROM booking_items WHERE booking_id = $1 AND kind = 'part')
+	`, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE bookings SET status = $1, updated_at = NOW() WHERE id = $2", BookingCancelled, id)
+	return err
+}
+
+// cancelAnimal withdraws an animal that hasn't been slaughtered and cancels its open
+// bookings. The status changes first, in the same transaction, so no booking can land
+// between the two. Returns how many bookings were cancelled.
+func cancelAnimal(id int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE booking_animals SET status = $1 WHERE id = $2 AND status <> $3", AnimalCancelled, id, AnimalSlaughtered)
+	if err != nil {
+		return 0, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return 0, errAnimalNotFound
+	}
+
+	rows, err := tx.Query("SELECT id FROM bookings WHERE animal_id = $1 AND status = $2", id, BookingReserved)
+	if err != nil {
+		return 0, err
+	}
+	var bookingIDs []int
+	for rows.Next() {
+		var bid int
+		rows.Scan(&bid)
+		bookingIDs = append(bookingIDs, bid)
+	}
+	rows.Close()
+	for _, bid := range bookingIDs {
+		if err := cancelBookingTx(tx, bid, 0); err != nil {
+			return 0, fmt.Errorf("booking %d: %w", bid, err)
+		}
+	}
+	return len(bookingIDs), tx.Commit()
+}
+
+func listBookings(userID int, date string) ([]Booking, error) {
+	rows, err := db.Query(`
+		SELECT b.id, b.user_id, COALESCE(u.name, ''), b.animal_id, COALESCE(a.tag, ''), a.species, a.slaughter_date::text,
+		       COALESCE(b.address_id, 0), b.amount, b.status, COALESCE(b.order_id, 0), b.created_at::text
+		FROM bookings b
+		JOIN booking_animals a ON b.animal_id = a.id
+		LEFT JOIN users u ON b.user_id = u.id
+		WHERE ($1 = 0 OR b.user_id = $1) AND ($2 = '' OR a.slaughter_date = $2::date)
+		ORDER BY a.slaughter_date DESC, b.id DESC
+	`, userID, date)
+	if err != nil {
+		return nil, err
+	}
+	bookings := []Booking{}
+	index := map[int]int{}
+	for rows.Next() {
+		var b Booking
+		rows.Scan(&b.ID, &b.UserID, &b.CustomerName, &b.AnimalID, &b.AnimalTag, &b.Species, &b.SlaughterDate,
+			&b.AddressID, &b.Amount, &b.Status, &b.OrderID, &b.CreatedAt)
+		b.Items = []BookingItem{}
+		index[b.ID] = len(bookings)
+		bookings = append(bookings, b)
+	}
+	rows.Close()
+	if len(bookings) == 0 {
+		return bookings, nil
+	}
+
+	itemRows, err := db.Query(`
+		SELECT bi.booking_id, bi.id, bi.kind, COALESCE(bi.part_id, 0), COALESCE(p.name, ''), COALESCE(p.unit, ''),
+		       COALESCE(bi.kg, 0), COALESCE(bi.cut_type, ''), bi.roast, bi.amount
+		FROM booking_items bi LEFT JOIN booking_animal_parts p ON bi.part_id = p.id
+		WHERE bi.booking_id IN (SELECT b.id FROM bookings b JOIN booking_animals a ON b.animal_id = a.id
+		                        WHERE ($1 = 0 OR b.user_id = $1) AND ($2 = '' OR a.slaughter_date = $2::date))
+		ORDER BY bi.id
+	`, userID, date)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var bookingID int
+		var it BookingItem
+		itemRows.Scan(&bookingID, &it.ID, &it.Kind, &it.PartID, &it.Name, &it.UnitNote, &it.Kg, &it.CutType, &it.Roast, &it.Amount)
+		if it.Kind == "meat" {
+			it.Name = fmt.Sprintf("Mutton %.1f kg (%s)", it.Kg, it.CutType)
+		}
+		if i, ok := index[bookingID]; ok {
+			bookings[i].Items = append(bookings[i].Items, it)
+		}
+	}
+	return bookings, nil
+}
+
+// closeBookingCutoffs closes animals whose cutoff has passed
+func closeBookingCutoffs() {
+	if _, err := db.Exec("UPDATE booking_animals SET status = $1 WHERE status = $2 AND cutoff_at <= NOW()", AnimalClosed, AnimalOpen); err != nil {
+		log.Println("Error closing bookings:", err)
+	}
+}
+
+// bookingRollFailure is a booking that could not be turned into an order
+type bookingRollFailure struct {
+	BookingID int    `json:"booking_id"`
+	Error     string `json:"error"`
+}
+
+// rollBookingsIntoOrders turns every reserved booking for animals slaughtered on date into a
+// cash-on-delivery order at the booked address. An animal is only marked slaughtered once
+// none of its bookings is left reserved; failed bookings are returned for an admin to fix
+// or cancel, and are retried on the next run.
+func rollBookingsIntoOrders(date time.Time) (int, []bookingRollFailure, error) {
+	day := date.Format("2006-01-02")
+	// Booking ends for the day's animals before their bookings are collected
+	if _, err := db.Exec("UPDATE booking_animals SET status = $1 WHERE slaughter_date = $2 AND status = $3",
+		AnimalClosed, day, AnimalOpen); err != nil {
+		return 0, nil, err
+	}
+	rows, err := db.Query(`
+		SELECT b.id FROM bookings b JOIN booking_animals a ON b.animal_id = a.id
+		WHERE a.slaughter_date = $1 AND a.status <> $2 AND b.status = $3
+		ORDER BY b.id
+	`, day, AnimalCancelled, BookingReserved)
+	if err != nil {
+		return 0, nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	created := 0
+	failures := []bookingRollFailure{}
+	for _, id := range ids {
+		if err := rollBooking(id); err != nil {
+			log.Printf("Booking %d could not be turned into an order: %v", id, err)
+			failures = append(failures, bookingRollFailure{BookingID: id, Error: err.Error()})
+			continue
+		}
+		created++
+	}
+	_, err = db.Exec(`
+		UPDATE booking_animals a SET status = $1
+		WHERE a.slaughter_date = $2 AND a.status = $3
+		  AND NOT EXISTS (SELECT 1 FROM bookings b WHERE b.animal_id = a.id AND b.status = $4)
+	`, AnimalSlaughtered, day, AnimalClosed, BookingReserved)
+	return created, failures, err
+}
+
+func rollBooking(bookingID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID, addressID int
+	var status, species, tag, image, slaughterDate string
+	err = tx.QueryRow(`
+		SELECT b.user_id, COALESCE(b.address_id, 0), b.status, a.species, COALESCE(a.tag, ''), COALESCE(a.image, ''), a.slaughter_date::text
+		FROM bookings b JOIN booking_animals a ON b.animal_id = a.id WHERE b.id = $1 FOR UPDATE OF b
+	`, bookingID).Scan(&userID, &addressID, &status, &species, &tag, &image, &slaughterDate)
+	if err != nil {
+		return err
+	}
+	if status != BookingReserved {
+		return nil
+	}
+
+	var address, landmark, receiverName, receiverPhone string
+	var lat, lng sql.NullFloat64
+	err = tx.QueryRow(`
+		SELECT full_address, COALESCE(landmark, ''), receiver_name, receiver_phone, latitude, longitude
+		FROM addresses WHERE id = $1
+	`, addressID).Scan(&address, &landmark, &receiverName, &receiverPhone, &lat, &lng)
+	if err == sql.ErrNoRows {
+		return errAddressNotFound
+	} else if err != nil {
+		return err
+	}
+
+	itemRows, err := tx.Query(`
+		SELECT bi.kind, COALESCE(p.name, ''), COALESCE(bi.kg, 0), COALESCE(bi.cut_type, ''), bi.roast, bi.amount
+		FROM booking_items bi LEFT JOIN booking_animal_parts p ON bi.part_id = p.id
+		WHERE bi.booking_id = $1 ORDER BY bi.id
+	`, bookingID)
+	if err != nil {
+		return err
+	}
+	animal := strings.ToUpper(species[:1]) + species[1:]
+	if tag != "" {
+		animal += " (" + tag + ")"
+	}
+	var lines []OrderLine
+	itemTotal := 0.0
+	for itemRows.Next() {
+		var kind, partName, cutType string
+		var kg, amount float64
+		var roast bool
+		itemRows.Scan(&kind, &partName, &kg, &cutType, &roast, &amount)
+		l := OrderLine{Category: "Meat", Image: image, UnitPrice: amount, Quantity: 1, LineTotal: amount}
+		if kind == "meat" {
+			l.Name, l.VariantName, l.Weight = "Mutton - "+animal, cutType, fmt.Sprintf("%.1f kg", kg)
+		} else {
+			l.Name = partName + " - " + animal
+			if roast {
+				l.VariantName = "Roasted"
+			}
+		}
+		lines = append(lines, l)
+		itemTotal += amount
+	}
+	itemRows.Close()
+
+	itemTotal = roundMoney(itemTotal)
+	deliveryFee, platformFee := computeOrderFees(itemTotal, "standard")
+	total := roundMoney(itemTotal + deliveryFee + platformFee)
+
+	var orderID int
+	err = tx.QueryRow(`
+		INSERT INTO orders (user_id, address_id, address, landmark, receiver_name, receiver_phone, latitude, longitude,
+		                    delivery_option, item_total, delivery_fee, platform_fee, total, payment_method, delivery_date, slot_type, zone_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, 'advance_booking', $9, $10, $11, $12, $13, $14, $15,
+		        (SELECT zone_id FROM addresses WHERE id = $2)) RETURNING id
+	`, userID, addressID, address, landmark, receiverName, receiverPhone, lat, lng,
+		itemTotal, deliveryFee, platformFee, total, PayCOD, slaughterDate, SlotMorning).Scan(&orderID)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		_, err := tx.Exec(`
+			INSERT INTO order_items (order_id, name, variant_name, category, weight, image, unit_price, quantity, line_total, species)
+			VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), $6, $7, $8, $9, $10)
+		`, orderID, l.Name, l.VariantName, l.Category, l.Weight, l.Image, l.UnitPrice, l.Quantity, l.LineTotal, species)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO order_status_events (order_id, from_status, to_status, reason_code, actor_email, actor_role)
+		VALUES ($1, NULL, $2, 'advance_booking', 'system', 'system')`, orderID, OrderPending); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE bookings SET status = $1, order_id = $2, updated_at = NOW() WHERE id = $3",
+		BookingOrdered, orderID, bookingID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// startBookingScheduler closes bookings at cutoff and rolls the day's bookings into orders.
+// Both steps are idempotent, so running hourly is safe.
+func startBookingScheduler() {
+	go func() {
+		for {
+			closeBookingCutoffs()
+			if n, failures, err := rollBookingsIntoOrders(time.Now()); err != nil {
+				log.Println("Rolling bookings into orders failed:", err)
+			} else if n > 0 || len(failures) > 0 {
+				log.Printf("Rolled %d mutton bookings into orders, %d failed", n, len(failures))
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+// --- Handlers ---
+
+// bookingAnimalsHandler lists animals open for booking: GET ?date=YYYY-MM-DD&type=sheep|goat
+func bookingAnimalsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	animals, err := listBookingAnimals(r.URL.Query().Get("date"), r.URL.Query().Get("type"), true)
+	if err != nil {
+		log.Println("Error fetching booking animals:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(animals)
+}
+
+// bookingsHandler serves a customer's bookings: GET list, POST reserve, DELETE ?id= cancel
+func bookingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		bookings, err := listBookings(userID, "")
+		if err != nil {
+			log.Println("Error fetching bookings:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(bookings)
+
+	case "POST":
+		var req bookingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		id, err := reserveBooking(userID, req)
+		if err != nil {
+			if bookingErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error reserving booking:", err)
+			}
+			http.Error(w, err.Error(), bookingErrorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id})
+
+	case "DELETE":
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		if err := cancelBooking(id, userID); err != nil {
+			http.Error(w, err.Error(), bookingErrorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminBookingAnimalsHandler lists (GET ?date=), publishes (POST) and cancels (DELETE ?id=) animals.
+// Cancelling an animal cancels its open bookings.
+func adminBookingAnimalsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		animals, err := listBookingAnimals(r.URL.Query().Get("date"), r.URL.Query().Get("type"), false)
+		if err != nil {
+			log.Println("Error fetching booking animals:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(animals)
+
+	case "POST":
+		var in animalInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		id, err := publishAnimal(in)
+		if err != nil {
+			if bookingErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error publishing animal:", err)
+			}
+			http.Error(w, err.Error(), bookingErrorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id})
+
+	case "DELETE":
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		n, err := cancelAnimal(id)
+		if err != nil {
+			if bookingErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error cancelling animal:", err)
+			}
+			http.Error(w, err.Error(), bookingErrorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "cancelled_bookings": n})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminBookingsHandler lists bookings for a slaughter date: GET ?date=YYYY-MM-DD
+func adminBookingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bookings, err := listBookings(0, r.URL.Query().Get("date"))
+	if err != nil {
+		log.Println("Error fetching bookings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(bookings)
+}
+
+// adminRollBookingsHandler rolls a date's bookings into orders now: POST {date}
+func adminRollBookingsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Date string `json:"date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	date, err := time.ParseInLocation("2006-01-02", req.Date, time.Local)
+	if err != nil {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	n, failures, err := rollBookingsIntoOrders(date)
+	if err != nil {
+		log.Println("Error rolling bookings:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "orders_created": n, "failed": failures})
+}
@@ -0,0 +1,1061 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// ONE-TIME ORDERS
+// ============================================================================
+//
+// Checkout re-prices the cart against the catalog (prices are snapshotted on
+// the order lines), takes the units out of catalog stock, copies the delivery
+// address, adds the delivery and platform fees and opens the payment. Orders
+// move Pending -> Processing -> Delivered, and can be Cancelled before
+// delivery: by the customer while still Pending (with a reason), by an admin
+// until Delivered. Cancelling puts the stock back and refunds a paid order.
+//
+// Routes follow the admin app's orderService:
+//   GET  /api/orders               own orders (admins: all, ?status=&user_id=&date=YYYY-MM-DD)
+//   POST /api/orders               place an order
+//   GET  /api/orders/{id}          owner or admin
+//   PUT  /api/orders/{id}/status   admin
+//   PUT  /api/orders/{id}/cancel   owner (Pending only) or admin
+
+// Order statuses, matching the admin app's OrderStatus
+const (
+	OrderPending    = "Pending"
+	OrderProcessing = "Processing"
+	OrderDelivered  = "Delivered"
+	OrderCancelled  = "Cancelled"
+)
+
+var orderTransitions = map[string][]string{
+	OrderPending:    {OrderProcessing, OrderCancelled},
+	OrderProcessing: {OrderDelivered, OrderCancelled},
+	OrderDelivered:  {},
+	OrderCancelled:  {},
+}
+
+// Payment methods and payment states of an order
+const (
+	PayCOD    = "cod"
+	PayUPI    = "upi"
+	PayCard   = "card"
+	PayWallet = "wallet"
+
+	OrderUnpaid   = "unpaid"
+	OrderPaid     = "paid"
+	OrderRefunded = "refunded"
+)
+
+// Reasons a customer can give when cancelling
+var orderCancelReasons = map[string]string{
+	"changed_mind":       "Changed my mind",
+	"ordered_by_mistake": "Ordered by mistake",
+	"delivery_too_late":  "Delivery time too late",
+	"wrong_address":      "Wrong address",
+	"found_cheaper":      "Found a better price",
+	"other":              "Other",
+}
+
+const maxLineQuantity = 50
+
+var (
+	errOrderNotFound      = errors.New("order not found")
+	errOrderTransition    = errors.New("illegal order status transition")
+	errOrderCancelReason  = errors.New("a valid cancellation reason is required")
+	errEmptyCart          = errors.New("cart is empty")
+	errAddressNotFound    = errors.New("address not found")
+	errPaymentMethod      = errors.New("payment_method must be cod, upi, card or wallet")
+	errCartInvalid        = errors.New("some cart items are unavailable")
+	errInsufficientFunds  = errors.New("wallet balance is too low for this order")
+	errOrderStatusUnknown = errors.New("unknown order status")
+)
+
+func orderErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errOrderNotFound), errors.Is(err, errAddressNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errOrderTransition), errors.Is(err, errCartInvalid), errors.Is(err, errInsufficientFunds):
+		return http.StatusConflict
+	case errors.Is(err, errOrderCancelReason), errors.Is(err, errEmptyCart), errors.Is(err, errPaymentMethod),
+		errors.Is(err, errOrderStatusUnknown), errors.Is(err, errSlotInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errPaymentProvider):
+		return http.StatusBadGateway
+	case errors.Is(err, errPaymentsDisabled):
+		return http.StatusServiceUnavailable
+	}
+	if code := slotErrorStatus(err); code != http.StatusInternalServerError {
+		return code
+	}
+	if code := zoneErrorStatus(err); code != http.StatusInternalServerError {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// OrderSummary is one row of an order list; field names match the admin app's Order type
+type OrderSummary struct {
+	ID            int     `json:"id"`
+	OrderNumber   string  `json:"order_number"`
+	UserID        int     `json:"user_id"`
+	CustomerName  string  `json:"customerName"`
+	Phone         string  `json:"phone"`
+	Items         int     `json:"items"`
+	Total         float64 `json:"total"`
+	Status        string  `json:"status"`
+	PaymentMethod string  `json:"payment_method"`
+	PaymentStatus string  `json:"payment_status"`
+	Date          string  `json:"date"`
+	Address       string  `json:"address"`
+}
+
+type Order struct {
+	OrderSummary
+	AddressID      int         `json:"address_id"`
+	Landmark       string      `json:"landmark,omitempty"`
+	DeliveryOption string      `json:"delivery_option"`
+	DeliveryDate   string      `json:"delivery_date"`
+	SlotType       string      `json:"slot_type"`
+	ItemTotal      float64     `json:"item_total"`
+	DeliveryFee    float64     `json:"delivery_fee"`
+	PlatformFee    float64     `json:"platform_fee"`
+	CancelReason   string      `json:"cancel_reason,omitempty"`
+	CancelNote     string      `json:"cancel_note,omitempty"`
+	Lines          []OrderLine `json:"lines"`
+	UpdatedAt      string      `json:"updated_at"`
+}
+
+type OrderLine struct {
+	ProductID   int     `json:"product_id"`
+	VariantID   int     `json:"variant_id,omitempty"`
+	Name        string  `json:"name"`
+	VariantName string  `json:"variant_name,omitempty"`
+	Category    string  `json:"category"`
+	Weight      string  `json:"weight,omitempty"`
+	Image       string  `json:"image,omitempty"`
+	UnitPrice   float64 `json:"unit_price"`
+	Quantity    int     `json:"quantity"`
+	LineTotal   float64 `json:"line_total"`
+	// Options are the "group:option" preparation codes, PrepNote their labels
+	Options  []string `json:"options,omitempty"`
+	PrepNote string   `json:"prep,omitempty"`
+}
+
+// CartItemRequest identifies a product (and variant), how it's prepared and how many
+type CartItemRequest struct {
+	ProductID int      `json:"product_id"`
+	VariantID int      `json:"variant_id"`
+	Quantity  int      `json:"quantity"`
+	Options   []string `json:"options,omitempty"`
+}
+
+// CartIssue explains why a cart item can't be ordered as-is
+type CartIssue struct {
+	ProductID int    `json:"product_id"`
+	VariantID int    `json:"variant_id,omitempty"`
+	Code      string `json:"code"` // not_found, unavailable, out_of_stock, invalid_quantity, variant_required, invalid_options
+	Message   string `json:"message"`
+	Available int    `json:"available,omitempty"`
+}
+
+type orderFees struct {
+	DeliveryFee           float64 `json:"delivery_fee"`
+	PlatformFee           float64 `json:"platform_fee"`
+	FreeDeliveryThreshold float64 `json:"free_delivery_threshold"`
+}
+
+func envMoney(name string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(name), 64); err == nil && v >= 0 {
+		return v
+	}
+	return def
+}
+
+// currentOrderFees mirrors the cart page: Rs. 35 delivery, free from Rs. 299, Rs. 5 platform fee
+func currentOrderFees() orderFees {
+	return orderFees{
+		DeliveryFee:           envMoney("ORDER_DELIVERY_FEE", 35),
+		PlatformFee:           envMoney("ORDER_PLATFORM_FEE", 5),
+		FreeDeliveryThreshold: envMoney("ORDER_FREE_DELIVERY_THRESHOLD", 299),
+	}
+}
+
+// computeOrderFees returns the delivery and platform fees for an item total.
+// Orders riding along with a subscription slot ship free.
+func computeOrderFees(itemTotal float64, deliveryOption string) (deliveryFee, platformFee float64) {
+	fees := currentOrderFees()
+	if itemTotal <= 0 {
+		return 0, 0
+	}
+	if deliveryOption != "subscription_slot" && itemTotal < fees.FreeDeliveryThreshold {
+		deliveryFee = fees.DeliveryFee
+	}
+	return deliveryFee, fees.PlatformFee
+}
+
+func initOrdersSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS orders (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			address_id INTEGER REFERENCES addresses(id) ON DELETE SET NULL,
+			address TEXT NOT NULL,
+			landmark TEXT,
+			receiver_name TEXT,
+			receiver_phone TEXT,
+			latitude DECIMAL(10,8),
+			longitude DECIMAL(11,8),
+			delivery_option TEXT NOT NULL DEFAULT 'standard',
+			item_total DECIMAL(10,2) NOT NULL,
+			delivery_fee DECIMAL(10,2) NOT NULL DEFAULT 0,
+			platform_fee DECIMAL(10,2) NOT NULL DEFAULT 0,
+			total DECIMAL(10,2) NOT NULL,
+			payment_method TEXT NOT NULL,
+			payment_status TEXT NOT NULL DEFAULT 'unpaid',
+			status TEXT NOT NULL DEFAULT 'Pending',
+			cancel_reason TEXT,
+			cancel_note TEXT,
+			delivered_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_orders_user ON orders(user_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_orders_status ON orders(status, created_at);
+
+		CREATE TABLE IF NOT EXISTS order_items (
+			id SERIAL PRIMARY KEY,
+			order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			product_id INTEGER REFERENCES products(id),
+			variant_id INTEGER REFERENCES product_variants(id) ON DELETE SET NULL,
+			name TEXT NOT NULL,
+			variant_name TEXT,
+			category TEXT,
+			weight TEXT,
+			image TEXT,
+			unit_price DECIMAL(10,2) NOT NULL,
+			quantity INTEGER NOT NULL CHECK (quantity > 0),
+			line_total DECIMAL(10,2) NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_order_items_order ON order_items(order_id);
+
+		CREATE TABLE IF NOT EXISTS order_status_events (
+			id SERIAL PRIMARY KEY,
+			order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+			from_status TEXT,
+			to_status TEXT NOT NULL,
+			reason_code TEXT,
+			note TEXT,
+			actor_email TEXT,
+			actor_role TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_order_status_events_order ON order_status_events(order_id, created_at);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create orders schema:", err)
+	}
+}
+
+func orderNumber(id int) string {
+	return fmt.Sprintf("ORD-%06d", id)
+}
+
+// priceCartItems validates items against the live catalog and prices them for a user.
+// Items that can't be ordered come back as issues instead of lines.
+func priceCartItems(q dbExecer, userID int, items []CartItemRequest) ([]OrderLine, []CartIssue, error) {
+	lines := []OrderLine{}
+	issues := []CartIssue{}
+	scope := userPriceScope(q, userID)
+
+	for _, it := range items {
+		issue := CartIssue{ProductID: it.ProductID, VariantID: it.VariantID}
+		if it.Quantity <= 0 || it.Quantity > maxLineQuantity {
+			issue.Code, issue.Message = "invalid_quantity", fmt.Sprintf("quantity must be between 1 and %d", maxLineQuantity)
+			issues = append(issues, issue)
+			continue
+		}
+
+		p, err := loadProduct(q, it.ProductID)
+		if err == errProductNotFound {
+			issue.Code, issue.Message = "not_found", "product no longer exists"
+			issues = append(issues, issue)
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+		var categoryActive bool
+		q.QueryRow("SELECT is_active FROM product_categories WHERE id = $1", p.CategoryID).Scan(&categoryActive)
+		if !p.IsAvailable || !categoryActive {
+			issue.Code, issue.Message = "unavailable", p.Name+" is currently unavailable"
+			issues = append(issues, issue)
+			continue
+		}
+
+		line := OrderLine{ProductID: p.ID, Name: p.Name, Category: p.Category, Weight: p.Weight, Image: p.Image,
+			UnitPrice: p.Price, Quantity: it.Quantity}
+		stock := p.Stock
+
+		if len(p.Variants) > 0 {
+			var variant *ProductVariant
+			for i := range p.Variants {
+				if p.Variants[i].ID == it.VariantID {
+					variant = &p.Variants[i]
+				}
+			}
+			if variant == nil {
+				issue.Code, issue.Message = "variant_required", "choose a size/cut for "+p.Name
+				issues = append(issues, issue)
+				continue
+			}
+			if !variant.IsAvailable {
+				issue.Code, issue.Message = "unavailable", p.Name+" ("+variant.Name+") is currently unavailable"
+				issues = append(issues, issue)
+				continue
+			}
+			line.VariantID, line.VariantName, line.UnitPrice, stock = variant.ID, variant.Name, variant.Price, variant.Stock
+		} else if p.MilkType != "" {
+			// Milk is priced from the price history like subscriptions
+			if rate, err := lookupPrice(q, p.MilkType, time.Now(), scope); err == nil {
+				line.UnitPrice = rate
+			}
+		}
+
+		groups, err := loadPrepGroups(q, p.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		prep, err := resolvePrep(groups, it.Options)
+		if err != nil {
+			issue.Code, issue.Message = "invalid_options", err.Error()
+			issues = append(issues, issue)
+			continue
+		}
+		line.Options, line.PrepNote = prep.Codes, prep.Note
+		line.UnitPrice = roundMoney(line.UnitPrice + prep.Modifier)
+
+		if stock < it.Quantity {
+			issue.Code, issue.Available = "out_of_stock", stock
+			issue.Message = fmt.Sprintf("only %d of %s left", stock, p.Name)
+			issues = append(issues, issue)
+			continue
+		}
+
+		line.LineTotal = roundMoney(line.UnitPrice * float64(line.Quantity))
+		lines = append(lines, line)
+	}
+	return lines, issues, nil
+}
+
+type placeOrderRequest struct {
+	Items          []CartItemRequest `json:"items"`
+	AddressID      int               `json:"address_id"`
+	PaymentMethod  string            `json:"payment_method"`
+	DeliveryOption string            `json:"delivery_option"`
+	DeliveryDate   string            `json:"delivery_date"`
+	SlotType       string            `json:"slot_type"`
+	// SlotHoldID confirms a slot held during checkout; without one the slot's capacity is checked now
+	SlotHoldID int `json:"slot_hold_id"`
+	// FromCart checks out the user's server cart when Items is empty, and clears it afterwards
+	FromCart bool `json:"from_cart"`
+}
+
+// placeOrder writes the order and, for wallet payments, debits the wallet in the same transaction.
+// Gateway payments are opened afterwards; the returned intent is nil for cod/wallet.
+func placeOrder(userID int, req placeOrderRequest, actorEmail, actorRole string) (*Order, []CartIssue, *PaymentIntent, error) {
+	if len(req.Items) == 0 {
+		return nil, nil, nil, errEmptyCart
+	}
+	switch req.PaymentMethod {
+	case PayCOD, PayUPI, PayCard, PayWallet:
+	default:
+		return nil, nil, nil, errPaymentMethod
+	}
+	if _, off := paymentProvider.(disabledProvider); off && (req.PaymentMethod == PayUPI || req.PaymentMethod == PayCard) {
+		return nil, nil, nil, errPaymentsDisabled
+	}
+	if req.DeliveryOption == "" {
+		req.DeliveryOption = "standard"
+	}
+	if req.DeliveryDate == "" && req.SlotType == "" && req.SlotHoldID == 0 {
+		// No slot chosen: take the next one with room in the address's zone
+		zoneID, _ := addressZone(db, req.AddressID)
+		if next, err := nextOpenSlot(zoneID); err == nil {
+			req.DeliveryDate, req.SlotType = next.Date, next.SlotType
+		}
+	}
+	date, slot, err := normalizeSlot(req.DeliveryDate, req.SlotType)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer tx.Rollback()
+
+	lines, issues, err := priceCartItems(tx, userID, req.Items)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(issues) > 0 {
+		return nil, issues, nil, errCartInvalid
+	}
+
+	var address, landmark, receiverName, receiverPhone string
+	var lat, lng sql.NullFloat64
+	err = tx.QueryRow(`
+		SELECT full_address, COALESCE(landmark, ''), receiver_name, receiver_phone, latitude, longitude
+		FROM addresses WHERE id = $1 AND user_id = $2
+	`, req.AddressID, userID).Scan(&address, &landmark, &receiverName, &receiverPhone, &lat, &lng)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil, errAddressNotFound
+	} else if err != nil {
+		return nil, nil, nil, err
+	}
+	zoneID, err := addressZone(tx, req.AddressID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	litres, err := itemsLitres(tx, req.Items)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if req.SlotHoldID != 0 {
+		hold, err := confirmSlotHold(tx, req.SlotHoldID, userID, req.AddressID, zoneID, litres)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		date, slot = hold.DeliveryDate, hold.SlotType
+	} else if err := checkSlotCapacity(tx, date, slot, zoneID, litres); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var itemTotal float64
+	for _, l := range lines {
+		itemTotal += l.LineTotal
+	}
+	itemTotal = roundMoney(itemTotal)
+	deliveryFee, platformFee := computeOrderFees(itemTotal, req.DeliveryOption)
+	total := roundMoney(itemTotal + deliveryFee + platformFee)
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO orders (user_id, address_id, address, landmark, receiver_name, receiver_phone, latitude, longitude,
+		                    delivery_option, item_total, delivery_fee, platform_fee, total, payment_method, delivery_date, slot_type)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING id
+	`, userID, req.AddressID, address, landmark, receiverName, receiverPhone, lat, lng,
+		req.DeliveryOption, itemTotal, deliveryFee, platformFee, total, req.PaymentMethod, date, slot).Scan(&id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if zoneID != 0 {
+		if _, err := tx.Exec("UPDATE orders SET zone_id = $1 WHERE id = $2", zoneID, id); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if req.SlotHoldID != 0 {
+		if _, err := tx.Exec("UPDATE slot_holds SET order_id = $1 WHERE id = $2", id, req.SlotHoldID); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	for _, l := range lines {
+		var variantArg interface{}
+		if l.VariantID != 0 {
+			variantArg = l.VariantID
+		}
+		_, err := tx.Exec(`
+			INSERT INTO order_items (order_id, product_id, variant_id, name, variant_name, category, weight, image, unit_price, quantity, line_total,
+			                         prep_options, prep_note)
+			VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $8, $9, $10, $11, NULLIF($12, ''), NULLIF($13, ''))
+		`, id, l.ProductID, variantArg, l.Name, l.VariantName, l.Category, l.Weight, l.Image, l.UnitPrice, l.Quantity, l.LineTotal,
+			strings.Join(l.Options, ","), l.PrepNote)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// Take the units now; a checkout racing this one for the last units fails here
+	// rather than both orders being accepted
+	if issues, err := reserveOrderStock(tx, lines); err != nil {
+		return nil, nil, nil, err
+	} else if len(issues) > 0 {
+		return nil, issues, nil, errCartInvalid
+	}
+
+	if req.PaymentMethod == PayWallet {
+		balance, err := walletBalance(tx, userID)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if balance < total {
+			return nil, nil, nil, errInsufficientFunds
+		}
+		_, err = postWalletTransaction(tx, walletTxn{
+			Key:         "order:" + strconv.Itoa(id),
+			Type:        WalletDebit,
+			UserID:      userID,
+			Amount:      total,
+			Description: "Order " + orderNumber(id),
+			CreatedBy:   actorEmail,
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if _, err := tx.Exec("UPDATE orders SET payment_status = $1 WHERE id = $2", OrderPaid, id); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO order_status_events (order_id, from_status, to_status, actor_email, actor_role) VALUES ($1, NULL, $2, $3, $4)`,
+		id, OrderPending, actorEmail, actorRole)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var intent *PaymentIntent
+	if req.PaymentMethod == PayUPI || req.PaymentMethod == PayCard {
+		_, in, err := createPayment(userID, PaymentForOrder, id, total)
+		if err != nil {
+			// The order stays Pending/unpaid; the customer can retry payment or cancel
+			log.Printf("Payment could not be opened for order %d: %v", id, err)
+		} else {
+			intent = &in
+		}
+	}
+	if req.PaymentMethod == PayWallet {
+		reevaluateFundingForUser(userID)
+	}
+
+	order, err := loadOrder(id)
+	return order, nil, intent, err
+}
+
+// reserveOrderStock takes each line's quantity out of catalog stock. The update only
+// succeeds while enough is left, so lines that lost the race come back as issues.
+func reserveOrderStock(q dbExecer, lines []OrderLine) ([]CartIssue, error) {
+	issues := []CartIssue{}
+	for _, l := range lines {
+		var res sql.Result
+		var err error
+		if l.VariantID != 0 {
+			res, err = q.Exec("UPDATE product_variants SET stock = stock - $2 WHERE id = $1 AND stock >= $2", l.VariantID, l.Quantity)
+		} else {
+			res, err = q.Exec("UPDATE products SET stock = stock - $2 WHERE id = $1 AND stock >= $2", l.ProductID, l.Quantity)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			issues = append(issues, CartIssue{ProductID: l.ProductID, VariantID: l.VariantID, Code: "out_of_stock",
+				Message: l.Name + " sold out before the order was placed"})
+			continue
+		}
+		// Listings are revalidated on products.updated_at
+		if _, err := q.Exec("UPDATE products SET updated_at = NOW() WHERE id = $1", l.ProductID); err != nil {
+			return nil, err
+		}
+	}
+	return issues, nil
+}
+
+// releaseOrderStock rebuilds the catalog stock of a cancelled order's lines from
+// the inventory ledger, which no longer counts them as open
+func releaseOrderStock(q dbExecer, orderID int) error {
+	rows, err := q.Query(`SELECT DISTINCT s.id FROM order_items oi
+		JOIN stock_items s ON s.product_id = oi.product_id AND COALESCE(s.variant_id, 0) = COALESCE(oi.variant_id, 0)
+		WHERE oi.order_id = $1`, orderID)
+	if err != nil {
+		return err
+	}
+	var items []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		items = append(items, id)
+	}
+	rows.Close()
+	for _, id := range items {
+		if err := refreshSellableStock(q, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const orderSummaryColumns = `o.id, o.user_id, COALESCE(u.name, ''), COALESCE(o.receiver_phone, ''),
+	(SELECT COALESCE(SUM(quantity), 0) FROM order_items WHERE order_id = o.id), o.total, o.status,
+	o.payment_method, o.payment_status, o.created_at::text, o.address`
+
+func scanOrderSummary(row interface{ Scan(...interface{}) error }, s *OrderSummary) error {
+	err := row.Scan(&s.ID, &s.UserID, &s.CustomerName, &s.Phone, &s.Items, &s.Total, &s.Status,
+		&s.PaymentMethod, &s.PaymentStatus, &s.Date, &s.Address)
+	s.OrderNumber = orderNumber(s.ID)
+	return err
+}
+
+func loadOrder(id int) (*Order, error) {
+	var o Order
+	row := db.QueryRow(`SELECT `+orderSummaryColumns+`, COALESCE(o.address_id, 0), COALESCE(o.landmark, ''), o.delivery_option,
+		COALESCE(o.delivery_date::text, ''), COALESCE(o.slot_type, ''),
+		o.item_total, o.delivery_fee, o.platform_fee, COALESCE(o.cancel_reason, ''), COALESCE(o.cancel_note, ''), o.updated_at::text
+		FROM orders o LEFT JOIN users u ON o.user_id = u.id WHERE o.id = $1`, id)
+	err := row.Scan(&o.ID, &o.UserID, &o.CustomerName, &o.Phone, &o.Items, &o.Total, &o.Status,
+		&o.PaymentMethod, &o.PaymentStatus, &o.Date, &o.Address,
+		&o.AddressID, &o.Landmark, &o.DeliveryOption, &o.DeliveryDate, &o.SlotType, &o.ItemTotal, &o.DeliveryFee, &o.PlatformFee,
+		&o.CancelReason, &o.CancelNote, &o.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errOrderNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	o.OrderNumber = orderNumber(o.ID)
+
+	rows, err := db.Query(`
+		SELECT COALESCE(product_id, 0), COALESCE(variant_id, 0), name, COALESCE(variant_name, ''), COALESCE(category, ''),
+		       COALESCE(weight, ''), COALESCE(image, ''), unit_price, quantity, line_total,
+		       COALESCE(prep_options, ''), COALESCE(prep_note, '')
+		FROM order_items WHERE order_id = $1 ORDER BY id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	o.Lines = []OrderLine{}
+	for rows.Next() {
+		var l OrderLine
+		var options string
+		rows.Scan(&l.ProductID, &l.VariantID, &l.Name, &l.VariantName, &l.Category, &l.Weight, &l.Image, &l.UnitPrice, &l.Quantity, &l.LineTotal,
+			&options, &l.PrepNote)
+		if options != "" {
+			l.Options = strings.Split(options, ",")
+		}
+		o.Lines = append(o.Lines, l)
+	}
+	return &o, nil
+}
+
+// listOrders lists orders, newest first; date, when set, is the day they were placed
+func listOrders(userID int, status, date string, limit, offset int) ([]OrderSummary, error) {
+	rows, err := db.Query(`SELECT `+orderSummaryColumns+` FROM orders o LEFT JOIN users u ON o.user_id = u.id
+		WHERE ($1 = 0 OR o.user_id = $1) AND ($2 = '' OR o.status = $2) AND ($3 = '' OR o.created_at::date = NULLIF($3, '')::date)
+		ORDER BY o.created_at DESC, o.id DESC LIMIT $4 OFFSET $5`, userID, status, date, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	orders := []OrderSummary{}
+	for rows.Next() {
+		var s OrderSummary
+		if err := scanOrderSummary(rows, &s); err != nil {
+			return nil, err
+		}
+		orders = append(orders, s)
+	}
+	return orders, nil
+}
+
+// orderTransition describes a requested order status change
+type orderTransition struct {
+	To         string
+	ReasonCode string
+	Note       string
+	ActorEmail string
+	ActorRole  string
+	// OwnerID, when non-zero, restricts the change to that user's orders
+	OwnerID int
+}
+
+// transitionOrder moves an order along its lifecycle, recording the change. Cancelling
+// a paid order refunds it: wallet payments back to the wallet, gateway payments to the source.
+func transitionOrder(id int, t orderTransition) error {
+	if _, ok := orderTransitions[t.To]; !ok {
+		return errOrderStatusUnknown
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var from, paymentMethod, paymentStatus string
+	var userID int
+	var total float64
+	err = tx.QueryRow("SELECT status, user_id, payment_method, payment_status, total FROM orders WHERE id = $1 FOR UPDATE", id).
+		Scan(&from, &userID, &paymentMethod, &paymentStatus, &total)
+	if err == sql.ErrNoRows || (err == nil && t.OwnerID != 0 && t.OwnerID != userID) {
+		return errOrderNotFound
+	} else if err != nil {
+		return err
+	}
+
+	allowed := false
+	for _, next := range orderTransitions[from] {
+		allowed = allowed || next == t.To
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s -> %s", errOrderTransition, from, t.To)
+	}
+
+	refundWallet := false
+	switch t.To {
+	case OrderCancelled:
+		if t.OwnerID != 0 {
+			// Customers can only cancel before the order is being prepared, and must say why
+			if from != OrderPending {
+				return fmt.Errorf("%w: order is already %s", errOrderTransition, from)
+			}
+			if _, ok := orderCancelReasons[t.ReasonCode]; !ok || (t.ReasonCode == "other" && strings.TrimSpace(t.Note) == "") {
+				return errOrderCancelReason
+			}
+		}
+		_, err = tx.Exec("UPDATE orders SET status = $1, cancel_reason = $2, cancel_note = NULLIF($3, ''), updated_at = NOW() WHERE id = $4",
+			t.To, t.ReasonCode, t.Note, id)
+		if err == nil {
+			err = releaseOrderStock(tx, id)
+		}
+		refundWallet = paymentStatus == OrderPaid && paymentMethod == PayWallet
+	case OrderDelivered:
+		// Cash is collected on delivery
+		_, err = tx.Exec(`UPDATE orders SET status = $1, delivered_at = NOW(), updated_at = NOW(),
+			payment_status = CASE WHEN payment_method = $2 THEN $3 ELSE payment_status END WHERE id = $4`,
+			t.To, PayCOD, OrderPaid, id)
+		if err == nil {
+			err = recordOrderDispatch(tx, id, t.ActorEmail)
+		}
+		if err == nil {
+			_, err = invoiceOrder(tx, id)
+		}
+	default:
+		_, err = tx.Exec("UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2", t.To, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	if refundWallet {
+		_, err = postWalletTransaction(tx, walletTxn{
+			Key:         "order-refund:" + strconv.Itoa(id),
+			Type:        WalletRefund,
+			UserID:      userID,
+			Amount:      total,
+			Description: "Refund for cancelled order " + orderNumber(id),
+			CreatedBy:   t.ActorEmail,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE orders SET payment_status = $1 WHERE id = $2", OrderRefunded, id); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO order_status_events (order_id, from_status, to_status, reason_code, note, actor_email, actor_role)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6, $7)
+	`, id, from, t.To, t.ReasonCode, t.Note, t.ActorEmail, t.ActorRole)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if t.To == OrderCancelled {
+		if refundWallet {
+			reevaluateFundingForUser(userID)
+		}
+		if paymentStatus == OrderPaid && paymentMethod != PayWallet && paymentMethod != PayCOD {
+			refundOrderPayments(id, t.ActorEmail)
+		}
+	}
+	return nil
+}
+
+// refundOrderPayments refunds every captured gateway payment of a cancelled order
+func refundOrderPayments(orderID int, actor string) {
+	rows, err := db.Query("SELECT id FROM payments WHERE order_id = $1 AND status IN ($2, $3)", orderID, PaymentCaptured, PaymentPartiallyRefunded)
+	if err != nil {
+		log.Println("Error loading order payments:", err)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, pid := range ids {
+		if _, err := refundPayment(pid, 0, "order cancelled", actor); err != nil {
+			log.Printf("Refund of payment %d for order %d failed: %v", pid, orderID, err)
+			continue
+		}
+		db.Exec("UPDATE orders SET payment_status = $1, updated_at = NOW() WHERE id = $2", OrderRefunded, orderID)
+	}
+}
+
+// markOrderPaid records a captured gateway payment on its order. A cancelled order is
+// left unpaid; refundLateOrderCaptures returns the money.
+func markOrderPaid(q dbExecer, orderID int) error {
+	_, err := q.Exec("UPDATE orders SET payment_status = $1, updated_at = NOW() WHERE id = $2 AND payment_status = $3 AND status <> $4",
+		OrderPaid, orderID, OrderUnpaid, OrderCancelled)
+	return err
+}
+
+// refundLateOrderCaptures refunds gateway payments captured after their order was
+// cancelled (e.g. a checkout left open while the order was cancelled)
+func refundLateOrderCaptures() {
+	rows, err := db.Query(`
+		SELECT DISTINCT o.id FROM payments p JOIN orders o ON o.id = p.order_id
+		WHERE p.purpose = $1 AND p.status = $2 AND o.status = $3 AND o.payment_status = $4
+	`, PaymentForOrder, PaymentCaptured, OrderCancelled, OrderUnpaid)
+	if err != nil {
+		log.Println("Error loading late order payments:", err)
+		return
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		log.Printf("Payment captured for cancelled order %d, refunding", id)
+		refundOrderPayments(id, "system")
+	}
+}
+
+// --- Handlers ---
+
+// ordersRouter dispatches /api/orders and /api/orders/{id}[/status|/cancel]
+func ordersRouter(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/orders"), "/")
+	if rest == "" {
+		switch r.Method {
+		case "GET":
+			listOrdersHandler(w, r)
+		case "POST":
+			placeOrderHandler(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	parts := strings.Split(rest, "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		getOrderHandler(w, r, id)
+	case parts[1] == "status":
+		updateOrderStatusHandler(w, r, id)
+	case parts[1] == "cancel":
+		cancelOrderHandler(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func listOrdersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	_, role := requestActor(r)
+	userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if role != "admin" {
+		if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	date := r.URL.Query().Get("date")
+	if _, err := time.Parse("2006-01-02", date); date != "" && err != nil {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset := paginationParams(r)
+	orders, err := listOrders(userID, r.URL.Query().Get("status"), date, limit, offset)
+	if err != nil {
+		log.Println("Error fetching orders:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(orders)
+}
+
+func placeOrderHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req placeOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	owner := &cartOwner{UserID: userID}
+	if req.FromCart && len(req.Items) == 0 {
+		items, err := cartItemRequests(db, owner)
+		if err != nil {
+			log.Println("Error loading cart:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		req.Items = items
+	}
+
+	actorEmail, actorRole := requestActor(r)
+	order, issues, intent, err := placeOrder(userID, req, actorEmail, actorRole)
+	if err == errCartInvalid {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": false, "error": err.Error(), "issues": issues})
+		return
+	} else if err != nil {
+		if orderErrorStatus(err) == http.StatusInternalServerError {
+			log.Println("Error placing order:", err)
+		}
+		http.Error(w, err.Error(), orderErrorStatus(err))
+		return
+	}
+	if req.FromCart {
+		clearCart(db, owner)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "order": order, "payment": intent})
+}
+
+func getOrderHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	order, err := loadOrder(id)
+	if err == nil {
+		if _, role := requestActor(r); role != "admin" {
+			var owner string
+			db.QueryRow("SELECT email FROM users WHERE id = $1", order.UserID).Scan(&owner)
+			if owner != requestEmail(r) {
+				err = errOrderNotFound
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), orderErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(order)
+}
+
+// updateOrderStatusHandler lets admins move an order along: PUT {status, reason, note}
+func updateOrderStatusHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	actorEmail, role := requestActor(r)
+	if role != "admin" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Status == OrderCancelled && req.Reason == "" {
+		req.Reason = "cancelled_by_admin"
+	}
+
+	err := transitionOrder(id, orderTransition{To: req.Status, ReasonCode: req.Reason, Note: req.Note, ActorEmail: actorEmail, ActorRole: role})
+	if err != nil {
+		http.Error(w, err.Error(), orderErrorStatus(err))
+		return
+	}
+	order, _ := loadOrder(id)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "order": order})
+}
+
+// cancelOrderHandler cancels an order: PUT {reason, note}. Customers cancel their own
+// Pending orders with one of orderCancelReasons; admins may cancel until delivery.
+func cancelOrderHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "PUT" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+		Note   string `json:"note"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+
+	actorEmail, role := requestActor(r)
+	t := orderTransition{To: OrderCancelled, ReasonCode: req.Reason, Note: req.Note, ActorEmail: actorEmail, ActorRole: role}
+	if role != "admin" {
+		if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&t.OwnerID); err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+	} else if t.ReasonCode == "" {
+		t.ReasonCode = "cancelled_by_admin"
+	}
+
+	if err := transitionOrder(id, t); err != nil {
+		http.Error(w, err.Error(), orderErrorStatus(err))
+		return
+	}
+	order, _ := loadOrder(id)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "order": order})
+}
+
+// orderCancelReasonsHandler lists the reasons a customer can pick from, and the current fees
+func orderCancelReasonsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cancel_reasons": orderCancelReasons,
+		"fees":           currentOrderFees(),
+	})
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================================================
+// PAYMENT PROVIDERS
+// ============================================================================
+//
+// PaymentProvider hides the gateway behind three operations: open a payment
+// intent (a gateway "order" the client pays against), verify signatures
+// (checkout callback and webhook), and refund a captured payment. The
+// Razorpay implementation talks to its REST API; the fake provider keeps
+// everything local and signs with a known secret so flows can be exercised
+// without a gateway account. Without either, online payments are switched off
+// (cash on delivery and wallet spending still work).
+
+// PaymentIntent is what the client needs to open the gateway checkout
+type PaymentIntent struct {
+	Provider        string  `json:"provider"`
+	ProviderOrderID string  `json:"provider_order_id"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	KeyID           string  `json:"key_id,omitempty"`
+}
+
+type PaymentProvider interface {
+	Name() string
+	// CreateIntent opens a gateway order for amount (in rupees); receipt is our reference
+	CreateIntent(amount float64, currency, receipt string) (PaymentIntent, error)
+	// VerifyPaymentSignature checks the signature the checkout returns to the client
+	VerifyPaymentSignature(providerOrderID, providerPaymentID, signature string) bool
+	// VerifyWebhookSignature checks the signature header on a webhook body
+	VerifyWebhookSignature(body []byte, signature string) bool
+	// Refund returns amount (in rupees) of a captured payment and gives the gateway refund id
+	Refund(providerPaymentID string, amount float64) (string, error)
+}
+
+var (
+	errPaymentProvider  = errors.New("payment provider error")
+	errPaymentsDisabled = errors.New("online payments are not available")
+)
+
+// hmacHex is the hex HMAC-SHA256 used by Razorpay-style signatures
+func hmacHex(secret string, message []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(message)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func hmacMatches(secret string, message []byte, signature string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+	return hmac.Equal([]byte(hmacHex(secret, message)), []byte(signature))
+}
+
+// toPaise converts rupees to the gateway's smallest currency unit
+func toPaise(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// --- Razorpay ---
+
+type razorpayProvider struct {
+	keyID         string
+	keySecret     string
+	webhookSecret string
+	baseURL       string
+	client        *http.Client
+}
+
+func newRazorpayProvider() *razorpayProvider {
+	baseURL := os.Getenv("RAZORPAY_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://api.razorpay.com/v1"
+	}
+	return &razorpayProvider{
+		keyID:         os.Getenv("RAZORPAY_KEY_ID"),
+		keySecret:     os.Getenv("RAZORPAY_KEY_SECRET"),
+		webhookSecret: os.Getenv("RAZORPAY_WEBHOOK_SECRET"),
+		baseURL:       baseURL,
+		client:        &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (p *razorpayProvider) Name() string { return "razorpay" }
+
+func (p *razorpayProvider) post(path string, body interface{}, out interface{}) error {
+	payload, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", p.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.keyID, p.keySecret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: %s %d: %s", errPaymentProvider, path, resp.StatusCode, data)
+	}
+	return json.Unmarshal(data, out)
+}
+
+func (p *razorpayProvider) CreateIntent(amount float64, currency, receipt string) (PaymentIntent, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	err := p.post("/orders", map[string]interface{}{
+		"amount":   toPaise(amount),
+		"currency": currency,
+		"receipt":  receipt,
+	}, &resp)
+	if err != nil {
+		return PaymentIntent{}, err
+	}
+	return PaymentIntent{Provider: p.Name(), ProviderOrderID: resp.ID, Amount: amount, Currency: currency, KeyID: p.keyID}, nil
+}
+
+func (p *razorpayProvider) VerifyPaymentSignature(providerOrderID, providerPaymentID, signature string) bool {
+	return hmacMatches(p.keySecret, []byte(providerOrderID+"|"+providerPaymentID), signature)
+}
+
+func (p *razorpayProvider) VerifyWebhookSignature(body []byte, signature string) bool {
+	return hmacMatches(p.webhookSecret, body, signature)
+}
+
+func (p *razorpayProvider) Refund(providerPaymentID string, amount float64) (string, error) {
+	var resp struct {
+		ID string `json:"id"`
+	}
+	err := p.post("/payments/"+providerPaymentID+"/refund", map[string]interface{}{"amount": toPaise(amount)}, &resp)
+	return resp.ID, err
+}
+
+// --- Fake (local) ---
+
+// fakeProvider never leaves the process. Signatures use the same HMAC scheme as
+// Razorpay with PAYMENT_FAKE_SECRET, so a client or test can sign its own callbacks.
+type fakeProvider struct {
+	secret string
+	seq    int64
+}
+
+func newFakeProvider() *fakeProvider {
+	secret := os.Getenv("PAYMENT_FAKE_SECRET")
+	if secret == "" {
+		secret = "fake-secret"
+	}
+	return &fakeProvider{secret: secret, seq: time.Now().UnixNano()}
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) nextID(prefix string) string {
+	return prefix + strconv.FormatInt(atomic.AddInt64(&p.seq, 1), 36)
+}
+
+func (p *fakeProvider) CreateIntent(amount float64, currency, receipt string) (PaymentIntent, error) {
+	return PaymentIntent{Provider: p.Name(), ProviderOrderID: p.nextID("order_fake_"), Amount: amount, Currency: currency, KeyID: "fake"}, nil
+}
+
+func (p *fakeProvider) VerifyPaymentSignature(providerOrderID, providerPaymentID, signature string) bool {
+	return hmacMatches(p.secret, []byte(providerOrderID+"|"+providerPaymentID), signature)
+}
+
+func (p *fakeProvider) VerifyWebhookSignature(body []byte, signature string) bool {
+	return hmacMatches(p.secret, body, signature)
+}
+
+func (p *fakeProvider) Refund(providerPaymentID string, amount float64) (string, error) {
+	return p.nextID("rfnd_fake_"), nil
+}
+
+// --- Disabled ---
+
+// disabledProvider is used when no gateway is configured: nothing can be paid and
+// no signature verifies
+type disabledProvider struct{}
+
+func (disabledProvider) Name() string { return "disabled" }
+
+func (disabledProvider) CreateIntent(amount float64, currency, receipt string) (PaymentIntent, error) {
+	return PaymentIntent{}, errPaymentsDisabled
+}
+
+func (disabledProvider) VerifyPaymentSignature(providerOrderID, providerPaymentID, signature string) bool {
+	return false
+}
+
+func (disabledProvider) VerifyWebhookSignature(body []byte, signature string) bool { return false }
+
+func (disabledProvider) Refund(providerPaymentID string, amount float64) (string, error) {
+	return "", errPaymentsDisabled
+}
+
+// paymentProvider is chosen once at startup: Razorpay when its keys are configured,
+// the fake provider only when PAYMENTS_FAKE=1 is set explicitly (its secret is not
+// secret, so anyone could sign a payment), otherwise payments are disabled.
+var paymentProvider PaymentProvider
+
+func initPaymentProvider() {
+	switch {
+	case os.Getenv("PAYMENTS_FAKE") == "1":
+		paymentProvider = newFakeProvider()
+		log.Println("WARNING: fake payment provider enabled, payments are not real")
+	case os.Getenv("RAZORPAY_KEY_ID") != "" || os.Getenv("PAYMENT_PROVIDER") == "razorpay":
+		rp := newRazorpayProvider()
+		if rp.keyID == "" || rp.keySecret == "" || rp.webhookSecret == "" {
+			log.Fatal("Razorpay needs RAZORPAY_KEY_ID, RAZORPAY_KEY_SECRET and RAZORPAY_WEBHOOK_SECRET")
+		}
+		paymentProvider = rp
+	default:
+		paymentProvider = disabledProvider{}
+	}
+	log.Println("Payment provider:", paymentProvider.Name())
+}
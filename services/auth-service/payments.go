@@ -0,0 +1,641 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ============================================================================
+// PAYMENTS (TOP-UPS, ORDER PAYMENTS, WEBHOOKS)
+// ============================================================================
+//
+// A payments row is opened for every intent and moves created -> captured
+// (or failed), then possibly partially_refunded / refunded. Capture can
+// arrive twice - from the client's checkout callback and from the gateway
+// webhook - so capturePayment is a no-op on anything already captured, and
+// webhook events are de-duplicated by event id before being applied.
+// Captured top-ups post a wallet top-up keyed on the payment id.
+
+// Payment purposes
+const (
+	PaymentForTopUp = "wallet_topup"
+	PaymentForOrder = "order"
+)
+
+// Payment statuses
+const (
+	PaymentCreated           = "created"
+	PaymentCaptured          = "captured"
+	PaymentFailed            = "failed"
+	PaymentPartiallyRefunded = "partially_refunded"
+	PaymentRefunded          = "refunded"
+)
+
+const (
+	minTopUp = 1.0
+	maxTopUp = 100000.0
+)
+
+var (
+	errPaymentNotFound       = errors.New("payment not found")
+	errPaymentAmountMismatch = errors.New("captured amount does not match payment")
+	errPaymentNotRefundable  = errors.New("payment is not refundable")
+	errRefundTooLarge        = errors.New("refund exceeds refundable amount")
+	errInsufficientWallet    = errors.New("wallet balance is lower than the refund")
+)
+
+type Payment struct {
+	ID                int     `json:"id"`
+	UserID            int     `json:"user_id"`
+	Purpose           string  `json:"purpose"`
+	OrderID           int     `json:"order_id,omitempty"`
+	Provider          string  `json:"provider"`
+	ProviderOrderID   string  `json:"provider_order_id"`
+	ProviderPaymentID string  `json:"provider_payment_id,omitempty"`
+	Amount            float64 `json:"amount"`
+	RefundedAmount    float64 `json:"refunded_amount"`
+	Currency          string  `json:"currency"`
+	Status            string  `json:"status"`
+	CreatedAt         string  `json:"created_at"`
+	UpdatedAt         string  `json:"updated_at"`
+}
+
+func initPaymentsSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS payments (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			purpose TEXT NOT NULL,
+			order_id INTEGER,
+			provider TEXT NOT NULL,
+			provider_order_id TEXT UNIQUE,
+			provider_payment_id TEXT,
+			amount DECIMAL(12,2) NOT NULL,
+			refunded_amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+			currency TEXT NOT NULL DEFAULT 'INR',
+			status TEXT NOT NULL DEFAULT 'created',
+			wallet_transaction_id INTEGER REFERENCES wallet_transactions(id),
+			captured_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_payments_user ON payments(user_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_payments_order ON payments(order_id);
+
+		CREATE TABLE IF NOT EXISTS payment_refunds (
+			id SERIAL PRIMARY KEY,
+			payment_id INTEGER NOT NULL REFERENCES payments(id),
+			provider_refund_id TEXT UNIQUE,
+			amount DECIMAL(12,2) NOT NULL,
+			reason TEXT,
+			created_by TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS payment_webhook_events (
+			id SERIAL PRIMARY KEY,
+			provider TEXT NOT NULL,
+			event_id TEXT NOT NULL,
+			event_type TEXT,
+			payload JSONB,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (provider, event_id)
+		);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create payments schema:", err)
+	}
+}
+
+const paymentColumns = `id, user_id, purpose, COALESCE(order_id, 0), provider, COALESCE(provider_order_id, ''),
+	COALESCE(provider_payment_id, ''), amount, refunded_amount, currency, status, created_at::text, updated_at::text`
+
+func scanPayment(row interface{ Scan(...interface{}) error }) (*Payment, error) {
+	var p Payment
+	err := row.Scan(&p.ID, &p.UserID, &p.Purpose, &p.OrderID, &p.Provider, &p.ProviderOrderID,
+		&p.ProviderPaymentID, &p.Amount, &p.RefundedAmount, &p.Currency, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errPaymentNotFound
+	}
+	return &p, err
+}
+
+// createPayment records a payment and opens the matching intent with the provider
+func createPayment(userID int, purpose string, orderID int, amount float64) (int, PaymentIntent, error) {
+	amount = roundMoney(amount)
+	var orderArg interface{}
+	if orderID != 0 {
+		orderArg = orderID
+	}
+
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO payments (user_id, purpose, order_id, provider, amount) VALUES ($1, $2, $3, $4, $5) RETURNING id
+	`, userID, purpose, orderArg, paymentProvider.Name(), amount).Scan(&id)
+	if err != nil {
+		return 0, PaymentIntent{}, err
+	}
+
+	intent, err := paymentProvider.CreateIntent(amount, "INR", purpose+"_"+strconv.Itoa(id))
+	if err != nil {
+		db.Exec("UPDATE payments SET status = $1, updated_at = NOW() WHERE id = $2", PaymentFailed, id)
+		return 0, PaymentIntent{}, err
+	}
+	db.Exec("UPDATE payments SET provider_order_id = $1, updated_at = NOW() WHERE id = $2", intent.ProviderOrderID, id)
+	return id, intent, nil
+}
+
+// capturePayment marks a payment captured and applies it (wallet top-up or order). Re-delivery
+// of the same capture is a no-op. paidPaise is the gateway's amount, or 0 if unknown.
+func capturePayment(q dbExecer, providerOrderID, providerPaymentID string, paidPaise int64) (*Payment, error) {
+	p, err := scanPayment(q.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE provider_order_id = $1 FOR UPDATE`, providerOrderID))
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != PaymentCreated && p.Status != PaymentFailed {
+		return p, nil
+	}
+	if paidPaise != 0 && paidPaise != toPaise(p.Amount) {
+		return nil, errPaymentAmountMismatch
+	}
+
+	var walletTxnID interface{}
+	if p.Purpose == PaymentForTopUp {
+		txnID, err := postWalletTransaction(q, walletTxn{
+			Key:         "payment:" + strconv.Itoa(p.ID),
+			Type:        WalletTopUp,
+			UserID:      p.UserID,
+			Amount:      p.Amount,
+			Description: fmt.Sprintf("Top-up via %s (%s)", p.Provider, providerPaymentID),
+			CreatedBy:   p.Provider,
+		})
+		if err != nil {
+			return nil, err
+		}
+		walletTxnID = txnID
+	}
+	if p.Purpose == PaymentForOrder && p.OrderID != 0 {
+		if err := markOrderPaid(q, p.OrderID); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = q.Exec(`
+		UPDATE payments SET status = $1, provider_payment_id = $2, wallet_transaction_id = $3, captured_at = NOW(), updated_at = NOW()
+		WHERE id = $4
+	`, PaymentCaptured, providerPaymentID, walletTxnID, p.ID)
+	p.Status, p.ProviderPaymentID = PaymentCaptured, providerPaymentID
+	return p, err
+}
+
+// failPayment marks an open payment failed; captured payments are left alone
+func failPayment(q dbExecer, providerOrderID, providerPaymentID string) error {
+	_, err := q.Exec(`
+		UPDATE payments SET status = $1, provider_payment_id = COALESCE(NULLIF($2, ''), provider_payment_id), updated_at = NOW()
+		WHERE provider_order_id = $3 AND status = $4
+	`, PaymentFailed, providerPaymentID, providerOrderID, PaymentCreated)
+	return err
+}
+
+// applyRefund records a refund against a captured payment. A refund of a top-up takes the
+// money back out of the wallet. A refund id seen before is ignored.
+func applyRefund(q dbExecer, p *Payment, providerRefundID string, amount float64, reason, actor string) error {
+	amount = roundMoney(amount)
+	var refundID int
+	err := q.QueryRow(`
+		INSERT INTO payment_refunds (payment_id, provider_refund_id, amount, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (provider_refund_id) DO NOTHING
+		RETURNING id
+	`, p.ID, providerRefundID, amount, reason, actor).Scan(&refundID)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if p.Purpose == PaymentForTopUp {
+		_, err = postWalletTransaction(q, walletTxn{
+			Key:         "refund:" + providerRefundID,
+			Type:        WalletPayout,
+			UserID:      p.UserID,
+			Amount:      amount,
+			Description: fmt.Sprintf("Refund of top-up #%d to original payment method", p.ID),
+			CreatedBy:   actor,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	refunded := roundMoney(p.RefundedAmount + amount)
+	status := PaymentPartiallyRefunded
+	if refunded >= p.Amount {
+		status = PaymentRefunded
+	}
+	_, err = q.Exec("UPDATE payments SET refunded_amount = $1, status = $2, updated_at = NOW() WHERE id = $3", refunded, status, p.ID)
+	p.RefundedAmount, p.Status = refunded, status
+	return err
+}
+
+// refundPayment refunds (part of) a captured payment through the provider
+func refundPayment(paymentID int, amount float64, reason, actor string) (*Payment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := scanPayment(tx.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE id = $1 FOR UPDATE`, paymentID))
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != PaymentCaptured && p.Status != PaymentPartiallyRefunded {
+		return nil, errPaymentNotRefundable
+	}
+	if amount == 0 {
+		amount = p.Amount - p.RefundedAmount
+	}
+	amount = roundMoney(amount)
+	if amount <= 0 {
+		return nil, errInvalidAmount
+	}
+	if amount > roundMoney(p.Amount-p.RefundedAmount) {
+		return nil, errRefundTooLarge
+	}
+	if p.Purpose == PaymentForTopUp {
+		balance, err := walletBalance(tx, p.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if balance < amount {
+			return nil, errInsufficientWallet
+		}
+	}
+
+	providerRefundID, err := paymentProvider.Refund(p.ProviderPaymentID, amount)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyRefund(tx, p, providerRefundID, amount, reason, actor); err != nil {
+		// The gateway has the refund; the refund.processed webhook will record it
+		log.Printf("Refund %s for payment %d not recorded: %v", providerRefundID, p.ID, err)
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if p.Purpose == PaymentForTopUp {
+		reevaluateFundingForUser(p.UserID)
+	}
+	return p, nil
+}
+
+// paymentErrorStatus maps payment errors to HTTP status codes
+func paymentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errPaymentNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errPaymentNotRefundable), errors.Is(err, errInsufficientWallet), errors.Is(err, errPaymentAmountMismatch):
+		return http.StatusConflict
+	case errors.Is(err, errRefundTooLarge), errors.Is(err, errInvalidAmount):
+		return http.StatusBadRequest
+	case errors.Is(err, errPaymentProvider):
+		return http.StatusBadGateway
+	case errors.Is(err, errPaymentsDisabled):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// --- Webhook ---
+
+// paymentWebhookEvent is the Razorpay webhook envelope (the fake provider uses the same shape)
+type paymentWebhookEvent struct {
+	Event   string `json:"event"`
+	Payload struct {
+		Payment struct {
+			Entity struct {
+				ID      string `json:"id"`
+				OrderID string `json:"order_id"`
+				Amount  int64  `json:"amount"`
+			} `json:"entity"`
+		} `json:"payment"`
+		Refund struct {
+			Entity struct {
+				ID        string `json:"id"`
+				PaymentID string `json:"payment_id"`
+				Amount    int64  `json:"amount"`
+			} `json:"entity"`
+		} `json:"refund"`
+	} `json:"payload"`
+}
+
+// applyWebhookEvent reconciles one gateway event; it returns the user whose wallet changed, if any
+func applyWebhookEvent(q dbExecer, ev paymentWebhookEvent) (int, error) {
+	switch ev.Event {
+	case "payment.captured", "order.paid":
+		e := ev.Payload.Payment.Entity
+		p, err := capturePayment(q, e.OrderID, e.ID, e.Amount)
+		if err == errPaymentNotFound {
+			log.Printf("Webhook %s for unknown order %s ignored", ev.Event, e.OrderID)
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if p.Purpose == PaymentForTopUp {
+			return p.UserID, nil
+		}
+
+	case "payment.failed":
+		e := ev.Payload.Payment.Entity
+		return 0, failPayment(q, e.OrderID, e.ID)
+
+	case "refund.processed", "refund.created":
+		e := ev.Payload.Refund.Entity
+		p, err := scanPayment(q.QueryRow(`SELECT `+paymentColumns+` FROM payments WHERE provider_payment_id = $1 FOR UPDATE`, e.PaymentID))
+		if err == errPaymentNotFound {
+			log.Printf("Webhook %s for unknown payment %s ignored", ev.Event, e.PaymentID)
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if err := applyRefund(q, p, e.ID, float64(e.Amount)/100, "gateway", p.Provider); err != nil {
+			return 0, err
+		}
+		if p.Purpose == PaymentForTopUp {
+			return p.UserID, nil
+		}
+	}
+	return 0, nil
+}
+
+// paymentWebhookHandler receives gateway events. The body is HMAC-verified, each event
+// is applied once (keyed on the gateway's event id), and a failure rolls back so the
+// gateway's retry can apply it again.
+func paymentWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !paymentProvider.VerifyWebhookSignature(body, r.Header.Get("X-Razorpay-Signature")) {
+		log.Println("Rejected payment webhook with bad signature")
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var ev paymentWebhookEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	eventID := r.Header.Get("X-Razorpay-Event-Id")
+	if eventID == "" {
+		sum := sha256.Sum256(body)
+		eventID = hex.EncodeToString(sum[:])
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var rowID int
+	err = tx.QueryRow(`
+		INSERT INTO payment_webhook_events (provider, event_id, event_type, payload) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, event_id) DO NOTHING RETURNING id
+	`, paymentProvider.Name(), eventID, ev.Event, string(body)).Scan(&rowID)
+	if err == sql.ErrNoRows {
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "duplicate": true})
+		return
+	} else if err != nil {
+		log.Println("Error recording webhook event:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := applyWebhookEvent(tx, ev)
+	if err != nil {
+		log.Printf("Error applying webhook %s (%s): %v", eventID, ev.Event, err)
+		http.Error(w, "Failed to process event", paymentErrorStatus(err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if userID != 0 {
+		reevaluateFundingForUser(userID)
+	}
+	refundLateOrderCaptures()
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
+
+// --- User endpoints ---
+
+// paymentTopUpHandler opens a wallet top-up payment for the signed-in user
+func paymentTopUpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Amount float64 `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Amount < minTopUp || req.Amount > maxTopUp {
+		http.Error(w, fmt.Sprintf("amount must be between %.0f and %.0f", minTopUp, maxTopUp), http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	paymentID, intent, err := createPayment(userID, PaymentForTopUp, 0, req.Amount)
+	if err != nil {
+		log.Println("Error creating top-up payment:", err)
+		http.Error(w, "Failed to start payment", paymentErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "payment_id": paymentID, "intent": intent})
+}
+
+// paymentVerifyHandler confirms a payment from the checkout callback (signature checked)
+func paymentVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ProviderOrderID   string `json:"provider_order_id"`
+		ProviderPaymentID string `json:"provider_payment_id"`
+		Signature         string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if !paymentProvider.VerifyPaymentSignature(req.ProviderOrderID, req.ProviderPaymentID, req.Signature) {
+		http.Error(w, "Invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	var ownerEmail string
+	db.QueryRow(`SELECT u.email FROM payments p JOIN users u ON p.user_id = u.id WHERE p.provider_order_id = $1`,
+		req.ProviderOrderID).Scan(&ownerEmail)
+	if ownerEmail == "" || ownerEmail != requestEmail(r) {
+		http.Error(w, "Payment not found", http.StatusNotFound)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	p, err := capturePayment(tx, req.ProviderOrderID, req.ProviderPaymentID, 0)
+	if err != nil {
+		log.Println("Error capturing payment:", err)
+		http.Error(w, err.Error(), paymentErrorStatus(err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if p.Purpose == PaymentForTopUp {
+		reevaluateFundingForUser(p.UserID)
+	} else {
+		refundLateOrderCaptures()
+	}
+
+	balance, _ := walletBalance(db, p.UserID)
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "payment": p, "balance": balance})
+}
+
+func listPayments(userID int, status string, limit, offset int) ([]Payment, error) {
+	rows, err := db.Query(`SELECT `+paymentColumns+` FROM payments
+		WHERE ($1 = 0 OR user_id = $1) AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC, id DESC LIMIT $3 OFFSET $4`, userID, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []Payment{}
+	for rows.Next() {
+		p, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *p)
+	}
+	return payments, nil
+}
+
+// paymentsHandler lists the signed-in user's payments
+func paymentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	limit, offset := paginationParams(r)
+	payments, err := listPayments(userID, r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		log.Println("Error fetching payments:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(payments)
+}
+
+// --- Admin endpoints ---
+
+// adminPaymentsHandler lists payments (GET ?user_id=&status=)
+func adminPaymentsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+	limit, offset := paginationParams(r)
+	payments, err := listPayments(userID, r.URL.Query().Get("status"), limit, offset)
+	if err != nil {
+		log.Println("Error fetching payments:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(payments)
+}
+
+// adminRefundPaymentHandler refunds a captured payment (POST {payment_id, amount, reason}); amount 0 = the rest
+func adminRefundPaymentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		PaymentID int     `json:"payment_id"`
+		Amount    float64 `json:"amount"`
+		Reason    string  `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	actorEmail, _ := requestActor(r)
+	p, err := refundPayment(req.PaymentID, req.Amount, req.Reason, actorEmail)
+	if err != nil {
+		log.Println("Error refunding payment:", err)
+		http.Error(w, err.Error(), paymentErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "payment": p})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// ============================================================================
+// MINIMAL PDF WRITER
+// ============================================================================
+//
+// Just enough PDF to print manifests and invoices: A4 pages of monospaced text
+// (Courier / Courier-Bold) with automatic page breaks. No external dependencies.
+
+const (
+	pdfPageWidth  = 595.0 // A4 in points
+	pdfPageHeight = 842.0
+	pdfMargin     = 36.0
+)
+
+type pdfTextLine struct {
+	x, y, size float64
+	bold       bool
+	text       string
+}
+
+type pdfDoc struct {
+	pages [][]pdfTextLine
+	y     float64
+}
+
+func newPDFDoc() *pdfDoc {
+	d := &pdfDoc{}
+	d.NewPage()
+	return d
+}
+
+// NewPage starts a fresh page
+func (d *pdfDoc) NewPage() {
+	d.pages = append(d.pages, nil)
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// Line writes one line of text at the left margin, breaking to a new page when full
+func (d *pdfDoc) Line(text string, size float64, bold bool) {
+	lineHeight := size * 1.35
+	if d.y-lineHeight < pdfMargin {
+		d.NewPage()
+	}
+	d.y -= lineHeight
+	last := len(d.pages) - 1
+	d.pages[last] = append(d.pages[last], pdfTextLine{x: pdfMargin, y: d.y, size: size, bold: bold, text: text})
+}
+
+// Gap adds vertical space
+func (d *pdfDoc) Gap(points float64) {
+	d.y -= points
+}
+
+// Remaining returns how many points are left on the current page
+func (d *pdfDoc) Remaining() float64 {
+	return d.y - pdfMargin
+}
+
+// pdfEscape makes text safe for a PDF string literal; non-ASCII is replaced as the base fonts can't render it
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '₹':
+			b.WriteString("Rs.")
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Bytes renders the document
+func (d *pdfDoc) Bytes() []byte {
+	var buf bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are fixed; each page then takes a page object and a content stream
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+i*2))
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold >>")
+
+	for i, lines := range d.pages {
+		var content bytes.Buffer
+		for _, l := range lines {
+			font := "F1"
+			if l.bold {
+				font = "F2"
+			}
+			fmt.Fprintf(&content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, l.size, l.x, l.y, pdfEscape(l.text))
+		}
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+i*2))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfFit pads or truncates s to exactly width characters (Courier is fixed-width)
+func pdfFit(s string, width int) string {
+	r := []rune(s)
+	if len(r) > width {
+		if width > 1 {
+			return string(r[:width-1]) + "~"
+		}
+		return string(r[:width])
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// PREPARATION OPTIONS
+// ============================================================================
+//
+// Meat is prepared to order: broiler vs natti, live vs cut, curry or biryani
+// cut, skin on/off, offals and head/legs in or out. Each product can carry
+// option groups ("cut", "skin", "offal"...), each option with a flat price
+// modifier per unit. Options are sent as "group:option" codes; exclusions
+// between options (e.g. prep:live rules out every cut:* and skin:*) define
+// the allowed combinations. Orders keep the chosen options so the butcher
+// gets a prep list per delivery slot.
+
+// Delivery slots an order can be prepared for
+const (
+	SlotMorning = "Morning"
+	SlotEvening = "Evening"
+)
+
+var (
+	errPrepInvalid = errors.New("invalid preparation options")
+	errSlotInvalid = errors.New("delivery_date must be today or later and slot_type Morning or Evening")
+)
+
+type PrepOption struct {
+	ID            int     `json:"id"`
+	Code          string  `json:"code"`
+	Label         string  `json:"label"`
+	PriceModifier float64 `json:"price_modifier"`
+	IsDefault     bool    `json:"is_default"`
+	// Excludes lists "group:option" codes that can't be combined with this option
+	Excludes []string `json:"excludes,omitempty"`
+}
+
+type PrepGroup struct {
+	ID          int          `json:"id"`
+	Code        string       `json:"code"`
+	Label       string       `json:"label"`
+	Required    bool         `json:"required"`
+	MultiSelect bool         `json:"multi_select"`
+	Options     []PrepOption `json:"options"`
+}
+
+// prepSelection is a validated choice of options for one product
+type prepSelection struct {
+	Codes    []string // sorted "group:option" codes
+	Note     string   // human-readable, for order lines and the prep list
+	Modifier float64
+}
+
+// Key identifies the selection in carts, so the same product with different prep is a separate line
+func (s prepSelection) Key() string {
+	return strings.Join(s.Codes, ",")
+}
+
+func initPrepOptionsSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS product_prep_groups (
+			id SERIAL PRIMARY KEY,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			code TEXT NOT NULL,
+			label TEXT NOT NULL,
+			required BOOLEAN NOT NULL DEFAULT false,
+			multi_select BOOLEAN NOT NULL DEFAULT false,
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(product_id, code)
+		);
+
+		CREATE TABLE IF NOT EXISTS product_prep_options (
+			id SERIAL PRIMARY KEY,
+			group_id INTEGER NOT NULL REFERENCES product_prep_groups(id) ON DELETE CASCADE,
+			code TEXT NOT NULL,
+			label TEXT NOT NULL,
+			price_modifier DECIMAL(10,2) NOT NULL DEFAULT 0,
+			is_default BOOLEAN NOT NULL DEFAULT false,
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(group_id, code)
+		);
+
+		CREATE TABLE IF NOT EXISTS product_prep_exclusions (
+			option_id INTEGER NOT NULL REFERENCES product_prep_options(id) ON DELETE CASCADE,
+			excludes_option_id INTEGER NOT NULL REFERENCES product_prep_options(id) ON DELETE CASCADE,
+			PRIMARY KEY (option_id, excludes_option_id)
+		);
+
+		ALTER TABLE order_items ADD COLUMN IF NOT EXISTS prep_options TEXT;
+		ALTER TABLE order_items ADD COLUMN IF NOT EXISTS prep_note TEXT;
+		ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivery_date DATE;
+		ALTER TABLE orders ADD COLUMN IF NOT EXISTS slot_type TEXT;
+		UPDATE orders SET delivery_date = created_at::date WHERE delivery_date IS NULL;
+		CREATE INDEX IF NOT EXISTS idx_orders_delivery ON orders(delivery_date, slot_type);
+
+		-- The same product with different prep is a separate cart line
+		ALTER TABLE cart_items ADD COLUMN IF NOT EXISTS options TEXT NOT NULL DEFAULT '';
+		ALTER TABLE cart_items DROP CONSTRAINT IF EXISTS cart_items_cart_id_product_id_variant_id_key;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_line ON cart_items(cart_id, product_id, variant_id, options);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create prep options schema:", err)
+	}
+}
+
+// loadPrepGroups returns a product's option groups with their options and exclusions
+func loadPrepGroups(q dbExecer, productID int) ([]PrepGroup, error) {
+	rows, err := q.Query(`
+		SELECT g.id, g.code, g.label, g.required, g.multi_select, o.id, o.code, o.label, o.price_modifier, o.is_default,
+		       COALESCE((SELECT string_agg(xg.code || ':' || xo.code, ',' ORDER BY xg.sort_order, xo.sort_order)
+		                 FROM product_prep_exclusions x
+		                 JOIN product_prep_options xo ON xo.id = x.excludes_option_id
+		                 JOIN product_prep_groups xg ON xg.id = xo.group_id
+		                 WHERE x.option_id = o.id), '')
+		FROM product_prep_groups g JOIN product_prep_options o ON o.group_id = g.id
+		WHERE g.product_id = $1
+		ORDER BY g.sort_order, g.id, o.sort_order, o.id
+	`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []PrepGroup{}
+	for rows.Next() {
+		var g PrepGroup
+		var o PrepOption
+		var excludes string
+		if err := rows.Scan(&g.ID, &g.Code, &g.Label, &g.Required, &g.MultiSelect,
+			&o.ID, &o.Code, &o.Label, &o.PriceModifier, &o.IsDefault, &excludes); err != nil {
+			return nil, err
+		}
+		if excludes != "" {
+			o.Excludes = strings.Split(excludes, ",")
+		}
+		if len(groups) == 0 || groups[len(groups)-1].ID != g.ID {
+			g.Options = []PrepOption{}
+			groups = append(groups, g)
+		}
+		last := &groups[len(groups)-1]
+		last.Options = append(last.Options, o)
+	}
+	return groups, nil
+}
+
+// resolvePrep validates chosen "group:option" codes against a product's groups: every code
+// must exist, single-select groups take one option, excluded combinations are rejected, and
+// required groups fall back to their default unless the choice rules the whole group out.
+func resolvePrep(groups []PrepGroup, chosen []string) (prepSelection, error) {
+	var sel prepSelection
+	if len(groups) == 0 {
+		if len(chosen) > 0 {
+			return sel, fmt.Errorf("%w: this product has no preparation options", errPrepInvalid)
+		}
+		return sel, nil
+	}
+
+	type found struct {
+		group  *PrepGroup
+		option *PrepOption
+	}
+	index := map[string]found{}
+	for gi := range groups {
+		for oi := range groups[gi].Options {
+			index[groups[gi].Code+":"+groups[gi].Options[oi].Code] = found{&groups[gi], &groups[gi].Options[oi]}
+		}
+	}
+
+	picked := map[string]found{}
+	perGroup := map[string]int{}
+	for _, code := range chosen {
+		code = strings.TrimSpace(code)
+		f, ok := index[code]
+		if !ok {
+			return sel, fmt.Errorf("%w: unknown option %s", errPrepInvalid, code)
+		}
+		if _, dup := picked[code]; dup {
+			continue
+		}
+		picked[code] = f
+		perGroup[f.group.Code]++
+		if perGroup[f.group.Code] > 1 && !f.group.MultiSelect {
+			return sel, fmt.Errorf("%w: choose one %s", errPrepInvalid, strings.ToLower(f.group.Label))
+		}
+	}
+
+	excluded := map[string]bool{}
+	for code, f := range picked {
+		for _, x := range f.option.Excludes {
+			if _, clash := picked[x]; clash {
+				return sel, fmt.Errorf("%w: %s can't be combined with %s", errPrepInvalid, code, x)
+			}
+			excluded[x] = true
+		}
+	}
+
+	for gi := range groups {
+		g := &groups[gi]
+		if !g.Required || perGroup[g.Code] > 0 {
+			continue
+		}
+		var fallback *PrepOption
+		allExcluded := true
+		for oi := range g.Options {
+			code := g.Code + ":" + g.Options[oi].Code
+			if excluded[code] {
+				continue
+			}
+			allExcluded = false
+			if g.Options[oi].IsDefault && fallback == nil {
+				fallback = &g.Options[oi]
+			}
+		}
+		if allExcluded {
+			continue
+		}
+		if fallback == nil {
+			return sel, fmt.Errorf("%w: choose a %s", errPrepInvalid, strings.ToLower(g.Label))
+		}
+		picked[g.Code+":"+fallback.Code] = found{g, fallback}
+	}
+
+	var labels []string
+	for code := range picked {
+		sel.Codes = append(sel.Codes, code)
+	}
+	sort.Strings(sel.Codes)
+	for _, code := range sel.Codes {
+		f := picked[code]
+		sel.Modifier += f.option.PriceModifier
+		labels = append(labels, f.option.Label)
+	}
+	sel.Modifier = roundMoney(sel.Modifier)
+	sel.Note = strings.Join(labels, ", ")
+	return sel, nil
+}
+
+type prepConfigInput struct {
+	Groups []struct {
+		Code        string `json:"code"`
+		Label       string `json:"label"`
+		Required    bool   `json:"required"`
+		MultiSelect bool   `json:"multi_select"`
+		Options     []struct {
+			Code          string  `json:"code"`
+			Label         string  `json:"label"`
+			PriceModifier float64 `json:"price_modifier"`
+			IsDefault     bool    `json:"is_default"`
+		} `json:"options"`
+	} `json:"groups"`
+	// Exclusions map an option to the options it rules out; "group:*" means the whole group
+	Exclusions []struct {
+		Option   string   `json:"option"`
+		Excludes []string `json:"excludes"`
+	} `json:"exclusions"`
+}
+
+// savePrepConfig replaces a product's option groups, options and exclusions
+func savePrepConfig(productID int, in prepConfigInput) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := loadProduct(tx, productID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM product_prep_groups WHERE product_id = $1", productID); err != nil {
+		return err
+	}
+
+	optionIDs := map[string]int{}
+	groupOptions := map[string][]string{}
+	for gi, g := range in.Groups {
+		if g.Code == "" || g.Label == "" || len(g.Options) == 0 {
+			return fmt.Errorf("%w: every group needs a code, a label and options", errPrepInvalid)
+		}
+		var groupID int
+		err := tx.QueryRow(`
+			INSERT INTO product_prep_groups (product_id, code, label, required, multi_select, sort_order)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+		`, productID, g.Code, g.Label, g.Required, g.MultiSelect, gi).Scan(&groupID)
+		if err != nil {
+			return fmt.Errorf("%w: duplicate group %s", errPrepInvalid, g.Code)
+		}
+		for oi, o := range g.Options {
+			if o.Code == "" || o.Label == "" {
+				return fmt.Errorf("%w: every option needs a code and a label", errPrepInvalid)
+			}
+			var optionID int
+			err := tx.QueryRow(`
+				INSERT INTO product_prep_options (group_id, code, label, price_modifier, is_default, sort_order)
+				VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+			`, groupID, o.Code, o.Label, o.PriceModifier, o.IsDefault, oi).Scan(&optionID)
+			if err != nil {
+				return fmt.Errorf("%w: duplicate option %s:%s", errPrepInvalid, g.Code, o.Code)
+			}
+			code := g.Code + ":" + o.Code
+			optionIDs[code] = optionID
+			groupOptions[g.Code] = append(groupOptions[g.Code], code)
+		}
+	}
+
+	for _, ex := range in.Exclusions {
+		from, ok := optionIDs[ex.Option]
+		if !ok {
+			return fmt.Errorf("%w: unknown option %s", errPrepInvalid, ex.Option)
+		}
+		var targets []string
+		for _, x := range ex.Excludes {
+			if strings.HasSuffix(x, ":*") {
+				targets = append(targets, groupOptions[strings.TrimSuffix(x, ":*")]...)
+			} else {
+				targets = append(targets, x)
+			}
+		}
+		for _, x := range targets {
+			to, ok := optionIDs[x]
+			if !ok {
+				return fmt.Errorf("%w: unknown option %s", errPrepInvalid, x)
+			}
+			if to == from {
+				continue
+			}
+			// Exclusions are symmetric
+			_, err := tx.Exec(`
+				INSERT INTO product_prep_exclusions (option_id, excludes_option_id) VALUES ($1, $2), ($2, $1)
+				ON CONFLICT DO NOTHING
+			`, from, to)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	tx.Exec("UPDATE products SET updated_at = NOW() WHERE id = $1", productID)
+	return tx.Commit()
+}
+
+// prepKey normalizes chosen options for a product (defaults filled in, sorted) into its cart key
+func prepKey(q dbExecer, productID int, chosen []string) (string, error) {
+	groups, err := loadPrepGroups(q, productID)
+	if err != nil {
+		return "", err
+	}
+	sel, err := resolvePrep(groups, chosen)
+	return sel.Key(), err
+}
+
+// normalizeSlot validates a delivery date and slot, defaulting to today's next slot
+func normalizeSlot(date, slot string) (string, string, error) {
+	now := time.Now()
+	if date == "" {
+		date = now.Format("2006-01-02")
+	}
+	d, err := time.ParseInLocation("2006-01-02", date, time.Local)
+	if err != nil || d.Before(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)) {
+		return "", "", errSlotInvalid
+	}
+	switch slot {
+	case SlotMorning, SlotEvening:
+	case "":
+		slot = SlotMorning
+		if date == now.Format("2006-01-02") && now.Hour() >= 12 {
+			slot = SlotEvening
+		}
+	default:
+		return "", "", errSlotInvalid
+	}
+	return date, slot, nil
+}
+
+// PrepListItem is one line of the butcher's list: a product prepared one way, summed over orders
+type PrepListItem struct {
+	Name        string   `json:"name"`
+	VariantName string   `json:"variant_name,omitempty"`
+	Weight      string   `json:"weight,omitempty"`
+	Prep        string   `json:"prep,omitempty"`
+	Quantity    int      `json:"quantity"`
+	Orders      []string `json:"orders"`
+}
+
+// prepList sums the open orders of a delivery slot by product, variant and preparation
+func prepList(date, slot, category string) ([]PrepListItem, error) {
+	rows, err := db.Query(`
+		SELECT oi.name, COALESCE(oi.variant_name, ''), COALESCE(oi.weight, ''), COALESCE(oi.prep_note, ''),
+		       SUM(oi.quantity), array_to_string(array_agg(DISTINCT o.id ORDER BY o.id), ',')
+		FROM order_items oi JOIN orders o ON oi.order_id = o.id
+		WHERE o.delivery_date = $1 AND ($2 = '' OR o.slot_type = $2) AND ($3 = '' OR oi.category = $3)
+		  AND o.status IN ($4, $5)
+		GROUP BY 1, 2, 3, 4
+		ORDER BY 1, 2, 4
+	`, date, slot, category, OrderPending, OrderProcessing)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PrepListItem{}
+	for rows.Next() {
+		var it PrepListItem
+		var ids string
+		rows.Scan(&it.Name, &it.VariantName, &it.Weight, &it.Prep, &it.Quantity, &ids)
+		it.Orders = []string{}
+		for _, id := range strings.Split(ids, ",") {
+			var n int
+			fmt.Sscan(id, &n)
+			it.Orders = append(it.Orders, orderNumber(n))
+		}
+		items = append(items, it)
+	}
+	return items, nil
+}
+
+// --- Handlers ---
+
+// productPrepHandler serves /api/products/{id}/prep: public GET, admin PUT (replace)
+func productPrepHandler(w http.ResponseWriter, r *http.Request, productID int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		groups, err := loadPrepGroups(db, productID)
+		if err != nil {
+			log.Println("Error fetching prep options:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		json.NewEncoder(w).Encode(groups)
+
+	case "PUT":
+		authMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			var in prepConfigInput
+			if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+			if err := savePrepConfig(productID, in); err != nil {
+				switch {
+				case errors.Is(err, errPrepInvalid):
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				case errors.Is(err, errProductNotFound):
+					http.Error(w, err.Error(), http.StatusNotFound)
+				default:
+					log.Println("Error saving prep options:", err)
+					http.Error(w, "Database error", http.StatusInternalServerError)
+				}
+				return
+			}
+			groups, _ := loadPrepGroups(db, productID)
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "groups": groups})
+		}, true)(w, r)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminPrepListHandler is the kitchen/butcher list for a slot: GET ?date=YYYY-MM-DD&slot=Morning&category=Meat
+func adminPrepListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	slot := r.URL.Query().Get("slot")
+
+	items, err := prepList(date, slot, r.URL.Query().Get("category"))
+	if err != nil {
+		log.Println("Error building prep list:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"date": date, "slot": slot, "items": items})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testPrepGroups is a mutton-like set: required cut and skin with defaults, an
+// optional live prep that rules both out, and multi-select extras
+func testPrepGroups() []PrepGroup {
+	return []PrepGroup{
+		{Code: "cut", Label: "Cut", Required: true, Options: []PrepOption{
+			{Code: "curry", Label: "Curry cut", IsDefault: true},
+			{Code: "boneless", Label: "Boneless", PriceModifier: 50},
+		}},
+		{Code: "skin", Label: "Skin", Required: true, Options: []PrepOption{
+			{Code: "with", Label: "With skin", IsDefault: true},
+			{Code: "without", Label: "Skinless", PriceModifier: 20},
+		}},
+		{Code: "prep", Label: "Preparation", Options: []PrepOption{
+			{Code: "live", Label: "Live", Excludes: []string{"cut:curry", "cut:boneless", "skin:with", "skin:without"}},
+		}},
+		{Code: "extras", Label: "Extras", MultiSelect: true, Options: []PrepOption{
+			{Code: "liver", Label: "Liver", PriceModifier: 30},
+			{Code: "head", Label: "Head", PriceModifier: 40.5},
+		}},
+	}
+}
+
+func TestResolvePrep(t *testing.T) {
+	tests := []struct {
+		name         string
+		groups       []PrepGroup
+		chosen       []string
+		wantCodes    []string
+		wantNote     string
+		wantModifier float64
+		wantErr      bool
+	}{
+		{name: "no groups, nothing chosen", groups: nil},
+		{name: "no groups, option chosen", groups: nil, chosen: []string{"cut:curry"}, wantErr: true},
+		{name: "defaults fill required groups", groups: testPrepGroups(),
+			wantCodes: []string{"cut:curry", "skin:with"}, wantNote: "Curry cut, With skin"},
+		{name: "chosen option replaces the default", groups: testPrepGroups(), chosen: []string{"cut:boneless"},
+			wantCodes: []string{"cut:boneless", "skin:with"}, wantNote: "Boneless, With skin", wantModifier: 50},
+		{name: "codes are trimmed and deduplicated", groups: testPrepGroups(), chosen: []string{" skin:without", "skin:without"},
+			wantCodes: []string{"cut:curry", "skin:without"}, wantNote: "Curry cut, Skinless", wantModifier: 20},
+		{name: "multi-select group takes several", groups: testPrepGroups(), chosen: []string{"extras:liver", "extras:head"},
+			wantCodes: []string{"cut:curry", "extras:head", "extras:liver", "skin:with"},
+			wantNote:  "Curry cut, Head, Liver, With skin", wantModifier: 70.5},
+		{name: "exclusions drop required groups", groups: testPrepGroups(), chosen: []string{"prep:live"},
+			wantCodes: []string{"prep:live"}, wantNote: "Live"},
+		{name: "unknown option", groups: testPrepGroups(), chosen: []string{"cut:mince"}, wantErr: true},
+		{name: "two options in a single-select group", groups: testPrepGroups(), chosen: []string{"cut:curry", "cut:boneless"}, wantErr: true},
+		{name: "excluded combination", groups: testPrepGroups(), chosen: []string{"prep:live", "cut:curry"}, wantErr: true},
+		{name: "required group without a default", groups: []PrepGroup{
+			{Code: "size", Label: "Size", Required: true, Options: []PrepOption{{Code: "small", Label: "Small"}, {Code: "large", Label: "Large"}}},
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel, err := resolvePrep(tt.groups, tt.chosen)
+			if tt.wantErr {
+				if !errors.Is(err, errPrepInvalid) {
+					t.Fatalf("resolvePrep() error = %v, want errPrepInvalid", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolvePrep() error = %v", err)
+			}
+			if !reflect.DeepEqual(sel.Codes, tt.wantCodes) {
+				t.Errorf("codes = %v, want %v", sel.Codes, tt.wantCodes)
+			}
+			if sel.Note != tt.wantNote {
+				t.Errorf("note = %q, want %q", sel.Note, tt.wantNote)
+			}
+			if sel.Modifier != tt.wantModifier {
+				t.Errorf("modifier = %v, want %v", sel.Modifier, tt.wantModifier)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// EFFECTIVE-DATED PRICE HISTORY
+// ============================================================================
+//
+// Every price lives in price_history with a half-open validity range
+// [effective_from, effective_to). effective_to is derived: it is always the
+// effective_from of the next row for the same (milk_type, zone, segment), or
+// NULL for the latest row. Rows without a zone/segment are the default price;
+// a lookup prefers the most specific matching row.
+
+type PriceChange struct {
+	ID            int     `json:"id"`
+	MilkType      string  `json:"milk_type"`
+	Price         float64 `json:"price"`
+	ZoneID        int     `json:"zone_id,omitempty"`
+	Segment       string  `json:"segment,omitempty"`
+	EffectiveFrom string  `json:"effective_from"`
+	EffectiveTo   string  `json:"effective_to,omitempty"`
+	CreatedBy     string  `json:"created_by,omitempty"`
+	CreatedAt     string  `json:"created_at"`
+}
+
+// priceScope narrows a price lookup to a delivery zone and/or customer segment
+type priceScope struct {
+	ZoneID  int
+	Segment string
+}
+
+func initPriceHistorySchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS price_history (
+			id SERIAL PRIMARY KEY,
+			milk_type TEXT NOT NULL,
+			price DECIMAL(8,2) NOT NULL,
+			zone_id INTEGER,
+			segment TEXT,
+			effective_from DATE NOT NULL,
+			effective_to DATE,
+			created_by TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_price_history_key
+			ON price_history(milk_type, (COALESCE(zone_id, 0)), (COALESCE(segment, '')), effective_from);
+
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS price_segment TEXT;
+	`)
+	if err != nil {
+		log.Fatal("Failed to create price history schema:", err)
+	}
+
+	// Carry over the one-step history held on the legacy pricing rows
+	db.Exec(`
+		INSERT INTO price_history (milk_type, price, effective_from, created_by)
+		SELECT milk_type, COALESCE(previous_price, price), DATE '2000-01-01', 'migration' FROM pricing p
+		WHERE NOT EXISTS (SELECT 1 FROM price_history h WHERE h.milk_type = p.milk_type)
+	`)
+	db.Exec(`
+		INSERT INTO price_history (milk_type, price, effective_from, created_by)
+		SELECT milk_type, price, updated_at::date, 'migration' FROM pricing p
+		WHERE previous_price IS NOT NULL AND previous_price <> price
+		  AND NOT EXISTS (SELECT 1 FROM price_history h WHERE h.milk_type = p.milk_type AND h.effective_from = p.updated_at::date)
+	`)
+	rows, err := db.Query("SELECT DISTINCT milk_type FROM price_history")
+	if err == nil {
+		var types []string
+		for rows.Next() {
+			var t string
+			rows.Scan(&t)
+			types = append(types, t)
+		}
+		rows.Close()
+		for _, t := range types {
+			recomputeEffectiveTo(db, t, priceScope{})
+		}
+	}
+}
+
+// recomputeEffectiveTo re-chains effective_to for one (milk_type, zone, segment) timeline
+func recomputeEffectiveTo(q dbExecer, milkType string, scope priceScope) error {
+	_, err := q.Exec(`
+		UPDATE price_history h SET effective_to = n.next_from
+		FROM (
+			SELECT id, LEAD(effective_from) OVER (ORDER BY effective_from) AS next_from
+			FROM price_history
+			WHERE milk_type = $1 AND COALESCE(zone_id, 0) = $2 AND COALESCE(segment, '') = $3
+		) n
+		WHERE h.id = n.id AND h.effective_to IS DISTINCT FROM n.next_from
+	`, milkType, scope.ZoneID, scope.Segment)
+	return err
+}
+
+// lookupPrice returns the price of a milk type on date D for the given scope.
+// Zone+segment rows beat zone rows, which beat segment rows, which beat the default.
+func lookupPrice(q dbExecer, milkType string, date time.Time, scope priceScope) (float64, error) {
+	price, _, err := lookupPriceSince(q, milkType, date, scope)
+	return price, err
+}
+
+// lookupPriceSince is lookupPrice that also returns the date the price took effect
+func lookupPriceSince(q dbExecer, milkType string, date time.Time, scope priceScope) (float64, time.Time, error) {
+	var price float64
+	var from time.Time
+	err := q.QueryRow(`
+		SELECT price, effective_from FROM price_history
+		WHERE milk_type = $1 AND effective_from <= $2 AND (effective_to IS NULL OR effective_to > $2)
+		  AND (zone_id IS NULL OR zone_id = $3)
+		  AND (segment IS NULL OR segment = $4)
+		ORDER BY (zone_id IS NOT NULL) DESC, (segment IS NOT NULL) DESC
+		LIMIT 1
+	`, milkType, date.Format("2006-01-02"), scope.ZoneID, scope.Segment).Scan(&price, &from)
+	return price, from, err
+}
+
+// priceOnDate returns the default (unscoped) price of a milk type on a date
+func priceOnDate(q dbExecer, milkType string, date time.Time) (float64, error) {
+	return lookupPrice(q, milkType, date, priceScope{})
+}
+
+// userPriceScope returns the zone and segment that apply to a user's prices.
+// The zone is that of the live subscription's address, else the default address.
+func userPriceScope(q dbExecer, userID int) priceScope {
+	scopes, _ := userPriceScopes(q, "{"+strconv.Itoa(userID)+"}")
+	return scopes[userID]
+}
+
+// userPriceScopes is userPriceScope for a set of users, given as a Postgres int array literal
+func userPriceScopes(q dbExecer, userList string) (map[int]priceScope, error) {
+	rows, err := q.Query(`
+		SELECT u.id, COALESCE(u.price_segment, ''), COALESCE(
+			(SELECT a.zone_id FROM milk_subscriptions ms JOIN addresses a ON a.id = ms.address_id
+			 WHERE ms.user_id = u.id AND ms.status <> 'Cancelled' ORDER BY ms.id DESC LIMIT 1),
+			(SELECT zone_id FROM addresses WHERE user_id = u.id ORDER BY is_default DESC, created_at DESC LIMIT 1),
+			0)
+		FROM users u WHERE u.id = ANY($1::int[])`, userList)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scopes := map[int]priceScope{}
+	for rows.Next() {
+		var userID int
+		var scope priceScope
+		rows.Scan(&userID, &scope.Segment, &scope.ZoneID)
+		scopes[userID] = scope
+	}
+	return scopes, nil
+}
+
+func scanPriceChanges(rows *sql.Rows) []PriceChange {
+	defer rows.Close()
+	changes := []PriceChange{}
+	for rows.Next() {
+		var c PriceChange
+		rows.Scan(&c.ID, &c.MilkType, &c.Price, &c.ZoneID, &c.Segment, &c.EffectiveFrom, &c.EffectiveTo, &c.CreatedBy, &c.CreatedAt)
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+const priceChangeColumns = `id, milk_type, price, COALESCE(zone_id, 0), COALESCE(segment, ''), effective_from::text,
+	COALESCE(effective_to::text, ''), COALESCE(created_by, ''), created_at::text`
+
+// upcomingPriceChanges lists scheduled changes (effective after today) for a milk type
+func upcomingPriceChanges(milkType string) []PriceChange {
+	rows, err := db.Query(`SELECT `+priceChangeColumns+` FROM price_history
+		WHERE milk_type = $1 AND effective_from > CURRENT_DATE ORDER BY effective_from`, milkType)
+	if err != nil {
+		log.Println("Error fetching upcoming prices:", err)
+		return []PriceChange{}
+	}
+	return scanPriceChanges(rows)
+}
+
+// setPrice records a price for a milk type from a date onwards (today or later)
+func setPrice(milkType string, price float64, from time.Time, scope priceScope, actor string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var zoneArg, segmentArg interface{}
+	if scope.ZoneID != 0 {
+		zoneArg = scope.ZoneID
+	}
+	if scope.Segment != "" {
+		segmentArg = scope.Segment
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO price_history (milk_type, price, zone_id, segment, effective_from, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (milk_type, (COALESCE(zone_id, 0)), (COALESCE(segment, '')), effective_from)
+		DO UPDATE SET price = EXCLUDED.price, created_by = EXCLUDED.created_by, created_at = NOW()
+		RETURNING id
+	`, milkType, price, zoneArg, segmentArg, from.Format("2006-01-02"), actor).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if err := recomputeEffectiveTo(tx, milkType, scope); err != nil {
+		return 0, err
+	}
+
+	// Keep the legacy pricing row (used as the list of milk types) in step for immediate default changes
+	if scope == (priceScope{}) && !from.After(time.Now()) {
+		_, err = tx.Exec(`
+			INSERT INTO pricing (milk_type, price) VALUES ($2, $1)
+			ON CONFLICT (milk_type) DO UPDATE SET previous_price = pricing.price, price = $1, updated_at = NOW()
+		`, price, milkType)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		tx.Exec("INSERT INTO pricing (milk_type, price) VALUES ($1, $2) ON CONFLICT (milk_type) DO NOTHING", milkType, price)
+	}
+
+	return id, tx.Commit()
+}
+
+// adminPriceHistoryHandler lists price history (?milk_type=) or cancels a scheduled change (DELETE ?id=)
+func adminPriceHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		rows, err := db.Query(`SELECT `+priceChangeColumns+` FROM price_history
+			WHERE ($1 = '' OR milk_type = $1)
+			ORDER BY milk_type, COALESCE(zone_id, 0), COALESCE(segment, ''), effective_from DESC`, r.URL.Query().Get("milk_type"))
+		if err != nil {
+			log.Println("Error fetching price history:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(scanPriceChanges(rows))
+
+	case "DELETE":
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		var c PriceChange
+		err := db.QueryRow(`SELECT milk_type, COALESCE(zone_id, 0), COALESCE(segment, '') FROM price_history
+			WHERE id = $1 AND effective_from > CURRENT_DATE`, id).Scan(&c.MilkType, &c.ZoneID, &c.Segment)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Only scheduled (future) price changes can be cancelled", http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		res, err := db.Exec("DELETE FROM price_history WHERE id = $1 AND effective_from > CURRENT_DATE", id)
+		if err != nil {
+			log.Println("Error cancelling price change:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Price change not found", http.StatusNotFound)
+			return
+		}
+		if err := recomputeEffectiveTo(db, c.MilkType, priceScope{ZoneID: c.ZoneID, Segment: c.Segment}); err != nil {
+			log.Println("Error recomputing price history:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminUserSegmentHandler sets the pricing segment of a customer
+func adminUserSegmentHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID      int    `json:"id"`
+		Segment string `json:"segment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.Exec("UPDATE users SET price_segment = NULLIF($1, ''), updated_at = NOW() WHERE id = $2", req.Segment, req.ID)
+	if err != nil {
+		http.Error(w, "Failed to update segment", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
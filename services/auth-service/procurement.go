@@ -0,0 +1,919 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// MILK PROCUREMENT
+// ============================================================================
+//
+// Farmers (suppliers) pour milk twice a day. Each collection records litres,
+// fat % and SNF % for a supplier, date and shift, and is priced from the rate
+// chart in force that day: a grid of (fat from, SNF from) -> rate per litre
+// where the cell with the highest thresholds at or below the sample applies.
+// Milk below the chart's lowest cell is rejected. Every collection posts a
+// receipt into inventory ("collection:<id>"); a correction posts the
+// difference as an adjustment. Collections are paid through statements per
+// 10-day cycle (1–10, 11–20, 21–month end); a collection on a statement is
+// final.
+//
+// Routes (admin):
+//   /api/admin/procurement/suppliers     GET list, POST, PUT {id,...}
+//   /api/admin/procurement/rate-charts   GET ?milk_type= (or ?milk_type&fat&snf&date to quote), POST {milk_type, effective_from, cells}
+//   /api/admin/procurement/collections   GET ?date&shift&supplier_id, POST, PUT {id, litres, fat, snf} (correction)
+//   /api/admin/procurement/statements    GET ?supplier_id|?id, POST {from, to} generate, PUT {id, payment_ref} mark paid
+
+// Statement states
+const (
+	StatementDraft = "draft"
+	StatementPaid  = "paid"
+)
+
+var (
+	errSupplierNotFound   = errors.New("supplier not found")
+	errSupplierInvalid    = errors.New("supplier needs a code and a name")
+	errNoRateChart        = errors.New("no procurement rate chart for this milk type and date")
+	errRateChartInvalid   = errors.New("rate chart needs a milk type, a date and cells with positive rates")
+	errBelowStandard      = errors.New("milk is below the minimum fat/SNF on the rate chart")
+	errCollectionInvalid  = errors.New("collection needs positive litres, fat and SNF between 0 and 15, and a Morning or Evening shift")
+	errCollectionNotFound = errors.New("collection not found")
+	errCollectionFinal    = errors.New("collection is already on a supplier statement")
+	errCollectionExists   = errors.New("a collection for this supplier, date, shift and milk type already exists; correct it instead")
+	errStatementNotFound  = errors.New("statement not found")
+)
+
+func procurementErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSupplierNotFound), errors.Is(err, errCollectionNotFound), errors.Is(err, errStatementNotFound),
+		errors.Is(err, errStockItemNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errCollectionFinal), errors.Is(err, errCollectionExists), errors.Is(err, errNoRateChart):
+		return http.StatusConflict
+	case errors.Is(err, errBelowStandard):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errSupplierInvalid), errors.Is(err, errRateChartInvalid), errors.Is(err, errCollectionInvalid):
+		return http.StatusBadRequest
+	}
+	return inventoryErrorStatus(err)
+}
+
+type Supplier struct {
+	ID          int    `json:"id"`
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Phone       string `json:"phone"`
+	Village     string `json:"village"`
+	MilkType    string `json:"milk_type"`
+	BankAccount string `json:"bank_account,omitempty"`
+	IFSC        string `json:"ifsc,omitempty"`
+	UPIID       string `json:"upi_id,omitempty"`
+	WarehouseID int    `json:"warehouse_id,omitempty"`
+	IsActive    bool   `json:"is_active"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// RateCell prices milk with at least FatFrom % fat and SNFFrom % SNF
+type RateCell struct {
+	FatFrom float64 `json:"fat_from"`
+	SNFFrom float64 `json:"snf_from"`
+	Rate    float64 `json:"rate"`
+}
+
+type RateChart struct {
+	ID            int        `json:"id"`
+	MilkType      string     `json:"milk_type"`
+	EffectiveFrom string     `json:"effective_from"`
+	Cells         []RateCell `json:"cells"`
+	CreatedBy     string     `json:"created_by,omitempty"`
+	CreatedAt     string     `json:"created_at"`
+}
+
+type MilkCollection struct {
+	ID           int     `json:"id"`
+	SupplierID   int     `json:"supplier_id"`
+	SupplierName string  `json:"supplier_name"`
+	Date         string  `json:"date"`
+	Shift        string  `json:"shift"`
+	MilkType     string  `json:"milk_type"`
+	Litres       float64 `json:"litres"`
+	Fat          float64 `json:"fat"`
+	SNF          float64 `json:"snf"`
+	Rate         float64 `json:"rate"`
+	Amount       float64 `json:"amount"`
+	ChartID      int     `json:"chart_id"`
+	StatementID  int     `json:"statement_id,omitempty"`
+	Revision     int     `json:"revision"`
+	CreatedBy    string  `json:"created_by,omitempty"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+type SupplierStatement struct {
+	ID           int              `json:"id"`
+	SupplierID   int              `json:"supplier_id"`
+	SupplierName string           `json:"supplier_name"`
+	PeriodStart  string           `json:"period_start"`
+	PeriodEnd    string           `json:"period_end"`
+	Litres       float64          `json:"litres"`
+	AvgFat       float64          `json:"avg_fat"`
+	AvgSNF       float64          `json:"avg_snf"`
+	Amount       float64          `json:"amount"`
+	Status       string           `json:"status"`
+	PaymentRef   string           `json:"payment_ref,omitempty"`
+	PaidAt       string           `json:"paid_at,omitempty"`
+	CreatedAt    string           `json:"created_at"`
+	Collections  []MilkCollection `json:"collections,omitempty"`
+}
+
+func initProcurementSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS suppliers (
+			id SERIAL PRIMARY KEY,
+			code TEXT UNIQUE NOT NULL,
+			name TEXT NOT NULL,
+			phone TEXT,
+			village TEXT,
+			milk_type TEXT NOT NULL,
+			bank_account TEXT,
+			ifsc TEXT,
+			upi_id TEXT,
+			warehouse_id INTEGER REFERENCES warehouses(id),
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS procurement_rate_charts (
+			id SERIAL PRIMARY KEY,
+			milk_type TEXT NOT NULL,
+			effective_from DATE NOT NULL,
+			created_by TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (milk_type, effective_from)
+		);
+
+		CREATE TABLE IF NOT EXISTS procurement_rate_cells (
+			chart_id INTEGER NOT NULL REFERENCES procurement_rate_charts(id) ON DELETE CASCADE,
+			fat_from DECIMAL(4,2) NOT NULL,
+			snf_from DECIMAL(4,2) NOT NULL,
+			rate DECIMAL(8,2) NOT NULL CHECK (rate > 0),
+			PRIMARY KEY (chart_id, fat_from, snf_from)
+		);
+
+		CREATE TABLE IF NOT EXISTS supplier_statements (
+			id SERIAL PRIMARY KEY,
+			supplier_id INTEGER NOT NULL REFERENCES suppliers(id),
+			period_start DATE NOT NULL,
+			period_end DATE NOT NULL,
+			litres DECIMAL(12,2) NOT NULL DEFAULT 0,
+			amount DECIMAL(12,2) NOT NULL DEFAULT 0,
+			status TEXT NOT NULL DEFAULT 'draft',
+			payment_ref TEXT,
+			paid_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (supplier_id, period_start, period_end)
+		);
+
+		CREATE TABLE IF NOT EXISTS milk_collections (
+			id SERIAL PRIMARY KEY,
+			supplier_id INTEGER NOT NULL REFERENCES suppliers(id),
+			collection_date DATE NOT NULL,
+			shift TEXT NOT NULL CHECK (shift IN ('Morning', 'Evening')),
+			milk_type TEXT NOT NULL,
+			litres DECIMAL(8,2) NOT NULL CHECK (litres > 0),
+			fat DECIMAL(4,2) NOT NULL,
+			snf DECIMAL(4,2) NOT NULL,
+			rate DECIMAL(8,2) NOT NULL,
+			amount DECIMAL(12,2) NOT NULL,
+			chart_id INTEGER NOT NULL REFERENCES procurement_rate_charts(id),
+			warehouse_id INTEGER NOT NULL REFERENCES warehouses(id),
+			statement_id INTEGER REFERENCES supplier_statements(id),
+			revision INTEGER NOT NULL DEFAULT 0,
+			created_by TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (supplier_id, collection_date, shift, milk_type)
+		);
+		CREATE INDEX IF NOT EXISTS idx_milk_collections_date ON milk_collections(collection_date, shift);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create procurement schema:", err)
+	}
+}
+
+// chartRate prices a sample: the cell with the highest fat threshold at or below
+// the sample's fat, then the highest SNF threshold at or below its SNF
+func chartRate(cells []RateCell, fat, snf float64) (float64, bool) {
+	best := -1
+	for i, c := range cells {
+		if c.FatFrom > fat || c.SNFFrom > snf {
+			continue
+		}
+		if best < 0 || c.FatFrom > cells[best].FatFrom ||
+			(c.FatFrom == cells[best].FatFrom && c.SNFFrom > cells[best].SNFFrom) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return 0, false
+	}
+	return cells[best].Rate, true
+}
+
+// procurementPeriod returns the 10-day payment cycle containing t
+func procurementPeriod(t time.Time) (start, end time.Time) {
+	day := 1
+	switch {
+	case t.Day() > 20:
+		day = 21
+	case t.Day() > 10:
+		day = 11
+	}
+	start = time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, time.UTC)
+	if day == 21 {
+		return start, time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC)
+	}
+	return start, start.AddDate(0, 0, 9)
+}
+
+// rateChartOn returns the chart for a milk type in force on a date
+func rateChartOn(q dbExecer, milkType, date string) (*RateChart, error) {
+	var c RateChart
+	err := q.QueryRow(`
+		SELECT id, milk_type, effective_from::text, COALESCE(created_by, ''), created_at::text FROM procurement_rate_charts
+		WHERE milk_type = $1 AND effective_from <= $2 ORDER BY effective_from DESC LIMIT 1`, milkType, date).
+		Scan(&c.ID, &c.MilkType, &c.EffectiveFrom, &c.CreatedBy, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errNoRateChart
+	} else if err != nil {
+		return nil, err
+	}
+	if err := loadRateCells(q, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func loadRateCells(q dbExecer, c *RateChart) error {
+	rows, err := q.Query("SELECT fat_from, snf_from, rate FROM procurement_rate_cells WHERE chart_id = $1 ORDER BY fat_from, snf_from", c.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	c.Cells = []RateCell{}
+	for rows.Next() {
+		var cell RateCell
+		rows.Scan(&cell.FatFrom, &cell.SNFFrom, &cell.Rate)
+		c.Cells = append(c.Cells, cell)
+	}
+	return nil
+}
+
+// saveRateChart stores a chart; saving again for the same type and date replaces its cells
+func saveRateChart(c RateChart, actor string) (int, error) {
+	if c.MilkType == "" || len(c.Cells) == 0 {
+		return 0, errRateChartInvalid
+	}
+	if _, err := time.Parse("2006-01-02", c.EffectiveFrom); err != nil {
+		return 0, errRateChartInvalid
+	}
+	for _, cell := range c.Cells {
+		if cell.Rate <= 0 || cell.FatFrom < 0 || cell.SNFFrom < 0 {
+			return 0, errRateChartInvalid
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO procurement_rate_charts (milk_type, effective_from, created_by) VALUES ($1, $2, $3)
+		ON CONFLICT (milk_type, effective_from) DO UPDATE SET created_by = EXCLUDED.created_by, created_at = NOW()
+		RETURNING id`, c.MilkType, c.EffectiveFrom, actor).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM procurement_rate_cells WHERE chart_id = $1", id); err != nil {
+		return 0, err
+	}
+	for _, cell := range c.Cells {
+		_, err := tx.Exec(`INSERT INTO procurement_rate_cells (chart_id, fat_from, snf_from, rate) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (chart_id, fat_from, snf_from) DO UPDATE SET rate = EXCLUDED.rate`, id, cell.FatFrom, cell.SNFFrom, cell.Rate)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+// collectionInput is a collection to record (ID 0) or correct
+type collectionInput struct {
+	ID         int     `json:"id"`
+	SupplierID int     `json:"supplier_id"`
+	Date       string  `json:"date"`
+	Shift      string  `json:"shift"`
+	MilkType   string  `json:"milk_type"`
+	Litres     float64 `json:"litres"`
+	Fat        float64 `json:"fat"`
+	SNF        float64 `json:"snf"`
+}
+
+func (in collectionInput) valid() bool {
+	return in.Litres > 0 && in.Fat > 0 && in.Fat <= 15 && in.SNF > 0 && in.SNF <= 15 &&
+		(in.Shift == SlotMorning || in.Shift == SlotEvening)
+}
+
+// recordCollection prices a new collection and receives it into inventory
+func recordCollection(in collectionInput, actor string) (*MilkCollection, error) {
+	if in.Date == "" {
+		in.Date = time.Now().Format("2006-01-02")
+	}
+	if !in.valid() {
+		return nil, errCollectionInvalid
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var supplierMilk string
+	var warehouseID int
+	var active bool
+	err = tx.QueryRow("SELECT milk_type, COALESCE(warehouse_id, 0), is_active FROM suppliers WHERE id = $1", in.SupplierID).
+		Scan(&supplierMilk, &warehouseID, &active)
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		return nil, errSupplierNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if in.MilkType == "" {
+		in.MilkType = supplierMilk
+	}
+	if warehouseID == 0 {
+		if warehouseID, err = warehouseForZone(tx, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	chart, err := rateChartOn(tx, in.MilkType, in.Date)
+	if err != nil {
+		return nil, err
+	}
+	rate, ok := chartRate(chart.Cells, in.Fat, in.SNF)
+	if !ok {
+		return nil, errBelowStandard
+	}
+	item, err := stockItemForMilk(tx, in.MilkType)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO milk_collections (supplier_id, collection_date, shift, milk_type, litres, fat, snf, rate, amount, chart_id, warehouse_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (supplier_id, collection_date, shift, milk_type) DO NOTHING
+		RETURNING id
+	`, in.SupplierID, in.Date, in.Shift, in.MilkType, in.Litres, in.Fat, in.SNF, rate, roundMoney(in.Litres*rate), chart.ID,
+		warehouseID, actor).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, errCollectionExists
+	} else if err != nil {
+		return nil, err
+	}
+
+	_, _, err = postInventoryMovement(tx, inventoryMove{
+		Key:         "collection:" + strconv.Itoa(id),
+		Kind:        MoveReceipt,
+		StockItemID: item.ID,
+		WarehouseID: warehouseID,
+		Quantity:    in.Litres * item.PackSize,
+		Date:        in.Date,
+		Note:        "Collection " + in.Shift,
+		CreatedBy:   actor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return loadCollection(db, id)
+}
+
+// correctCollection re-prices a collection not yet on a statement and posts the
+// litres difference into inventory as an adjustment
+func correctCollection(in collectionInput, actor string) (*MilkCollection, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var c MilkCollection
+	var warehouseID int
+	err = tx.QueryRow(`
+		SELECT supplier_id, collection_date::text, shift, milk_type, litres, COALESCE(statement_id, 0), revision, warehouse_id
+		FROM milk_collections WHERE id = $1 FOR UPDATE`, in.ID).
+		Scan(&c.SupplierID, &c.Date, &c.Shift, &c.MilkType, &c.Litres, &c.StatementID, &c.Revision, &warehouseID)
+	if err == sql.ErrNoRows {
+		return nil, errCollectionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if c.StatementID != 0 {
+		return nil, errCollectionFinal
+	}
+	in.Shift = c.Shift
+	if !in.valid() {
+		return nil, errCollectionInvalid
+	}
+
+	chart, err := rateChartOn(tx, c.MilkType, c.Date)
+	if err != nil {
+		return nil, err
+	}
+	rate, ok := chartRate(chart.Cells, in.Fat, in.SNF)
+	if !ok {
+		return nil, errBelowStandard
+	}
+	revision := c.Revision + 1
+	_, err = tx.Exec(`UPDATE milk_collections SET litres = $1, fat = $2, snf = $3, rate = $4, amount = $5, chart_id = $6,
+		revision = $7, updated_at = NOW() WHERE id = $8`,
+		in.Litres, in.Fat, in.SNF, rate, roundMoney(in.Litres*rate), chart.ID, revision, in.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if diff := roundQty(in.Litres - c.Litres); diff != 0 {
+		item, err := stockItemForMilk(tx, c.MilkType)
+		if err != nil {
+			return nil, err
+		}
+		_, _, err = postInventoryMovement(tx, inventoryMove{
+			Key:         "collection:" + strconv.Itoa(in.ID) + ":rev:" + strconv.Itoa(revision),
+			Kind:        MoveAdjustment,
+			StockItemID: item.ID,
+			WarehouseID: warehouseID,
+			Quantity:    diff * item.PackSize,
+			Date:        c.Date,
+			Note:        "Collection correction",
+			CreatedBy:   actor,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return loadCollection(db, in.ID)
+}
+
+const collectionColumns = `c.id, c.supplier_id, s.name, c.collection_date::text, c.shift, c.milk_type, c.litres, c.fat, c.snf,
+	c.rate, c.amount, c.chart_id, COALESCE(c.statement_id, 0), c.revision, COALESCE(c.created_by, ''), c.created_at::text`
+
+func scanCollection(row interface{ Scan(...interface{}) error }) (*MilkCollection, error) {
+	var c MilkCollection
+	err := row.Scan(&c.ID, &c.SupplierID, &c.SupplierName, &c.Date, &c.Shift, &c.MilkType, &c.Litres, &c.Fat, &c.SNF,
+		&c.Rate, &c.Amount, &c.ChartID, &c.StatementID, &c.Revision, &c.CreatedBy, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errCollectionNotFound
+	}
+	return &c, err
+}
+
+func loadCollection(q dbExecer, id int) (*MilkCollection, error) {
+	return scanCollection(q.QueryRow(`SELECT `+collectionColumns+` FROM milk_collections c JOIN suppliers s ON s.id = c.supplier_id
+		WHERE c.id = $1`, id))
+}
+
+// generateStatements puts every supplier's unstatemented collections in the period
+// on a draft statement. Returns the ids of statements created or topped up.
+func generateStatements(from, to time.Time) ([]int, error) {
+	rows, err := db.Query(`SELECT DISTINCT supplier_id FROM milk_collections
+		WHERE statement_id IS NULL AND collection_date BETWEEN $1 AND $2 ORDER BY supplier_id`,
+		from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	var suppliers []int
+	for rows.Next() {
+		var id int
+		rows.Scan(&id)
+		suppliers = append(suppliers, id)
+	}
+	rows.Close()
+
+	ids := []int{}
+	for _, supplierID := range suppliers {
+		id, err := statementForSupplier(supplierID, from, to)
+		if err != nil {
+			log.Printf("Error generating statement for supplier %d: %v", supplierID, err)
+			continue
+		}
+		if id != 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func statementForSupplier(supplierID int, from, to time.Time) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	var status string
+	err = tx.QueryRow(`
+		INSERT INTO supplier_statements (supplier_id, period_start, period_end) VALUES ($1, $2, $3)
+		ON CONFLICT (supplier_id, period_start, period_end) DO UPDATE SET supplier_id = EXCLUDED.supplier_id
+		RETURNING id, status`, supplierID, from.Format("2006-01-02"), to.Format("2006-01-02")).Scan(&id, &status)
+	if err != nil {
+		return 0, err
+	}
+	if status == StatementPaid {
+		// Late entries for a paid period wait for a statement over a new range
+		return 0, nil
+	}
+
+	_, err = tx.Exec(`UPDATE milk_collections SET statement_id = $1
+		WHERE supplier_id = $2 AND statement_id IS NULL AND collection_date BETWEEN $3 AND $4`,
+		id, supplierID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`UPDATE supplier_statements st SET
+		litres = t.litres, amount = t.amount
+		FROM (SELECT COALESCE(SUM(litres), 0) AS litres, COALESCE(SUM(amount), 0) AS amount FROM milk_collections WHERE statement_id = $1) t
+		WHERE st.id = $1`, id)
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+const statementColumns = `st.id, st.supplier_id, s.name, st.period_start::text, st.period_end::text, st.litres,
+	COALESCE((SELECT SUM(fat * litres) / NULLIF(SUM(litres), 0) FROM milk_collections WHERE statement_id = st.id), 0),
+	COALESCE((SELECT SUM(snf * litres) / NULLIF(SUM(litres), 0) FROM milk_collections WHERE statement_id = st.id), 0),
+	st.amount, st.status, COALESCE(st.payment_ref, ''), COALESCE(st.paid_at::text, ''), st.created_at::text`
+
+func scanStatement(row interface{ Scan(...interface{}) error }) (*SupplierStatement, error) {
+	var st SupplierStatement
+	err := row.Scan(&st.ID, &st.SupplierID, &st.SupplierName, &st.PeriodStart, &st.PeriodEnd, &st.Litres, &st.AvgFat, &st.AvgSNF,
+		&st.Amount, &st.Status, &st.PaymentRef, &st.PaidAt, &st.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errStatementNotFound
+	}
+	st.AvgFat, st.AvgSNF = roundMoney(st.AvgFat), roundMoney(st.AvgSNF)
+	return &st, err
+}
+
+// --- Handlers ---
+
+func writeProcurementError(w http.ResponseWriter, err error, context string) {
+	if procurementErrorStatus(err) == http.StatusInternalServerError {
+		log.Println("Error "+context+":", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, err.Error(), procurementErrorStatus(err))
+}
+
+// adminSuppliersHandler manages farmers/suppliers: GET list (?active=true), POST create, PUT {id,...} update
+func adminSuppliersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		rows, err := db.Query(`
+			SELECT id, code, name, COALESCE(phone, ''), COALESCE(village, ''), milk_type, COALESCE(bank_account, ''),
+			       COALESCE(ifsc, ''), COALESCE(upi_id, ''), COALESCE(warehouse_id, 0), is_active, created_at::text
+			FROM suppliers WHERE is_active OR $1 <> 'true' ORDER BY code`, r.URL.Query().Get("active"))
+		if err != nil {
+			log.Println("Error fetching suppliers:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		suppliers := []Supplier{}
+		for rows.Next() {
+			var s Supplier
+			rows.Scan(&s.ID, &s.Code, &s.Name, &s.Phone, &s.Village, &s.MilkType, &s.BankAccount, &s.IFSC, &s.UPIID,
+				&s.WarehouseID, &s.IsActive, &s.CreatedAt)
+			suppliers = append(suppliers, s)
+		}
+		json.NewEncoder(w).Encode(suppliers)
+
+	case "POST", "PUT":
+		s := Supplier{IsActive: true}
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		s.Code = strings.ToUpper(strings.TrimSpace(s.Code))
+		s.Name = strings.TrimSpace(s.Name)
+		if s.Code == "" || s.Name == "" || s.MilkType == "" {
+			http.Error(w, errSupplierInvalid.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err := stockItemForMilk(db, s.MilkType); err != nil {
+			http.Error(w, "No milk product with this milk_type", http.StatusBadRequest)
+			return
+		}
+		var warehouseArg interface{}
+		if s.WarehouseID != 0 {
+			warehouseArg = s.WarehouseID
+		}
+		var err error
+		if r.Method == "POST" {
+			err = db.QueryRow(`
+				INSERT INTO suppliers (code, name, phone, village, milk_type, bank_account, ifsc, upi_id, warehouse_id, is_active)
+				VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, ''), $9, $10) RETURNING id
+			`, s.Code, s.Name, s.Phone, s.Village, s.MilkType, s.BankAccount, s.IFSC, s.UPIID, warehouseArg, s.IsActive).Scan(&s.ID)
+		} else {
+			err = db.QueryRow(`
+				UPDATE suppliers SET code = $1, name = $2, phone = NULLIF($3, ''), village = NULLIF($4, ''), milk_type = $5,
+				       bank_account = NULLIF($6, ''), ifsc = NULLIF($7, ''), upi_id = NULLIF($8, ''), warehouse_id = $9, is_active = $10
+				WHERE id = $11 RETURNING id
+			`, s.Code, s.Name, s.Phone, s.Village, s.MilkType, s.BankAccount, s.IFSC, s.UPIID, warehouseArg, s.IsActive, s.ID).Scan(&s.ID)
+		}
+		if err == sql.ErrNoRows {
+			http.Error(w, errSupplierNotFound.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println("Error saving supplier:", err)
+			http.Error(w, "Could not save supplier (the code may already be in use, or the warehouse does not exist)", http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": s.ID})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminRateChartsHandler lists charts for a milk type (GET ?milk_type=), quotes a
+// sample (GET ?milk_type&fat&snf&date) or saves a chart (POST)
+func adminRateChartsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		q := r.URL.Query()
+		milkType := q.Get("milk_type")
+		if milkType == "" {
+			http.Error(w, "milk_type is required", http.StatusBadRequest)
+			return
+		}
+		if q.Get("fat") != "" || q.Get("snf") != "" {
+			fat, _ := strconv.ParseFloat(q.Get("fat"), 64)
+			snf, _ := strconv.ParseFloat(q.Get("snf"), 64)
+			date := q.Get("date")
+			if date == "" {
+				date = time.Now().Format("2006-01-02")
+			}
+			chart, err := rateChartOn(db, milkType, date)
+			if err != nil {
+				writeProcurementError(w, err, "fetching rate chart")
+				return
+			}
+			rate, ok := chartRate(chart.Cells, fat, snf)
+			json.NewEncoder(w).Encode(map[string]interface{}{"accepted": ok, "rate": rate, "chart_id": chart.ID})
+			return
+		}
+
+		rows, err := db.Query(`SELECT id, milk_type, effective_from::text, COALESCE(created_by, ''), created_at::text
+			FROM procurement_rate_charts WHERE milk_type = $1 ORDER BY effective_from DESC`, milkType)
+		if err != nil {
+			log.Println("Error fetching rate charts:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		charts := []RateChart{}
+		for rows.Next() {
+			var c RateChart
+			rows.Scan(&c.ID, &c.MilkType, &c.EffectiveFrom, &c.CreatedBy, &c.CreatedAt)
+			charts = append(charts, c)
+		}
+		rows.Close()
+		for i := range charts {
+			loadRateCells(db, &charts[i])
+		}
+		json.NewEncoder(w).Encode(charts)
+
+	case "POST":
+		var c RateChart
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		actorEmail, _ := requestActor(r)
+		id, err := saveRateChart(c, actorEmail)
+		if err != nil {
+			writeProcurementError(w, err, "saving rate chart")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminCollectionsHandler lists a day's collections (GET ?date&shift&supplier_id),
+// records one (POST) or corrects one (PUT {id, litres, fat, snf})
+func adminCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		q := r.URL.Query()
+		date := q.Get("date")
+		if date == "" {
+			date = time.Now().Format("2006-01-02")
+		}
+		supplierID, _ := strconv.Atoi(q.Get("supplier_id"))
+		rows, err := db.Query(`SELECT `+collectionColumns+` FROM milk_collections c JOIN suppliers s ON s.id = c.supplier_id
+			WHERE c.collection_date = $1 AND ($2 = '' OR c.shift = $2) AND ($3 = 0 OR c.supplier_id = $3)
+			ORDER BY c.shift DESC, s.code`, date, q.Get("shift"), supplierID)
+		if err != nil {
+			log.Println("Error fetching collections:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		collections := []MilkCollection{}
+		var litres, amount float64
+		for rows.Next() {
+			c, err := scanCollection(rows)
+			if err != nil {
+				log.Println("Error scanning collection:", err)
+				continue
+			}
+			litres += c.Litres
+			amount += c.Amount
+			collections = append(collections, *c)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"date":        date,
+			"collections": collections,
+			"litres":      roundMoney(litres),
+			"amount":      roundMoney(amount),
+		})
+
+	case "POST", "PUT":
+		var in collectionInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		actorEmail, _ := requestActor(r)
+		var c *MilkCollection
+		var err error
+		if r.Method == "POST" {
+			c, err = recordCollection(in, actorEmail)
+		} else {
+			c, err = correctCollection(in, actorEmail)
+		}
+		if err != nil {
+			writeProcurementError(w, err, "saving collection")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "collection": c})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminSupplierStatementsHandler lists statements (GET ?supplier_id= or one with its
+// collections via ?id=), generates them for a period (POST {from, to}, default the
+// last completed cycle) or marks one paid (PUT {id, payment_ref})
+func adminSupplierStatementsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		q := r.URL.Query()
+		if id, _ := strconv.Atoi(q.Get("id")); id != 0 {
+			st, err := scanStatement(db.QueryRow(`SELECT `+statementColumns+` FROM supplier_statements st
+				JOIN suppliers s ON s.id = st.supplier_id WHERE st.id = $1`, id))
+			if err != nil {
+				writeProcurementError(w, err, "fetching statement")
+				return
+			}
+			rows, err := db.Query(`SELECT `+collectionColumns+` FROM milk_collections c JOIN suppliers s ON s.id = c.supplier_id
+				WHERE c.statement_id = $1 ORDER BY c.collection_date, c.shift DESC`, id)
+			if err != nil {
+				log.Println("Error fetching statement collections:", err)
+				http.Error(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			defer rows.Close()
+			st.Collections = []MilkCollection{}
+			for rows.Next() {
+				if c, err := scanCollection(rows); err == nil {
+					st.Collections = append(st.Collections, *c)
+				}
+			}
+			json.NewEncoder(w).Encode(st)
+			return
+		}
+
+		supplierID, _ := strconv.Atoi(q.Get("supplier_id"))
+		limit, offset := paginationParams(r)
+		rows, err := db.Query(`SELECT `+statementColumns+` FROM supplier_statements st JOIN suppliers s ON s.id = st.supplier_id
+			WHERE ($1 = 0 OR st.supplier_id = $1) AND ($2 = '' OR st.status = $2)
+			ORDER BY st.period_start DESC, s.code LIMIT $3 OFFSET $4`, supplierID, q.Get("status"), limit, offset)
+		if err != nil {
+			log.Println("Error fetching statements:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		statements := []SupplierStatement{}
+		for rows.Next() {
+			if st, err := scanStatement(rows); err == nil {
+				statements = append(statements, *st)
+			}
+		}
+		json.NewEncoder(w).Encode(statements)
+
+	case "POST":
+		var req struct {
+			From string `json:"from"`
+			To   string `json:"to"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		from, to := procurementPeriod(time.Now())
+		from, to = procurementPeriod(from.AddDate(0, 0, -1))
+		if req.From != "" || req.To != "" {
+			var err1, err2 error
+			from, err1 = time.Parse("2006-01-02", req.From)
+			to, err2 = time.Parse("2006-01-02", req.To)
+			if err1 != nil || err2 != nil || to.Before(from) {
+				http.Error(w, "from and to must be YYYY-MM-DD with from <= to", http.StatusBadRequest)
+				return
+			}
+		}
+		ids, err := generateStatements(from, to)
+		if err != nil {
+			log.Println("Error generating statements:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		sort.Ints(ids)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":       true,
+			"period_start":  from.Format("2006-01-02"),
+			"period_end":    to.Format("2006-01-02"),
+			"statement_ids": ids,
+		})
+
+	case "PUT":
+		var req struct {
+			ID         int    `json:"id"`
+			PaymentRef string `json:"payment_ref"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.PaymentRef) == "" {
+			http.Error(w, "payment_ref is required", http.StatusBadRequest)
+			return
+		}
+		res, err := db.Exec(`UPDATE supplier_statements SET status = $1, payment_ref = $2, paid_at = NOW()
+			WHERE id = $3 AND status = $4`, StatementPaid, strings.TrimSpace(req.PaymentRef), req.ID, StatementDraft)
+		if err != nil {
+			log.Println("Error marking statement paid:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Statement not found or already paid", http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
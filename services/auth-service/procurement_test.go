@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChartRate(t *testing.T) {
+	cells := []RateCell{
+		{FatFrom: 3.0, SNFFrom: 8.0, Rate: 30},
+		{FatFrom: 3.0, SNFFrom: 8.5, Rate: 32},
+		{FatFrom: 4.0, SNFFrom: 8.0, Rate: 36},
+		{FatFrom: 4.0, SNFFrom: 8.5, Rate: 38},
+		{FatFrom: 6.0, SNFFrom: 9.0, Rate: 50},
+	}
+
+	tests := []struct {
+		name     string
+		cells    []RateCell
+		fat, snf float64
+		want     float64
+		wantOK   bool
+	}{
+		{"exact lowest cell", cells, 3.0, 8.0, 30, true},
+		{"between thresholds takes the lower", cells, 3.5, 8.2, 30, true},
+		{"higher snf band", cells, 3.9, 8.7, 32, true},
+		{"higher fat band", cells, 4.2, 8.4, 36, true},
+		{"highest fat and snf at or below", cells, 5.5, 9.5, 38, true},
+		{"top cell", cells, 6.5, 9.1, 50, true},
+		{"high fat but snf below the top cell", cells, 6.5, 8.9, 38, true},
+		{"fat below every cell", cells, 2.9, 9.0, 0, false},
+		{"snf below every cell", cells, 4.5, 7.9, 0, false},
+		{"empty chart", nil, 4.0, 8.5, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := chartRate(tt.cells, tt.fat, tt.snf)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("chartRate(%v, %v) = %v, %v; want %v, %v", tt.fat, tt.snf, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestProcurementPeriod(t *testing.T) {
+	tests := []struct {
+		date      string
+		wantStart string
+		wantEnd   string
+	}{
+		{"2024-03-01", "2024-03-01", "2024-03-10"},
+		{"2024-03-10", "2024-03-01", "2024-03-10"},
+		{"2024-03-11", "2024-03-11", "2024-03-20"},
+		{"2024-03-20", "2024-03-11", "2024-03-20"},
+		{"2024-03-21", "2024-03-21", "2024-03-31"},
+		{"2024-03-31", "2024-03-21", "2024-03-31"},
+		{"2024-04-30", "2024-04-21", "2024-04-30"},
+		{"2024-02-25", "2024-02-21", "2024-02-29"},
+		{"2023-02-21", "2023-02-21", "2023-02-28"},
+		{"2024-12-31", "2024-12-21", "2024-12-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			d, _ := time.Parse("2006-01-02", tt.date)
+			start, end := procurementPeriod(d)
+			if got := start.Format("2006-01-02"); got != tt.wantStart {
+				t.Errorf("start = %s, want %s", got, tt.wantStart)
+			}
+			if got := end.Format("2006-01-02"); got != tt.wantEnd {
+				t.Errorf("end = %s, want %s", got, tt.wantEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,736 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// PRODUCT CATALOG
+// ============================================================================
+//
+// Categories (Milk, Meat, Fruits, Vegetables, ...) hold products; a product
+// can have variants (weight / cut type, each with its own price and stock)
+// and extra images. Products are archived rather than deleted so past orders
+// keep pointing at them. Milk products carry the milk_type whose price lives
+// in price_history; meat products carry the species that decides their tax
+// classification (gst_invoices.go). Stock is kept by the inventory ledger (inventory.go):
+// the stock columns here are its cache, and writing a stock number posts an
+// adjustment movement.
+//
+// Routes follow the admin app's productService:
+//   GET    /api/products?category=       public, cacheable listing
+//   GET    /api/products/{id}            public
+//   POST   /api/products                 admin
+//   PUT    /api/products/{id}            admin (partial update)
+//   DELETE /api/products/{id}            admin (archive)
+//   PUT    /api/products/{id}/stock      admin
+//   GET    /api/products/{id}/prep       public; PUT admin (see prep_options.go)
+//   GET    /api/products/categories      public
+
+type ProductCategory struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Slug      string `json:"slug"`
+	SortOrder int    `json:"sort_order"`
+	IsActive  bool   `json:"is_active"`
+}
+
+type ProductVariant struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	SKU         string  `json:"sku,omitempty"`
+	WeightGrams int     `json:"weight_grams,omitempty"`
+	CutType     string  `json:"cut_type,omitempty"`
+	Price       float64 `json:"price"`
+	Stock       int     `json:"stock"`
+	IsAvailable bool    `json:"isAvailable"`
+	SortOrder   int     `json:"sort_order"`
+}
+
+type ProductImage struct {
+	ID        int    `json:"id"`
+	URL       string `json:"url"`
+	SortOrder int    `json:"sort_order"`
+}
+
+// Product field names match the admin app's Product type
+type Product struct {
+	ID          int              `json:"id"`
+	CategoryID  int              `json:"category_id"`
+	Category    string           `json:"category"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Price       float64          `json:"price"`
+	Image       string           `json:"image"`
+	Images      []ProductImage   `json:"images"`
+	Weight      string           `json:"weight"`
+	Stock       int              `json:"stock"`
+	IsAvailable bool             `json:"isAvailable"`
+	SortOrder   int              `json:"sort_order"`
+	MilkType    string           `json:"milk_type,omitempty"`
+	Species     string           `json:"species,omitempty"`
+	Variants    []ProductVariant `json:"variants"`
+	UpdatedAt   string           `json:"updated_at"`
+}
+
+var (
+	errProductNotFound  = errors.New("product not found")
+	errUnknownCategory  = errors.New("unknown category")
+	errVariantNotFound  = errors.New("variant not found")
+	errNegativeStock    = errors.New("stock cannot be negative")
+	errProductNameEmpty = errors.New("name is required")
+)
+
+func initProductsSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS product_categories (
+			id SERIAL PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			slug TEXT UNIQUE NOT NULL,
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO product_categories (name, slug, sort_order) VALUES
+			('Milk', 'milk', 1), ('Meat', 'meat', 2), ('Fruits', 'fruits', 3), ('Vegetables', 'vegetables', 4)
+		ON CONFLICT (name) DO NOTHING;
+
+		CREATE TABLE IF NOT EXISTS products (
+			id SERIAL PRIMARY KEY,
+			category_id INTEGER NOT NULL REFERENCES product_categories(id),
+			name TEXT NOT NULL,
+			description TEXT,
+			price DECIMAL(10,2) NOT NULL DEFAULT 0,
+			image TEXT,
+			weight TEXT,
+			stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0),
+			is_available BOOLEAN NOT NULL DEFAULT true,
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			milk_type TEXT,
+			archived_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_products_category ON products(category_id, sort_order);
+
+		CREATE TABLE IF NOT EXISTS product_variants (
+			id SERIAL PRIMARY KEY,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			sku TEXT UNIQUE,
+			weight_grams INTEGER,
+			cut_type TEXT,
+			price DECIMAL(10,2) NOT NULL,
+			stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0),
+			is_available BOOLEAN NOT NULL DEFAULT true,
+			sort_order INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_product_variants_product ON product_variants(product_id, sort_order);
+
+		CREATE TABLE IF NOT EXISTS product_images (
+			id SERIAL PRIMARY KEY,
+			product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+			url TEXT NOT NULL,
+			sort_order INTEGER NOT NULL DEFAULT 0
+		);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create products schema:", err)
+	}
+
+	// The pricing rows were the only catalog so far: give each milk type a product
+	db.Exec(`
+		INSERT INTO products (category_id, name, description, price, weight, milk_type, sort_order)
+		SELECT c.id, INITCAP(p.milk_type) || ' Milk', 'Fresh ' || p.milk_type || ' milk', p.price, '1 L', p.milk_type, p.id
+		FROM pricing p JOIN product_categories c ON c.name = 'Milk'
+		WHERE NOT EXISTS (SELECT 1 FROM products pr WHERE pr.milk_type = p.milk_type)
+	`)
+}
+
+// --- Queries ---
+
+const productColumns = `p.id, p.category_id, c.name, p.name, COALESCE(p.description, ''), p.price, COALESCE(p.image, ''),
+	COALESCE(p.weight, ''), p.stock, p.is_available, p.sort_order, COALESCE(p.milk_type, ''),
+	COALESCE(p.species, ''), p.updated_at::text`
+
+func scanProduct(row interface{ Scan(...interface{}) error }) (*Product, error) {
+	var p Product
+	err := row.Scan(&p.ID, &p.CategoryID, &p.Category, &p.Name, &p.Description, &p.Price, &p.Image,
+		&p.Weight, &p.Stock, &p.IsAvailable, &p.SortOrder, &p.MilkType, &p.Species, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, errProductNotFound
+	}
+	return &p, err
+}
+
+// loadProductChildren fills variants and images for a set of products in two queries
+func loadProductChildren(q dbExecer, products []Product) error {
+	if len(products) == 0 {
+		return nil
+	}
+	index := map[int]*Product{}
+	ids := make([]string, len(products))
+	for i := range products {
+		products[i].Variants = []ProductVariant{}
+		products[i].Images = []ProductImage{}
+		index[products[i].ID] = &products[i]
+		ids[i] = strconv.Itoa(products[i].ID)
+	}
+	idList := "{" + strings.Join(ids, ",") + "}"
+
+	rows, err := q.Query(`
+		SELECT product_id, id, name, COALESCE(sku, ''), COALESCE(weight_grams, 0), COALESCE(cut_type, ''), price, stock, is_available, sort_order
+		FROM product_variants WHERE product_id = ANY($1::int[]) ORDER BY product_id, sort_order, id
+	`, idList)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var pid int
+		var v ProductVariant
+		rows.Scan(&pid, &v.ID, &v.Name, &v.SKU, &v.WeightGrams, &v.CutType, &v.Price, &v.Stock, &v.IsAvailable, &v.SortOrder)
+		index[pid].Variants = append(index[pid].Variants, v)
+	}
+	rows.Close()
+
+	rows, err = q.Query(`SELECT product_id, id, url, sort_order FROM product_images WHERE product_id = ANY($1::int[]) ORDER BY product_id, sort_order, id`, idList)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var pid int
+		var img ProductImage
+		rows.Scan(&pid, &img.ID, &img.URL, &img.SortOrder)
+		index[pid].Images = append(index[pid].Images, img)
+	}
+	rows.Close()
+	return nil
+}
+
+func loadProduct(q dbExecer, id int) (*Product, error) {
+	p, err := scanProduct(q.QueryRow(`SELECT `+productColumns+` FROM products p JOIN product_categories c ON p.category_id = c.id
+		WHERE p.id = $1 AND p.archived_at IS NULL`, id))
+	if err != nil {
+		return nil, err
+	}
+	list := []Product{*p}
+	if err := loadProductChildren(q, list); err != nil {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+// listProducts returns live products, optionally for one category (name or slug) and only available ones
+func listProducts(category string, availableOnly bool) ([]Product, error) {
+	rows, err := db.Query(`SELECT `+productColumns+` FROM products p JOIN product_categories c ON p.category_id = c.id
+		WHERE p.archived_at IS NULL
+		  AND ($1 = '' OR LOWER(c.name) = LOWER($1) OR c.slug = LOWER($1))
+		  AND (NOT $2 OR (p.is_available AND c.is_active))
+		ORDER BY c.sort_order, p.sort_order, p.name`, category, availableOnly)
+	if err != nil {
+		return nil, err
+	}
+	products := []Product{}
+	for rows.Next() {
+		p, err := scanProduct(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		products = append(products, *p)
+	}
+	rows.Close()
+	return products, loadProductChildren(db, products)
+}
+
+// catalogETag fingerprints the catalog state so listings can be revalidated cheaply
+func catalogETag(query string) string {
+	var stamp string
+	db.QueryRow(`
+		SELECT COALESCE(MAX(updated_at)::text, '') || '/' || COUNT(*) ||
+		       '/' || COALESCE((SELECT MAX(updated_at)::text FROM product_categories), '')
+		FROM products
+	`).Scan(&stamp)
+	sum := sha256.Sum256([]byte(stamp + "?" + query))
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func categoryIDByName(q dbExecer, name string) (int, error) {
+	var id int
+	err := q.QueryRow("SELECT id FROM product_categories WHERE LOWER(name) = LOWER($1) OR slug = LOWER($1)", name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errUnknownCategory
+	}
+	return id, err
+}
+
+// --- Writes ---
+
+// productInput is the admin payload; nil fields are left unchanged on update.
+// Variants and images, when present, replace the product's current set.
+type productInput struct {
+	Category    *string           `json:"category"`
+	CategoryID  *int              `json:"category_id"`
+	Name        *string           `json:"name"`
+	Description *string           `json:"description"`
+	Price       *float64          `json:"price"`
+	Image       *string           `json:"image"`
+	Weight      *string           `json:"weight"`
+	Stock       *int              `json:"stock"`
+	IsAvailable *bool             `json:"isAvailable"`
+	SortOrder   *int              `json:"sort_order"`
+	MilkType    *string           `json:"milk_type"`
+	Species     *string           `json:"species"`
+	Variants    *[]ProductVariant `json:"variants"`
+	Images      *[]ProductImage   `json:"images"`
+}
+
+// saveProduct creates (id == 0) or updates a product with its variants and images
+func saveProduct(id int, in productInput) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if in.CategoryID == nil && in.Category != nil {
+		cid, err := categoryIDByName(tx, *in.Category)
+		if err != nil {
+			return 0, err
+		}
+		in.CategoryID = &cid
+	}
+	if in.Stock != nil && *in.Stock < 0 {
+		return 0, errNegativeStock
+	}
+	if in.Name != nil && strings.TrimSpace(*in.Name) == "" {
+		return 0, errProductNameEmpty
+	}
+
+	if id == 0 {
+		if in.Name == nil {
+			return 0, errProductNameEmpty
+		}
+		if in.CategoryID == nil {
+			return 0, errUnknownCategory
+		}
+		err = tx.QueryRow("INSERT INTO products (category_id, name) VALUES ($1, $2) RETURNING id", *in.CategoryID, *in.Name).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+	} else if _, err := scanProduct(tx.QueryRow(`SELECT `+productColumns+` FROM products p JOIN product_categories c ON p.category_id = c.id
+		WHERE p.id = $1 AND p.archived_at IS NULL FOR UPDATE OF p`, id)); err != nil {
+		return 0, err
+	}
+
+	// COALESCE keeps the stored value for fields that weren't sent
+	_, err = tx.Exec(`
+		UPDATE products SET
+			category_id = COALESCE($2, category_id), name = COALESCE($3, name), description = COALESCE($4, description),
+			price = COALESCE($5, price), image = COALESCE($6, image), weight = COALESCE($7, weight),
+			is_available = COALESCE($8, is_available), sort_order = COALESCE($9, sort_order),
+			milk_type = COALESCE(NULLIF($10, ''), milk_type), species = COALESCE(NULLIF(LOWER($11), ''), species), updated_at = NOW()
+		WHERE id = $1
+	`, id, in.CategoryID, in.Name, in.Description, in.Price, in.Image, in.Weight, in.IsAvailable, in.SortOrder, in.MilkType, in.Species)
+	if err != nil {
+		if strings.Contains(err.Error(), "foreign key") {
+			return 0, errUnknownCategory
+		}
+		return 0, err
+	}
+
+	variantStock := map[int]int{}
+	if in.Variants != nil {
+		keep := []string{"0"}
+		for i, v := range *in.Variants {
+			if v.Stock < 0 {
+				return 0, errNegativeStock
+			}
+			if v.SortOrder == 0 {
+				v.SortOrder = i + 1
+			}
+			var sku interface{}
+			if v.SKU != "" {
+				sku = v.SKU
+			}
+			if v.ID > 0 {
+				res, err := tx.Exec(`
+					UPDATE product_variants SET name = $1, sku = $2, weight_grams = NULLIF($3, 0), cut_type = NULLIF($4, ''),
+						price = $5, is_available = $6, sort_order = $7
+					WHERE id = $8 AND product_id = $9
+				`, v.Name, sku, v.WeightGrams, v.CutType, v.Price, v.IsAvailable, v.SortOrder, v.ID, id)
+				if err != nil {
+					return 0, err
+				}
+				if n, _ := res.RowsAffected(); n == 0 {
+					return 0, errVariantNotFound
+				}
+			} else {
+				err := tx.QueryRow(`
+					INSERT INTO product_variants (product_id, name, sku, weight_grams, cut_type, price, is_available, sort_order)
+					VALUES ($1, $2, $3, NULLIF($4, 0), NULLIF($5, ''), $6, $7, $8) RETURNING id
+				`, id, v.Name, sku, v.WeightGrams, v.CutType, v.Price, v.IsAvailable, v.SortOrder).Scan(&v.ID)
+				if err != nil {
+					return 0, err
+				}
+			}
+			keep = append(keep, strconv.Itoa(v.ID))
+			variantStock[v.ID] = v.Stock
+		}
+		if _, err := tx.Exec("DELETE FROM product_variants WHERE product_id = $1 AND NOT (id = ANY($2::int[]))",
+			id, "{"+strings.Join(keep, ",")+"}"); err != nil {
+			return 0, err
+		}
+	}
+
+	if in.Images != nil {
+		if _, err := tx.Exec("DELETE FROM product_images WHERE product_id = $1", id); err != nil {
+			return 0, err
+		}
+		for i, img := range *in.Images {
+			if img.SortOrder == 0 {
+				img.SortOrder = i + 1
+			}
+			if _, err := tx.Exec("INSERT INTO product_images (product_id, url, sort_order) VALUES ($1, $2, $3)", id, img.URL, img.SortOrder); err != nil {
+				return 0, err
+			}
+		}
+		// The first image doubles as the primary one unless an image was set explicitly
+		if in.Image == nil && len(*in.Images) > 0 {
+			tx.Exec("UPDATE products SET image = $1 WHERE id = $2", (*in.Images)[0].URL, id)
+		}
+	}
+
+	if err := syncStockItems(tx); err != nil {
+		return 0, err
+	}
+	// Products with variants keep their stock per variant, so a product-level
+	// number only applies while there are none
+	if in.Stock != nil {
+		if err := setSellableStock(tx, id, 0, *in.Stock, "catalog"); err != nil && !errors.Is(err, errStockItemNotFound) {
+			return 0, err
+		}
+	}
+	for variantID, stock := range variantStock {
+		if err := setSellableStock(tx, id, variantID, stock, "catalog"); err != nil {
+			return 0, err
+		}
+	}
+	var milkType string
+	var price float64
+	var priced bool
+	tx.QueryRow(`SELECT COALESCE(p.milk_type, ''), p.price,
+		EXISTS (SELECT 1 FROM price_history h WHERE h.milk_type = p.milk_type AND h.zone_id IS NULL AND h.segment IS NULL)
+		FROM products p WHERE p.id = $1`, id).Scan(&milkType, &price, &priced)
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	// A new milk type (A2, ...) is sold at its product price until one is set in pricing
+	if milkType != "" && !priced && price > 0 {
+		today, _ := time.Parse("2006-01-02", time.Now().Format("2006-01-02"))
+		if _, err := setPrice(milkType, price, today, priceScope{}, "catalog"); err != nil {
+			log.Println("Error seeding price for milk type", milkType+":", err)
+		}
+	}
+	return id, nil
+}
+
+// setProductStock sets the sellable stock of a product, or of one of its
+// variants, by posting the difference to the inventory ledger
+func setProductStock(productID, variantID, stock int, actor string) error {
+	if stock < 0 {
+		return errNegativeStock
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if variantID != 0 {
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM product_variants v JOIN products p ON p.id = v.product_id
+			WHERE v.id = $1 AND v.product_id = $2 AND p.archived_at IS NULL)`, variantID, productID).Scan(&exists)
+	} else {
+		err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND archived_at IS NULL)", productID).Scan(&exists)
+	}
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if variantID != 0 {
+			return errVariantNotFound
+		}
+		return errProductNotFound
+	}
+	if err := syncStockItems(tx); err != nil {
+		return err
+	}
+	if err := setSellableStock(tx, productID, variantID, stock, actor); err != nil {
+		// A product with variants has no product-level stock
+		if errors.Is(err, errStockItemNotFound) {
+			return errVariantNotFound
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// productErrorStatus maps catalog errors to HTTP status codes
+func productErrorStatus(err error) int {
+	switch err {
+	case errProductNotFound, errVariantNotFound:
+		return http.StatusNotFound
+	case errUnknownCategory, errNegativeStock, errProductNameEmpty:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// --- Handlers ---
+
+// productsRouter dispatches /api/products and /api/products/... : reads are public, writes need an admin
+func productsRouter(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/products"), "/")
+	parts := strings.Split(rest, "/")
+
+	switch {
+	case rest == "":
+		if r.Method == "GET" {
+			listProductsHandler(w, r)
+		} else {
+			authMiddleware(adminCreateProductHandler, true)(w, r)
+		}
+	case rest == "categories":
+		productCategoriesHandler(w, r)
+	case len(parts) == 1:
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "GET" {
+			getProductHandler(w, r, id)
+		} else {
+			authMiddleware(func(w http.ResponseWriter, r *http.Request) { adminProductHandler(w, r, id) }, true)(w, r)
+		}
+	case len(parts) == 2 && parts[1] == "prep":
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		productPrepHandler(w, r, id)
+	case len(parts) == 2 && parts[1] == "stock":
+		id, err := strconv.Atoi(parts[0])
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		authMiddleware(func(w http.ResponseWriter, r *http.Request) { adminProductStockHandler(w, r, id) }, true)(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// listProductsHandler is the public catalog (?category=, ?available=true). Responses are
+// cacheable for a minute and revalidate with an ETag.
+func listProductsHandler(w http.ResponseWriter, r *http.Request) {
+	etag := catalogETag(r.URL.RawQuery)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	products, err := listProducts(r.URL.Query().Get("category"), r.URL.Query().Get("available") == "true")
+	if err != nil {
+		log.Println("Error fetching products:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(products)
+}
+
+func getProductHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	p, err := loadProduct(db, id)
+	if err != nil {
+		if err != errProductNotFound {
+			log.Println("Error fetching product:", err)
+		}
+		http.Error(w, err.Error(), productErrorStatus(err))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	json.NewEncoder(w).Encode(p)
+}
+
+func adminCreateProductHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var in productInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	id, err := saveProduct(0, in)
+	if err != nil {
+		log.Println("Error creating product:", err)
+		http.Error(w, err.Error(), productErrorStatus(err))
+		return
+	}
+	p, _ := loadProduct(db, id)
+	json.NewEncoder(w).Encode(p)
+}
+
+// adminProductHandler updates (PUT, partial) or archives (DELETE) a product
+func adminProductHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "PUT":
+		var in productInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if _, err := saveProduct(id, in); err != nil {
+			if productErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error updating product:", err)
+			}
+			http.Error(w, err.Error(), productErrorStatus(err))
+			return
+		}
+		p, _ := loadProduct(db, id)
+		json.NewEncoder(w).Encode(p)
+
+	case "DELETE":
+		res, err := db.Exec("UPDATE products SET archived_at = NOW(), is_available = false, updated_at = NOW() WHERE id = $1 AND archived_at IS NULL", id)
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "product not found", http.StatusNotFound)
+			return
+		}
+		syncStockItems(db)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminProductStockHandler sets stock: PUT {stock, variant_id}
+func adminProductStockHandler(w http.ResponseWriter, r *http.Request, id int) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Stock     *int `json:"stock"`
+		VariantID int  `json:"variant_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Stock == nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	actorEmail, _ := requestActor(r)
+	if err := setProductStock(id, req.VariantID, *req.Stock, actorEmail); err != nil {
+		http.Error(w, err.Error(), productErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id, "stock": *req.Stock})
+}
+
+// productCategoriesHandler lists categories publicly (GET); admins create or edit them (POST/PUT)
+func productCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		authMiddleware(adminProductCategoriesHandler, true)(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	rows, err := db.Query("SELECT id, name, slug, sort_order, is_active FROM product_categories ORDER BY sort_order, name")
+	if err != nil {
+		log.Println("Error fetching categories:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	categories := []ProductCategory{}
+	for rows.Next() {
+		var c ProductCategory
+		rows.Scan(&c.ID, &c.Name, &c.Slug, &c.SortOrder, &c.IsActive)
+		categories = append(categories, c)
+	}
+	json.NewEncoder(w).Encode(categories)
+}
+
+func adminProductCategoriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var c ProductCategory
+	if r.Method == "POST" || r.Method == "PUT" {
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil || strings.TrimSpace(c.Name) == "" {
+			http.Error(w, "name is required", http.StatusBadRequest)
+			return
+		}
+		if c.Slug == "" {
+			c.Slug = strings.ToLower(strings.Join(strings.Fields(c.Name), "-"))
+		}
+	}
+
+	var err error
+	switch r.Method {
+	case "POST":
+		err = db.QueryRow(`INSERT INTO product_categories (name, slug, sort_order, is_active) VALUES ($1, $2, $3, true) RETURNING id`,
+			c.Name, c.Slug, c.SortOrder).Scan(&c.ID)
+		c.IsActive = true
+	case "PUT":
+		err = db.QueryRow(`UPDATE product_categories SET name = $1, slug = $2, sort_order = $3, is_active = $4, updated_at = NOW()
+			WHERE id = $5 RETURNING id`, c.Name, c.Slug, c.SortOrder, c.IsActive, c.ID).Scan(&c.ID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "category not found", http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			http.Error(w, fmt.Sprintf("category %q already exists", c.Name), http.StatusConflict)
+			return
+		}
+		log.Println("Error saving category:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(c)
+}
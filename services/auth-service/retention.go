@@ -0,0 +1,491 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// RETENTION AND CHURN
+// ============================================================================
+//
+// Customers are grouped into cohorts by the month of their first subscription.
+// A customer is retained k months after signing up if any of their
+// subscriptions was live (not Cancelled) at that moment; statuses are replayed
+// from the subscription audit trail. Only customers who signed up at least k
+// months ago count towards the month-k rate.
+//
+// Cancelling a subscription requires a reason (and a note for "other"), kept
+// on the subscription with the time of cancellation; every status change goes
+// through setSubscriptionStatus so none can skip it. A subscription replaced
+// by a new one from the same customer is recorded as "replaced" and is not
+// churn.
+//
+// A live subscription is at risk when its skip rate over the last 14 days is
+// well above the 4 weeks before, when it was paused repeatedly in the last 60
+// days, or when auto-pay is on and the wallet won't last the warning window.
+//
+// Routes:
+//   /api/subscription/cancel-reasons   GET reasons a customer can pick from
+//   /api/admin/retention/cohorts       GET ?from=YYYY-MM&to=YYYY-MM&months=1,2,3,6
+//   /api/admin/retention/churn         GET ?from&to  cancellations by reason and the latest ones
+//   /api/admin/retention/at-risk       GET ?signal=  live subscriptions showing churn signals
+
+// Reasons a customer can give when cancelling a subscription
+var churnReasons = map[string]string{
+	"too_expensive":   "Too expensive",
+	"quality":         "Not happy with the milk quality",
+	"delivery_issues": "Delivery problems",
+	"moving":          "Moving away",
+	"not_needed":      "Don't need it anymore",
+	"switched":        "Switched to another supplier",
+	"other":           "Other",
+}
+
+// churnReplaced marks a subscription cancelled because the customer created a new one
+const churnReplaced = "replaced"
+
+var retentionMonths = []int{1, 2, 3, 6}
+
+// At-risk signals
+const (
+	RiskRisingSkips    = "rising_skips"
+	RiskRepeatedPauses = "repeated_pauses"
+	RiskLowWallet      = "low_wallet"
+
+	riskRecentDays    = 14
+	riskPriorDays     = 28
+	riskPauseDays     = 60
+	riskMinStops      = 4
+	riskSkipRate      = 0.25
+	riskSkipIncrease  = 0.15
+	riskPausesAllowed = 1
+)
+
+var errChurnReason = errors.New("a valid cancel_reason is required to cancel (with cancel_note for other)")
+
+func validChurnReason(reason, note string) bool {
+	if _, ok := churnReasons[reason]; !ok {
+		return false
+	}
+	return reason != "other" || strings.TrimSpace(note) != ""
+}
+
+func initRetentionSchema() {
+	_, err := db.Exec(`
+		ALTER TABLE milk_subscriptions ADD COLUMN IF NOT EXISTS cancel_reason TEXT;
+		ALTER TABLE milk_subscriptions ADD COLUMN IF NOT EXISTS cancel_note TEXT;
+		ALTER TABLE milk_subscriptions ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
+		CREATE INDEX IF NOT EXISTS idx_milk_subscriptions_user_created ON milk_subscriptions(user_id, created_at);
+
+		-- Subscriptions cancelled before reasons were captured keep the time they were cancelled
+		UPDATE milk_subscriptions ms SET cancelled_at = COALESCE(
+			(SELECT MAX(created_at) FROM subscription_events e WHERE e.subscription_id = ms.id AND e.event_type = 'cancelled'),
+			ms.updated_at)
+		WHERE ms.status = 'Cancelled' AND ms.cancelled_at IS NULL;
+	`)
+	if err != nil {
+		log.Fatal("Failed to create retention schema:", err)
+	}
+}
+
+// setSubscriptionStatus changes a subscription's status. Cancelling stores why
+// and when in the same statement, re-cancelling keeps the original record and
+// any other status forgets it (a subscription brought back to life).
+//
+// A subscription that stops delivering drops its scheduled deliveries so they
+// leave manifests, routes and the forecast and are never charged: from today
+// when paused or cancelled, from tomorrow when suspended for lack of funds.
+// Callers run this in the transaction that records the change and regenerate
+// deliveries with generateDeliveries once a subscription is Active again.
+func setSubscriptionStatus(q dbExecer, subID int, status, reason, note, actorEmail, actorRole string) error {
+	_, err := q.Exec(`
+		UPDATE milk_subscriptions SET
+			cancel_reason = CASE WHEN $1 <> 'Cancelled' THEN NULL WHEN status = 'Cancelled' THEN cancel_reason ELSE $2 END,
+			cancel_note = CASE WHEN $1 <> 'Cancelled' THEN NULL WHEN status = 'Cancelled' THEN cancel_note ELSE NULLIF($3, '') END,
+			cancelled_at = CASE WHEN $1 <> 'Cancelled' THEN NULL WHEN status = 'Cancelled' THEN cancelled_at ELSE NOW() END,
+			status = $1, updated_at = NOW()
+		WHERE id = $4`, status, reason, strings.TrimSpace(note), subID)
+	if err != nil || status == "Active" {
+		return err
+	}
+
+	from, dropReason := 0, reasonSubscriptionStopped
+	if status == SubStatusSuspendedNoFunds {
+		from, dropReason = 1, reasonInsufficientFunds
+	}
+	_, err = q.Exec(`
+		WITH dropped AS (
+			UPDATE deliveries SET status = $2, failure_reason = $3, status_updated_at = NOW()
+			WHERE subscription_id = $1 AND status = $4 AND delivery_date >= CURRENT_DATE + $5::int
+			RETURNING id
+		)
+		INSERT INTO delivery_status_events (delivery_id, from_status, to_status, reason_code, actor_email, actor_role)
+		SELECT id, $4, $2, $3, $6, $7 FROM dropped
+	`, subID, DeliveryCancelled, dropReason, DeliveryScheduled, from, actorEmail, actorRole)
+	return err
+}
+
+// CohortRetention is one cohort's retention at each month offset
+type CohortRetention struct {
+	Cohort    string           `json:"cohort"`
+	Customers int              `json:"customers"`
+	Retention []RetentionPoint `json:"retention"`
+}
+
+type RetentionPoint struct {
+	Month    int     `json:"month"`
+	Eligible int     `json:"eligible"`
+	Retained int     `json:"retained"`
+	Rate     float64 `json:"rate"`
+}
+
+// loadCohorts builds cohort retention for signup months in [from, to]
+func loadCohorts(from, to time.Time, months []int) ([]CohortRetention, error) {
+	monthList := make([]string, len(months))
+	for i, m := range months {
+		monthList[i] = strconv.Itoa(m)
+	}
+	at := "c.signup + k * INTERVAL '1 month'"
+	rows, err := db.Query(`
+		WITH cohort AS (
+			SELECT user_id, MIN(created_at) AS signup FROM milk_subscriptions
+			WHERE user_id IS NOT NULL GROUP BY user_id
+		)
+		SELECT to_char(c.signup, 'YYYY-MM'), k, COUNT(*),
+		       COUNT(*) FILTER (WHERE `+at+` <= NOW()),
+		       COUNT(*) FILTER (WHERE `+at+` <= NOW() AND EXISTS (
+		           SELECT 1 FROM milk_subscriptions ms
+		           WHERE ms.user_id = c.user_id AND ms.created_at <= `+at+`
+		             AND `+subscriptionStatusSQL(at)+` <> 'Cancelled'))
+		FROM cohort c CROSS JOIN unnest($3::int[]) AS k
+		WHERE c.signup >= $1 AND c.signup < $2
+		GROUP BY 1, 2 ORDER BY 1, 2
+	`, from.Format("2006-01-02"), to.AddDate(0, 1, 0).Format("2006-01-02"), "{"+strings.Join(monthList, ",")+"}")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cohorts := []CohortRetention{}
+	for rows.Next() {
+		var cohort string
+		var p RetentionPoint
+		var size int
+		rows.Scan(&cohort, &p.Month, &size, &p.Eligible, &p.Retained)
+		if len(cohorts) == 0 || cohorts[len(cohorts)-1].Cohort != cohort {
+			cohorts = append(cohorts, CohortRetention{Cohort: cohort, Customers: size, Retention: []RetentionPoint{}})
+		}
+		if p.Eligible > 0 {
+			p.Rate = roundQty(float64(p.Retained) / float64(p.Eligible))
+		}
+		c := &cohorts[len(cohorts)-1]
+		c.Retention = append(c.Retention, p)
+	}
+	return cohorts, nil
+}
+
+// AtRiskSubscription is a live subscription showing one or more churn signals
+type AtRiskSubscription struct {
+	SubscriptionID int      `json:"subscription_id"`
+	UserID         int      `json:"user_id"`
+	CustomerName   string   `json:"customer_name"`
+	Email          string   `json:"email"`
+	Mobile         string   `json:"mobile"`
+	Status         string   `json:"status"`
+	Signals        []string `json:"signals"`
+	RecentSkipRate float64  `json:"recent_skip_rate"`
+	PriorSkipRate  float64  `json:"prior_skip_rate"`
+	RecentStops    int      `json:"recent_stops"`
+	Pauses         int      `json:"pauses"`
+	WalletBalance  float64  `json:"wallet_balance"`
+	RunsOutOn      string   `json:"runs_out_on,omitempty"`
+	TenureDays     int      `json:"tenure_days"`
+}
+
+// usageSignals applies the skip and pause thresholds to a subscription's recent
+// history: skips are rising when enough recent stops were skipped often, and
+// clearly more often than before
+func usageSignals(recentStops int, recentSkipRate, priorSkipRate float64, pauses int) []string {
+	signals := []string{}
+	if recentStops >= riskMinStops && recentSkipRate >= riskSkipRate && recentSkipRate >= priorSkipRate+riskSkipIncrease {
+		signals = append(signals, RiskRisingSkips)
+	}
+	if pauses > riskPausesAllowed {
+		signals = append(signals, RiskRepeatedPauses)
+	}
+	return signals
+}
+
+// loadAtRisk evaluates every live subscription against the churn signals
+func loadAtRisk() ([]AtRiskSubscription, error) {
+	rows, err := db.Query(`
+		SELECT ms.id, ms.user_id, COALESCE(u.name, ''), u.email, COALESCE(u.mobile, ''), ms.status, ms.auto_pay,
+		       (CURRENT_DATE - ms.created_at::date),
+		       COUNT(d.id) FILTER (WHERE d.delivery_date >= CURRENT_DATE - $1::int),
+		       COUNT(d.id) FILTER (WHERE d.delivery_date >= CURRENT_DATE - $1::int AND d.status IN ($4, $5)),
+		       COUNT(d.id) FILTER (WHERE d.delivery_date < CURRENT_DATE - $1::int),
+		       COUNT(d.id) FILTER (WHERE d.delivery_date < CURRENT_DATE - $1::int AND d.status IN ($4, $5)),
+		       (SELECT COUNT(*) FROM subscription_events e
+		        WHERE e.subscription_id = ms.id AND e.event_type = $6 AND e.created_at >= CURRENT_DATE - $3::int)
+		FROM milk_subscriptions ms
+		JOIN users u ON u.id = ms.user_id
+		LEFT JOIN deliveries d ON d.subscription_id = ms.id
+		     AND d.delivery_date >= CURRENT_DATE - ($1::int + $2::int) AND d.delivery_date < CURRENT_DATE
+		     AND COALESCE(d.failure_reason, '') NOT IN ($7, $8)
+		WHERE ms.status <> 'Cancelled'
+		GROUP BY ms.id, u.id
+	`, riskRecentDays, riskPriorDays, riskPauseDays, DeliverySkipped, DeliveryCancelled, SubEventPaused, reasonInsufficientFunds, reasonSubscriptionStopped)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		AtRiskSubscription
+		autoPay bool
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		var recentSkips, priorStops, priorSkips int
+		rows.Scan(&c.SubscriptionID, &c.UserID, &c.CustomerName, &c.Email, &c.Mobile, &c.Status, &c.autoPay, &c.TenureDays,
+			&c.RecentStops, &recentSkips, &priorStops, &priorSkips, &c.Pauses)
+		if c.RecentStops > 0 {
+			c.RecentSkipRate = roundQty(float64(recentSkips) / float64(c.RecentStops))
+		}
+		if priorStops > 0 {
+			c.PriorSkipRate = roundQty(float64(priorSkips) / float64(priorStops))
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+
+	autoPay := map[int]int{}
+	for _, c := range candidates {
+		if c.autoPay {
+			autoPay[c.SubscriptionID] = c.UserID
+		}
+	}
+	projections, err := projectSubscriptionsFunding(autoPay)
+	if err != nil {
+		return nil, err
+	}
+
+	atRisk := []AtRiskSubscription{}
+	warnBy := time.Now().AddDate(0, 0, lowBalanceWarnDays())
+	for _, c := range candidates {
+		c.Signals = usageSignals(c.RecentStops, c.RecentSkipRate, c.PriorSkipRate, c.Pauses)
+		if c.autoPay {
+			if p, ok := projections[c.SubscriptionID]; ok {
+				c.WalletBalance = roundMoney(p.Balance)
+				if !p.RunsOutOn.IsZero() {
+					c.RunsOutOn = p.RunsOutOn.Format("2006-01-02")
+				}
+				if c.Status == SubStatusSuspendedNoFunds || (!p.RunsOutOn.IsZero() && p.RunsOutOn.Before(warnBy)) {
+					c.Signals = append(c.Signals, RiskLowWallet)
+				}
+			}
+		}
+		if len(c.Signals) > 0 {
+			atRisk = append(atRisk, c.AtRiskSubscription)
+		}
+	}
+	sort.SliceStable(atRisk, func(i, j int) bool {
+		if len(atRisk[i].Signals) != len(atRisk[j].Signals) {
+			return len(atRisk[i].Signals) > len(atRisk[j].Signals)
+		}
+		return atRisk[i].RecentSkipRate > atRisk[j].RecentSkipRate
+	})
+	return atRisk, nil
+}
+
+// --- Handlers ---
+
+// subscriptionCancelReasonsHandler lists the reasons a customer can pick when cancelling
+func subscriptionCancelReasonsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"cancel_reasons": churnReasons})
+}
+
+// adminCohortsHandler returns retention by signup month
+func adminCohortsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -11, 0)
+	var err error
+	if v := q.Get("to"); v != "" {
+		if to, err = time.Parse("2006-01", v); err != nil {
+			http.Error(w, "to must be YYYY-MM", http.StatusBadRequest)
+			return
+		}
+		from = to.AddDate(0, -11, 0)
+	}
+	if v := q.Get("from"); v != "" {
+		if from, err = time.Parse("2006-01", v); err != nil {
+			http.Error(w, "from must be YYYY-MM", http.StatusBadRequest)
+			return
+		}
+	}
+	if from.After(to) || from.AddDate(3, 0, 0).Before(to) {
+		http.Error(w, "from must be before to, at most 3 years apart", http.StatusBadRequest)
+		return
+	}
+	months := retentionMonths
+	if v := q.Get("months"); v != "" {
+		months = nil
+		for _, part := range strings.Split(v, ",") {
+			m, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || m < 1 || m > 36 {
+				http.Error(w, "months must be a list of numbers between 1 and 36", http.StatusBadRequest)
+				return
+			}
+			months = append(months, m)
+		}
+		sort.Ints(months)
+	}
+
+	cohorts, err := loadCohorts(from, to, months)
+	if err != nil {
+		log.Println("Error computing cohorts:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":    from.Format("2006-01"),
+		"to":      to.Format("2006-01"),
+		"months":  months,
+		"cohorts": cohorts,
+	})
+}
+
+// adminChurnHandler summarises cancellations by reason over a date range
+func adminChurnHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	from, to, err := inventoryRange(r)
+	if err != nil {
+		http.Error(w, "from and to must be YYYY-MM-DD with from <= to", http.StatusBadRequest)
+		return
+	}
+	fromStr, toStr := from.Format("2006-01-02"), to.Format("2006-01-02")
+
+	type reasonCount struct {
+		Reason        string  `json:"reason"`
+		Label         string  `json:"label"`
+		Cancellations int     `json:"cancellations"`
+		AvgTenureDays float64 `json:"avg_tenure_days"`
+		SharePercent  float64 `json:"share_pct"`
+	}
+	byReason := []reasonCount{}
+	rows, err := db.Query(`
+		SELECT COALESCE(cancel_reason, 'unspecified'), COUNT(*), AVG(cancelled_at::date - created_at::date)
+		FROM milk_subscriptions
+		WHERE status = 'Cancelled' AND cancelled_at::date BETWEEN $1 AND $2 AND cancel_reason IS DISTINCT FROM $3
+		GROUP BY 1 ORDER BY 2 DESC
+	`, fromStr, toStr, churnReplaced)
+	if err != nil {
+		log.Println("Error fetching churn reasons:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	total := 0
+	for rows.Next() {
+		var c reasonCount
+		rows.Scan(&c.Reason, &c.Cancellations, &c.AvgTenureDays)
+		c.Label = churnReasons[c.Reason]
+		if c.Label == "" {
+			c.Label = "Not recorded"
+		}
+		c.AvgTenureDays = roundMoney(c.AvgTenureDays)
+		total += c.Cancellations
+		byReason = append(byReason, c)
+	}
+	rows.Close()
+	for i := range byReason {
+		byReason[i].SharePercent = roundMoney(100 * float64(byReason[i].Cancellations) / float64(total))
+	}
+
+	type cancellation struct {
+		SubscriptionID int    `json:"subscription_id"`
+		UserID         int    `json:"user_id"`
+		CustomerName   string `json:"customer_name"`
+		Reason         string `json:"reason"`
+		Note           string `json:"note,omitempty"`
+		CancelledAt    string `json:"cancelled_at"`
+		TenureDays     int    `json:"tenure_days"`
+	}
+	recent := []cancellation{}
+	limit, offset := paginationParams(r)
+	rows, err = db.Query(`
+		SELECT ms.id, ms.user_id, COALESCE(u.name, u.email, ''), COALESCE(ms.cancel_reason, 'unspecified'), COALESCE(ms.cancel_note, ''),
+		       ms.cancelled_at::text, (ms.cancelled_at::date - ms.created_at::date)
+		FROM milk_subscriptions ms LEFT JOIN users u ON u.id = ms.user_id
+		WHERE ms.status = 'Cancelled' AND ms.cancelled_at::date BETWEEN $1 AND $2 AND ms.cancel_reason IS DISTINCT FROM $3
+		ORDER BY ms.cancelled_at DESC LIMIT $4 OFFSET $5
+	`, fromStr, toStr, churnReplaced, limit, offset)
+	if err != nil {
+		log.Println("Error fetching cancellations:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var c cancellation
+		rows.Scan(&c.SubscriptionID, &c.UserID, &c.CustomerName, &c.Reason, &c.Note, &c.CancelledAt, &c.TenureDays)
+		recent = append(recent, c)
+	}
+	rows.Close()
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":          fromStr,
+		"to":            toStr,
+		"cancellations": total,
+		"by_reason":     byReason,
+		"recent":        recent,
+	})
+}
+
+// adminAtRiskHandler lists live subscriptions showing churn signals, most signals first
+func adminAtRiskHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	atRisk, err := loadAtRisk()
+	if err != nil {
+		log.Println("Error computing at-risk subscriptions:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if signal := r.URL.Query().Get("signal"); signal != "" {
+		filtered := []AtRiskSubscription{}
+		for _, s := range atRisk {
+			for _, sig := range s.Signals {
+				if sig == signal {
+					filtered = append(filtered, s)
+					break
+				}
+			}
+		}
+		atRisk = filtered
+	}
+	json.NewEncoder(w).Encode(atRisk)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidChurnReason(t *testing.T) {
+	tests := []struct {
+		reason, note string
+		want         bool
+	}{
+		{"too_expensive", "", true},
+		{"moving", "relocating to Pune", true},
+		{"other", "milk arrives too late for us", true},
+		{"other", "", false},
+		{"other", "   ", false},
+		{"", "", false},
+		{"Too expensive", "", false},
+		{churnReplaced, "", false}, // only recorded by the system
+	}
+	for _, tt := range tests {
+		t.Run(tt.reason+"/"+tt.note, func(t *testing.T) {
+			if got := validChurnReason(tt.reason, tt.note); got != tt.want {
+				t.Errorf("validChurnReason(%q, %q) = %v, want %v", tt.reason, tt.note, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageSignals(t *testing.T) {
+	tests := []struct {
+		name        string
+		recentStops int
+		recent      float64
+		prior       float64
+		pauses      int
+		want        []string
+	}{
+		{"quiet subscription", 14, 0, 0, 0, []string{}},
+		{"skips rising", 14, 0.5, 0.1, 0, []string{RiskRisingSkips}},
+		{"at the skip rate threshold", 8, riskSkipRate, 0, 0, []string{RiskRisingSkips}},
+		{"just below the skip rate threshold", 8, riskSkipRate - 0.01, 0, 0, []string{}},
+		{"too few recent stops", riskMinStops - 1, 1, 0, 0, []string{}},
+		{"enough recent stops", riskMinStops, 1, 0, 0, []string{RiskRisingSkips}},
+		{"always skipped this much", 14, 0.5, 0.4, 0, []string{}},
+		{"one pause allowed", 14, 0, 0, riskPausesAllowed, []string{}},
+		{"repeated pauses", 14, 0, 0, riskPausesAllowed + 1, []string{RiskRepeatedPauses}},
+		{"both", 14, 0.6, 0, 3, []string{RiskRisingSkips, RiskRepeatedPauses}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := usageSignals(tt.recentStops, tt.recent, tt.prior, tt.pauses)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("usageSignals(%d, %v, %v, %d) = %v, want %v", tt.recentStops, tt.recent, tt.prior, tt.pauses, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// DELIVERY RIDERS
+// ============================================================================
+
+// RoleRider is the users.role value for delivery riders
+const RoleRider = "rider"
+
+type Rider struct {
+	ID        int    `json:"id"`
+	Email     string `json:"email"`
+	Mobile    string `json:"mobile"`
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Assigned  int    `json:"assigned_today"`
+	CreatedAt string `json:"created_at"`
+}
+
+// RiderStop is one delivery on a rider's route, with what they need to find the customer
+type RiderStop struct {
+	Delivery
+	RouteSeq      int     `json:"route_seq"`
+	Landmark      string  `json:"landmark"`
+	ReceiverName  string  `json:"receiver_name"`
+	ReceiverPhone string  `json:"receiver_phone"`
+	Latitude      float64 `json:"latitude"`
+	Longitude     float64 `json:"longitude"`
+}
+
+func initRidersSchema() {
+	_, err := db.Exec(`
+		ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS rider_id INTEGER REFERENCES users(id);
+		ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS route_seq INTEGER;
+		ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS proof_photo_url TEXT;
+		ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS proof_latitude DECIMAL(10,8);
+		ALTER TABLE deliveries ADD COLUMN IF NOT EXISTS proof_longitude DECIMAL(11,8);
+		CREATE INDEX IF NOT EXISTS idx_deliveries_rider_date ON deliveries(rider_id, delivery_date, slot_type);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create riders schema:", err)
+	}
+}
+
+// requireRider wraps a handler that has already passed authMiddleware and lets riders (and admins
+// acting for a rider, see currentRider) through
+func requireRider(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value("role").(string)
+		if role != RoleRider && role != "admin" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// currentRider resolves the rider a request acts for: the signed-in rider, or for an
+// admin the rider named by ?rider_id= (an admin has no deliveries of their own)
+func currentRider(r *http.Request) (id int, name string, err error) {
+	if role, _ := r.Context().Value("role").(string); role == "admin" {
+		riderID, convErr := strconv.Atoi(r.URL.Query().Get("rider_id"))
+		if convErr != nil {
+			return 0, "", sql.ErrNoRows
+		}
+		err = db.QueryRow("SELECT id, COALESCE(name, email) FROM users WHERE id = $1 AND role = $2", riderID, RoleRider).Scan(&id, &name)
+		return id, name, err
+	}
+	email, _ := r.Context().Value("email").(string)
+	err = db.QueryRow("SELECT id, COALESCE(name, email) FROM users WHERE email = $1", email).Scan(&id, &name)
+	return id, name, err
+}
+
+// --- Admin rider management ---
+
+func adminRidersHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		rows, err := db.Query(`
+			SELECT u.id, u.email, u.mobile, COALESCE(u.name, ''), COALESCE(u.status, 'Active'), u.created_at::text,
+			       (SELECT COUNT(*) FROM deliveries d WHERE d.rider_id = u.id AND d.delivery_date = CURRENT_DATE)
+			FROM users u WHERE u.role = $1
+			ORDER BY u.name
+		`, RoleRider)
+		if err != nil {
+			log.Println("Error fetching riders:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+
+		riders := []Rider{}
+		for rows.Next() {
+			var rd Rider
+			rows.Scan(&rd.ID, &rd.Email, &rd.Mobile, &rd.Name, &rd.Status, &rd.CreatedAt, &rd.Assigned)
+			riders = append(riders, rd)
+		}
+		json.NewEncoder(w).Encode(riders)
+
+	case "POST":
+		// Create a rider, or promote an existing user to rider
+		var req struct {
+			Email  string `json:"email"`
+			Mobile string `json:"mobile"`
+			Name   string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Email == "" || req.Mobile == "" {
+			http.Error(w, "email and mobile are required", http.StatusBadRequest)
+			return
+		}
+
+		var id int
+		var existingRole string
+		err := db.QueryRow(`
+			INSERT INTO users (email, mobile, name, role, status)
+			VALUES ($1, $2, $3, $4, 'Active')
+			ON CONFLICT (email) DO UPDATE SET role = CASE WHEN users.role = 'admin' THEN users.role ELSE $4 END, updated_at = NOW()
+			RETURNING id, role
+		`, req.Email, req.Mobile, req.Name, RoleRider).Scan(&id, &existingRole)
+		if err != nil {
+			log.Println("Error creating rider:", err)
+			http.Error(w, "Failed to create rider (mobile may already be in use)", http.StatusConflict)
+			return
+		}
+		if existingRole != RoleRider {
+			http.Error(w, "An admin account cannot be made a rider", http.StatusConflict)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id})
+
+	case "DELETE":
+		// Demote a rider back to a regular user and release their open deliveries
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		result, err := db.Exec("UPDATE users SET role = 'user', updated_at = NOW() WHERE id = $1 AND role = $2", id, RoleRider)
+		if err != nil {
+			http.Error(w, "Failed to remove rider", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Rider not found", http.StatusNotFound)
+			return
+		}
+		db.Exec(`
+			UPDATE deliveries SET rider_id = NULL, route_seq = NULL
+			WHERE rider_id = $1 AND status IN ('Scheduled', 'Packed')
+		`, id)
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminAssignDeliveriesHandler (re)assigns deliveries to a rider in bulk.
+//
+// Either pass delivery_ids explicitly (their order becomes the route order), or
+// pass date + slot_type (+ optional from_rider_id) to move a whole slot at once.
+// rider_id 0 unassigns.
+func adminAssignDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		RiderID     int    `json:"rider_id"`
+		DeliveryIDs []int  `json:"delivery_ids"`
+		Date        string `json:"date"`
+		SlotType    string `json:"slot_type"`
+		FromRiderID int    `json:"from_rider_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	var riderArg interface{}
+	if req.RiderID != 0 {
+		var role string
+		if err := db.QueryRow("SELECT COALESCE(role, 'user') FROM users WHERE id = $1", req.RiderID).Scan(&role); err != nil || role != RoleRider {
+			http.Error(w, "Rider not found", http.StatusNotFound)
+			return
+		}
+		riderArg = req.RiderID
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	assigned := 0
+	if len(req.DeliveryIDs) > 0 {
+		// Append after whatever the rider already has on this date/slot
+		for _, id := range req.DeliveryIDs {
+			result, err := tx.Exec(`
+				UPDATE deliveries d SET rider_id = $1,
+				       route_seq = CASE WHEN $1::int IS NULL THEN NULL ELSE (
+				           SELECT COALESCE(MAX(o.route_seq), 0) + 1 FROM deliveries o
+				           WHERE o.rider_id = $1 AND o.delivery_date = d.delivery_date AND o.slot_type = d.slot_type
+				       ) END
+				WHERE d.id = $2 AND d.status NOT IN ('Delivered', 'Failed', 'Cancelled')
+			`, riderArg, id)
+			if err != nil {
+				log.Println("Error assigning delivery:", err)
+				http.Error(w, "Failed to assign deliveries", http.StatusInternalServerError)
+				return
+			}
+			n, _ := result.RowsAffected()
+			assigned += int(n)
+		}
+	} else {
+		if req.Date == "" || req.SlotType == "" {
+			http.Error(w, "delivery_ids or date and slot_type are required", http.StatusBadRequest)
+			return
+		}
+		if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		var fromArg interface{}
+		if req.FromRiderID != 0 {
+			fromArg = req.FromRiderID
+		}
+		result, err := tx.Exec(`
+			UPDATE deliveries SET rider_id = $1, route_seq = CASE WHEN $1::int IS NULL THEN NULL ELSE route_seq END
+			WHERE delivery_date = $2 AND slot_type = $3
+			  AND status NOT IN ('Delivered', 'Failed', 'Cancelled')
+			  AND ($4::int IS NULL OR rider_id = $4)
+		`, riderArg, req.Date, req.SlotType, fromArg)
+		if err != nil {
+			log.Println("Error reassigning slot:", err)
+			http.Error(w, "Failed to assign deliveries", http.StatusInternalServerError)
+			return
+		}
+		n, _ := result.RowsAffected()
+		assigned = int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to assign deliveries", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "assigned": assigned})
+}
+
+// --- Rider-facing API ---
+
+// queryRiderStops lists a rider's deliveries for a date/slot in route order
+func queryRiderStops(riderID int, date, slotType string) ([]RiderStop, error) {
+	rows, err := db.Query(`
+		SELECT d.id, d.subscription_id, d.slot_id, d.user_id, d.delivery_date::text, d.slot_type, d.quantity, d.milk_type,
+		       COALESCE(d.address, ''), COALESCE(d.customer_name, ''), d.status,
+		       COALESCE(d.delivered_at::text, ''), COALESCE(d.delivered_by, ''), COALESCE(d.failure_reason, ''),
+		       COALESCE(d.route_seq, 0), COALESCE(a.landmark, ''), COALESCE(a.receiver_name, ''), COALESCE(a.receiver_phone, ''),
+		       COALESCE(a.latitude, 0), COALESCE(a.longitude, 0)
+		FROM deliveries d
+		LEFT JOIN milk_subscriptions ms ON d.subscription_id = ms.id
+		LEFT JOIN addresses a ON ms.address_id = a.id
+		WHERE d.rider_id = $1 AND d.delivery_date = $2 AND ($3 = '' OR d.slot_type = $3)
+		ORDER BY d.slot_type, d.route_seq NULLS LAST, d.customer_name
+	`, riderID, date, slotType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stops := []RiderStop{}
+	for rows.Next() {
+		var s RiderStop
+		rows.Scan(&s.ID, &s.SubscriptionID, &s.SlotID, &s.UserID, &s.DeliveryDate, &s.SlotType, &s.Quantity, &s.MilkType,
+			&s.Address, &s.CustomerName, &s.Status, &s.DeliveredAt, &s.DeliveredBy, &s.FailureReason,
+			&s.RouteSeq, &s.Landmark, &s.ReceiverName, &s.ReceiverPhone, &s.Latitude, &s.Longitude)
+		stops = append(stops, s)
+	}
+	return stops, nil
+}
+
+func riderDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	riderID, _, err := currentRider(r)
+	if err != nil {
+		http.Error(w, "Rider not found", http.StatusNotFound)
+		return
+	}
+
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+
+	stops, err := queryRiderStops(riderID, date, r.URL.Query().Get("slot"))
+	if err != nil {
+		log.Println("Error fetching rider deliveries:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(stops)
+}
+
+// riderUpdateDeliveryHandler lets a rider pick up, deliver or fail one of their own deliveries
+func riderUpdateDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID         int      `json:"id"`
+		Status     string   `json:"status"`
+		ReasonCode string   `json:"reason_code"`
+		Note       string   `json:"note"`
+		PhotoURL   string   `json:"photo_url"`
+		Latitude   *float64 `json:"latitude"`
+		Longitude  *float64 `json:"longitude"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if req.Status != DeliveryOutForDelivery && req.Status != DeliveryDelivered && req.Status != DeliveryFailed {
+		http.Error(w, "Riders may only set OutForDelivery, Delivered or Failed", http.StatusBadRequest)
+		return
+	}
+
+	riderID, riderName, err := currentRider(r)
+	if err != nil {
+		http.Error(w, "Rider not found", http.StatusNotFound)
+		return
+	}
+
+	actorEmail, actorRole := requestActor(r)
+	err = transitionDelivery(req.ID, deliveryTransition{
+		To:            req.Status,
+		ReasonCode:    req.ReasonCode,
+		Note:          req.Note,
+		DeliveredBy:   riderName,
+		ActorEmail:    actorEmail,
+		ActorRole:     actorRole,
+		RiderID:       riderID,
+		ProofPhotoURL: req.PhotoURL,
+		Latitude:      req.Latitude,
+		Longitude:     req.Longitude,
+	})
+	if err != nil {
+		if deliveryErrorStatus(err) == http.StatusInternalServerError {
+			log.Println("Error updating delivery:", err)
+			http.Error(w, "Failed to update delivery", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, err.Error(), deliveryErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// ROUTE PLANNING
+// ============================================================================
+//
+// The planner itself (PlanRoutes and helpers) is pure Go with no database access,
+// so it can be exercised offline with hand-built stops.
+
+const earthRadiusKm = 6371.0
+
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+func (p GeoPoint) located() bool {
+	return p.Lat != 0 || p.Lng != 0
+}
+
+// RouteStop is one delivery to be placed on a route
+type RouteStop struct {
+	DeliveryID int      `json:"delivery_id"`
+	Customer   string   `json:"customer_name,omitempty"`
+	Location   GeoPoint `json:"location"`
+	Litres     float64  `json:"litres"`
+}
+
+type PlannedRoute struct {
+	Stops      []RouteStop `json:"stops"`
+	Litres     float64     `json:"litres"`
+	DistanceKm float64     `json:"distance_km"`
+	RiderID    int         `json:"rider_id,omitempty"`
+}
+
+type RoutePlan struct {
+	Depot      GeoPoint       `json:"depot"`
+	Capacity   float64        `json:"capacity_litres"`
+	Routes     []PlannedRoute `json:"routes"`
+	Unrouted   []RouteStop    `json:"unrouted"` // stops without coordinates
+	DistanceKm float64        `json:"total_distance_km"`
+}
+
+// haversineKm returns the great-circle distance between two points
+func haversineKm(a, b GeoPoint) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// routeDistance is the length of depot -> stops... -> depot
+func routeDistance(depot GeoPoint, stops []RouteStop) float64 {
+	if len(stops) == 0 {
+		return 0
+	}
+	total := haversineKm(depot, stops[0].Location)
+	for i := 1; i < len(stops); i++ {
+		total += haversineKm(stops[i-1].Location, stops[i].Location)
+	}
+	return total + haversineKm(stops[len(stops)-1].Location, depot)
+}
+
+// PlanRoutes splits stops into capacity-limited routes and orders each one.
+//
+// Clustering uses a sweep around the depot (stops sorted by bearing, filled
+// until the next stop would exceed capacity), which keeps each rider in one
+// sector of town. Each route is then ordered nearest-neighbour from the depot
+// and improved with 2-opt. A capacity <= 0 means a single unlimited route.
+func PlanRoutes(depot GeoPoint, stops []RouteStop, capacity float64) RoutePlan {
+	plan := RoutePlan{Depot: depot, Capacity: capacity, Routes: []PlannedRoute{}, Unrouted: []RouteStop{}}
+
+	var located []RouteStop
+	for _, s := range stops {
+		if s.Location.located() {
+			located = append(located, s)
+		} else {
+			plan.Unrouted = append(plan.Unrouted, s)
+		}
+	}
+
+	for _, cluster := range sweepClusters(depot, located, capacity) {
+		ordered := twoOpt(depot, nearestNeighbour(depot, cluster))
+		route := PlannedRoute{Stops: ordered, DistanceKm: routeDistance(depot, ordered)}
+		for _, s := range ordered {
+			route.Litres += s.Litres
+		}
+		plan.DistanceKm += route.DistanceKm
+		plan.Routes = append(plan.Routes, route)
+	}
+	return plan
+}
+
+func bearing(from, to GeoPoint) float64 {
+	return math.Atan2(to.Lat-from.Lat, (to.Lng-from.Lng)*math.Cos(from.Lat*math.Pi/180))
+}
+
+func sweepClusters(depot GeoPoint, stops []RouteStop, capacity float64) [][]RouteStop {
+	if len(stops) == 0 {
+		return nil
+	}
+	sorted := append([]RouteStop(nil), stops...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		bi, bj := bearing(depot, sorted[i].Location), bearing(depot, sorted[j].Location)
+		if bi != bj {
+			return bi < bj
+		}
+		return haversineKm(depot, sorted[i].Location) < haversineKm(depot, sorted[j].Location)
+	})
+
+	var clusters [][]RouteStop
+	var current []RouteStop
+	var load float64
+	for _, s := range sorted {
+		// A single stop larger than capacity still gets its own route
+		if capacity > 0 && len(current) > 0 && load+s.Litres > capacity {
+			clusters = append(clusters, current)
+			current, load = nil, 0
+		}
+		current = append(current, s)
+		load += s.Litres
+	}
+	return append(clusters, current)
+}
+
+func nearestNeighbour(depot GeoPoint, stops []RouteStop) []RouteStop {
+	remaining := append([]RouteStop(nil), stops...)
+	ordered := make([]RouteStop, 0, len(stops))
+	pos := depot
+	for len(remaining) > 0 {
+		best := 0
+		bestDist := math.Inf(1)
+		for i, s := range remaining {
+			if d := haversineKm(pos, s.Location); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		pos = remaining[best].Location
+		ordered = append(ordered, remaining[best])
+		remaining = append(remaining[:best], remaining[best+1:]...)
+	}
+	return ordered
+}
+
+// twoOpt repeatedly reverses segments of the tour while that shortens it
+func twoOpt(depot GeoPoint, stops []RouteStop) []RouteStop {
+	n := len(stops)
+	if n < 3 {
+		return stops
+	}
+	// points[0] and points[n+1] are the depot
+	point := func(i int) GeoPoint {
+		if i == 0 || i == n+1 {
+			return depot
+		}
+		return stops[i-1].Location
+	}
+
+	improved := true
+	for iter := 0; improved && iter < 1000; iter++ {
+		improved = false
+		for i := 1; i < n; i++ {
+			for j := i + 1; j <= n; j++ {
+				before := haversineKm(point(i-1), point(i)) + haversineKm(point(j), point(j+1))
+				after := haversineKm(point(i-1), point(j)) + haversineKm(point(i), point(j+1))
+				if after < before-1e-9 {
+					for a, b := i-1, j-1; a < b; a, b = a+1, b-1 {
+						stops[a], stops[b] = stops[b], stops[a]
+					}
+					improved = true
+				}
+			}
+		}
+	}
+	return stops
+}
+
+// --- HTTP ---
+
+// defaultDepot reads the depot location from DEPOT_LAT / DEPOT_LNG
+func defaultDepot() GeoPoint {
+	lat, _ := strconv.ParseFloat(os.Getenv("DEPOT_LAT"), 64)
+	lng, _ := strconv.ParseFloat(os.Getenv("DEPOT_LNG"), 64)
+	return GeoPoint{Lat: lat, Lng: lng}
+}
+
+// loadRouteStops reads the open deliveries for a date and slot with their address coordinates,
+// limited to addresses in one delivery zone when zoneID is set
+func loadRouteStops(date, slotType string, zoneID int) ([]RouteStop, error) {
+	rows, err := db.Query(`
+		SELECT d.id, COALESCE(d.customer_name, ''), COALESCE(a.latitude, 0), COALESCE(a.longitude, 0), d.quantity
+		FROM deliveries d
+		LEFT JOIN milk_subscriptions ms ON d.subscription_id = ms.id
+		LEFT JOIN addresses a ON ms.address_id = a.id
+		WHERE d.delivery_date = $1 AND d.slot_type = $2 AND d.status IN ('Scheduled', 'Packed')
+		  AND ($3 = 0 OR a.zone_id = $3)
+		ORDER BY d.id
+	`, date, slotType, zoneID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stops []RouteStop
+	for rows.Next() {
+		var s RouteStop
+		rows.Scan(&s.DeliveryID, &s.Customer, &s.Location.Lat, &s.Location.Lng, &s.Litres)
+		stops = append(stops, s)
+	}
+	return stops, nil
+}
+
+// adminRoutePlanHandler plans routes for a date and slot, optionally for one zone (?zone_id=)
+// starting from that zone's depot. GET previews the plan; POST with rider_ids also assigns
+// route i to rider_ids[i] in planned order.
+func adminRoutePlanHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" && r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	date := q.Get("date")
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+	slotType := q.Get("slot")
+	if slotType == "" {
+		http.Error(w, "slot is required", http.StatusBadRequest)
+		return
+	}
+
+	depot := defaultDepot()
+	zoneID, _ := strconv.Atoi(q.Get("zone_id"))
+	if zoneID != 0 {
+		zones, err := loadZones(db, false)
+		if err != nil {
+			log.Println("Error loading zones:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		found := false
+		for _, z := range zones {
+			if z.ID == zoneID {
+				depot, found = z.depot(), true
+			}
+		}
+		if !found {
+			http.Error(w, errZoneNotFound.Error(), http.StatusNotFound)
+			return
+		}
+	}
+	if v, err := strconv.ParseFloat(q.Get("depot_lat"), 64); err == nil {
+		depot.Lat = v
+	}
+	if v, err := strconv.ParseFloat(q.Get("depot_lng"), 64); err == nil {
+		depot.Lng = v
+	}
+	if !depot.located() {
+		http.Error(w, "depot_lat and depot_lng are required (or set DEPOT_LAT/DEPOT_LNG)", http.StatusBadRequest)
+		return
+	}
+	capacity, _ := strconv.ParseFloat(q.Get("capacity"), 64)
+
+	stops, err := loadRouteStops(date, slotType, zoneID)
+	if err != nil {
+		log.Println("Error loading route stops:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	plan := PlanRoutes(depot, stops, capacity)
+
+	if r.Method == "POST" {
+		var req struct {
+			RiderIDs []int `json:"rider_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if len(req.RiderIDs) < len(plan.Routes) {
+			http.Error(w, "Not enough riders for the planned routes ("+strconv.Itoa(len(plan.Routes))+" needed)", http.StatusConflict)
+			return
+		}
+		for _, id := range req.RiderIDs[:len(plan.Routes)] {
+			var role string
+			if err := db.QueryRow("SELECT COALESCE(role, 'user') FROM users WHERE id = $1", id).Scan(&role); err != nil || role != RoleRider {
+				http.Error(w, "Rider not found: "+strconv.Itoa(id), http.StatusNotFound)
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		for i := range plan.Routes {
+			plan.Routes[i].RiderID = req.RiderIDs[i]
+			for seq, s := range plan.Routes[i].Stops {
+				// A stop skipped, cancelled or dispatched since it was loaded makes the plan stale
+				res, err := tx.Exec("UPDATE deliveries SET rider_id = $1, route_seq = $2 WHERE id = $3 AND status IN ($4, $5)",
+					req.RiderIDs[i], seq+1, s.DeliveryID, DeliveryScheduled, DeliveryPacked)
+				if err != nil {
+					log.Println("Error applying route plan:", err)
+					http.Error(w, "Failed to apply route plan", http.StatusInternalServerError)
+					return
+				}
+				if n, _ := res.RowsAffected(); n == 0 {
+					http.Error(w, "Delivery "+strconv.Itoa(s.DeliveryID)+" is no longer scheduled; plan again", http.StatusConflict)
+					return
+				}
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to apply route plan", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(plan)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+var testDepot = GeoPoint{Lat: 12.97, Lng: 77.59}
+
+// stopAt places a stop dLat/dLng degrees from the test depot
+func stopAt(id int, dLat, dLng, litres float64) RouteStop {
+	return RouteStop{DeliveryID: id, Location: GeoPoint{Lat: testDepot.Lat + dLat, Lng: testDepot.Lng + dLng}, Litres: litres}
+}
+
+func stopIDs(stops []RouteStop) []int {
+	ids := make([]int, len(stops))
+	for i, s := range stops {
+		ids[i] = s.DeliveryID
+	}
+	return ids
+}
+
+func TestSweepClusters(t *testing.T) {
+	// Bearings sort south, east, north, west
+	north := stopAt(1, 0.01, 0, 10)
+	east := stopAt(2, 0, 0.01, 10)
+	south := stopAt(3, -0.01, 0, 10)
+	west := stopAt(4, 0, -0.01, 10)
+	all := []RouteStop{north, east, south, west}
+
+	tests := []struct {
+		name     string
+		stops    []RouteStop
+		capacity float64
+		want     [][]int
+	}{
+		{"no stops", nil, 20, nil},
+		{"unlimited capacity", all, 0, [][]int{{3, 2, 1, 4}}},
+		{"two per route", all, 20, [][]int{{3, 2}, {1, 4}}},
+		{"three then one", all, 30, [][]int{{3, 2, 1}, {4}}},
+		{"stops larger than capacity", all, 5, [][]int{{3}, {2}, {1}, {4}}},
+		{"nearer stop first on the same bearing", []RouteStop{stopAt(5, 0, 0.02, 1), stopAt(6, 0, 0.01, 1)}, 0, [][]int{{6, 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got [][]int
+			for _, c := range sweepClusters(testDepot, tt.stops, tt.capacity) {
+				got = append(got, stopIDs(c))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sweepClusters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTwoOpt(t *testing.T) {
+	// Stops on a line east of the depot: the shortest tour runs out and back
+	p := func(id int) RouteStop { return stopAt(id, 0, 0.01*float64(id), 1) }
+	optimum := routeDistance(testDepot, []RouteStop{p(1), p(2), p(3), p(4)})
+
+	tests := []struct {
+		name  string
+		order []int
+	}{
+		{"already shortest", []int{1, 2, 3, 4}},
+		{"crossing", []int{3, 1, 4, 2}},
+		{"reversed pair", []int{1, 3, 2, 4}},
+		{"worst zigzag", []int{4, 1, 3, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stops []RouteStop
+			for _, id := range tt.order {
+				stops = append(stops, p(id))
+			}
+			before := routeDistance(testDepot, stops)
+			got := twoOpt(testDepot, stops)
+			after := routeDistance(testDepot, got)
+			if after > before+1e-9 {
+				t.Errorf("twoOpt lengthened the route: %.4f -> %.4f km", before, after)
+			}
+			if math.Abs(after-optimum) > 1e-6 {
+				t.Errorf("twoOpt(%v) = %v, %.4f km; want %.4f km", tt.order, stopIDs(got), after, optimum)
+			}
+			if len(got) != len(tt.order) {
+				t.Errorf("twoOpt returned %d stops, want %d", len(got), len(tt.order))
+			}
+		})
+	}
+
+	t.Run("fewer than three stops unchanged", func(t *testing.T) {
+		stops := []RouteStop{p(2), p(1)}
+		if got := stopIDs(twoOpt(testDepot, stops)); !reflect.DeepEqual(got, []int{2, 1}) {
+			t.Errorf("twoOpt() = %v, want [2 1]", got)
+		}
+	})
+}
+
+func TestPlanRoutes(t *testing.T) {
+	stops := []RouteStop{
+		stopAt(1, 0.01, 0, 10),
+		stopAt(2, 0, 0.01, 10),
+		{DeliveryID: 3, Litres: 5}, // no coordinates
+		stopAt(4, -0.01, 0, 10),
+	}
+
+	tests := []struct {
+		name         string
+		capacity     float64
+		wantRoutes   int
+		wantUnrouted []int
+	}{
+		{"single route", 0, 1, []int{3}},
+		{"split by capacity", 20, 2, []int{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := PlanRoutes(testDepot, stops, tt.capacity)
+			if len(plan.Routes) != tt.wantRoutes {
+				t.Fatalf("got %d routes, want %d", len(plan.Routes), tt.wantRoutes)
+			}
+			if got := stopIDs(plan.Unrouted); !reflect.DeepEqual(got, tt.wantUnrouted) {
+				t.Errorf("unrouted = %v, want %v", got, tt.wantUnrouted)
+			}
+			var litres, distance float64
+			for _, r := range plan.Routes {
+				if tt.capacity > 0 && r.Litres > tt.capacity {
+					t.Errorf("route carries %.1f L over capacity %.1f", r.Litres, tt.capacity)
+				}
+				if d := routeDistance(testDepot, r.Stops); math.Abs(d-r.DistanceKm) > 1e-9 {
+					t.Errorf("route distance %.4f, recomputed %.4f", r.DistanceKm, d)
+				}
+				litres += r.Litres
+				distance += r.DistanceKm
+			}
+			if litres != 30 {
+				t.Errorf("routed %.1f L, want 30", litres)
+			}
+			if math.Abs(distance-plan.DistanceKm) > 1e-9 {
+				t.Errorf("total distance %.4f, sum of routes %.4f", plan.DistanceKm, distance)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// SUBSCRIPTION HISTORY / AUDIT TRAIL
+// ============================================================================
+
+// Subscription event types
+const (
+	SubEventCreated       = "created"
+	SubEventSlotsChanged  = "slots_changed"
+	SubEventUpdated       = "updated"
+	SubEventPaused        = "paused"
+	SubEventResumed       = "resumed"
+	SubEventCancelled     = "cancelled"
+	SubEventStatusChanged = "status_changed"
+)
+
+type SubscriptionEvent struct {
+	ID             int             `json:"id"`
+	SubscriptionID int             `json:"subscription_id"`
+	UserID         int             `json:"user_id"`
+	EventType      string          `json:"event_type"`
+	ActorEmail     string          `json:"actor_email"`
+	ActorRole      string          `json:"actor_role"`
+	Before         json.RawMessage `json:"before"`
+	After          json.RawMessage `json:"after"`
+	CreatedAt      string          `json:"created_at"`
+}
+
+func initSubscriptionEventsSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscription_events (
+			id SERIAL PRIMARY KEY,
+			subscription_id INTEGER REFERENCES milk_subscriptions(id) ON DELETE CASCADE,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			event_type TEXT NOT NULL,
+			actor_email TEXT,
+			actor_role TEXT,
+			before_state JSONB,
+			after_state JSONB,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_subscription_events_sub ON subscription_events(subscription_id, created_at);
+		CREATE INDEX IF NOT EXISTS idx_subscription_events_user ON subscription_events(user_id, created_at);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create subscription_events schema:", err)
+	}
+}
+
+// loadSubscriptionSnapshot reads a subscription and all of its slots as they are right now.
+// Returns nil if the subscription does not exist.
+func loadSubscriptionSnapshot(q dbExecer, subID int) *MilkSubscription {
+	var sub MilkSubscription
+	err := q.QueryRow(`
+		SELECT id, user_id, COALESCE(address_id, 0), status, auto_pay, created_at::text, updated_at::text
+		FROM milk_subscriptions WHERE id = $1
+	`, subID).Scan(&sub.ID, &sub.UserID, &sub.AddressID, &sub.Status, &sub.AutoPay, &sub.CreatedAt, &sub.UpdatedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Error loading subscription snapshot:", err)
+		}
+		return nil
+	}
+
+	rows, err := q.Query(`
+		SELECT id, subscription_id, slot_type, milk_type, quantity, time_slot, frequency, days, is_enabled
+		FROM subscription_slots WHERE subscription_id = $1 ORDER BY id
+	`, subID)
+	if err != nil {
+		log.Println("Error loading subscription slots snapshot:", err)
+		return &sub
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var slot SubscriptionSlot
+		var daysStr sql.NullString
+		rows.Scan(&slot.ID, &slot.SubscriptionID, &slot.SlotType, &slot.MilkType,
+			&slot.Quantity, &slot.TimeSlot, &slot.Frequency, &daysStr, &slot.IsEnabled)
+		if daysStr.Valid {
+			if days := strings.Trim(daysStr.String, "{}"); days != "" {
+				slot.Days = strings.Split(days, ",")
+			}
+		}
+		sub.Slots = append(sub.Slots, slot)
+	}
+	return &sub
+}
+
+// recordSubscriptionEvent appends an entry to the subscription audit trail.
+// before/after may be nil (e.g. no "before" on create).
+func recordSubscriptionEvent(q dbExecer, subID, userID int, eventType, actorEmail, actorRole string, before, after *MilkSubscription) {
+	var beforeJSON, afterJSON interface{}
+	if before != nil {
+		b, _ := json.Marshal(before)
+		beforeJSON = string(b)
+	}
+	if after != nil {
+		b, _ := json.Marshal(after)
+		afterJSON = string(b)
+	}
+	_, err := q.Exec(`
+		INSERT INTO subscription_events (subscription_id, user_id, event_type, actor_email, actor_role, before_state, after_state)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, subID, userID, eventType, actorEmail, actorRole, beforeJSON, afterJSON)
+	if err != nil {
+		log.Println("Error recording subscription event:", err)
+	}
+}
+
+// statusEventType maps a status transition to the event type it should be recorded as
+func statusEventType(from, to string) string {
+	switch {
+	case to == "Cancelled":
+		return SubEventCancelled
+	case to == "Paused" || to == SubStatusSuspendedNoFunds:
+		return SubEventPaused
+	case to == "Active" && (from == "Paused" || from == SubStatusSuspendedNoFunds):
+		return SubEventResumed
+	}
+	return SubEventStatusChanged
+}
+
+// recordSubscriptionChanges diffs two snapshots and records one event per kind of change
+func recordSubscriptionChanges(q dbExecer, before, after *MilkSubscription, actorEmail, actorRole string) {
+	if before == nil || after == nil {
+		return
+	}
+	if before.Status != after.Status {
+		eventType := statusEventType(before.Status, after.Status)
+		recordSubscriptionEvent(q, after.ID, after.UserID, eventType, actorEmail, actorRole, before, after)
+	}
+	if !reflect.DeepEqual(before.Slots, after.Slots) {
+		recordSubscriptionEvent(q, after.ID, after.UserID, SubEventSlotsChanged, actorEmail, actorRole, before, after)
+	}
+	if before.AddressID != after.AddressID || before.AutoPay != after.AutoPay {
+		recordSubscriptionEvent(q, after.ID, after.UserID, SubEventUpdated, actorEmail, actorRole, before, after)
+	}
+}
+
+func querySubscriptionEvents(where string, args ...interface{}) ([]SubscriptionEvent, error) {
+	rows, err := db.Query(`
+		SELECT id, COALESCE(subscription_id, 0), COALESCE(user_id, 0), event_type,
+		       COALESCE(actor_email, ''), COALESCE(actor_role, ''),
+		       COALESCE(before_state::text, 'null'), COALESCE(after_state::text, 'null'), created_at::text
+		FROM subscription_events
+		WHERE `+where+`
+		ORDER BY created_at DESC, id DESC
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []SubscriptionEvent{}
+	for rows.Next() {
+		var e SubscriptionEvent
+		var before, after string
+		if err := rows.Scan(&e.ID, &e.SubscriptionID, &e.UserID, &e.EventType, &e.ActorEmail, &e.ActorRole,
+			&before, &after, &e.CreatedAt); err != nil {
+			log.Println("Error scanning subscription event:", err)
+			continue
+		}
+		e.Before = json.RawMessage(before)
+		e.After = json.RawMessage(after)
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// subscriptionHistoryHandler returns the signed-in user's subscription history across all their subscriptions
+func subscriptionHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := requestEmail(r)
+	if email == "" {
+		http.Error(w, "Email required", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", email).Scan(&userID); err != nil {
+		json.NewEncoder(w).Encode([]SubscriptionEvent{})
+		return
+	}
+
+	var events []SubscriptionEvent
+	var err error
+	if subID, _ := strconv.Atoi(r.URL.Query().Get("subscription_id")); subID > 0 {
+		events, err = querySubscriptionEvents("user_id = $1 AND subscription_id = $2", userID, subID)
+	} else {
+		events, err = querySubscriptionEvents("user_id = $1", userID)
+	}
+	if err != nil {
+		log.Println("Error fetching subscription history:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(events)
+}
+
+// adminSubscriptionHistoryHandler returns the full audit trail for one subscription
+func adminSubscriptionHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	subID, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	if subID == 0 {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	events, err := querySubscriptionEvents("subscription_id = $1", subID)
+	if err != nil {
+		log.Println("Error fetching subscription history:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(events)
+}
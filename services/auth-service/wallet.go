@@ -0,0 +1,438 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ============================================================================
+// CUSTOMER WALLET (DOUBLE-ENTRY LEDGER)
+// ============================================================================
+//
+// Every wallet operation is a wallet_transactions row with two or more
+// wallet_entries that sum to zero. Each customer has one account; money
+// comes from / goes to system accounts (payments clearing, revenue,
+// adjustments). A balance is never stored: it is SUM(amount) of the
+// account's entries. Transactions carry a unique idempotency key so a
+// retried request never posts twice.
+
+// Wallet transaction types
+const (
+	WalletTopUp      = "topup"
+	WalletDebit      = "debit"
+	WalletRefund     = "refund"
+	WalletAdjustment = "adjustment"
+	WalletPayout     = "payout" // money returned to the customer's payment method
+)
+
+// System accounts, keyed by code
+const (
+	AccountPaymentsClearing = "payments_clearing"
+	AccountRevenue          = "revenue"
+	AccountAdjustments      = "adjustments"
+)
+
+var (
+	errIdempotencyConflict = errors.New("idempotency key already used for a different request")
+	errInvalidAmount       = errors.New("amount must be positive")
+)
+
+type WalletEntry struct {
+	ID            int     `json:"id"`
+	TransactionID int     `json:"transaction_id"`
+	Type          string  `json:"type"`
+	Amount        float64 `json:"amount"`
+	Balance       float64 `json:"balance_after"`
+	DeliveryID    int     `json:"delivery_id,omitempty"`
+	Description   string  `json:"description"`
+	CreatedAt     string  `json:"created_at"`
+}
+
+// walletTxn describes one operation against a customer's wallet.
+// Amount is always positive except for adjustments, where the sign is the direction.
+type walletTxn struct {
+	Key         string
+	Type        string
+	UserID      int
+	Amount      float64
+	DeliveryID  int
+	Description string
+	CreatedBy   string
+}
+
+func initWalletSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS wallet_accounts (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER UNIQUE REFERENCES users(id),
+			code TEXT UNIQUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK ((user_id IS NULL) <> (code IS NULL))
+		);
+
+		CREATE TABLE IF NOT EXISTS wallet_transactions (
+			id SERIAL PRIMARY KEY,
+			idempotency_key TEXT UNIQUE NOT NULL,
+			type TEXT NOT NULL,
+			user_id INTEGER REFERENCES users(id),
+			amount DECIMAL(12,2) NOT NULL,
+			delivery_id INTEGER REFERENCES deliveries(id),
+			description TEXT,
+			created_by TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS wallet_entries (
+			id SERIAL PRIMARY KEY,
+			transaction_id INTEGER NOT NULL REFERENCES wallet_transactions(id),
+			account_id INTEGER NOT NULL REFERENCES wallet_accounts(id),
+			amount DECIMAL(12,2) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_wallet_entries_account ON wallet_entries(account_id, id);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create wallet schema:", err)
+	}
+
+	for _, code := range []string{AccountPaymentsClearing, AccountRevenue, AccountAdjustments} {
+		db.Exec("INSERT INTO wallet_accounts (code) VALUES ($1) ON CONFLICT (code) DO NOTHING", code)
+	}
+}
+
+func systemAccountID(q dbExecer, code string) (int, error) {
+	var id int
+	err := q.QueryRow("SELECT id FROM wallet_accounts WHERE code = $1", code).Scan(&id)
+	return id, err
+}
+
+// customerAccountID returns the user's wallet account, opening one if needed
+func customerAccountID(q dbExecer, userID int) (int, error) {
+	var id int
+	err := q.QueryRow(`
+		INSERT INTO wallet_accounts (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING id
+	`, userID).Scan(&id)
+	return id, err
+}
+
+func walletBalance(q dbExecer, userID int) (float64, error) {
+	var balance float64
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(e.amount), 0) FROM wallet_entries e
+		JOIN wallet_accounts a ON e.account_id = a.id
+		WHERE a.user_id = $1
+	`, userID).Scan(&balance)
+	return balance, err
+}
+
+// postWalletTransaction writes a balanced transaction. If the idempotency key was
+// already used for the same request the original transaction id is returned.
+func postWalletTransaction(q dbExecer, t walletTxn) (int, error) {
+	t.Amount = roundMoney(t.Amount)
+	if t.Amount == 0 || (t.Type != WalletAdjustment && t.Amount < 0) {
+		return 0, errInvalidAmount
+	}
+
+	// customer side of the entry, and the system account on the other side
+	var customerAmount float64
+	var contra string
+	switch t.Type {
+	case WalletTopUp:
+		customerAmount, contra = t.Amount, AccountPaymentsClearing
+	case WalletRefund:
+		customerAmount, contra = t.Amount, AccountRevenue
+	case WalletDebit:
+		customerAmount, contra = -t.Amount, AccountRevenue
+	case WalletPayout:
+		customerAmount, contra = -t.Amount, AccountPaymentsClearing
+	case WalletAdjustment:
+		customerAmount, contra = t.Amount, AccountAdjustments
+	default:
+		return 0, fmt.Errorf("unknown wallet transaction type %q", t.Type)
+	}
+
+	var deliveryArg interface{}
+	if t.DeliveryID != 0 {
+		deliveryArg = t.DeliveryID
+	}
+
+	var txnID int
+	err := q.QueryRow(`
+		INSERT INTO wallet_transactions (idempotency_key, type, user_id, amount, delivery_id, description, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id
+	`, t.Key, t.Type, t.UserID, t.Amount, deliveryArg, t.Description, t.CreatedBy).Scan(&txnID)
+	if err == sql.ErrNoRows {
+		// Replay: same key must mean the same request
+		var existing walletTxn
+		err = q.QueryRow("SELECT id, type, COALESCE(user_id, 0), amount FROM wallet_transactions WHERE idempotency_key = $1", t.Key).
+			Scan(&txnID, &existing.Type, &existing.UserID, &existing.Amount)
+		if err != nil {
+			return 0, err
+		}
+		if existing.Type != t.Type || existing.UserID != t.UserID || roundMoney(existing.Amount) != t.Amount {
+			return 0, errIdempotencyConflict
+		}
+		return txnID, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	customerAcct, err := customerAccountID(q, t.UserID)
+	if err != nil {
+		return 0, err
+	}
+	contraAcct, err := systemAccountID(q, contra)
+	if err != nil {
+		return 0, err
+	}
+	_, err = q.Exec(`
+		INSERT INTO wallet_entries (transaction_id, account_id, amount) VALUES ($1, $2, $3), ($1, $4, $5)
+	`, txnID, customerAcct, customerAmount, contraAcct, -customerAmount)
+	if err != nil {
+		return 0, err
+	}
+	return txnID, nil
+}
+
+// debitWalletForDelivery charges an auto-pay subscription's wallet for a delivered delivery.
+// Called inside the transaction that marks the delivery Delivered.
+func debitWalletForDelivery(q dbExecer, deliveryID int) error {
+	var userID int
+	var autoPay bool
+	var milkType string
+	var quantity float64
+	var date time.Time
+	err := q.QueryRow(`
+		SELECT d.user_id, COALESCE(ms.auto_pay, false), d.milk_type, d.quantity, d.delivery_date
+		FROM deliveries d LEFT JOIN milk_subscriptions ms ON d.subscription_id = ms.id
+		WHERE d.id = $1
+	`, deliveryID).Scan(&userID, &autoPay, &milkType, &quantity, &date)
+	if err != nil {
+		return err
+	}
+	if !autoPay {
+		return nil
+	}
+
+	rate, err := lookupPrice(q, milkType, date, userPriceScope(q, userID))
+	if err == sql.ErrNoRows {
+		log.Printf("No price for %s on %s, delivery %d not debited", milkType, date.Format("2006-01-02"), deliveryID)
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = postWalletTransaction(q, walletTxn{
+		Key:         "delivery:" + strconv.Itoa(deliveryID),
+		Type:        WalletDebit,
+		UserID:      userID,
+		Amount:      rate * quantity,
+		DeliveryID:  deliveryID,
+		Description: fmt.Sprintf("%s milk %.2fL on %s", milkType, quantity, date.Format("2006-01-02")),
+		CreatedBy:   "auto_pay",
+	})
+	return err
+}
+
+// walletStatement returns a page of entries on the user's account, newest first, with running balance
+func walletStatement(userID, limit, offset int) ([]WalletEntry, int, error) {
+	var total int
+	db.QueryRow(`
+		SELECT COUNT(*) FROM wallet_entries e JOIN wallet_accounts a ON e.account_id = a.id WHERE a.user_id = $1
+	`, userID).Scan(&total)
+
+	rows, err := db.Query(`
+		SELECT * FROM (
+			SELECT e.id, e.transaction_id, t.type, e.amount,
+			       SUM(e.amount) OVER (ORDER BY e.id) AS balance_after,
+			       COALESCE(t.delivery_id, 0), COALESCE(t.description, ''), e.created_at::text
+			FROM wallet_entries e
+			JOIN wallet_accounts a ON e.account_id = a.id
+			JOIN wallet_transactions t ON e.transaction_id = t.id
+			WHERE a.user_id = $1
+		) s
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []WalletEntry{}
+	for rows.Next() {
+		var e WalletEntry
+		rows.Scan(&e.ID, &e.TransactionID, &e.Type, &e.Amount, &e.Balance, &e.DeliveryID, &e.Description, &e.CreatedAt)
+		entries = append(entries, e)
+	}
+	return entries, total, nil
+}
+
+// paginationParams reads ?limit= (default 20, max 100) and ?offset=
+func paginationParams(r *http.Request) (limit, offset int) {
+	limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ = strconv.Atoi(r.URL.Query().Get("offset"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return limit, offset
+}
+
+func writeWalletStatement(w http.ResponseWriter, r *http.Request, userID int) {
+	limit, offset := paginationParams(r)
+	entries, total, err := walletStatement(userID, limit, offset)
+	if err != nil {
+		log.Println("Error fetching wallet statement:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	balance, _ := walletBalance(db, userID)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"balance": balance,
+		"entries": entries,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// walletHandler returns the signed-in user's balance
+func walletHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	balance, err := walletBalance(db, userID)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"user_id": userID, "balance": balance})
+}
+
+// walletStatementHandler returns the signed-in user's paginated statement
+func walletStatementHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var userID int
+	if err := db.QueryRow("SELECT id FROM users WHERE email = $1", requestEmail(r)).Scan(&userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	writeWalletStatement(w, r, userID)
+}
+
+// adminWalletHandler shows a customer's statement (GET ?user_id=) or posts a
+// top-up, refund or adjustment (POST) on their behalf
+func adminWalletHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		userID, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+		if userID == 0 {
+			http.Error(w, "user_id is required", http.StatusBadRequest)
+			return
+		}
+		writeWalletStatement(w, r, userID)
+
+	case "POST":
+		var req struct {
+			UserID         int     `json:"user_id"`
+			Type           string  `json:"type"`
+			Amount         float64 `json:"amount"`
+			IdempotencyKey string  `json:"idempotency_key"`
+			Description    string  `json:"description"`
+			DeliveryID     int     `json:"delivery_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Type != WalletTopUp && req.Type != WalletRefund && req.Type != WalletAdjustment {
+			http.Error(w, "type must be topup, refund or adjustment", http.StatusBadRequest)
+			return
+		}
+		if req.IdempotencyKey == "" {
+			http.Error(w, "idempotency_key is required", http.StatusBadRequest)
+			return
+		}
+
+		var exists int
+		db.QueryRow("SELECT COUNT(*) FROM users WHERE id = $1", req.UserID).Scan(&exists)
+		if exists == 0 {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+
+		actorEmail, _ := requestActor(r)
+		tx, err := db.Begin()
+		if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		txnID, err := postWalletTransaction(tx, walletTxn{
+			Key:         "admin:" + req.IdempotencyKey,
+			Type:        req.Type,
+			UserID:      req.UserID,
+			Amount:      req.Amount,
+			DeliveryID:  req.DeliveryID,
+			Description: req.Description,
+			CreatedBy:   actorEmail,
+		})
+		if err == errIdempotencyConflict {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err == errInvalidAmount {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			log.Println("Error posting wallet transaction:", err)
+			http.Error(w, "Failed to post transaction", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			http.Error(w, "Failed to post transaction", http.StatusInternalServerError)
+			return
+		}
+
+		// Money in may lift a funding suspension; money out may trigger one
+		reevaluateFundingForUser(req.UserID)
+
+		balance, _ := walletBalance(db, req.UserID)
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "transaction_id": txnID, "balance": balance})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// LOW-BALANCE POLICY
+// ============================================================================
+//
+// For every auto-pay subscription the wallet balance is projected forward
+// against the scheduled deliveries. If the money runs out within
+// LOW_BALANCE_WARN_DAYS the customer is warned (once per episode); if it
+// can't cover the next delivery the subscription moves to Suspended-NoFunds,
+// which stops delivery generation. A top-up that covers the next delivery
+// resumes the subscription automatically.
+
+// SubStatusSuspendedNoFunds is the subscription status used while a wallet can't pay
+const SubStatusSuspendedNoFunds = "Suspended-NoFunds"
+
+// Funding alert kinds
+const (
+	FundingLowBalance = "low_balance"
+	FundingSuspended  = "suspended"
+	FundingResumed    = "resumed"
+)
+
+const fundingHorizonDays = 30
+
+// reasonInsufficientFunds marks deliveries cancelled by a funding suspension
+const reasonInsufficientFunds = "insufficient_funds"
+
+// reasonSubscriptionStopped marks deliveries cancelled because their
+// subscription was paused, cancelled or replaced
+const reasonSubscriptionStopped = "subscription_stopped"
+
+// scheduledSlot is the part of a subscription slot needed to project cost
+type scheduledSlot struct {
+	ID        int
+	MilkType  string
+	Quantity  float64
+	Frequency string
+	Days      []string
+}
+
+// deliversOn reports whether the slot delivers on a date (same rules as generateDeliveries)
+func (s scheduledSlot) deliversOn(date time.Time) bool {
+	if s.Frequency == "daily" {
+		return true
+	}
+	if s.Frequency != "alternate" {
+		return false
+	}
+	dayName := date.Weekday().String()[:3]
+	for _, d := range s.Days {
+		if d == dayName {
+			return true
+		}
+	}
+	return false
+}
+
+// fundingProjection is the result of walking a balance forward day by day
+type fundingProjection struct {
+	Balance   float64
+	NextCost  float64   // cost of the first upcoming delivery day
+	RunsOutOn time.Time // first day the balance can't cover; zero if it lasts the horizon
+}
+
+// projectFunding walks the balance forward from start over the slots' schedule.
+// prices is per milk type; skipped holds "slotID:YYYY-MM-DD" keys that won't be delivered.
+func projectFunding(balance float64, slots []scheduledSlot, prices map[string]float64, skipped map[string]bool,
+	start time.Time, horizonDays int) fundingProjection {
+	p := fundingProjection{Balance: balance}
+	remaining := balance
+	for i := 0; i < horizonDays; i++ {
+		date := start.AddDate(0, 0, i)
+		var dayCost float64
+		for _, s := range slots {
+			if s.deliversOn(date) && !skipped[strconv.Itoa(s.ID)+":"+date.Format("2006-01-02")] {
+				dayCost += s.Quantity * prices[s.MilkType]
+			}
+		}
+		if dayCost == 0 {
+			continue
+		}
+		if p.NextCost == 0 {
+			p.NextCost = dayCost
+		}
+		remaining -= dayCost
+		if remaining < 0 {
+			p.RunsOutOn = date
+			return p
+		}
+	}
+	return p
+}
+
+func initWalletPolicySchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscription_funding_alerts (
+			id SERIAL PRIMARY KEY,
+			subscription_id INTEGER REFERENCES milk_subscriptions(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			balance DECIMAL(12,2),
+			runs_out_on DATE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_funding_alerts_sub ON subscription_funding_alerts(subscription_id, created_at);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create wallet policy schema:", err)
+	}
+
+	// auto_pay defaulted to true before wallets existed, so subscriptions from then
+	// never opted in to paying from a wallet. Move them to invoiced billing (and undo
+	// any suspension already applied) rather than cutting off every empty wallet.
+	applyMigration("wallet_policy_legacy_auto_pay", `
+		UPDATE milk_subscriptions SET status = CASE WHEN status = '`+SubStatusSuspendedNoFunds+`' THEN 'Active' ELSE status END,
+		       auto_pay = false, updated_at = NOW()
+		WHERE auto_pay = true
+	`)
+}
+
+func lowBalanceWarnDays() int {
+	if n, err := strconv.Atoi(os.Getenv("LOW_BALANCE_WARN_DAYS")); err == nil && n > 0 {
+		return n
+	}
+	return 3
+}
+
+// projectSubscriptionFunding projects one subscription's wallet from tomorrow onwards
+func projectSubscriptionFunding(subID, userID int) (fundingProjection, error) {
+	projections, err := projectSubscriptionsFunding(map[int]int{subID: userID})
+	return projections[subID], err
+}
+
+// projectSubscriptionsFunding projects a set of subscriptions (id -> user id) at once,
+// loading balances, slots, price scopes and skips with one query each
+func projectSubscriptionsFunding(owners map[int]int) (map[int]fundingProjection, error) {
+	projections := map[int]fundingProjection{}
+	if len(owners) == 0 {
+		return projections, nil
+	}
+	subIDs := make([]string, 0, len(owners))
+	userIDs := make([]string, 0, len(owners))
+	for subID, userID := range owners {
+		subIDs = append(subIDs, strconv.Itoa(subID))
+		userIDs = append(userIDs, strconv.Itoa(userID))
+	}
+	subList := "{" + strings.Join(subIDs, ",") + "}"
+	userList := "{" + strings.Join(userIDs, ",") + "}"
+
+	balances := map[int]float64{}
+	rows, err := db.Query(`
+		SELECT a.user_id, COALESCE(SUM(e.amount), 0) FROM wallet_entries e
+		JOIN wallet_accounts a ON e.account_id = a.id
+		WHERE a.user_id = ANY($1::int[])
+		GROUP BY a.user_id
+	`, userList)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var userID int
+		var balance float64
+		rows.Scan(&userID, &balance)
+		balances[userID] = balance
+	}
+	rows.Close()
+
+	slots := map[int][]scheduledSlot{}
+	rows, err = db.Query(`
+		SELECT subscription_id, id, milk_type, quantity, frequency, COALESCE(array_to_string(days, ','), '')
+		FROM subscription_slots WHERE subscription_id = ANY($1::int[]) AND is_enabled = true
+	`, subList)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var subID int
+		var s scheduledSlot
+		var days string
+		rows.Scan(&subID, &s.ID, &s.MilkType, &s.Quantity, &s.Frequency, &days)
+		if days != "" {
+			s.Days = strings.Split(days, ",")
+		}
+		slots[subID] = append(slots[subID], s)
+	}
+	rows.Close()
+
+	scopes, err := userPriceScopes(db, userList)
+	if err != nil {
+		return nil, err
+	}
+
+	skipped := map[int]map[string]bool{}
+	rows, err = db.Query(`
+		SELECT subscription_id, slot_id, delivery_date::text FROM deliveries
+		WHERE subscription_id = ANY($1::int[]) AND delivery_date > CURRENT_DATE
+		  AND (status = 'Skipped' OR (status = 'Cancelled' AND COALESCE(failure_reason, '') NOT IN ($2, $3)))
+	`, subList, reasonInsufficientFunds, reasonSubscriptionStopped)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var subID, slotID int
+		var date string
+		rows.Scan(&subID, &slotID, &date)
+		if skipped[subID] == nil {
+			skipped[subID] = map[string]bool{}
+		}
+		skipped[subID][strconv.Itoa(slotID)+":"+date] = true
+	}
+	rows.Close()
+
+	// Prices depend only on milk type and scope, so each pair is looked up once
+	type priceKey struct {
+		milkType string
+		scope    priceScope
+	}
+	rates := map[priceKey]float64{}
+	now := time.Now()
+	today, _ := time.Parse("2006-01-02", now.Format("2006-01-02"))
+	for subID, userID := range owners {
+		prices := map[string]float64{}
+		for _, s := range slots[subID] {
+			k := priceKey{s.MilkType, scopes[userID]}
+			rate, ok := rates[k]
+			if !ok {
+				rate, _ = lookupPrice(db, s.MilkType, now, k.scope)
+				rates[k] = rate
+			}
+			prices[s.MilkType] = rate
+		}
+		projections[subID] = projectFunding(balances[userID], slots[subID], prices, skipped[subID], today.AddDate(0, 0, 1), fundingHorizonDays)
+	}
+	return projections, nil
+}
+
+func recordFundingAlert(subID int, kind string, p fundingProjection) {
+	var runsOut interface{}
+	if !p.RunsOutOn.IsZero() {
+		runsOut = p.RunsOutOn.Format("2006-01-02")
+	}
+	db.Exec("INSERT INTO subscription_funding_alerts (subscription_id, kind, balance, runs_out_on) VALUES ($1, $2, $3, $4)",
+		subID, kind, p.Balance, runsOut)
+}
+
+// alreadyWarned reports whether a low-balance warning went out since the last top-up
+func alreadyWarned(subID, userID int) bool {
+	var n int
+	db.QueryRow(`
+		SELECT COUNT(*) FROM subscription_funding_alerts fa
+		WHERE fa.subscription_id = $1 AND fa.kind = $2
+		  AND fa.created_at > COALESCE((SELECT MAX(created_at) FROM wallet_transactions
+		                                WHERE user_id = $3 AND type IN ('topup', 'refund', 'adjustment')), 'epoch')
+	`, subID, FundingLowBalance, userID).Scan(&n)
+	return n > 0
+}
+
+func sendFundingEmail(userID int, subject, message string) {
+	var email, name string
+	if err := db.QueryRow("SELECT email, COALESCE(name, '') FROM users WHERE id = $1", userID).Scan(&email, &name); err != nil {
+		return
+	}
+	body := fmt.Sprintf(`<p>Hello %s,</p><p>%s</p><p>Best regards,<br><strong>Team Taaza</strong></p>`, name, message)
+	if err := deliverHTMLEmail(email, subject, body); err != nil {
+		log.Println("Failed to send funding email:", err)
+	}
+}
+
+// setSubscriptionFundingStatus moves a subscription between Active and Suspended-NoFunds with an audit event.
+// Suspending drops scheduled deliveries from tomorrow; generateDeliveries recreates them on resume.
+func setSubscriptionFundingStatus(subID int, status string) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("Error setting funding status:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	before := loadSubscriptionSnapshot(tx, subID)
+	if before == nil {
+		return
+	}
+	if err := setSubscriptionStatus(tx, subID, status, "", "", "system", "system"); err != nil {
+		log.Println("Error setting funding status:", err)
+		return
+	}
+	recordSubscriptionChanges(tx, before, loadSubscriptionSnapshot(tx, subID), "system", "system")
+	if err := tx.Commit(); err != nil {
+		log.Println("Error setting funding status:", err)
+		return
+	}
+
+	if status == "Active" {
+		generateDeliveries(subID, before.UserID, 7)
+	}
+}
+
+// evaluateSubscriptionFunding applies the policy to one auto-pay subscription
+func evaluateSubscriptionFunding(subID, userID int, status string) {
+	p, err := projectSubscriptionFunding(subID, userID)
+	if err != nil {
+		log.Printf("Funding projection failed for subscription %d: %v", subID, err)
+		return
+	}
+
+	cannotPayNext := p.NextCost > 0 && p.Balance < p.NextCost
+	switch {
+	case status == "Active" && cannotPayNext:
+		setSubscriptionFundingStatus(subID, SubStatusSuspendedNoFunds)
+		recordFundingAlert(subID, FundingSuspended, p)
+		sendFundingEmail(userID, "Your Taaza milk subscription is paused",
+			fmt.Sprintf("Your wallet balance of Rs. %.2f can't cover your next delivery (Rs. %.2f), so deliveries are paused. "+
+				"They will resume automatically as soon as you top up.", p.Balance, p.NextCost))
+
+	case status == SubStatusSuspendedNoFunds && !cannotPayNext:
+		setSubscriptionFundingStatus(subID, "Active")
+		recordFundingAlert(subID, FundingResumed, p)
+		sendFundingEmail(userID, "Your Taaza milk subscription has resumed",
+			"Thanks for topping up. Your deliveries have resumed.")
+
+	case status == "Active" && !p.RunsOutOn.IsZero() && p.RunsOutOn.Before(time.Now().AddDate(0, 0, lowBalanceWarnDays()+1)):
+		if alreadyWarned(subID, userID) {
+			return
+		}
+		recordFundingAlert(subID, FundingLowBalance, p)
+		sendFundingEmail(userID, "Low wallet balance",
+			fmt.Sprintf("Your wallet balance of Rs. %.2f will run out on %s. Please top up to avoid interruption.",
+				p.Balance, p.RunsOutOn.Format("2 Jan 2006")))
+	}
+}
+
+// runFundingPolicy evaluates auto-pay subscriptions (all, or one user's when userID != 0)
+func runFundingPolicy(userID int) {
+	rows, err := db.Query(`
+		SELECT id, user_id, status FROM milk_subscriptions
+		WHERE auto_pay = true AND status IN ('Active', $1) AND ($2 = 0 OR user_id = $2)
+	`, SubStatusSuspendedNoFunds, userID)
+	if err != nil {
+		log.Println("Error loading subscriptions for funding policy:", err)
+		return
+	}
+	type sub struct {
+		id, userID int
+		status     string
+	}
+	var subs []sub
+	for rows.Next() {
+		var s sub
+		rows.Scan(&s.id, &s.userID, &s.status)
+		subs = append(subs, s)
+	}
+	rows.Close()
+
+	for _, s := range subs {
+		evaluateSubscriptionFunding(s.id, s.userID, s.status)
+	}
+}
+
+// reevaluateFundingForUser re-runs the policy after money lands in a user's wallet
+func reevaluateFundingForUser(userID int) {
+	runFundingPolicy(userID)
+}
+
+// generateUpcomingDeliveries keeps a week of deliveries materialised for every Active subscription
+func generateUpcomingDeliveries() {
+	rows, err := db.Query("SELECT id, user_id FROM milk_subscriptions WHERE status = 'Active'")
+	if err != nil {
+		log.Println("Error loading subscriptions for delivery generation:", err)
+		return
+	}
+	var subs [][2]int
+	for rows.Next() {
+		var s [2]int
+		rows.Scan(&s[0], &s[1])
+		subs = append(subs, s)
+	}
+	rows.Close()
+
+	for _, s := range subs {
+		generateDeliveries(s[0], s[1], 7)
+	}
+}
+
+// startSubscriptionScheduler runs the funding policy and delivery generation hourly
+func startSubscriptionScheduler() {
+	go func() {
+		for {
+			runFundingPolicy(0)
+			generateUpcomingDeliveries()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
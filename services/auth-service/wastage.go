@@ -0,0 +1,838 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================================================
+// WASTAGE AND SHRINKAGE
+// ============================================================================
+//
+// Every loss of stock is a wastage event with a reason code, the stock item,
+// quantity (in the item's unit), day and slot, who or where it happened (rider
+// or location) and an optional photo. Each event posts one wastage movement
+// ("wastage:<id>") into the inventory ledger. A delivery failed as "spilled"
+// records a spillage event against its rider automatically.
+//
+// Thresholds cap wastage over a rolling window of days, either in total, per
+// reason or per rider, optionally for one stock item. After each event the
+// thresholds are evaluated for the window ending that day; a breach raises one
+// alert per threshold, rider and window end, emailed to the admins.
+//
+// Routes:
+//   /api/admin/wastage                   GET ?from&to&reason&rider_id&sku&limit&offset, POST event
+//   /api/admin/wastage/report            GET ?from&to  totals by reason, by rider and by day
+//   /api/admin/wastage/thresholds        GET, POST, PUT {id,...}, DELETE ?id= (deactivates)
+//   /api/admin/wastage/alerts            GET ?from&to, PUT {id} acknowledge
+//   /api/rider/wastage                   POST event for the signed-in rider
+
+// Wastage reason codes
+var wastageReasons = map[string]string{
+	"spoilage":       "Spoilage",
+	"spillage":       "Spillage",
+	"leak":           "Leak",
+	"return":         "Customer return discarded",
+	"quality_reject": "Quality reject",
+}
+
+// wastageUnspecified marks wastage booked before reasons were recorded
+const wastageUnspecified = "unspecified"
+
+// Threshold scopes
+const (
+	WastageScopeTotal  = "total"
+	WastageScopeReason = "reason"
+	WastageScopeRider  = "rider"
+)
+
+var (
+	errWastageReason    = errors.New("reason must be one of spoilage, spillage, leak, return, quality_reject")
+	errWastageThreshold = errors.New("threshold needs a scope of total, reason or rider, a positive max_quantity and window_days between 1 and 31")
+	errThresholdMissing = errors.New("threshold not found")
+)
+
+func wastageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errWastageReason), errors.Is(err, errWastageThreshold):
+		return http.StatusBadRequest
+	case errors.Is(err, errThresholdMissing):
+		return http.StatusNotFound
+	}
+	return inventoryErrorStatus(err)
+}
+
+type WastageEvent struct {
+	ID          int     `json:"id"`
+	Reason      string  `json:"reason"`
+	StockItemID int     `json:"stock_item_id"`
+	SKU         string  `json:"sku"`
+	ItemName    string  `json:"item_name"`
+	Uom         string  `json:"uom"`
+	WarehouseID int     `json:"warehouse_id"`
+	Quantity    float64 `json:"quantity"`
+	Date        string  `json:"date"`
+	SlotType    string  `json:"slot_type,omitempty"`
+	RiderID     int     `json:"rider_id,omitempty"`
+	RiderName   string  `json:"rider_name,omitempty"`
+	Location    string  `json:"location,omitempty"`
+	PhotoURL    string  `json:"photo_url,omitempty"`
+	DeliveryID  int     `json:"delivery_id,omitempty"`
+	Note        string  `json:"note,omitempty"`
+	MovementID  int     `json:"movement_id"`
+	ReportedBy  string  `json:"reported_by"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+type WastageThreshold struct {
+	ID          int     `json:"id"`
+	Scope       string  `json:"scope"`
+	Reason      string  `json:"reason,omitempty"`
+	StockItemID int     `json:"stock_item_id,omitempty"`
+	WindowDays  int     `json:"window_days"`
+	MaxQuantity float64 `json:"max_quantity"`
+	IsActive    bool    `json:"is_active"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+type WastageAlert struct {
+	ID             int     `json:"id"`
+	ThresholdID    int     `json:"threshold_id"`
+	Scope          string  `json:"scope"`
+	Reason         string  `json:"reason,omitempty"`
+	RiderID        int     `json:"rider_id,omitempty"`
+	RiderName      string  `json:"rider_name,omitempty"`
+	WindowStart    string  `json:"window_start"`
+	WindowEnd      string  `json:"window_end"`
+	Quantity       float64 `json:"quantity"`
+	MaxQuantity    float64 `json:"max_quantity"`
+	AcknowledgedBy string  `json:"acknowledged_by,omitempty"`
+	CreatedAt      string  `json:"created_at"`
+}
+
+// wastageInput is a wastage event to record
+type wastageInput struct {
+	IdempotencyKey string  `json:"idempotency_key"`
+	Reason         string  `json:"reason"`
+	StockItemID    int     `json:"stock_item_id"`
+	SKU            string  `json:"sku"`
+	WarehouseID    int     `json:"warehouse_id"`
+	Quantity       float64 `json:"quantity"`
+	Date           string  `json:"date"`
+	SlotType       string  `json:"slot_type"`
+	RiderID        int     `json:"rider_id"`
+	Location       string  `json:"location"`
+	PhotoURL       string  `json:"photo_url"`
+	DeliveryID     int     `json:"delivery_id"`
+	Note           string  `json:"note"`
+	ReportedBy     string  `json:"-"`
+}
+
+func initWastageSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS wastage_events (
+			id SERIAL PRIMARY KEY,
+			idempotency_key TEXT UNIQUE NOT NULL,
+			reason TEXT NOT NULL CHECK (reason IN ('spoilage', 'spillage', 'leak', 'return', 'quality_reject', 'unspecified')),
+			stock_item_id INTEGER NOT NULL REFERENCES stock_items(id),
+			warehouse_id INTEGER NOT NULL REFERENCES warehouses(id),
+			quantity DECIMAL(12,3) NOT NULL CHECK (quantity > 0),
+			event_date DATE NOT NULL,
+			slot_type TEXT,
+			rider_id INTEGER REFERENCES users(id),
+			location TEXT,
+			photo_url TEXT,
+			delivery_id INTEGER REFERENCES deliveries(id),
+			note TEXT,
+			movement_id INTEGER UNIQUE REFERENCES inventory_movements(id),
+			reported_by TEXT,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS idx_wastage_events_date ON wastage_events(event_date, reason);
+		CREATE INDEX IF NOT EXISTS idx_wastage_events_rider ON wastage_events(rider_id, event_date);
+
+		CREATE TABLE IF NOT EXISTS wastage_thresholds (
+			id SERIAL PRIMARY KEY,
+			scope TEXT NOT NULL CHECK (scope IN ('total', 'reason', 'rider')),
+			reason TEXT,
+			stock_item_id INTEGER REFERENCES stock_items(id),
+			window_days INTEGER NOT NULL DEFAULT 1 CHECK (window_days BETWEEN 1 AND 31),
+			max_quantity DECIMAL(12,3) NOT NULL CHECK (max_quantity > 0),
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS wastage_alerts (
+			id SERIAL PRIMARY KEY,
+			threshold_id INTEGER NOT NULL REFERENCES wastage_thresholds(id),
+			reason TEXT NOT NULL DEFAULT '',
+			rider_id INTEGER NOT NULL DEFAULT 0,
+			window_start DATE NOT NULL,
+			window_end DATE NOT NULL,
+			quantity DECIMAL(12,3) NOT NULL,
+			acknowledged_by TEXT,
+			acknowledged_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (threshold_id, reason, rider_id, window_end)
+		);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create wastage schema:", err)
+	}
+
+	// Wastage booked straight into the ledger before events existed keeps its movement
+	// and shows up in reports as "unspecified"
+	_, err = db.Exec(`
+		INSERT INTO wastage_events (idempotency_key, reason, stock_item_id, warehouse_id, quantity, event_date, note, movement_id, reported_by, created_at)
+		SELECT 'movement:' || m.id, $1, m.stock_item_id, m.warehouse_id, -m.quantity, m.movement_date, m.note, m.id, m.created_by, m.created_at
+		FROM inventory_movements m
+		WHERE m.kind = $2 AND m.stock_item_id IS NOT NULL AND m.warehouse_id IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM wastage_events e WHERE e.movement_id = m.id)
+		ON CONFLICT DO NOTHING
+	`, wastageUnspecified, MoveWastage)
+	if err != nil {
+		log.Fatal("Failed to migrate wastage:", err)
+	}
+}
+
+// recordWastage stores a wastage event and takes the quantity out of stock.
+// A replayed idempotency key returns the original event with replayed=true.
+func recordWastage(q dbExecer, in wastageInput) (id int, replayed bool, err error) {
+	if _, ok := wastageReasons[in.Reason]; !ok {
+		return 0, false, errWastageReason
+	}
+	in.Quantity = roundQty(in.Quantity)
+	if in.Quantity <= 0 {
+		return 0, false, errMovementQuantity
+	}
+	if in.SlotType != "" && in.SlotType != SlotMorning && in.SlotType != SlotEvening {
+		return 0, false, errSlotInvalid
+	}
+	if in.Date == "" {
+		in.Date = time.Now().Format("2006-01-02")
+	}
+	item, err := stockItemBySKU(q, in.StockItemID, in.SKU)
+	if err != nil {
+		return 0, false, err
+	}
+	if in.WarehouseID == 0 {
+		if in.WarehouseID, err = warehouseForZone(q, 0); err != nil {
+			return 0, false, err
+		}
+	}
+	var riderArg, deliveryArg interface{}
+	if in.RiderID != 0 {
+		riderArg = in.RiderID
+	}
+	if in.DeliveryID != 0 {
+		deliveryArg = in.DeliveryID
+	}
+
+	err = q.QueryRow(`
+		INSERT INTO wastage_events (idempotency_key, reason, stock_item_id, warehouse_id, quantity, event_date, slot_type, rider_id,
+		                            location, photo_url, delivery_id, note, reported_by)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, NULLIF($9, ''), NULLIF($10, ''), $11, NULLIF($12, ''), NULLIF($13, ''))
+		ON CONFLICT (idempotency_key) DO NOTHING
+		RETURNING id
+	`, in.IdempotencyKey, in.Reason, item.ID, in.WarehouseID, in.Quantity, in.Date, in.SlotType, riderArg,
+		in.Location, in.PhotoURL, deliveryArg, in.Note, in.ReportedBy).Scan(&id)
+	if err == sql.ErrNoRows {
+		var reason string
+		var itemID int
+		var quantity float64
+		err = q.QueryRow("SELECT id, reason, stock_item_id, quantity FROM wastage_events WHERE idempotency_key = $1", in.IdempotencyKey).
+			Scan(&id, &reason, &itemID, &quantity)
+		if err != nil {
+			return 0, false, err
+		}
+		if reason != in.Reason || itemID != item.ID || roundQty(quantity) != in.Quantity {
+			return 0, false, errIdempotencyConflict
+		}
+		return id, true, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	note := wastageReasons[in.Reason]
+	if in.Note != "" {
+		note += ": " + in.Note
+	}
+	movementID, _, err := postInventoryMovement(q, inventoryMove{
+		Key:         "wastage:" + strconv.Itoa(id),
+		Kind:        MoveWastage,
+		StockItemID: item.ID,
+		WarehouseID: in.WarehouseID,
+		Quantity:    in.Quantity,
+		Date:        in.Date,
+		DeliveryID:  in.DeliveryID,
+		Note:        note,
+		CreatedBy:   in.ReportedBy,
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	_, err = q.Exec("UPDATE wastage_events SET movement_id = $1 WHERE id = $2", movementID, id)
+	return id, false, err
+}
+
+// recordDeliverySpill books the milk of a delivery failed as spilled against its rider
+func recordDeliverySpill(q dbExecer, deliveryID int, actor string) error {
+	var milkType, date, slotType string
+	var quantity float64
+	var riderID, zoneID int
+	err := q.QueryRow(`
+		SELECT d.milk_type, d.delivery_date::text, d.slot_type, d.quantity, COALESCE(d.rider_id, 0), COALESCE(a.zone_id, 0)
+		FROM deliveries d
+		LEFT JOIN milk_subscriptions ms ON ms.id = d.subscription_id LEFT JOIN addresses a ON a.id = ms.address_id
+		WHERE d.id = $1`, deliveryID).Scan(&milkType, &date, &slotType, &quantity, &riderID, &zoneID)
+	if err != nil {
+		return err
+	}
+	item, err := stockItemForMilk(q, milkType)
+	if errors.Is(err, errStockItemNotFound) || quantity <= 0 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	warehouseID, err := warehouseForZone(q, zoneID)
+	if err != nil {
+		return err
+	}
+	_, _, err = recordWastage(q, wastageInput{
+		IdempotencyKey: "delivery-spill:" + strconv.Itoa(deliveryID),
+		Reason:         "spillage",
+		StockItemID:    item.ID,
+		WarehouseID:    warehouseID,
+		Quantity:       quantity * item.PackSize,
+		Date:           date,
+		SlotType:       slotType,
+		RiderID:        riderID,
+		DeliveryID:     deliveryID,
+		ReportedBy:     actor,
+	})
+	return err
+}
+
+// evaluateWastageThresholds checks every active threshold over the window ending on
+// date and raises an alert for each new breach
+func evaluateWastageThresholds(date string) {
+	end, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return
+	}
+	thresholds, err := loadWastageThresholds(true)
+	if err != nil {
+		log.Println("Error loading wastage thresholds:", err)
+		return
+	}
+	for _, t := range thresholds {
+		start := end.AddDate(0, 0, 1-t.WindowDays)
+		group := "''"
+		switch t.Scope {
+		case WastageScopeReason:
+			group = "reason"
+		case WastageScopeRider:
+			group = "COALESCE(rider_id, 0)"
+		}
+		rows, err := db.Query(`
+			SELECT `+group+`, SUM(quantity) FROM wastage_events
+			WHERE event_date BETWEEN $1 AND $2 AND ($3 = '' OR reason = $3) AND ($4 = 0 OR stock_item_id = $4)
+			  AND ($5 <> 'rider' OR rider_id IS NOT NULL)
+			GROUP BY 1 HAVING SUM(quantity) > $6
+		`, start.Format("2006-01-02"), date, t.Reason, t.StockItemID, t.Scope, t.MaxQuantity)
+		if err != nil {
+			log.Println("Error evaluating wastage threshold:", err)
+			continue
+		}
+		type breach struct {
+			reason   string
+			riderID  int
+			quantity float64
+		}
+		var breaches []breach
+		for rows.Next() {
+			var key string
+			var b breach
+			rows.Scan(&key, &b.quantity)
+			if t.Scope == WastageScopeRider {
+				b.riderID, _ = strconv.Atoi(key)
+			} else {
+				b.reason = key
+			}
+			breaches = append(breaches, b)
+		}
+		rows.Close()
+
+		for _, b := range breaches {
+			// An existing alert for the window only has its quantity refreshed; xmax = 0 marks a fresh row
+			var inserted bool
+			err := db.QueryRow(`
+				INSERT INTO wastage_alerts (threshold_id, reason, rider_id, window_start, window_end, quantity)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (threshold_id, reason, rider_id, window_end) DO UPDATE SET quantity = EXCLUDED.quantity
+				RETURNING (xmax = 0)
+			`, t.ID, b.reason, b.riderID, start.Format("2006-01-02"), date, b.quantity).Scan(&inserted)
+			if err != nil {
+				log.Println("Error raising wastage alert:", err)
+				continue
+			}
+			if inserted {
+				notifyWastageAlert(t, b.reason, b.riderID, start.Format("2006-01-02"), date, b.quantity)
+			}
+		}
+	}
+}
+
+// notifyWastageAlert emails every admin about a threshold breach
+func notifyWastageAlert(t WastageThreshold, reason string, riderID int, from, to string, quantity float64) {
+	subject := fmt.Sprintf("Wastage alert: %.3f over the %.3f limit", quantity, t.MaxQuantity)
+	what := "Total wastage"
+	switch {
+	case t.Scope == WastageScopeReason:
+		what = "Wastage for " + wastageReasons[reason]
+	case t.Scope == WastageScopeRider:
+		var name string
+		db.QueryRow("SELECT COALESCE(name, email) FROM users WHERE id = $1", riderID).Scan(&name)
+		what = "Wastage by rider " + name
+	}
+	body := fmt.Sprintf(`<p>%s between %s and %s was <strong>%.3f</strong>, above the limit of %.3f.</p>`,
+		what, from, to, quantity, t.MaxQuantity)
+	log.Printf("Wastage alert (threshold %d): %s %s..%s = %.3f", t.ID, what, from, to, quantity)
+
+	rows, err := db.Query("SELECT email FROM users WHERE role = 'admin'")
+	if err != nil {
+		return
+	}
+	var emails []string
+	for rows.Next() {
+		var email string
+		rows.Scan(&email)
+		emails = append(emails, email)
+	}
+	rows.Close()
+	for _, email := range emails {
+		if err := deliverHTMLEmail(email, subject, body); err != nil {
+			log.Println("Failed to send wastage alert:", err)
+		}
+	}
+}
+
+func loadWastageThresholds(activeOnly bool) ([]WastageThreshold, error) {
+	rows, err := db.Query(`
+		SELECT id, scope, COALESCE(reason, ''), COALESCE(stock_item_id, 0), window_days, max_quantity, is_active, created_at::text
+		FROM wastage_thresholds WHERE is_active OR NOT $1 ORDER BY id`, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	thresholds := []WastageThreshold{}
+	for rows.Next() {
+		var t WastageThreshold
+		rows.Scan(&t.ID, &t.Scope, &t.Reason, &t.StockItemID, &t.WindowDays, &t.MaxQuantity, &t.IsActive, &t.CreatedAt)
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, nil
+}
+
+// wastageRange reads ?from&to, defaulting to the last 30 days
+func wastageRange(r *http.Request) (string, string, bool) {
+	from, to, err := inventoryRange(r)
+	if err != nil {
+		return "", "", false
+	}
+	return from.Format("2006-01-02"), to.Format("2006-01-02"), true
+}
+
+func writeWastageError(w http.ResponseWriter, err error, context string) {
+	if errors.Is(err, errSlotInvalid) {
+		http.Error(w, "slot_type must be Morning or Evening", http.StatusBadRequest)
+		return
+	}
+	if wastageErrorStatus(err) == http.StatusInternalServerError {
+		log.Println("Error "+context+":", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, err.Error(), wastageErrorStatus(err))
+}
+
+// postWastage records an event from a request body in its own transaction
+func postWastage(w http.ResponseWriter, in wastageInput) {
+	if in.IdempotencyKey == "" {
+		http.Error(w, "idempotency_key is required", http.StatusBadRequest)
+		return
+	}
+	in.IdempotencyKey = "api:" + in.IdempotencyKey
+	tx, err := db.Begin()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	id, replayed, err := recordWastage(tx, in)
+	if err != nil {
+		writeWastageError(w, err, "recording wastage")
+		return
+	}
+	var date string
+	tx.QueryRow("SELECT event_date::text FROM wastage_events WHERE id = $1", id).Scan(&date)
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !replayed {
+		go evaluateWastageThresholds(date)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id, "replayed": replayed})
+}
+
+// --- Handlers ---
+
+// adminWastageHandler lists wastage events (GET) or records one (POST)
+func adminWastageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		from, to, ok := wastageRange(r)
+		if !ok {
+			http.Error(w, "from and to must be YYYY-MM-DD with from <= to", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		riderID, _ := strconv.Atoi(q.Get("rider_id"))
+		limit, offset := paginationParams(r)
+		rows, err := db.Query(`
+			SELECT e.id, e.reason, e.stock_item_id, s.sku, s.name, s.uom, e.warehouse_id, e.quantity, e.event_date::text,
+			       COALESCE(e.slot_type, ''), COALESCE(e.rider_id, 0), COALESCE(u.name, u.email, ''), COALESCE(e.location, ''),
+			       COALESCE(e.photo_url, ''), COALESCE(e.delivery_id, 0), COALESCE(e.note, ''), COALESCE(e.movement_id, 0),
+			       COALESCE(e.reported_by, ''), e.created_at::text, COUNT(*) OVER ()
+			FROM wastage_events e
+			JOIN stock_items s ON s.id = e.stock_item_id
+			LEFT JOIN users u ON u.id = e.rider_id
+			WHERE e.event_date BETWEEN $1 AND $2 AND ($3 = '' OR e.reason = $3) AND ($4 = 0 OR e.rider_id = $4)
+			  AND ($5 = '' OR s.sku = $5)
+			ORDER BY e.event_date DESC, e.id DESC LIMIT $6 OFFSET $7
+		`, from, to, q.Get("reason"), riderID, q.Get("sku"), limit, offset)
+		if err != nil {
+			log.Println("Error fetching wastage:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		events := []WastageEvent{}
+		total := 0
+		for rows.Next() {
+			var e WastageEvent
+			rows.Scan(&e.ID, &e.Reason, &e.StockItemID, &e.SKU, &e.ItemName, &e.Uom, &e.WarehouseID, &e.Quantity, &e.Date,
+				&e.SlotType, &e.RiderID, &e.RiderName, &e.Location, &e.PhotoURL, &e.DeliveryID, &e.Note, &e.MovementID,
+				&e.ReportedBy, &e.CreatedAt, &total)
+			events = append(events, e)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"events": events, "total": total, "limit": limit, "offset": offset})
+
+	case "POST":
+		var in wastageInput
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		in.ReportedBy, _ = requestActor(r)
+		postWastage(w, in)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// riderWastageHandler lets a rider report wastage on their round (POST)
+func riderWastageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	riderID, _, err := currentRider(r)
+	if err != nil {
+		http.Error(w, "Rider not found", http.StatusNotFound)
+		return
+	}
+	var in wastageInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	in.RiderID = riderID
+	in.ReportedBy, _ = requestActor(r)
+	if in.DeliveryID != 0 {
+		var owner int
+		db.QueryRow("SELECT COALESCE(rider_id, 0) FROM deliveries WHERE id = $1", in.DeliveryID).Scan(&owner)
+		if owner != riderID {
+			http.Error(w, "Delivery not found", http.StatusNotFound)
+			return
+		}
+	}
+	postWastage(w, in)
+}
+
+// adminWastageReportHandler totals wastage by reason, by rider and by day over a range
+func adminWastageReportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	from, to, ok := wastageRange(r)
+	if !ok {
+		http.Error(w, "from and to must be YYYY-MM-DD with from <= to", http.StatusBadRequest)
+		return
+	}
+
+	type reasonTotal struct {
+		Reason   string  `json:"reason"`
+		Label    string  `json:"label"`
+		Uom      string  `json:"uom"`
+		Events   int     `json:"events"`
+		Quantity float64 `json:"quantity"`
+	}
+	byReason := []reasonTotal{}
+	rows, err := db.Query(`
+		SELECT e.reason, s.uom, COUNT(*), SUM(e.quantity) FROM wastage_events e JOIN stock_items s ON s.id = e.stock_item_id
+		WHERE e.event_date BETWEEN $1 AND $2 GROUP BY e.reason, s.uom ORDER BY SUM(e.quantity) DESC`, from, to)
+	if err != nil {
+		log.Println("Error building wastage report:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var t reasonTotal
+		rows.Scan(&t.Reason, &t.Uom, &t.Events, &t.Quantity)
+		t.Label = wastageReasons[t.Reason]
+		if t.Label == "" {
+			t.Label = "Unspecified"
+		}
+		t.Quantity = roundQty(t.Quantity)
+		byReason = append(byReason, t)
+	}
+	rows.Close()
+
+	// Riders are compared on the share of what they carried, not only the raw quantity
+	type riderTotal struct {
+		RiderID       int     `json:"rider_id"`
+		RiderName     string  `json:"rider_name"`
+		Uom           string  `json:"uom"`
+		Events        int     `json:"events"`
+		Quantity      float64 `json:"quantity"`
+		Dispatched    float64 `json:"dispatched"`
+		WastagePct    float64 `json:"wastage_pct"`
+		TopReason     string  `json:"top_reason"`
+		FailedSpilled int     `json:"spilled_deliveries"`
+	}
+	byRider := []riderTotal{}
+	rows, err = db.Query(`
+		SELECT e.rider_id, COALESCE(u.name, u.email), s.uom, COUNT(*), SUM(e.quantity),
+		       COALESCE((SELECT -SUM(m.quantity) FROM inventory_movements m JOIN deliveries d ON d.id = m.delivery_id
+		                 JOIN stock_items s2 ON s2.id = m.stock_item_id
+		                 WHERE m.kind = $3 AND d.rider_id = e.rider_id AND s2.uom = s.uom
+		                   AND m.movement_date BETWEEN $1 AND $2), 0),
+		       MODE() WITHIN GROUP (ORDER BY e.reason),
+		       COUNT(*) FILTER (WHERE e.delivery_id IS NOT NULL AND e.reason = 'spillage')
+		FROM wastage_events e
+		JOIN stock_items s ON s.id = e.stock_item_id
+		JOIN users u ON u.id = e.rider_id
+		WHERE e.event_date BETWEEN $1 AND $2
+		GROUP BY e.rider_id, u.name, u.email, s.uom
+		ORDER BY SUM(e.quantity) DESC`, from, to, MoveDispatch)
+	if err != nil {
+		log.Println("Error building wastage report:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var t riderTotal
+		rows.Scan(&t.RiderID, &t.RiderName, &t.Uom, &t.Events, &t.Quantity, &t.Dispatched, &t.TopReason, &t.FailedSpilled)
+		if t.Dispatched+t.Quantity > 0 {
+			t.WastagePct = roundMoney(100 * t.Quantity / (t.Dispatched + t.Quantity))
+		}
+		t.Quantity, t.Dispatched = roundQty(t.Quantity), roundQty(t.Dispatched)
+		byRider = append(byRider, t)
+	}
+	rows.Close()
+
+	type dayTotal struct {
+		Date     string  `json:"date"`
+		Uom      string  `json:"uom"`
+		Quantity float64 `json:"quantity"`
+	}
+	byDay := []dayTotal{}
+	rows, err = db.Query(`
+		SELECT e.event_date::text, s.uom, SUM(e.quantity) FROM wastage_events e JOIN stock_items s ON s.id = e.stock_item_id
+		WHERE e.event_date BETWEEN $1 AND $2 GROUP BY e.event_date, s.uom ORDER BY e.event_date`, from, to)
+	if err != nil {
+		log.Println("Error building wastage report:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var t dayTotal
+		rows.Scan(&t.Date, &t.Uom, &t.Quantity)
+		t.Quantity = roundQty(t.Quantity)
+		byDay = append(byDay, t)
+	}
+	rows.Close()
+
+	var openAlerts int
+	db.QueryRow("SELECT COUNT(*) FROM wastage_alerts WHERE acknowledged_at IS NULL AND window_end BETWEEN $1 AND $2", from, to).
+		Scan(&openAlerts)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":        from,
+		"to":          to,
+		"by_reason":   byReason,
+		"by_rider":    byRider,
+		"by_day":      byDay,
+		"open_alerts": openAlerts,
+	})
+}
+
+// adminWastageThresholdsHandler manages alert thresholds
+func adminWastageThresholdsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		thresholds, err := loadWastageThresholds(false)
+		if err != nil {
+			log.Println("Error fetching wastage thresholds:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(thresholds)
+
+	case "POST", "PUT":
+		t := WastageThreshold{WindowDays: 1, IsActive: true}
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if _, ok := wastageReasons[t.Reason]; t.Reason != "" && !ok {
+			writeWastageError(w, errWastageReason, "")
+			return
+		}
+		if (t.Scope != WastageScopeTotal && t.Scope != WastageScopeReason && t.Scope != WastageScopeRider) ||
+			t.MaxQuantity <= 0 || t.WindowDays < 1 || t.WindowDays > 31 {
+			writeWastageError(w, errWastageThreshold, "")
+			return
+		}
+		var itemArg interface{}
+		if t.StockItemID != 0 {
+			itemArg = t.StockItemID
+		}
+		var err error
+		if r.Method == "POST" {
+			err = db.QueryRow(`INSERT INTO wastage_thresholds (scope, reason, stock_item_id, window_days, max_quantity, is_active)
+				VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6) RETURNING id`,
+				t.Scope, t.Reason, itemArg, t.WindowDays, t.MaxQuantity, t.IsActive).Scan(&t.ID)
+		} else {
+			err = db.QueryRow(`UPDATE wastage_thresholds SET scope = $1, reason = NULLIF($2, ''), stock_item_id = $3, window_days = $4,
+				max_quantity = $5, is_active = $6 WHERE id = $7 RETURNING id`,
+				t.Scope, t.Reason, itemArg, t.WindowDays, t.MaxQuantity, t.IsActive, t.ID).Scan(&t.ID)
+		}
+		if err == sql.ErrNoRows {
+			writeWastageError(w, errThresholdMissing, "")
+			return
+		} else if err != nil {
+			log.Println("Error saving wastage threshold:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": t.ID})
+
+	case "DELETE":
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		res, err := db.Exec("UPDATE wastage_thresholds SET is_active = false WHERE id = $1", id)
+		if err != nil {
+			log.Println("Error deactivating wastage threshold:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			writeWastageError(w, errThresholdMissing, "")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// adminWastageAlertsHandler lists alerts (GET ?from&to&open=true) or acknowledges one (PUT {id})
+func adminWastageAlertsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		from, to, ok := wastageRange(r)
+		if !ok {
+			http.Error(w, "from and to must be YYYY-MM-DD with from <= to", http.StatusBadRequest)
+			return
+		}
+		rows, err := db.Query(`
+			SELECT a.id, a.threshold_id, t.scope, a.reason, a.rider_id, COALESCE(u.name, u.email, ''), a.window_start::text,
+			       a.window_end::text, a.quantity, t.max_quantity, COALESCE(a.acknowledged_by, ''), a.created_at::text
+			FROM wastage_alerts a
+			JOIN wastage_thresholds t ON t.id = a.threshold_id
+			LEFT JOIN users u ON u.id = NULLIF(a.rider_id, 0)
+			WHERE a.window_end BETWEEN $1 AND $2 AND ($3 <> 'true' OR a.acknowledged_at IS NULL)
+			ORDER BY a.window_end DESC, a.id DESC`, from, to, r.URL.Query().Get("open"))
+		if err != nil {
+			log.Println("Error fetching wastage alerts:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		alerts := []WastageAlert{}
+		for rows.Next() {
+			var a WastageAlert
+			rows.Scan(&a.ID, &a.ThresholdID, &a.Scope, &a.Reason, &a.RiderID, &a.RiderName, &a.WindowStart, &a.WindowEnd,
+				&a.Quantity, &a.MaxQuantity, &a.AcknowledgedBy, &a.CreatedAt)
+			alerts = append(alerts, a)
+		}
+		json.NewEncoder(w).Encode(alerts)
+
+	case "PUT":
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		actorEmail, _ := requestActor(r)
+		res, err := db.Exec(`UPDATE wastage_alerts SET acknowledged_by = $1, acknowledged_at = NOW()
+			WHERE id = $2 AND acknowledged_at IS NULL`, strings.TrimSpace(actorEmail), req.ID)
+		if err != nil {
+			log.Println("Error acknowledging wastage alert:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "Alert not found or already acknowledged", http.StatusConflict)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ============================================================================
+// DELIVERY ZONES
+// ============================================================================
+//
+// A delivery zone is either a polygon of lat/lng points or a radius around a
+// centre (usually a depot), optionally with a list of pincodes used when an
+// address has no coordinates. Addresses are assigned a zone on create/update;
+// once any active zone exists, addresses outside every zone are rejected.
+// The zone feeds zone-scoped prices, per-zone delivery windows/capacity and
+// per-zone route planning from the zone's depot.
+//
+// Routes:
+//   GET /api/zones/serviceable?lat=&lng=  or  ?pincode=   (public)
+//   /api/admin/zones                                       (GET list, POST, PUT {id,...}, DELETE ?id=)
+
+// Zone kinds
+const (
+	ZonePolygon = "polygon"
+	ZoneRadius  = "radius"
+)
+
+var (
+	errNotServiceable = errors.New("sorry, we do not deliver to this location yet")
+	errZoneInvalid    = errors.New("zone needs a name and either 3+ polygon points or a centre and radius_km")
+	errZoneNotFound   = errors.New("delivery zone not found")
+	errPincodeTaken   = errors.New("pincode already belongs to another zone")
+)
+
+func zoneErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errZoneNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errNotServiceable):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errZoneInvalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errPincodeTaken):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+type DeliveryZone struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Kind      string     `json:"kind"`
+	Polygon   []GeoPoint `json:"polygon,omitempty"`
+	Center    GeoPoint   `json:"center"`
+	RadiusKm  float64    `json:"radius_km,omitempty"`
+	Depot     GeoPoint   `json:"depot"`
+	Pincodes  []string   `json:"pincodes"`
+	Priority  int        `json:"priority"` // higher wins where zones overlap
+	IsActive  bool       `json:"is_active"`
+	CreatedAt string     `json:"created_at"`
+}
+
+func initZonesSchema() {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS delivery_zones (
+			id SERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE,
+			kind TEXT NOT NULL CHECK (kind IN ('polygon', 'radius')),
+			polygon JSONB,
+			center_lat DECIMAL(10,8),
+			center_lng DECIMAL(11,8),
+			radius_km DECIMAL(8,3),
+			depot_lat DECIMAL(10,8),
+			depot_lng DECIMAL(11,8),
+			priority INTEGER NOT NULL DEFAULT 0,
+			is_active BOOLEAN NOT NULL DEFAULT true,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS delivery_zone_pincodes (
+			pincode TEXT PRIMARY KEY,
+			zone_id INTEGER NOT NULL REFERENCES delivery_zones(id) ON DELETE CASCADE
+		);
+
+		ALTER TABLE addresses ADD COLUMN IF NOT EXISTS pincode TEXT;
+		ALTER TABLE addresses ADD COLUMN IF NOT EXISTS zone_id INTEGER REFERENCES delivery_zones(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_addresses_zone ON addresses(zone_id);
+	`)
+	if err != nil {
+		log.Fatal("Failed to create zones schema:", err)
+	}
+}
+
+// pointInPolygon is a ray-casting test treating lat/lng as planar coordinates,
+// which is accurate enough at city scale
+func pointInPolygon(p GeoPoint, poly []GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lng < (b.Lng-a.Lng)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lng {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func (z DeliveryZone) contains(p GeoPoint) bool {
+	switch z.Kind {
+	case ZonePolygon:
+		return len(z.Polygon) >= 3 && pointInPolygon(p, z.Polygon)
+	case ZoneRadius:
+		return z.RadiusKm > 0 && haversineKm(z.Center, p) <= z.RadiusKm
+	}
+	return false
+}
+
+// depot is where routes for the zone start; radius zones default to their centre
+func (z DeliveryZone) depot() GeoPoint {
+	if z.Depot.located() {
+		return z.Depot
+	}
+	if z.Kind == ZoneRadius {
+		return z.Center
+	}
+	return defaultDepot()
+}
+
+func normalizePincode(pin string) string {
+	return strings.ReplaceAll(strings.TrimSpace(pin), " ", "")
+}
+
+// matchZone picks the zone for a location: coordinates decide when present,
+// otherwise the pincode. zones must be ordered by priority (highest first).
+func matchZone(zones []DeliveryZone, p GeoPoint, pincode string) *DeliveryZone {
+	if p.located() {
+		for i := range zones {
+			if zones[i].contains(p) {
+				return &zones[i]
+			}
+		}
+		return nil
+	}
+	pincode = normalizePincode(pincode)
+	if pincode == "" {
+		return nil
+	}
+	for i := range zones {
+		for _, pin := range zones[i].Pincodes {
+			if pin == pincode {
+				return &zones[i]
+			}
+		}
+	}
+	return nil
+}
+
+// loadZones reads zones (highest priority first) with their pincodes
+func loadZones(q dbExecer, activeOnly bool) ([]DeliveryZone, error) {
+	rows, err := q.Query(`
+		SELECT id, name, kind, COALESCE(polygon::text, '[]'), COALESCE(center_lat, 0), COALESCE(center_lng, 0),
+		       COALESCE(radius_km, 0), COALESCE(depot_lat, 0), COALESCE(depot_lng, 0), priority, is_active, created_at::text
+		FROM delivery_zones WHERE is_active OR NOT $1
+		ORDER BY priority DESC, id`, activeOnly)
+	if err != nil {
+		return nil, err
+	}
+	zones := []DeliveryZone{}
+	index := map[int]int{}
+	for rows.Next() {
+		var z DeliveryZone
+		var polygon string
+		if err := rows.Scan(&z.ID, &z.Name, &z.Kind, &polygon, &z.Center.Lat, &z.Center.Lng, &z.RadiusKm,
+			&z.Depot.Lat, &z.Depot.Lng, &z.Priority, &z.IsActive, &z.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		json.Unmarshal([]byte(polygon), &z.Polygon)
+		z.Pincodes = []string{}
+		index[z.ID] = len(zones)
+		zones = append(zones, z)
+	}
+	rows.Close()
+
+	pins, err := q.Query("SELECT zone_id, pincode FROM delivery_zone_pincodes ORDER BY pincode")
+	if err != nil {
+		return nil, err
+	}
+	defer pins.Close()
+	for pins.Next() {
+		var zoneID int
+		var pin string
+		pins.Scan(&zoneID, &pin)
+		if i, ok := index[zoneID]; ok {
+			zones[i].Pincodes = append(zones[i].Pincodes, pin)
+		}
+	}
+	return zones, nil
+}
+
+// resolveZone returns the zone for a location. While no zones are configured every
+// location is serviceable with zone 0; after that, unmatched locations are rejected.
+func resolveZone(q dbExecer, p GeoPoint, pincode string) (*DeliveryZone, error) {
+	zones, err := loadZones(q, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, nil
+	}
+	if z := matchZone(zones, p, pincode); z != nil {
+		return z, nil
+	}
+	return nil, errNotServiceable
+}
+
+// addressZone returns an address's zone, or errNotServiceable when zones are
+// configured and the address sits outside all of them
+func addressZone(q dbExecer, addressID int) (int, error) {
+	var zoneID sql.NullInt64
+	var zoned bool
+	err := q.QueryRow(`
+		SELECT a.zone_id, EXISTS (SELECT 1 FROM delivery_zones WHERE is_active)
+		FROM addresses a WHERE a.id = $1`, addressID).Scan(&zoneID, &zoned)
+	if err != nil {
+		return 0, err
+	}
+	if zoned && !zoneID.Valid {
+		return 0, errNotServiceable
+	}
+	return int(zoneID.Int64), nil
+}
+
+// assignAddressZones recomputes every address's zone after the zones change.
+// Addresses that fall outside all zones keep existing but lose their zone.
+func assignAddressZones() error {
+	zones, err := loadZones(db, true)
+	if err != nil {
+		return err
+	}
+	rows, err := db.Query("SELECT id, COALESCE(latitude, 0), COALESCE(longitude, 0), COALESCE(pincode, ''), COALESCE(zone_id, 0) FROM addresses")
+	if err != nil {
+		return err
+	}
+	type change struct{ id, zoneID int }
+	var changes []change
+	for rows.Next() {
+		var id, current int
+		var p GeoPoint
+		var pincode string
+		rows.Scan(&id, &p.Lat, &p.Lng, &pincode, &current)
+		zoneID := 0
+		if z := matchZone(zones, p, pincode); z != nil {
+			zoneID = z.ID
+		}
+		if zoneID != current {
+			changes = append(changes, change{id, zoneID})
+		}
+	}
+	rows.Close()
+
+	for _, c := range changes {
+		var zoneArg interface{}
+		if c.zoneID != 0 {
+			zoneArg = c.zoneID
+		}
+		if _, err := db.Exec("UPDATE addresses SET zone_id = $1 WHERE id = $2", zoneArg, c.id); err != nil {
+			return err
+		}
+	}
+	if len(changes) > 0 {
+		log.Printf("Reassigned delivery zone for %d addresses", len(changes))
+	}
+	return nil
+}
+
+// saveZone creates (ID 0) or replaces a zone and its pincodes
+func saveZone(z DeliveryZone) (int, error) {
+	z.Name = strings.TrimSpace(z.Name)
+	switch {
+	case z.Name == "":
+		return 0, errZoneInvalid
+	case z.Kind == ZonePolygon && len(z.Polygon) < 3:
+		return 0, errZoneInvalid
+	case z.Kind == ZoneRadius && (!z.Center.located() || z.RadiusKm <= 0):
+		return 0, errZoneInvalid
+	case z.Kind != ZonePolygon && z.Kind != ZoneRadius:
+		return 0, errZoneInvalid
+	}
+
+	var polygonArg, centerLat, centerLng, radiusArg, depotLat, depotLng interface{}
+	if z.Kind == ZonePolygon {
+		data, _ := json.Marshal(z.Polygon)
+		polygonArg = string(data)
+	} else {
+		centerLat, centerLng, radiusArg = z.Center.Lat, z.Center.Lng, z.RadiusKm
+	}
+	if z.Depot.located() {
+		depotLat, depotLng = z.Depot.Lat, z.Depot.Lng
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id := z.ID
+	if id == 0 {
+		err = tx.QueryRow(`
+			INSERT INTO delivery_zones (name, kind, polygon, center_lat, center_lng, radius_km, depot_lat, depot_lng, priority, is_active)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
+		`, z.Name, z.Kind, polygonArg, centerLat, centerLng, radiusArg, depotLat, depotLng, z.Priority, z.IsActive).Scan(&id)
+	} else {
+		err = tx.QueryRow(`
+			UPDATE delivery_zones SET name = $1, kind = $2, polygon = $3, center_lat = $4, center_lng = $5, radius_km = $6,
+			       depot_lat = $7, depot_lng = $8, priority = $9, is_active = $10, updated_at = NOW()
+			WHERE id = $11 RETURNING id
+		`, z.Name, z.Kind, polygonArg, centerLat, centerLng, radiusArg, depotLat, depotLng, z.Priority, z.IsActive, z.ID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return 0, errZoneNotFound
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM delivery_zone_pincodes WHERE zone_id = $1", id); err != nil {
+		return 0, err
+	}
+	for _, pin := range z.Pincodes {
+		pin = normalizePincode(pin)
+		if pin == "" {
+			continue
+		}
+		var owner int
+		err := tx.QueryRow(`
+			INSERT INTO delivery_zone_pincodes (pincode, zone_id) VALUES ($1, $2)
+			ON CONFLICT (pincode) DO UPDATE SET pincode = EXCLUDED.pincode
+			RETURNING zone_id`, pin, id).Scan(&owner)
+		if err != nil {
+			return 0, err
+		}
+		if owner != id {
+			return 0, errPincodeTaken
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if err := assignAddressZones(); err != nil {
+		log.Println("Error reassigning address zones:", err)
+	}
+	return id, nil
+}
+
+// --- Handlers ---
+
+// serviceableHandler answers whether a location is served: GET ?lat=&lng= or ?pincode=
+func serviceableHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	var p GeoPoint
+	p.Lat, _ = strconv.ParseFloat(q.Get("lat"), 64)
+	p.Lng, _ = strconv.ParseFloat(q.Get("lng"), 64)
+	pincode := q.Get("pincode")
+	if !p.located() && normalizePincode(pincode) == "" {
+		http.Error(w, "lat and lng, or pincode, are required", http.StatusBadRequest)
+		return
+	}
+
+	zone, err := resolveZone(db, p, pincode)
+	if err != nil && !errors.Is(err, errNotServiceable) {
+		log.Println("Error checking serviceability:", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	resp := map[string]interface{}{"serviceable": err == nil}
+	if err != nil {
+		resp["message"] = err.Error()
+	}
+	if zone != nil {
+		resp["zone"] = map[string]interface{}{"id": zone.ID, "name": zone.Name}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// adminZonesHandler manages zones: GET list, POST create, PUT {id,...} replace, DELETE ?id= (deactivate)
+func adminZonesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case "GET":
+		zones, err := loadZones(db, false)
+		if err != nil {
+			log.Println("Error fetching zones:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		counts := map[int]int{}
+		if rows, err := db.Query("SELECT zone_id, COUNT(*) FROM addresses WHERE zone_id IS NOT NULL GROUP BY zone_id"); err == nil {
+			for rows.Next() {
+				var id, n int
+				rows.Scan(&id, &n)
+				counts[id] = n
+			}
+			rows.Close()
+		}
+		out := make([]map[string]interface{}, 0, len(zones))
+		for _, z := range zones {
+			out = append(out, map[string]interface{}{"zone": z, "addresses": counts[z.ID]})
+		}
+		json.NewEncoder(w).Encode(out)
+
+	case "POST", "PUT":
+		z := DeliveryZone{IsActive: true}
+		if err := json.NewDecoder(r.Body).Decode(&z); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		if r.Method == "POST" {
+			z.ID = 0
+		}
+		id, err := saveZone(z)
+		if err != nil {
+			if zoneErrorStatus(err) == http.StatusInternalServerError {
+				log.Println("Error saving zone:", err)
+				http.Error(w, "Could not save zone (the name may already be in use)", http.StatusConflict)
+				return
+			}
+			http.Error(w, err.Error(), zoneErrorStatus(err))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "id": id})
+
+	case "DELETE":
+		id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+		// Prices, windows and orders reference zones, so they are deactivated rather than deleted
+		res, err := db.Exec("UPDATE delivery_zones SET is_active = false, updated_at = NOW() WHERE id = $1", id)
+		if err != nil {
+			log.Println("Error deactivating zone:", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, errZoneNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if err := assignAddressZones(); err != nil {
+			log.Println("Error reassigning address zones:", err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestPointInPolygon(t *testing.T) {
+	square := []GeoPoint{{Lat: 12.90, Lng: 77.50}, {Lat: 12.90, Lng: 77.60}, {Lat: 13.00, Lng: 77.60}, {Lat: 13.00, Lng: 77.50}}
+	// An L shape: the square with its north-east quarter cut out
+	ell := []GeoPoint{
+		{Lat: 12.90, Lng: 77.50}, {Lat: 12.90, Lng: 77.60}, {Lat: 12.95, Lng: 77.60},
+		{Lat: 12.95, Lng: 77.55}, {Lat: 13.00, Lng: 77.55}, {Lat: 13.00, Lng: 77.50},
+	}
+
+	tests := []struct {
+		name string
+		poly []GeoPoint
+		p    GeoPoint
+		want bool
+	}{
+		{"centre of square", square, GeoPoint{Lat: 12.95, Lng: 77.55}, true},
+		{"west of square", square, GeoPoint{Lat: 12.95, Lng: 77.40}, false},
+		{"north of square", square, GeoPoint{Lat: 13.05, Lng: 77.55}, false},
+		{"east of square at the same latitude", square, GeoPoint{Lat: 12.95, Lng: 77.70}, false},
+		{"inside the L", ell, GeoPoint{Lat: 12.92, Lng: 77.58}, true},
+		{"in the L's missing corner", ell, GeoPoint{Lat: 12.98, Lng: 77.58}, false},
+		{"inside the L's upright", ell, GeoPoint{Lat: 12.98, Lng: 77.52}, true},
+		{"empty polygon", nil, GeoPoint{Lat: 12.95, Lng: 77.55}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pointInPolygon(tt.p, tt.poly); got != tt.want {
+				t.Errorf("pointInPolygon(%v) = %v, want %v", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchZone(t *testing.T) {
+	// Ordered by priority: the small radius zone sits inside the polygon and wins
+	zones := []DeliveryZone{
+		{ID: 1, Kind: ZoneRadius, Center: GeoPoint{Lat: 12.95, Lng: 77.55}, RadiusKm: 1, Pincodes: []string{"560001"}},
+		{ID: 2, Kind: ZonePolygon, Polygon: []GeoPoint{
+			{Lat: 12.90, Lng: 77.50}, {Lat: 12.90, Lng: 77.60}, {Lat: 13.00, Lng: 77.60}, {Lat: 13.00, Lng: 77.50},
+		}, Pincodes: []string{"560002", "560003"}},
+		{ID: 3, Kind: ZonePolygon, Polygon: []GeoPoint{{Lat: 12.80, Lng: 77.40}, {Lat: 12.80, Lng: 77.45}}, Pincodes: []string{"560004"}},
+	}
+
+	tests := []struct {
+		name    string
+		p       GeoPoint
+		pincode string
+		want    int // zone id, 0 for none
+	}{
+		{"inside both: higher priority wins", GeoPoint{Lat: 12.951, Lng: 77.551}, "", 1},
+		{"polygon only", GeoPoint{Lat: 12.91, Lng: 77.59}, "", 2},
+		{"coordinates outside every zone", GeoPoint{Lat: 13.20, Lng: 77.80}, "560001", 0},
+		{"no coordinates: pincode decides", GeoPoint{}, "560003", 2},
+		{"pincode with spaces", GeoPoint{}, " 560 001 ", 1},
+		{"degenerate polygon still matches by pincode", GeoPoint{}, "560004", 3},
+		{"unknown pincode", GeoPoint{}, "110001", 0},
+		{"nothing to go on", GeoPoint{}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			if z := matchZone(zones, tt.p, tt.pincode); z != nil {
+				got = z.ID
+			}
+			if got != tt.want {
+				t.Errorf("matchZone(%v, %q) = zone %d, want %d", tt.p, tt.pincode, got, tt.want)
+			}
+		})
+	}
+}